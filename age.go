@@ -0,0 +1,110 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// ageMagic starts every binary age file
+const ageMagic = "age-encryption.org/"
+
+// WithAgeRecipients encrypts age files for the recipients, for example parsed with
+// age.ParseRecipients, instead of the passphrase. It requires FormatAge.
+func WithAgeRecipients(recipients ...age.Recipient) Option {
+	return func(e *Encryptor) {
+		e.ageRecipients = append(e.ageRecipients, recipients...)
+	}
+}
+
+// WithAgeIdentities decrypts age files encrypted for recipients with the identities
+func WithAgeIdentities(identities ...age.Identity) Option {
+	return func(e *Encryptor) {
+		e.ageIdentities = append(e.ageIdentities, identities...)
+	}
+}
+
+// newAgeWriter returns a writer encrypting to w in the age format, for the age
+// recipients or the passphrase using scrypt
+func (e *Encryptor) newAgeWriter(w io.Writer) (io.WriteCloser, error) {
+	recipients := e.ageRecipients
+	if len(recipients) == 0 {
+		if len(e.pass) == 0 {
+			return nil, errors.New("age format needs a passphrase or recipients")
+		}
+		// age takes the passphrase as a string, it cannot be cleared
+		r, err := age.NewScryptRecipient(string(e.pass))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create scrypt recipient: %w", err)
+		}
+		if e.kdf.Name == KDFScrypt {
+			r.SetWorkFactor(int(e.kdf.Time))
+		}
+		recipients = []age.Recipient{r}
+	}
+	aw, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return aw, nil
+}
+
+// newAgeReader returns a reader decrypting the age file read from r with the age
+// identities and the passphrase
+func (e *Encryptor) newAgeReader(r io.Reader, armored bool) (io.Reader, error) {
+	if armored {
+		r = armor.NewReader(r)
+	}
+	identities := e.ageIdentities
+	if len(e.pass) > 0 {
+		id, err := age.NewScryptIdentity(string(e.pass))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create scrypt identity: %w", err)
+		}
+		identities = append(identities[:len(identities):len(identities)], id)
+	}
+	if len(identities) == 0 {
+		return nil, ErrNoIdentity
+	}
+	ar, err := age.Decrypt(r, identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, ErrNoIdentity
+		}
+		return nil, fmt.Errorf("failed to read age header: %w", err)
+	}
+	return ar, nil
+}
+
+// detectAge reports whether the data buffered in br starts with a binary or armored age file
+func detectAge(br *bufio.Reader) (isAge, armored bool) {
+	if b, err := br.Peek(len(ageMagic)); err == nil && string(b) == ageMagic {
+		return true, false
+	}
+	if b, err := br.Peek(len(armor.Header)); err == nil && bytes.Equal(b, []byte(armor.Header)) {
+		return true, true
+	}
+	return false, false
+}
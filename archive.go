@@ -0,0 +1,454 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotArchive is returned when extracting a file that was not created by EncryptDir
+var ErrNotArchive = errors.New("not an encrypted archive")
+
+// SelectFunc is called for every file, directory and symbolic link EncryptDir
+// archives, with its name in the archive and its info. Files and links it
+// returns false for are left out, directories are always archived.
+type SelectFunc func(name string, info fs.FileInfo) bool
+
+// WithSelectFunc makes EncryptDir archive only the files fn selects, e.g. those
+// changed since the last backup
+func WithSelectFunc(fn SelectFunc) Option {
+	return func(e *Encryptor) {
+		e.selected = fn
+	}
+}
+
+// EncryptDir packs the directory srcDir with its files, subdirectories and
+// symbolic links into a tar archive and encrypts it to dstPath. The entries are
+// named relative to the parent of srcDir, so extracting recreates the directory.
+// Other file types are skipped and reported to the SkipFunc of WithSkipFunc.
+func (e *Encryptor) EncryptDir(srcDir, dstPath string) error {
+	absSrc, stat, err := archiveSource(srcDir)
+	if err != nil {
+		return err
+	}
+
+	// The archive must not end up in itself
+	absDst, err := filepath.Abs(dstPath)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(absSrc, absDst); err == nil && filepath.IsLocal(rel) {
+		return fmt.Errorf("archive %s must not be inside %s", dstPath, srcDir)
+	}
+
+	return e.writeAtomic(dstPath, e.overwrite, func(w io.Writer) error {
+		return e.writeArchive(w, srcDir, absSrc, stat)
+	})
+}
+
+// EncryptDirTo packs the directory srcDir like EncryptDir and writes the
+// encrypted archive to w, e.g. to stream it to remote storage. It does not close w.
+func (e *Encryptor) EncryptDirTo(w io.Writer, srcDir string) error {
+	absSrc, stat, err := archiveSource(srcDir)
+	if err != nil {
+		return err
+	}
+	return e.writeArchive(w, srcDir, absSrc, stat)
+}
+
+// archiveSource checks that srcDir is a directory and returns its absolute path and info
+func archiveSource(srcDir string) (string, fs.FileInfo, error) {
+	stat, err := os.Stat(srcDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open directory: %w", err)
+	}
+	if !stat.IsDir() {
+		return "", nil, fmt.Errorf("%s is not a directory", srcDir)
+	}
+	absSrc, err := filepath.Abs(srcDir)
+	if err != nil {
+		return "", nil, err
+	}
+	return absSrc, stat, nil
+}
+
+// writeArchive encrypts the tar archive of srcDir, whose absolute path and
+// info are absSrc and stat, to w
+func (e *Encryptor) writeArchive(w io.Writer, srcDir, absSrc string, stat fs.FileInfo) error {
+	// Record the directory and mark the content as archive
+	md := Metadata{UID: -1, GID: -1}
+	if e.metadata != nil {
+		md = *e.metadata
+	}
+	if e.fileMetadata {
+		md = e.fileMetadataOf(absSrc, stat)
+	}
+	md.Archive = true
+
+	ew, err := e.newWriter(w, &md, nil)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(ew)
+	if err := e.archiveTree(tw, srcDir, filepath.Base(absSrc), map[string]bool{}, map[fileID]string{}); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return ew.Close()
+}
+
+// archiveTree writes the directory dir and everything below it as entry name
+// to tw. With WithFollowSymlinks links are replaced by what they point to;
+// visited holds the directories archived so far, so links into a loop are skipped.
+// links maps files with several hard links to the entry of the first one archived.
+func (e *Encryptor) archiveTree(tw *tar.Writer, dir, name string, visited map[string]bool, links map[fileID]string) error {
+	// The real path also lets the walk enter a directory reached through a link
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	if real, err = filepath.Abs(real); err != nil {
+		return err
+	}
+	return filepath.WalkDir(real, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(real, path)
+		if err != nil {
+			return err
+		}
+		entry := filepath.ToSlash(filepath.Join(name, rel))
+		if d.IsDir() {
+			visited[path] = true
+		}
+		if d.Type()&fs.ModeSymlink == 0 || !e.followSymlinks {
+			return e.addToArchive(tw, path, entry, d, links)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			e.skip(path, "broken symbolic link")
+			return nil
+		}
+		if !info.IsDir() {
+			return e.addToArchive(tw, path, entry, fs.FileInfoToDirEntry(info), links)
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			target, err = filepath.Abs(target)
+		}
+		if err != nil || visited[target] {
+			e.skip(path, "symbolic link to a directory archived already")
+			return nil
+		}
+		return e.archiveTree(tw, target, entry, visited, links)
+	})
+}
+
+// addToArchive writes the file at path as entry name to tw, other file types than
+// regular files, directories and symbolic links are skipped. Further hard links
+// to a file archived already are written as link to its entry.
+func (e *Encryptor) addToArchive(tw *tar.Writer, path, name string, d fs.DirEntry, links map[fileID]string) error {
+	info, err := d.Info()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	var link string
+	switch {
+	case info.Mode().IsRegular(), info.IsDir():
+	case info.Mode()&fs.ModeSymlink != 0:
+		if link, err = os.Readlink(path); err != nil {
+			return fmt.Errorf("failed to read link: %w", err)
+		}
+	default:
+		e.skip(path, SpecialFileType(info.Mode()))
+		return nil
+	}
+	if e.selected != nil && !e.selected(name, info) && !info.IsDir() {
+		return nil
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", path, err)
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Format = tar.FormatPAX
+	if !e.storeOwner {
+		hdr.Uid, hdr.Gid = 0, 0
+	}
+	if id, ok := hardLinkID(info); ok && info.Mode().IsRegular() {
+		if first, seen := links[id]; seen {
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+		} else {
+			links[id] = name
+		}
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	src, err := e.progressReader(file, path)
+	if err != nil {
+		return err
+	}
+	if _, err := e.copy(tw, src); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", path, err)
+	}
+	return nil
+}
+
+// ExtractArchive decrypts the archive created by EncryptDir at srcPath into the
+// directory dstDir, which is created if needed. Entries cannot be written
+// outside of dstDir. Existing files are only replaced with WithOverwrite. As the
+// archive is extracted while it is decrypted, an error can leave the files
+// extracted so far behind; their contents have been authenticated.
+func (e *Encryptor) ExtractArchive(srcPath, dstDir string) error {
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open encrypted file: %w", err)
+	}
+	defer file.Close()
+	src, err := e.progressReader(file, srcPath)
+	if err != nil {
+		return err
+	}
+	return e.ExtractArchiveFrom(src, dstDir)
+}
+
+// ExtractArchiveFrom extracts the archive read from r into dstDir like
+// ExtractArchive, e.g. while it is downloaded
+func (e *Encryptor) ExtractArchiveFrom(r io.Reader, dstDir string) error {
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	root, err := os.OpenRoot(dstDir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer root.Close()
+
+	// Directory permissions and times are applied last, extracting changes them
+	var dirs []*tar.Header
+	folder := nameFolder{}
+	// Hard links may only point to files extracted before from the archive
+	extracted := map[string]bool{}
+	err = e.readArchiveFrom(r, func(tr *tar.Reader, th *tar.Header) error {
+		name, ok, err := localName(th.Name)
+		if err != nil {
+			return fmt.Errorf("%w in archive", err)
+		}
+		if !ok {
+			e.skip(th.Name, "its name is not valid on this system")
+			return nil
+		}
+		// Directories differing in case are merged, everything else would be replaced
+		if other, found := folder.collision(name); found && th.Typeflag != tar.TypeDir {
+			e.skip(th.Name, fmt.Sprintf("its name differs only in case from %s", other))
+			return nil
+		}
+		switch th.Typeflag {
+		case tar.TypeDir:
+			if err := root.MkdirAll(name, 0700); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			dirs = append(dirs, th)
+			return nil
+		case tar.TypeReg:
+			if err := e.extractFile(root, name, tr); err != nil {
+				return err
+			}
+			extracted[name] = true
+		case tar.TypeLink:
+			target, ok, err := localName(th.Linkname)
+			if err != nil {
+				return fmt.Errorf("%w in archive", err)
+			}
+			if !ok {
+				e.skip(th.Name, "the name of the file it links to is not valid on this system")
+				return nil
+			}
+			if !extracted[target] {
+				e.skip(th.Name, "the file it links to is not an earlier file of the archive")
+				return nil
+			}
+			if e.overwrite {
+				root.Remove(name)
+			}
+			err = root.Link(target, name)
+			if errors.Is(err, fs.ErrNotExist) {
+				e.skip(th.Name, "the file it links to was not extracted")
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create hard link: %w", err)
+			}
+			return nil
+		case tar.TypeSymlink:
+			if e.overwrite {
+				root.Remove(name)
+			}
+			if err := root.Symlink(th.Linkname, name); err != nil {
+				return fmt.Errorf("failed to create link: %w", err)
+			}
+			if e.storeOwner {
+				root.Lchown(name, th.Uid, th.Gid)
+			}
+			return nil
+		default:
+			return nil
+		}
+		return restoreEntry(root, name, th, e.storeOwner)
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name, _, _ := localName(dirs[i].Name)
+		if err := restoreEntry(root, name, dirs[i], e.storeOwner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile writes the contents of the current archive entry to name
+func (e *Encryptor) extractFile(root *os.Root, name string, r io.Reader) error {
+	if dir := filepath.Dir(name); dir != "." {
+		if err := root.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !e.overwrite {
+		flags |= os.O_EXCL
+	}
+	file, err := root.OpenFile(name, flags, 0600)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%w: %s, overwrite is disabled", ErrFileExists, name)
+		}
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if _, err := e.copy(file, r); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	return nil
+}
+
+// restoreEntry applies the permissions, modification time and optionally owner of th to name
+func restoreEntry(root *os.Root, name string, th *tar.Header, owner bool) error {
+	if err := root.Chmod(name, th.FileInfo().Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to restore permissions: %w", err)
+	}
+	if err := root.Chtimes(name, th.ModTime, th.ModTime); err != nil {
+		return fmt.Errorf("failed to restore modification time: %w", err)
+	}
+	if owner {
+		if err := root.Lchown(name, th.Uid, th.Gid); err != nil {
+			return fmt.Errorf("failed to restore owner: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListArchive decrypts the archive at srcPath and calls fn for every entry. The
+// whole archive is authenticated before ListArchive returns nil.
+func (e *Encryptor) ListArchive(srcPath string, fn func(*tar.Header)) error {
+	return e.readArchive(srcPath, func(_ *tar.Reader, th *tar.Header) error {
+		fn(th)
+		return nil
+	})
+}
+
+// readArchive decrypts the archive at srcPath and calls fn for every entry, the
+// entry contents can be read from tr
+func (e *Encryptor) readArchive(srcPath string, fn func(tr *tar.Reader, th *tar.Header) error) error {
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open encrypted file: %w", err)
+	}
+	defer file.Close()
+	src, err := e.progressReader(file, srcPath)
+	if err != nil {
+		return err
+	}
+	return e.readArchiveFrom(src, fn)
+}
+
+// readArchiveFrom implements readArchive for the encrypted archive read from src
+func (e *Encryptor) readArchiveFrom(src io.Reader, fn func(tr *tar.Reader, th *tar.Header) error) error {
+	r, hdr, err := e.newReader(src)
+	if err != nil {
+		return err
+	}
+	if hdr.Version != 0 {
+		if md, err := hdr.metadata(); err != nil || !md.Archive {
+			return ErrNotArchive
+		}
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		th, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if errors.Is(err, ErrAuthFailed) {
+				return err
+			}
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if err := fn(tr, th); err != nil {
+			return err
+		}
+	}
+
+	// Read up to the end so the final chunk is authenticated as well
+	if _, err := e.copy(io.Discard, r); err != nil {
+		if errors.Is(err, ErrAuthFailed) {
+			return err
+		}
+		return fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return nil
+}
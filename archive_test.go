@@ -0,0 +1,194 @@
+package fileenc_test
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// TestArchiveHardLinks stores a file with two names once and restores both
+// names as links to the same file
+func TestArchiveHardLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links are archived as separate files on this platform")
+	}
+	src := filepath.Join(t.TempDir(), "tree")
+	data := randomData(8, 1<<20)
+	writeFiles(t, src, map[string][]byte{"a": data, "other": []byte("not linked")})
+	if err := os.Link(filepath.Join(src, "a"), filepath.Join(src, "b")); err != nil {
+		t.Fatal(err)
+	}
+	e := repoEncryptor(t)
+	archive := filepath.Join(t.TempDir(), "tree.enc")
+	if err := e.EncryptDir(src, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second name is a link record without data
+	in, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain bytes.Buffer
+	err = e.Decrypt(&plain, in)
+	in.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(&plain)
+	var stored, links int
+	for {
+		th, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case th.Typeflag == tar.TypeLink:
+			links++
+			if th.Name != "tree/b" || th.Linkname != "tree/a" || th.Size != 0 {
+				t.Errorf("link record %s -> %s with %d bytes", th.Name, th.Linkname, th.Size)
+			}
+		case th.Size == int64(len(data)):
+			stored++
+		}
+	}
+	if stored != 1 || links != 1 {
+		t.Fatalf("linked file stored %d times with %d link records, want once with one", stored, links)
+	}
+
+	dst := t.TempDir()
+	if err := e.ExtractArchive(archive, dst); err != nil {
+		t.Fatal(err)
+	}
+	a, err := os.Stat(filepath.Join(dst, "tree", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(filepath.Join(dst, "tree", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Error("a and b restored as separate files")
+	}
+	if got, err := os.ReadFile(filepath.Join(dst, "tree", "b")); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("b restored with different content: %v", err)
+	}
+	if other, err := os.Stat(filepath.Join(dst, "tree", "other")); err != nil || os.SameFile(a, other) {
+		t.Errorf("unlinked file restored as link: %v", err)
+	}
+}
+
+// craftedArchive encrypts a tar archive of the entries, a header with a
+// Linkname is a hard link record
+func craftedArchive(t *testing.T, e *fileenc.Encryptor, entries []tar.Header) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, th := range entries {
+		th.Mode = 0600
+		content := "content of " + th.Name
+		if th.Linkname != "" {
+			th.Typeflag = tar.TypeLink
+		} else {
+			th.Typeflag, th.Size = tar.TypeReg, int64(len(content))
+		}
+		if err := tw.WriteHeader(&th); err != nil {
+			t.Fatal(err)
+		}
+		if th.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "crafted.enc")
+	archiver := repoEncryptor(t, fileenc.WithMetadata(fileenc.Metadata{Name: "x", Archive: true, UID: -1, GID: -1}))
+	var out bytes.Buffer
+	if err := archiver.Encrypt(&out, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, out.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestArchiveLinkRejected checks that hard link records only link to files
+// extracted before from the same archive
+func TestArchiveLinkRejected(t *testing.T) {
+	var skipped []string
+	e := repoEncryptor(t, fileenc.WithSkipFunc(func(path, reason string) {
+		skipped = append(skipped, path)
+	}))
+
+	// Links to a later entry or to a file that was there before are skipped
+	dst := t.TempDir()
+	writeFiles(t, dst, map[string][]byte{"x/victim": []byte("not from the archive")})
+	archive := craftedArchive(t, e, []tar.Header{
+		{Name: "x/early", Linkname: "x/target"},
+		{Name: "x/target"},
+		{Name: "x/existing", Linkname: "x/victim"},
+		{Name: "x/late", Linkname: "x/target"},
+	})
+	if err := e.ExtractArchive(archive, dst); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(skipped, ",") != "x/early,x/existing" {
+		t.Errorf("skipped %v, want x/early and x/existing", skipped)
+	}
+	for _, name := range []string{"early", "existing"} {
+		if _, err := os.Lstat(filepath.Join(dst, "x", name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("link %s was created", name)
+		}
+	}
+	target, err := os.Stat(filepath.Join(dst, "x", "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if late, err := os.Stat(filepath.Join(dst, "x", "late")); runtime.GOOS != "windows" && (err != nil || !os.SameFile(target, late)) {
+		t.Errorf("link to an earlier entry not created: %v", err)
+	}
+
+	// Links leaving the destination fail the extraction
+	for _, link := range []string{"../outside", "/etc/passwd", "x/../../outside"} {
+		archive := craftedArchive(t, e, []tar.Header{{Name: "x/target"}, {Name: "x/escape", Linkname: link}})
+		dst := filepath.Join(t.TempDir(), "dst")
+		if err := e.ExtractArchive(archive, dst); err == nil {
+			t.Errorf("link to %s extracted", link)
+		}
+		if _, err := os.Lstat(filepath.Join(dst, "x", "escape")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("link to %s was created", link)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age/armor"
+)
+
+const (
+	// armorHeader and armorFooter enclose the Base64 lines of an armored fileenc file
+	armorHeader = "-----BEGIN FILEENC ENCRYPTED FILE-----"
+	armorFooter = "-----END FILEENC ENCRYPTED FILE-----"
+	// armorColumns is the length of the Base64 lines
+	armorColumns = 64
+	// maxArmorLine is the longest line accepted when reading armor, lines
+	// wrapped by other tools are tolerated but a missing newline is not
+	// buffered without limit
+	maxArmorLine = 4096
+	// textPrefix starts the one line text form written by EncryptText
+	textPrefix = "FILEENC:"
+)
+
+// ErrInvalidArmor is returned when armored data is not valid Base64 or lacks its END line
+var ErrInvalidArmor = errors.New("invalid armor")
+
+// WithArmor makes encryption write ASCII armor: the encrypted data as Base64
+// lines between BEGIN and END markers, which survives being pasted into mails,
+// tickets or configuration files. The age and OpenPGP formats use their own
+// armor, so the output can still be decrypted with age and gpg. Decryption
+// recognizes armor automatically.
+func WithArmor() Option {
+	return func(e *Encryptor) {
+		e.armor = true
+	}
+}
+
+// newArmorWriter returns a writer armoring everything written to it to w in
+// the style of the format
+func newArmorWriter(w io.Writer, format string) io.WriteCloser {
+	switch format {
+	case FormatAge:
+		return armor.NewWriter(w)
+	case FormatOpenPGP:
+		return newBase64Armor(w, pgpArmorHeader+"\n\n", pgpArmorFooter, true)
+	}
+	return newBase64Armor(w, armorHeader+"\n", armorFooter, false)
+}
+
+// base64Armor writes Base64 lines between a header and a footer, optionally
+// followed by the CRC-24 checksum of OpenPGP armor (RFC 4880 section 6)
+type base64Armor struct {
+	w       io.Writer
+	b64     io.WriteCloser
+	lines   *lineWriter
+	header  string
+	footer  string
+	crc     uint32
+	withCRC bool
+}
+
+// newBase64Armor returns a base64Armor writing to w, the header is written with the first data
+func newBase64Armor(w io.Writer, header, footer string, withCRC bool) *base64Armor {
+	lines := &lineWriter{w: w}
+	return &base64Armor{
+		w: w, lines: lines, b64: base64.NewEncoder(base64.StdEncoding, lines),
+		header: header, footer: footer, crc: crc24Init, withCRC: withCRC,
+	}
+}
+
+// Write encodes p
+func (a *base64Armor) Write(p []byte) (int, error) {
+	if err := a.writeHeader(); err != nil {
+		return 0, err
+	}
+	if a.withCRC {
+		a.crc = crc24(a.crc, p)
+	}
+	return a.b64.Write(p)
+}
+
+// writeHeader writes the header once
+func (a *base64Armor) writeHeader() error {
+	if a.header == "" {
+		return nil
+	}
+	_, err := io.WriteString(a.w, a.header)
+	a.header = ""
+	return err
+}
+
+// Close flushes the last line and writes the checksum and the footer, it does not close w
+func (a *base64Armor) Close() error {
+	if err := a.writeHeader(); err != nil {
+		return err
+	}
+	if err := a.b64.Close(); err != nil {
+		return err
+	}
+	trailer := ""
+	if a.lines.column > 0 {
+		trailer = "\n"
+	}
+	if a.withCRC {
+		sum := []byte{byte(a.crc >> 16), byte(a.crc >> 8), byte(a.crc)}
+		trailer += "=" + base64.StdEncoding.EncodeToString(sum) + "\n"
+	}
+	_, err := io.WriteString(a.w, trailer+a.footer+"\n")
+	return err
+}
+
+// lineWriter breaks the written text into lines of armorColumns characters
+type lineWriter struct {
+	w      io.Writer
+	column int
+}
+
+// Write writes p, inserting line breaks
+func (l *lineWriter) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		n := min(len(p), armorColumns-l.column)
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return total, err
+		}
+		total += n
+		l.column += n
+		p = p[n:]
+		if l.column == armorColumns {
+			if _, err := l.w.Write([]byte{'\n'}); err != nil {
+				return total, err
+			}
+			l.column = 0
+		}
+	}
+	return total, nil
+}
+
+// crc24Init and crc24Poly define the CRC-24 of OpenPGP armor
+const (
+	crc24Init = 0xb704ce
+	crc24Poly = 0x1864cfb
+)
+
+// crc24 updates crc with p
+func crc24(crc uint32, p []byte) uint32 {
+	for _, b := range p {
+		crc ^= uint32(b) << 16
+		for range 8 {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= crc24Poly
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+// isArmored reports whether the data buffered in br starts with fileenc armor
+func isArmored(br *bufio.Reader) bool {
+	b, err := br.Peek(len(armorHeader))
+	return err == nil && string(b) == armorHeader
+}
+
+// armorReader decodes fileenc armor
+type armorReader struct {
+	br   *bufio.Reader
+	buf  []byte
+	done bool
+}
+
+// newArmorReader returns a reader decoding the armored data in br, which must start with the header line
+func newArmorReader(br *bufio.Reader) (*armorReader, error) {
+	line, err := readLine(br, maxArmorLine)
+	if err != nil || strings.TrimSpace(line) != armorHeader {
+		return nil, fmt.Errorf("%w: missing BEGIN line", ErrInvalidArmor)
+	}
+	return &armorReader{br: br}, nil
+}
+
+// Read decodes the next lines until the footer
+func (a *armorReader) Read(p []byte) (int, error) {
+	for len(a.buf) == 0 {
+		if a.done {
+			return 0, io.EOF
+		}
+		line, err := readLine(a.br, maxArmorLine)
+		if errors.Is(err, errLineTooLong) {
+			return 0, fmt.Errorf("%w: %v", ErrInvalidArmor, err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == armorFooter:
+			a.done = true
+			continue
+		case line == "" && err != nil:
+			return 0, fmt.Errorf("%w: missing END line", ErrInvalidArmor)
+		case err != nil && err != io.EOF:
+			return 0, err
+		}
+		if a.buf, err = base64.StdEncoding.DecodeString(line); err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrInvalidArmor, err)
+		}
+	}
+	n := copy(p, a.buf)
+	a.buf = a.buf[n:]
+	return n, nil
+}
+
+// errLineTooLong is returned by readLine for lines longer than the limit
+var errLineTooLong = errors.New("line too long")
+
+// readLine reads a line of at most max bytes including the newline from br
+func readLine(br *bufio.Reader, max int) (string, error) {
+	var line []byte
+	for {
+		part, err := br.ReadSlice('\n')
+		if len(line)+len(part) > max {
+			return "", errLineTooLong
+		}
+		line = append(line, part...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+// EncryptText encrypts a short secret like an API token and returns it as a
+// single line of text: FILEENC: followed by the Base64 encrypted data, which
+// fits into environment variables and configuration files.
+func (e *Encryptor) EncryptText(plaintext []byte) (string, error) {
+	if e.format != FormatFileenc {
+		return "", errors.New("text encryption requires the fileenc format")
+	}
+	var buf bytes.Buffer
+	te := *e
+	te.armor = false
+	if err := te.Encrypt(&buf, bytes.NewReader(plaintext)); err != nil {
+		return "", err
+	}
+	return textPrefix + base64.RawStdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecryptText decrypts text written by EncryptText. Armored files are accepted as well.
+func (e *Encryptor) DecryptText(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	var src io.Reader = strings.NewReader(text)
+	if data, ok := strings.CutPrefix(text, textPrefix); ok {
+		raw, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(data, "="))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArmor, err)
+		}
+		src = bytes.NewReader(raw)
+	}
+	var buf bytes.Buffer
+	if err := e.Decrypt(&buf, src); err != nil {
+		clear(buf.Bytes())
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,69 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultBufferSize is the size of the buffers data is copied through unless
+// WithBufferSize sets another, four chunks
+const DefaultBufferSize = 4 * chunkSize
+
+// WithBufferSize sets the size of the buffers Encrypt, Decrypt and the file
+// functions copy data through, n <= 0 selects DefaultBufferSize. The buffers
+// are pooled and reused across files. The encrypting writers and decrypting
+// readers fill and drain their chunks directly where they can, without a buffer.
+func WithBufferSize(n int) Option {
+	return func(e *Encryptor) {
+		if n <= 0 {
+			n = DefaultBufferSize
+		}
+		e.bufferSize = n
+	}
+}
+
+// bufferPools holds a *sync.Pool of *[]byte for every buffer size in use
+var bufferPools sync.Map
+
+// getBuffer returns a buffer of size bytes from its pool
+func getBuffer(size int) *[]byte {
+	pool, ok := bufferPools.Load(size)
+	if !ok {
+		pool, _ = bufferPools.LoadOrStore(size, &sync.Pool{New: func() any {
+			buf := make([]byte, size)
+			return &buf
+		}})
+	}
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
+
+// putBuffer returns a buffer from getBuffer to its pool
+func putBuffer(buf *[]byte) {
+	if pool, ok := bufferPools.Load(len(*buf)); ok {
+		pool.(*sync.Pool).Put(buf)
+	}
+}
+
+// copy copies src to dst like io.Copy, through a pooled buffer of the size set
+// with WithBufferSize if neither of them can copy without one
+func (e *Encryptor) copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := getBuffer(e.bufferSize)
+	defer putBuffer(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
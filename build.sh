@@ -22,7 +22,7 @@ for PLATFORM in "${PLATFORMS[@]}"; do
     fi
 
     echo "Building $PLATFORM/$ARCH..."
-    GOOS="$PLATFORM" GOARCH="$ARCH" go build -o "$OUTPUT_DIR/$OUTPUT_NAME" .
+    GOOS="$PLATFORM" GOARCH="$ARCH" go build -o "$OUTPUT_DIR/$OUTPUT_NAME" ./cmd/fileenc
     
     # Fehlerprüfung
     if [ $? -ne 0 ]; then
@@ -0,0 +1,87 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// dropWindow is the amount of data read before its pages are dropped from the cache
+const dropWindow = 8 << 20
+
+// WithDropCache keeps the file functions from filling the page cache with the
+// data they read and write, so encrypting terabytes does not evict the cache
+// of other programs like databases. On Linux the pages are dropped as the
+// source is read and once the output is complete, which syncs it to disk first.
+// On macOS sources are read past the cache, elsewhere the option has no effect.
+func WithDropCache() Option {
+	return func(e *Encryptor) {
+		e.dropCache = true
+	}
+}
+
+// cacheDropper drops the pages of a file from the page cache once they have been read
+type cacheDropper struct {
+	f       *os.File
+	off     int64
+	dropped int64
+}
+
+// uncachedReader returns file as reader dropping what it read from the cache
+// with WithDropCache, else file
+func (e *Encryptor) uncachedReader(file *os.File) io.Reader {
+	if !e.dropCache {
+		return file
+	}
+	noCache(file)
+	return &cacheDropper{f: file}
+}
+
+// Read reads from the file and drops the data read so far every dropWindow bytes
+// and at the end. Pages that cannot be dropped are left alone.
+func (c *cacheDropper) Read(p []byte) (int, error) {
+	n, err := c.f.Read(p)
+	c.off += int64(n)
+	if c.off-c.dropped >= dropWindow || err != nil {
+		dropCache(c.f, 0, c.off)
+		c.dropped = c.off
+	}
+	return n, err
+}
+
+// dropOutput syncs the complete output w and drops it from the page cache with
+// WithDropCache. Outputs that are no files are left alone.
+func (e *Encryptor) dropOutput(w io.Writer) error {
+	var file *os.File
+	switch w := w.(type) {
+	case *os.File:
+		file = w
+	case *holeWriter:
+		file = w.f
+	}
+	if !e.dropCache || file == nil {
+		return nil
+	}
+	// Dirty pages are not dropped, they have to be written first
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.Name(), err)
+	}
+	dropCache(file, 0, 0)
+	return nil
+}
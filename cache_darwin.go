@@ -0,0 +1,33 @@
+//go:build darwin
+
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// noCache makes the reads and writes of f bypass the unified buffer cache
+func noCache(f *os.File) {
+	unix.FcntlInt(f.Fd(), unix.F_NOCACHE, 1)
+}
+
+// dropCache does nothing, files opened with noCache are not cached
+func dropCache(f *os.File, off, n int64) {}
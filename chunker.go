@@ -0,0 +1,106 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Content defined chunking cuts a stream where its content says, so inserting
+// or removing data only changes the chunks around the change and the others
+// are deduplicated
+const (
+	// cdcMinSize and cdcMaxSize bound the size of the chunks
+	cdcMinSize = 256 << 10
+	cdcMaxSize = 4 << 20
+	// cdcMask selects the hash bits that must be zero at a cut, 20 bits give
+	// chunks of about 1 MiB on top of the minimum
+	cdcMask = uint64(1<<20-1) << 44
+)
+
+// gearTable holds the random values of the gear hash
+type gearTable [256]uint64
+
+// newGearTable derives the gear table from key, so the chunk boundaries
+// reveal nothing about the content to those without it
+func newGearTable(key []byte) (*gearTable, error) {
+	data, err := hkdf.Key(sha256.New, key, nil, "fileenc chunker gear", 256*8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive chunker table: %w", err)
+	}
+	var g gearTable
+	for i := range g {
+		g[i] = binary.BigEndian.Uint64(data[8*i:])
+	}
+	return &g, nil
+}
+
+// chunker splits the data read from r into content defined chunks
+type chunker struct {
+	r    io.Reader
+	gear *gearTable
+	buf  []byte
+	n    int
+	eof  bool
+}
+
+// newChunker returns a chunker for r
+func newChunker(r io.Reader, gear *gearTable) *chunker {
+	return &chunker{r: r, gear: gear, buf: make([]byte, cdcMaxSize)}
+}
+
+// next returns the next chunk, a copy the caller owns, and io.EOF after the last one
+func (c *chunker) next() ([]byte, error) {
+	for c.n < len(c.buf) && !c.eof {
+		m, err := c.r.Read(c.buf[c.n:])
+		c.n += m
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+	cut := c.cut(c.buf[:c.n])
+	chunk := slices.Clone(c.buf[:cut])
+	c.n = copy(c.buf, c.buf[cut:c.n])
+	return chunk, nil
+}
+
+// cut returns the length of the chunk at the start of data, which holds
+// cdcMaxSize bytes unless the stream ends
+func (c *chunker) cut(data []byte) int {
+	if len(data) <= cdcMinSize {
+		return len(data)
+	}
+	var h uint64
+	for i := cdcMinSize; i < len(data); i++ {
+		h = h<<1 + c.gear[data[i]]
+		if h&cdcMask == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
@@ -0,0 +1,366 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// CipherAESGCM selects chunked, authenticated AES-GCM encryption
+	CipherAESGCM = "aes-gcm"
+	// CipherAESCFB selects unauthenticated AES-CFB encryption as used by older fileenc versions
+	CipherAESCFB = "aes-cfb"
+	// CipherChaCha20Poly1305 selects chunked, authenticated ChaCha20-Poly1305
+	// encryption, which is faster than AES on CPUs without AES instructions
+	CipherChaCha20Poly1305 = "chacha20-poly1305"
+	// CipherXChaCha20Poly1305 selects ChaCha20-Poly1305 with extended 192 bit nonces
+	CipherXChaCha20Poly1305 = "xchacha20-poly1305"
+
+	// chunkSize is the amount of plaintext sealed into a single chunk
+	chunkSize = 64 * 1024
+	// tagSize is the length of the authentication tag appended to every chunk,
+	// it is the same for all authenticated ciphers
+	tagSize = 16
+)
+
+// ErrAuthFailed is returned when a chunk fails authentication
+var ErrAuthFailed = errors.New("authentication failed, file is corrupt, truncated or has been tampered with")
+
+// newCFBWriter returns a writer encrypting to w with AES-CFB
+func newCFBWriter(w io.Writer, key, iv []byte) (io.WriteCloser, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return nopCloser{&cipher.StreamWriter{S: cipher.NewCFBEncrypter(block, iv), W: w}}, nil
+}
+
+// newCFBReader returns a reader decrypting AES-CFB data read from r
+func newCFBReader(r io.Reader, key, iv []byte) (io.Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid IV length %d", len(iv))
+	}
+	return &cipher.StreamReader{S: cipher.NewCFBDecrypter(block, iv), R: r}, nil
+}
+
+// nopCloser turns a writer into an io.WriteCloser without closing the underlying writer
+type nopCloser struct {
+	io.Writer
+}
+
+// Close does nothing
+func (nopCloser) Close() error {
+	return nil
+}
+
+// newChunkAEAD derives a per-file subkey from key and salt and returns an
+// instance of the authenticated cipher name using it
+func newChunkAEAD(name string, key, salt []byte) (cipher.AEAD, error) {
+	if s, ok := registeredCipher(name); ok {
+		return newSuiteAEAD(name, s, key, salt)
+	}
+	// AES keeps the key size, ChaCha20 always uses 256 bit keys
+	size := len(key)
+	if name != CipherAESGCM {
+		size = chacha20poly1305.KeySize
+	}
+	subkey, err := hkdf.Key(sha256.New, key, salt, "fileenc "+name, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive subkey: %w", err)
+	}
+	defer clear(subkey)
+
+	switch name {
+	case CipherChaCha20Poly1305:
+		return chacha20poly1305.New(subkey)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(subkey)
+	}
+	block, err := aes.NewCipher(subkey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// newSuiteAEAD derives the subkey for the registered cipher suite s and
+// checks that its AEAD fits the chunk layout
+func newSuiteAEAD(name string, s CipherSuite, key, salt []byte) (cipher.AEAD, error) {
+	size := s.KeySize
+	if size == 0 {
+		size = len(key)
+	}
+	subkey, err := hkdf.Key(sha256.New, key, salt, "fileenc "+name, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive subkey: %w", err)
+	}
+	defer clear(subkey)
+	aead, err := s.New(subkey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	if aead.Overhead() != tagSize || aead.NonceSize() < 9 {
+		return nil, fmt.Errorf("cipher %s does not fit the chunk layout, it needs 16 byte tags and nonces of 9 bytes or more", name)
+	}
+	return aead, nil
+}
+
+// keyCheckSize is the length of the key check value stored in the header
+const keyCheckSize = 16
+
+// keyCheck derives the key check value of key, salted with the IV like the
+// chunk subkey. It reveals nothing about the key beyond allowing to test a
+// guess, which the first chunk allows anyway.
+func keyCheck(key, iv []byte) ([]byte, error) {
+	kcv, err := hkdf.Key(sha256.New, key, iv, "fileenc key check", keyCheckSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key check: %w", err)
+	}
+	return kcv, nil
+}
+
+// chunkNonce builds the nonce of chunk number n for aead. The counter fills the
+// 8 bytes in front of the last byte, which flags the final chunk so truncation
+// is detected.
+func chunkNonce(aead cipher.AEAD, n uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:len(nonce)-1], n)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// chunkWriter seals the data written to it in chunks of chunkSize. Every chunk
+// authenticates the file header, the final chunk is written on Close.
+type chunkWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	hdr     []byte
+	buf     []byte
+	out     []byte
+	counter uint64
+	closed  bool
+	err     error
+}
+
+// newChunkWriter returns a writer sealing to w with the authenticated cipher name
+func newChunkWriter(w io.Writer, name string, key, salt, hdr []byte) (*chunkWriter, error) {
+	aead, err := newChunkAEAD(name, key, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkWriter{
+		w:    w,
+		aead: aead,
+		hdr:  hdr,
+		buf:  make([]byte, 0, chunkSize+1),
+		out:  make([]byte, 0, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+// Write buffers p and seals every full chunk once more data follows it
+func (g *chunkWriter) Write(p []byte) (int, error) {
+	if g.closed {
+		return 0, errors.New("write to closed encrypting writer")
+	}
+	if g.err != nil {
+		return 0, g.err
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed when more data arrives, it could be the final chunk
+		if len(g.buf) == chunkSize {
+			if g.err = g.seal(false); g.err != nil {
+				return written, g.err
+			}
+		}
+		n := copy(g.buf[len(g.buf):chunkSize], p)
+		g.buf = g.buf[:len(g.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// ReadFrom reads r straight into the chunk buffer, saving io.Copy the copy
+// through its own buffer. One byte more than a chunk is read, so a full chunk
+// is only sealed once more data follows it.
+func (g *chunkWriter) ReadFrom(r io.Reader) (int64, error) {
+	if g.closed {
+		return 0, errors.New("write to closed encrypting writer")
+	}
+	if g.err != nil {
+		return 0, g.err
+	}
+	var total int64
+	for {
+		n, err := r.Read(g.buf[len(g.buf) : chunkSize+1])
+		g.buf = g.buf[:len(g.buf)+n]
+		total += int64(n)
+		if len(g.buf) > chunkSize {
+			next := g.buf[chunkSize]
+			g.buf = g.buf[:chunkSize]
+			if g.err = g.seal(false); g.err != nil {
+				return total, g.err
+			}
+			g.buf = append(g.buf, next)
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Close seals the final chunk, it does not close the underlying writer
+func (g *chunkWriter) Close() error {
+	if g.closed || g.err != nil {
+		return g.err
+	}
+	g.closed = true
+	g.err = g.seal(true)
+	return g.err
+}
+
+// seal encrypts the buffered chunk and writes it to the underlying writer
+func (g *chunkWriter) seal(last bool) error {
+	g.out = g.aead.Seal(g.out[:0], chunkNonce(g.aead, g.counter, last), g.buf, g.hdr)
+	g.counter++
+	g.buf = g.buf[:0]
+	if _, err := g.w.Write(g.out); err != nil {
+		return fmt.Errorf("failed to write encrypted data: %w", err)
+	}
+	return nil
+}
+
+// chunkReader opens the sealed chunks read from r. Plaintext is only
+// returned after its chunk has been authenticated.
+type chunkReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	hdr     []byte
+	buf     []byte
+	pending int
+	plain   []byte
+	out     []byte
+	counter uint64
+	eof     bool
+	err     error
+}
+
+// newChunkReader returns a reader opening the chunks sealed with the
+// authenticated cipher name read from r
+func newChunkReader(r io.Reader, name string, key, salt, hdr []byte) (*chunkReader, error) {
+	aead, err := newChunkAEAD(name, key, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{
+		r:     r,
+		aead:  aead,
+		hdr:   hdr,
+		buf:   make([]byte, chunkSize+aead.Overhead()+1),
+		plain: make([]byte, 0, chunkSize),
+	}, nil
+}
+
+// Read returns decrypted and authenticated plaintext
+func (g *chunkReader) Read(p []byte) (int, error) {
+	for len(g.out) == 0 {
+		if g.err != nil {
+			return 0, g.err
+		}
+		if g.eof {
+			return 0, io.EOF
+		}
+		g.err = g.open()
+	}
+	n := copy(p, g.out)
+	g.out = g.out[n:]
+	return n, nil
+}
+
+// WriteTo writes the plaintext of every chunk straight to w, saving io.Copy
+// the copy through its own buffer
+func (g *chunkReader) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		if len(g.out) > 0 {
+			n, err := w.Write(g.out)
+			total += int64(n)
+			g.out = g.out[n:]
+			if err != nil {
+				return total, err
+			}
+		}
+		if g.err != nil {
+			return total, g.err
+		}
+		if g.eof {
+			return total, nil
+		}
+		g.err = g.open()
+	}
+}
+
+// open reads and authenticates the next chunk. One byte is read ahead so the
+// final chunk can be recognized.
+func (g *chunkReader) open() error {
+	encSize := chunkSize + g.aead.Overhead()
+	n, err := io.ReadFull(g.r, g.buf[g.pending:])
+	n += g.pending
+	last := false
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return fmt.Errorf("failed to read encrypted data: %w", err)
+	}
+	end := n
+	if !last {
+		end = encSize
+	}
+	carry := g.buf[encSize]
+	g.plain, err = g.aead.Open(g.plain[:0], chunkNonce(g.aead, g.counter, last), g.buf[:end], g.hdr)
+	if err != nil {
+		return ErrAuthFailed
+	}
+	g.out = g.plain
+	g.counter++
+	g.eof = last
+	g.buf[0] = carry
+	g.pending = 1
+	return nil
+}
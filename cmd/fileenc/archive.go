@@ -0,0 +1,162 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"archive/tar"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// runArchive implements "fileenc archive [options] <dir>"
+func runArchive(args []string) {
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	keys := addKeyFlags(fs, true)
+	ciphers := addCipherFlags(fs)
+	metadata := addMetadataFlags(fs)
+	output := fs.String("o", "", "encrypted archive to create, default <dir>.tar"+encExt)
+	overwrite := fs.Bool("overwrite", false, "replace an existing archive")
+	follow := fs.Bool("follow-symlinks", false, "archive the files and directories symbolic links point to instead of the links")
+	quiet := fs.Bool("quiet", false, "only report errors, no progress or success messages")
+	progressFlag := fs.String("progress", progressAuto, "progress reporting on stderr: auto (bar on terminals), bar, json or none")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc archive [options] <dir>")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	dir := args[0]
+	if *output == "" {
+		*output = filepath.Clean(dir) + ".tar" + encExt
+	}
+
+	opts, err := ciphers.options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	opts = append(opts, fileenc.WithOverwrite(*overwrite))
+	opts = append(opts, metadata.options()...)
+	opts = append(opts, fileenc.WithSkipFunc(func(path, reason string) {
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s, %s\n", path, reason)
+	}))
+	if *follow {
+		opts = append(opts, fileenc.WithFollowSymlinks())
+	}
+	enc, key := newEncryptor(keys, false, *progressFlag, *quiet, opts)
+
+	// Ctrl-C removes the partial archive
+	ctx, stop := interruptContext()
+	err = enc.EncryptDirContext(ctx, dir, *output)
+	stop()
+	clear(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error archiving %s: %v\n", dir, err)
+		os.Exit(exitCode(err, exitFailure))
+	}
+	if !*quiet {
+		fmt.Printf("Directory %s archived to %s successfully.\n", dir, *output)
+	}
+}
+
+// runExtract implements "fileenc extract [options] <archive>"
+func runExtract(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	keys := addKeyFlags(fs, false)
+	dir := fs.String("C", ".", "directory to extract into")
+	list := fs.Bool("list", false, "only list the contents of the archive")
+	overwrite := fs.Bool("overwrite", false, "replace existing files")
+	owner := fs.Bool("owner", false, "restore the owner of the files, requires root")
+	quiet := fs.Bool("quiet", false, "only report errors, no progress or success messages")
+	progressFlag := fs.String("progress", progressAuto, "progress reporting on stderr: auto (bar on terminals), bar, json or none")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc extract [options] <archive>")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	archive := args[0]
+
+	opts := []fileenc.Option{fileenc.WithOverwrite(*overwrite), fileenc.WithFileMetadata(false, *owner),
+		fileenc.WithSkipFunc(func(path, reason string) {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s, %s\n", path, reason)
+		})}
+	enc, key := newEncryptor(keys, true, *progressFlag, *quiet || *list, opts)
+
+	if *list {
+		err := enc.ListArchive(archive, func(th *tar.Header) {
+			name := th.Name
+			if th.Typeflag == tar.TypeLink {
+				name += " link to " + th.Linkname
+			}
+			fmt.Printf("%s %10d %s %s\n", th.FileInfo().Mode(), th.Size, th.ModTime.Format(time.DateTime), name)
+		})
+		clear(key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listing %s: %v\n", archive, err)
+			os.Exit(exitCode(err, exitFailure))
+		}
+		return
+	}
+
+	ctx, stop := interruptContext()
+	err := enc.ExtractArchiveContext(ctx, archive, *dir)
+	stop()
+	clear(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error extracting %s: %v\n", archive, err)
+		os.Exit(exitCode(err, exitFailure))
+	}
+	if !*quiet {
+		fmt.Printf("Archive %s extracted to %s successfully.\n", archive, *dir)
+	}
+}
+
+// newEncryptor loads the key and creates the Encryptor for a subcommand, it exits on errors
+func newEncryptor(keys *keyFlags, decrypt bool, progressMode string, quiet bool, opts []fileenc.Option) (*fileenc.Encryptor, []byte) {
+	progress, err := newProgressPrinter(progressMode, quiet)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	if progress != nil {
+		opts = append(opts, fileenc.WithProgress(progress.update))
+	}
+	key, keyOpts, err := keys.load(decrypt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	enc, err := fileenc.New(key, append(opts, keyOpts...)...)
+	if err != nil {
+		clear(key)
+		fmt.Fprintf(os.Stderr, "Invalid settings: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	return enc, key
+}
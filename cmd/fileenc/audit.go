@@ -0,0 +1,277 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// auditLogEnv sets the audit log when -audit-log is not given, so
+// administrators can enable it for all users
+const auditLogEnv = "FILEENC_AUDIT_LOG"
+
+// auditEntry records one operation in the audit log
+type auditEntry struct {
+	Seq       int64  `json:"seq"`
+	Time      string `json:"time"`
+	User      string `json:"user"`
+	Host      string `json:"host"`
+	Operation string `json:"operation"`
+	File      string `json:"file"`
+	Output    string `json:"output"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	BytesIn   int64  `json:"bytes_in"`
+	BytesOut  int64  `json:"bytes_out"`
+	// Attempts is the number of times the passphrase was typed, if it was
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// auditLine is a line of the audit log: the entry and the hash chaining it to
+// the previous line. The hash covers the entry as written, so changing,
+// removing or reordering lines breaks the chain.
+type auditLine struct {
+	Entry json.RawMessage `json:"entry"`
+	Hash  string          `json:"hash"`
+}
+
+// auditLog appends hash-chained entries to a file. Every entry is written
+// under an exclusive lock and synced, so several processes can share a log.
+type auditLog struct {
+	path string
+	mu   sync.Mutex
+	// attempts is recorded with every entry, see auditEntry
+	attempts int
+}
+
+// auditHash chains entry to the hash of the previous line, the first line
+// follows 32 zero bytes
+func auditHash(prev []byte, entry []byte) []byte {
+	h := sha256.New()
+	h.Write(prev)
+	h.Write(entry)
+	return h.Sum(nil)
+}
+
+// auditUser returns the name of the user running fileenc
+var auditUser = sync.OnceValue(func() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return fmt.Sprint(os.Getuid())
+})
+
+// record appends the outcome of an operation that read bytesIn bytes from in
+// and wrote bytesOut bytes to out, started at start
+func (a *auditLog) record(operation, in, out string, bytesIn, bytesOut int64, start time.Time, err error) error {
+	e := auditEntry{
+		Time: start.UTC().Format(time.RFC3339Nano), User: auditUser(), Operation: operation,
+		File: auditPath(in), Output: auditPath(out), Status: "ok", BytesIn: bytesIn, BytesOut: bytesOut,
+		Attempts: a.attempts,
+	}
+	e.Host, _ = os.Hostname()
+	switch {
+	case errors.Is(err, errUnchanged):
+		e.Status = "unchanged"
+	case err != nil:
+		e.Status, e.Error = "error", err.Error()
+	}
+	return a.append(e)
+}
+
+// auditPath returns the absolute path of a local file, URLs without their
+// credentials and "-" for stdin and stdout
+func auditPath(name string) string {
+	if name == "-" {
+		return name
+	}
+	if u, _, ok := fileenc.StorageURL(name); ok {
+		return u.Redacted()
+	}
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return name
+}
+
+// auditCounter counts the bytes read or written through it for the audit log
+type auditCounter struct {
+	r io.Reader
+	w io.Writer
+	n int64
+}
+
+func (c *auditCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *auditCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// append numbers e, chains it to the last line and writes it
+func (a *auditLog) append(e auditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	file, err := os.OpenFile(a.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the audit log: %w", err)
+	}
+	defer file.Close()
+	if err := lockAuditLog(file); err != nil {
+		return fmt.Errorf("failed to lock the audit log: %w", err)
+	}
+	last, err := lastAuditLine(file)
+	if err != nil {
+		return err
+	}
+	prev := make([]byte, sha256.Size)
+	if last != nil {
+		var prevEntry auditEntry
+		if prev, err = hex.DecodeString(last.Hash); err != nil || json.Unmarshal(last.Entry, &prevEntry) != nil {
+			return fmt.Errorf("the last line of the audit log %s is damaged, check it with fileenc audit verify", a.path)
+		}
+		e.Seq = prevEntry.Seq
+	}
+	e.Seq++
+	entry, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(auditLine{Entry: entry, Hash: hex.EncodeToString(auditHash(prev, entry))})
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write the audit log: %w", err)
+	}
+	return file.Sync()
+}
+
+// lastAuditLine returns the last line of the audit log, nil if it is empty
+func lastAuditLine(file *os.File) (*auditLine, error) {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return nil, err
+	}
+	// Entries are short, the last one is within the tail
+	offset := max(info.Size()-64<<10, 0)
+	tail := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read the audit log: %w", err)
+	}
+	tail = bytes.TrimSuffix(tail, []byte("\n"))
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	var last auditLine
+	if err := json.Unmarshal(tail, &last); err != nil {
+		return nil, fmt.Errorf("the last line of the audit log %s is damaged, check it with fileenc audit verify", file.Name())
+	}
+	return &last, nil
+}
+
+// verifyAuditLog checks the hash chain and numbering of the log read from r
+// and returns the number of entries and the last hash. If anchor is set, the
+// line with this hash must be part of the log.
+func verifyAuditLog(r io.Reader, anchor string) (int64, string, error) {
+	prev := make([]byte, sha256.Size)
+	var n int64
+	found := anchor == ""
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		n++
+		var line auditLine
+		var e auditEntry
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			return n, "", fmt.Errorf("line %d is damaged: %w", n, err)
+		}
+		if err := json.Unmarshal(line.Entry, &e); err != nil {
+			return n, "", fmt.Errorf("line %d is damaged: %w", n, err)
+		}
+		hash := auditHash(prev, line.Entry)
+		if line.Hash != hex.EncodeToString(hash) {
+			return n, "", fmt.Errorf("line %d does not match its hash, it or an earlier line was changed, removed or inserted", n)
+		}
+		if e.Seq != n {
+			return n, "", fmt.Errorf("line %d has number %d, the log does not start with its first entry", n, e.Seq)
+		}
+		found = found || line.Hash == anchor
+		prev = hash
+	}
+	if err := sc.Err(); err != nil {
+		return n, "", err
+	}
+	if !found {
+		return n, "", fmt.Errorf("no entry has the hash %s, the log was truncated or replaced", anchor)
+	}
+	return n, hex.EncodeToString(prev), nil
+}
+
+// runAudit implements "fileenc audit verify [-log <file>] [-anchor <hash>]"
+func runAudit(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	path := fs.String("log", os.Getenv(auditLogEnv), "audit log to verify, "+auditLogEnv+" by default")
+	anchor := fs.String("anchor", "", "fail unless the entry with this hash, noted from an earlier verification, is still in the log")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc audit verify [-log <file>] [-anchor <hash>]")
+		fmt.Fprintln(fs.Output(), "Checks the hash chain of an audit log written with -audit-log.")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "verify" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	parseArgs(fs, args[1:])
+	if *path == "" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	file, err := os.Open(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitIO)
+	}
+	defer file.Close()
+	n, last, err := verifyAuditLog(file, *anchor)
+	if err != nil {
+		fmt.Printf("Audit log %s is NOT intact: %v\n", *path, err)
+		file.Close()
+		os.Exit(exitCorrupt)
+	}
+	fmt.Printf("Audit log %s is intact: %d entries, last hash %s\n", *path, n, last)
+}
@@ -0,0 +1,570 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// snapshotLayout is the layout of the UTC time in snapshot file names
+const snapshotLayout = "20060102T150405Z"
+
+// snapshot is a backup stored as <name>-<time>.tar.enc, or <name>-<time>.incr.tar.enc
+// if it only holds the changes since the snapshot before it
+type snapshot struct {
+	file        string
+	name        string
+	time        time.Time
+	incremental bool
+}
+
+// snapshotFile returns the file name of the snapshot of name taken at t
+func snapshotFile(name string, t time.Time, incremental bool) string {
+	kind := ""
+	if incremental {
+		kind = ".incr"
+	}
+	return name + "-" + t.UTC().Format(snapshotLayout) + kind + ".tar" + encExt
+}
+
+// indexFile returns the file name of the index of the snapshot
+func (s snapshot) indexFile() string {
+	return s.name + "-" + s.time.Format(snapshotLayout) + ".index" + encExt
+}
+
+// parseSnapshot parses the file name of a snapshot
+func parseSnapshot(file string) (snapshot, bool) {
+	base, ok := strings.CutSuffix(file, ".tar"+encExt)
+	base, incremental := strings.CutSuffix(base, ".incr")
+	n := len(base) - len(snapshotLayout)
+	if !ok || n < 2 || base[n-1] != '-' {
+		return snapshot{}, false
+	}
+	t, err := time.Parse(snapshotLayout, base[n:])
+	if err != nil {
+		return snapshot{}, false
+	}
+	return snapshot{file: file, name: base[:n-1], time: t, incremental: incremental}, true
+}
+
+// indexEntry records a file, directory or link of a snapshot
+type indexEntry struct {
+	Size    int64       `json:"size"`
+	ModTime int64       `json:"mtime"`
+	Mode    fs.FileMode `json:"mode"`
+}
+
+// backupIndex lists the complete tree at the time of a snapshot, so the next
+// incremental snapshot knows what changed and a restore what was deleted. It is
+// stored encrypted next to the snapshot.
+type backupIndex struct {
+	// Base is the snapshot an incremental snapshot holds the changes to
+	Base    string                `json:"base,omitempty"`
+	Entries map[string]indexEntry `json:"entries"`
+	// previous is the index of the base while a backup runs
+	previous *backupIndex
+}
+
+// readIndex downloads and decrypts the index of the snapshot s in dest
+func readIndex(enc *fileenc.Encryptor, dest string, s snapshot) (*backupIndex, error) {
+	r, _, err := openInput(joinObject(dest, s.indexFile()))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	if err := enc.Decrypt(&buf, r); err != nil {
+		return nil, fmt.Errorf("failed to decrypt the index of %s: %w", s.file, err)
+	}
+	var idx backupIndex
+	if err := json.Unmarshal(buf.Bytes(), &idx); err != nil {
+		return nil, fmt.Errorf("failed to read the index of %s: %w", s.file, err)
+	}
+	return &idx, nil
+}
+
+// record adds the entry name to the index and reports whether it changed
+// since the previous snapshot, it is the SelectFunc of a backup
+func (idx *backupIndex) record(name string, info fs.FileInfo) bool {
+	entry := indexEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Mode: info.Mode()}
+	idx.Entries[name] = entry
+	if idx.previous == nil {
+		return true
+	}
+	old, ok := idx.previous.Entries[name]
+	return !ok || old != entry
+}
+
+// writeIndex encrypts the index of the snapshot s to dest
+func writeIndex(enc *fileenc.Encryptor, dest string, s snapshot, idx *backupIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	w, err := createOutput(joinObject(dest, s.indexFile()), false)
+	if err != nil {
+		return err
+	}
+	if err := enc.Encrypt(w, bytes.NewReader(data)); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		w.Abort()
+		return err
+	}
+	return nil
+}
+
+// listSnapshots returns the snapshots of name, or of all names if it is
+// empty, in the directory or URL dest, the oldest first
+func listSnapshots(dest, name string) ([]snapshot, error) {
+	files, err := listObjects(dest)
+	if err != nil {
+		return nil, err
+	}
+	var snaps []snapshot
+	for _, file := range files {
+		if s, ok := parseSnapshot(file); ok && (name == "" || s.name == name) {
+			snaps = append(snaps, s)
+		}
+	}
+	slices.SortFunc(snaps, func(a, b snapshot) int {
+		return a.time.Compare(b.time)
+	})
+	return snaps, nil
+}
+
+// retention selects the snapshots to keep, snapshots are kept if any rule keeps them
+type retention struct {
+	last, daily, weekly, monthly int
+}
+
+// addRetentionFlags registers the -keep flags on fs
+func addRetentionFlags(fs *flag.FlagSet) *retention {
+	r := &retention{}
+	fs.IntVar(&r.last, "keep-last", 0, "keep the n most recent snapshots")
+	fs.IntVar(&r.daily, "keep-daily", 0, "keep the most recent snapshot of each of the last n days with snapshots")
+	fs.IntVar(&r.weekly, "keep-weekly", 0, "keep the most recent snapshot of each of the last n weeks with snapshots")
+	fs.IntVar(&r.monthly, "keep-monthly", 0, "keep the most recent snapshot of each of the last n months with snapshots")
+	return r
+}
+
+// set reports whether any rule is given, without rules all snapshots are kept
+func (r *retention) set() bool {
+	return r.last > 0 || r.daily > 0 || r.weekly > 0 || r.monthly > 0
+}
+
+// expired returns the snapshots, sorted oldest first, that no rule keeps and
+// no incremental snapshot kept builds on
+func (r *retention) expired(snaps []snapshot) []snapshot {
+	type rule struct {
+		left   int
+		period func(time.Time) string
+		last   string
+	}
+	rules := []*rule{
+		{left: r.last, period: func(t time.Time) string { return t.String() }},
+		{left: r.daily, period: func(t time.Time) string { return t.Format(time.DateOnly) }},
+		{left: r.weekly, period: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprint(year, week)
+		}},
+		{left: r.monthly, period: func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	// Walk from the newest snapshot, each rule keeps the first one of a period.
+	// An incremental snapshot kept needs the one before it as well.
+	var expired []snapshot
+	needed := false
+	for _, s := range slices.Backward(snaps) {
+		keep := needed
+		for _, rl := range rules {
+			if p := rl.period(s.time.Local()); rl.left > 0 && p != rl.last {
+				rl.left--
+				rl.last = p
+				keep = true
+			}
+		}
+		needed = keep && s.incremental
+		if !keep {
+			expired = append(expired, s)
+		}
+	}
+	slices.Reverse(expired)
+	return expired
+}
+
+// runBackup implements "fileenc backup [options] <dir> <dest>"
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	keys := addKeyFlags(fs, true)
+	ciphers := addCipherFlags(fs)
+	metadata := addMetadataFlags(fs)
+	keep := addRetentionFlags(fs)
+	name := fs.String("name", "", "name of the snapshots, default the name of the directory")
+	incremental := fs.Bool("incremental", false, "store only what changed since the previous snapshot, which needs the key or, with -recipient, an -identity to read its index")
+	follow := fs.Bool("follow-symlinks", false, "back up the files and directories symbolic links point to instead of the links")
+	quiet := fs.Bool("quiet", false, "only report errors, no progress or success messages")
+	progressFlag := fs.String("progress", progressAuto, "progress reporting on stderr: auto (bar on terminals), bar, json or none")
+	// Backups are compressed unless told otherwise
+	compress := fs.Lookup("compress")
+	compress.DefValue = fileenc.CompressionZstd
+	compress.Value.Set(fileenc.CompressionZstd)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc backup [options] <dir> <dest>")
+		fmt.Fprintln(fs.Output(), "<dest> is a local directory or a URL of a directory, e.g. s3:// or sftp://, the snapshot is stored there as <name>-<time>.tar"+encExt)
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	dir, dest := args[0], args[1]
+	if *name == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		*name = filepath.Base(abs)
+	}
+	if strings.ContainsAny(*name, `/\`) {
+		fmt.Fprintf(os.Stderr, "Error: invalid snapshot name %q\n", *name)
+		os.Exit(exitUsage)
+	}
+	if strings.HasPrefix(dest, "https://") {
+		fmt.Fprintln(os.Stderr, "Error: backups are stored in a local directory or an s3:// or sftp:// URL")
+		os.Exit(exitUsage)
+	}
+
+	// A local destination is created and must not be inside the directory
+	if !isRemote(dest) {
+		absDir, err1 := filepath.Abs(dir)
+		absDest, err2 := filepath.Abs(dest)
+		if err := errors.Join(err1, err2); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		if rel, err := filepath.Rel(absDir, absDest); err == nil && filepath.IsLocal(rel) {
+			fmt.Fprintf(os.Stderr, "Error: the backup destination %s must not be inside %s\n", dest, dir)
+			os.Exit(exitUsage)
+		}
+		if err := os.MkdirAll(dest, 0700); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCode(err, exitFailure))
+		}
+	}
+
+	opts, err := ciphers.options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	opts = append(opts, metadata.options()...)
+	opts = append(opts, fileenc.WithSkipFunc(func(path, reason string) {
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s, %s\n", path, reason)
+	}))
+	if *follow {
+		opts = append(opts, fileenc.WithFollowSymlinks())
+	}
+	// Record every entry in the index, with a previous index only changed files are archived
+	idx := &backupIndex{Entries: map[string]indexEntry{}}
+	opts = append(opts, fileenc.WithSelectFunc(idx.record))
+	if *incremental && len(keys.identities) > 0 {
+		identities, ageIdentities, err := loadIdentities(keys.identities)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to read identities: %v\n", err)
+			os.Exit(exitCode(err, exitUsage))
+		}
+		opts = append(opts, fileenc.WithIdentities(identities...), fileenc.WithAgeIdentities(ageIdentities...))
+	}
+	enc, key := newEncryptor(keys, false, *progressFlag, *quiet, opts)
+	defer clear(key)
+
+	// An incremental snapshot builds on the most recent one, a full snapshot is
+	// written if there is none or it has no index
+	if *incremental {
+		snaps, err := listSnapshots(dest, *name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Error listing snapshots: %v\n", err)
+			os.Exit(exitCode(err, exitFailure))
+		}
+		if len(snaps) > 0 {
+			base := snaps[len(snaps)-1]
+			idx.previous, err = readIndex(enc, dest, base)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				fmt.Fprintf(os.Stderr, "Warning: %s has no index, writing a full snapshot\n", base.file)
+			case err != nil:
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(exitCode(err, exitFailure))
+			default:
+				idx.Base = base.file
+			}
+		}
+	}
+
+	// Stream the archive to the destination, it only appears there once complete
+	snap := snapshot{name: *name, time: time.Now().UTC().Truncate(time.Second), incremental: idx.previous != nil}
+	snap.file = snapshotFile(snap.name, snap.time, snap.incremental)
+	out := joinObject(dest, snap.file)
+	w, err := createOutput(out, false)
+	if err == nil {
+		if err = enc.EncryptDirTo(w, dir); err != nil {
+			w.Abort()
+		} else if err = w.Close(); err != nil {
+			w.Abort()
+		}
+	}
+	if err == nil {
+		if err = writeIndex(enc, dest, snap, idx); err != nil {
+			err = fmt.Errorf("failed to write the index: %w", err)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error backing up %s: %v\n", dir, err)
+		os.Exit(exitCode(err, exitFailure))
+	}
+	if !*quiet {
+		fmt.Printf("Directory %s backed up to %s successfully.\n", dir, out)
+	}
+
+	if !keep.set() {
+		return
+	}
+	snaps, err := listSnapshots(dest, *name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing snapshots: %v\n", err)
+		os.Exit(exitCode(err, exitFailure))
+	}
+	var failed []error
+	for _, s := range keep.expired(snaps) {
+		if err := removeObject(joinObject(dest, s.file)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing snapshot %s: %v\n", s.file, err)
+			failed = append(failed, err)
+			continue
+		}
+		if err := removeObject(joinObject(dest, s.indexFile())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Error removing the index of %s: %v\n", s.file, err)
+			failed = append(failed, err)
+		}
+		if !*quiet {
+			fmt.Printf("Snapshot %s removed.\n", s.file)
+		}
+	}
+	if len(failed) > 0 {
+		os.Exit(batchExitCode(failed))
+	}
+}
+
+// runRestore implements "fileenc restore [options] <dest>"
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	keys := addKeyFlags(fs, false)
+	name := fs.String("name", "", "restore a snapshot of this name, needed if the destination holds backups of several directories")
+	snap := fs.String("snapshot", "", "snapshot to restore, its file name or time as in the file name, default the most recent")
+	list := fs.Bool("list", false, "only list the snapshots, no key is needed")
+	dir := fs.String("C", ".", "directory to restore into")
+	overwrite := fs.Bool("overwrite", false, "replace existing files")
+	owner := fs.Bool("owner", false, "restore the owner of the files, requires root")
+	quiet := fs.Bool("quiet", false, "only report errors, no progress or success messages")
+	progressFlag := fs.String("progress", progressAuto, "progress reporting on stderr: auto (bar on terminals), bar, json or none")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc restore [options] <dest>")
+		fmt.Fprintln(fs.Output(), "<dest> is the local directory or s3:// or sftp:// URL the backups were written to")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	dest := args[0]
+
+	snaps, err := listSnapshots(dest, *name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing snapshots: %v\n", err)
+		os.Exit(exitCode(err, exitFailure))
+	}
+	if *list {
+		for _, s := range snaps {
+			kind := "full"
+			if s.incremental {
+				kind = "incremental"
+			}
+			fmt.Printf("%s  %-11s  %s  %s\n", s.time.Local().Format(time.DateTime), kind, s.name, s.file)
+		}
+		return
+	}
+
+	// Pick the requested or the most recent snapshot
+	var chosen *snapshot
+	for i, s := range snaps {
+		if *snap == "" || *snap == s.file || *snap == s.time.Format(snapshotLayout) {
+			chosen = &snaps[i]
+		}
+	}
+	switch {
+	case chosen == nil && *snap != "":
+		fmt.Fprintf(os.Stderr, "Error: no snapshot %s in %s\n", *snap, dest)
+		os.Exit(exitCode(os.ErrNotExist, exitFailure))
+	case chosen == nil:
+		fmt.Fprintf(os.Stderr, "Error: no snapshots in %s\n", dest)
+		os.Exit(exitCode(os.ErrNotExist, exitFailure))
+	case *name == "" && *snap == "" && slices.ContainsFunc(snaps, func(s snapshot) bool { return s.name != chosen.name }):
+		fmt.Fprintf(os.Stderr, "Error: %s holds backups of several directories, select one with -name\n", dest)
+		os.Exit(exitUsage)
+	}
+
+	progress, err := newProgressPrinter(*progressFlag, *quiet)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	// The snapshots an incremental one builds on are restored first, it replaces their files
+	key, keyOpts, err := keys.load(true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	defer clear(key)
+	opts := append(keyOpts, fileenc.WithFileMetadata(false, *owner), fileenc.WithSkipFunc(func(path, reason string) {
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s, %s\n", path, reason)
+	}))
+	enc, err1 := fileenc.New(key, slices.Concat(opts, []fileenc.Option{fileenc.WithOverwrite(*overwrite)})...)
+	replace, err2 := fileenc.New(key, slices.Concat(opts, []fileenc.Option{fileenc.WithOverwrite(true)})...)
+	if err := errors.Join(err1, err2); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid settings: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	chain, indexes, err := snapshotChain(enc, dest, snaps, *chosen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitFailure))
+	}
+
+	// Extract while downloading
+	for i, s := range chain {
+		e := enc
+		if i > 0 {
+			e = replace
+		}
+		in := joinObject(dest, s.file)
+		r, size, err := openInput(in)
+		if err == nil {
+			var src io.Reader = r
+			if progress != nil {
+				src = fileenc.NewProgressReader(r, s.file, size, 200*time.Millisecond, progress.update)
+			}
+			err = e.ExtractArchiveFrom(src, *dir)
+			r.Close()
+			if progress != nil {
+				progress.clear()
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error restoring %s: %v\n", in, err)
+			os.Exit(exitCode(err, exitFailure))
+		}
+	}
+	if err := removeDeleted(*dir, indexes); err != nil {
+		fmt.Fprintf(os.Stderr, "Error removing deleted files: %v\n", err)
+		os.Exit(exitCode(err, exitFailure))
+	}
+	if !*quiet {
+		fmt.Printf("Snapshot %s restored to %s successfully.\n", chosen.file, *dir)
+	}
+}
+
+// snapshotChain returns the snapshots to restore for s, the full snapshot and
+// the incremental ones up to s, together with their indexes
+func snapshotChain(enc *fileenc.Encryptor, dest string, snaps []snapshot, s snapshot) ([]snapshot, []*backupIndex, error) {
+	chain := []snapshot{s}
+	var indexes []*backupIndex
+	for {
+		if !chain[0].incremental && len(chain) == 1 {
+			return chain, nil, nil
+		}
+		idx, err := readIndex(enc, dest, chain[0])
+		if err != nil && (chain[0].incremental || !errors.Is(err, os.ErrNotExist)) {
+			return nil, nil, err
+		}
+		indexes = slices.Insert(indexes, 0, idx)
+		if !chain[0].incremental {
+			return chain, indexes, nil
+		}
+		i := slices.IndexFunc(snaps, func(s snapshot) bool { return s.file == idx.Base })
+		if i < 0 {
+			return nil, nil, fmt.Errorf("%s builds on %s, which is missing", chain[0].file, idx.Base)
+		}
+		chain = slices.Insert(chain, 0, snaps[i])
+	}
+}
+
+// removeDeleted removes the files, links and empty directories restored from
+// the earlier snapshots of a chain that the index of the last one does not
+// list, as they were deleted in between
+func removeDeleted(dir string, indexes []*backupIndex) error {
+	if len(indexes) < 2 {
+		return nil
+	}
+	last := indexes[len(indexes)-1]
+	var deleted []string
+	for _, idx := range indexes[:len(indexes)-1] {
+		if idx == nil {
+			continue
+		}
+		for name := range idx.Entries {
+			if _, ok := last.Entries[name]; !ok && filepath.IsLocal(filepath.FromSlash(name)) {
+				deleted = append(deleted, name)
+			}
+		}
+	}
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	// Reverse order removes the contents of directories before them
+	slices.Sort(deleted)
+	for _, name := range slices.Backward(slices.Compact(deleted)) {
+		if err := root.Remove(filepath.FromSlash(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			// Directories holding files not from the backup are kept
+			if info, statErr := root.Lstat(filepath.FromSlash(name)); statErr == nil && info.IsDir() {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestRetention checks which snapshots the -keep rules remove
+func TestRetention(t *testing.T) {
+	at := func(date string, incremental bool) snapshot {
+		tm, err := time.ParseInLocation(time.DateTime, date, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return snapshot{file: date, time: tm, incremental: incremental}
+	}
+	days := []snapshot{
+		at("2025-03-01 10:00:00", false),
+		at("2025-03-01 18:00:00", false),
+		at("2025-03-02 09:00:00", false),
+		at("2025-03-02 20:00:00", false),
+		at("2025-03-03 12:00:00", false),
+	}
+	months := []snapshot{
+		at("2025-01-10 12:00:00", false),
+		at("2025-01-20 12:00:00", false),
+		at("2025-02-03 12:00:00", false),
+		at("2025-02-05 12:00:00", false),
+		at("2025-02-12 12:00:00", false),
+	}
+	chain := []snapshot{
+		at("2025-04-01 12:00:00", false),
+		at("2025-04-02 12:00:00", true),
+		at("2025-04-03 12:00:00", true),
+		at("2025-04-04 12:00:00", false),
+		at("2025-04-05 12:00:00", true),
+	}
+	tests := []struct {
+		name  string
+		keep  retention
+		snaps []snapshot
+		want  []string
+	}{
+		{"last", retention{last: 2}, days, []string{"2025-03-01 10:00:00", "2025-03-01 18:00:00", "2025-03-02 09:00:00"}},
+		{"last more than there are", retention{last: 10}, days, nil},
+		{"daily", retention{daily: 2}, days, []string{"2025-03-01 10:00:00", "2025-03-01 18:00:00", "2025-03-02 09:00:00"}},
+		{"daily and last", retention{daily: 3, last: 2}, days, []string{"2025-03-01 10:00:00", "2025-03-02 09:00:00"}},
+		{"weekly and monthly", retention{weekly: 1, monthly: 2}, months, []string{"2025-01-10 12:00:00", "2025-02-03 12:00:00", "2025-02-05 12:00:00"}},
+		{"incremental needs its base", retention{last: 1}, chain, []string{"2025-04-01 12:00:00", "2025-04-02 12:00:00", "2025-04-03 12:00:00"}},
+		{"incremental chain", retention{last: 1}, chain[:3], nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.keep.set() {
+				t.Fatal("rules not set")
+			}
+			var got []string
+			for _, s := range tt.keep.expired(tt.snaps) {
+				got = append(got, s.file)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expired %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if (&retention{}).set() {
+		t.Error("retention without rules is set")
+	}
+}
@@ -0,0 +1,830 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// encExt is the default extension of encrypted files
+const encExt = ".enc"
+
+// stringList is a flag.Value collecting the values of a repeated flag
+type stringList []string
+
+// String returns the collected values
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set adds a value
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// parseArgs parses flags and positional arguments in any order and returns the
+// positional ones. Everything after "--" is taken as positional. The defaults
+// file is applied first.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	if err := applyDefaults(fs); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	var rest []string
+	for {
+		fs.Parse(args)
+		remaining := fs.Args()
+		if n := len(args) - len(remaining); n > 0 && args[n-1] == "--" {
+			return append(rest, remaining...)
+		}
+		if len(remaining) == 0 {
+			return rest
+		}
+		rest = append(rest, remaining[0])
+		args = remaining[1:]
+	}
+}
+
+// expandSources expands the shell-style glob patterns in sources and returns the
+// matched files in order without duplicates. Sources without glob characters are
+// passed through unchanged. Patterns without suffix match the counterparts
+// with suffix, e.g. *.txt matches *.txt.enc when decrypting; an empty suffix
+// matches the patterns as given.
+func expandSources(sources []string, suffix string) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	for _, source := range sources {
+		matches := []string{source}
+		if strings.ContainsAny(source, "*?[") {
+			pattern := source
+			if !strings.HasSuffix(pattern, suffix) {
+				pattern += suffix
+			}
+			var err error
+			if matches, err = filepath.Glob(pattern); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", source, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", source)
+			}
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				files = append(files, m)
+			}
+		}
+	}
+	return files, nil
+}
+
+// walkOptions controls which files expandDirs takes and how it treats
+// symbolic links and special files
+type walkOptions struct {
+	followLinks   bool
+	preserveLinks bool
+	includeHidden bool
+	// exclude holds the -exclude patterns, they apply below every source directory
+	exclude ignoreRules
+	log     *slog.Logger
+}
+
+// expandDirs replaces the directories among files with the files below them.
+// When decrypting these are the files with suffix and first volumes, when
+// encrypting all others, so outputs next to the sources are not encrypted again.
+func expandDirs(files []string, decrypt bool, suffix string, opts walkOptions) ([]string, error) {
+	var expanded []string
+	add := func(path string) {
+		name := filepath.Base(path)
+		encrypted := strings.HasSuffix(name, suffix) || strings.Contains(name, suffix+".")
+		switch {
+		case decrypt && (strings.HasSuffix(name, suffix) || strings.HasSuffix(name, suffix+firstVolume)):
+			expanded = append(expanded, path)
+		case !decrypt && !encrypted && !strings.HasSuffix(name, ".partial"):
+			expanded = append(expanded, path)
+		}
+	}
+	visited := map[string]bool{}
+	for _, file := range files {
+		if !isDir(file) {
+			expanded = append(expanded, file)
+			continue
+		}
+		if err := walkFiles(file, "", opts.exclude, opts, visited, add); err != nil {
+			return nil, fmt.Errorf("failed to read directory: %w", err)
+		}
+	}
+	return expanded, nil
+}
+
+// walkFiles calls fn for the files below dir in lexical order. rel is dir
+// relative to the source, slash separated. Hidden files and those matching the
+// rules or the ignore files are skipped. Symbolic links are skipped, followed
+// or passed on as selected by opts, special files are skipped with a warning.
+// visited holds the directories walked so far, so links into a loop are skipped.
+func walkFiles(dir, rel string, rules ignoreRules, opts walkOptions, visited map[string]bool, fn func(path string)) error {
+	real, err := filepath.EvalSymlinks(dir)
+	if err == nil {
+		real, err = filepath.Abs(real)
+	}
+	if err != nil {
+		return err
+	}
+	if visited[real] {
+		opts.log.Warn("Skipping symbolic link to a directory processed already", "file", dir)
+		return nil
+	}
+	visited[real] = true
+
+	if rules, err = readIgnoreFile(rules, dir, rel); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range entries {
+		path := filepath.Join(dir, d.Name())
+		if !opts.includeHidden && isHidden(dir, d) {
+			opts.log.Debug("Skipping hidden file", "file", path)
+			continue
+		}
+		entryRel := d.Name()
+		if rel != "" {
+			entryRel = rel + "/" + entryRel
+		}
+		mode := d.Type()
+		if rules.ignored(entryRel, mode.IsDir()) {
+			opts.log.Debug("Skipping ignored file", "file", path)
+			continue
+		}
+		if mode&os.ModeSymlink != 0 {
+			if opts.preserveLinks {
+				fn(path)
+				continue
+			}
+			if !opts.followLinks {
+				opts.log.Warn("Skipping symbolic link, use -follow-symlinks or -preserve-symlinks", "file", path)
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				opts.log.Warn("Skipping broken symbolic link", "file", path)
+				continue
+			}
+			mode = info.Mode().Type()
+		}
+		switch {
+		case mode.IsDir():
+			if err := walkFiles(path, entryRel, rules, opts, visited, fn); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			fn(path)
+		default:
+			opts.log.Warn("Skipping special file", "file", path, "type", fileenc.SpecialFileType(mode))
+		}
+	}
+	return nil
+}
+
+// encryptedNames returns the output names of files with -encrypt-names: the
+// encrypted names with suffix when encrypting, the original names read from the
+// encrypted names when decrypting
+func encryptedNames(enc *fileenc.Encryptor, files []string, decrypt bool, suffix string) (map[string]string, error) {
+	names := map[string]string{}
+	for _, file := range files {
+		in, out := targetPaths(file, decrypt, suffix)
+		if !decrypt {
+			dir, err := filepath.Abs(filepath.Dir(in))
+			if err != nil {
+				return nil, err
+			}
+			name, err := enc.EncryptName(dir, filepath.Base(in))
+			if err != nil {
+				return nil, err
+			}
+			names[file] = name + suffix
+			continue
+		}
+		info, err := fileenc.InspectFile(in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", in, err)
+		}
+		name, err := enc.DecryptName(filepath.Base(out), info.KDF)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt the name of %s: %w", in, err)
+		}
+		names[file] = name
+	}
+	return names, nil
+}
+
+// targetPaths returns the file to read and the file to write when processing
+// source. Sources are given without the suffix of encrypted files, when
+// decrypting a source with suffix or the first volume of a split file is
+// accepted as well.
+func targetPaths(source string, decrypt bool, suffix string) (in, out string) {
+	if !decrypt {
+		return source, source + suffix
+	}
+	if out, ok := strings.CutSuffix(source, suffix+firstVolume); ok {
+		return source, out
+	}
+	if strings.HasSuffix(source, suffix) {
+		return source, strings.TrimSuffix(source, suffix)
+	}
+	return source + suffix, source
+}
+
+// task holds the settings applied to every processed file
+type task struct {
+	enc         *fileenc.Encryptor
+	decrypt     bool
+	legacy      bool
+	overwrite   bool
+	suffix      string
+	out         string
+	outDir      string
+	inPlace     bool
+	rename      bool
+	shred       bool
+	shredPasses int
+	verifySrc   bool
+	deleteSrc   bool
+	logs        *logFlags
+	json        bool
+	checksum    string
+	daemon      string
+	force       bool
+	split       int64
+	shares      int
+	threshold   int
+	dialog      bool
+	abort       context.Context
+	names       map[string]string
+	manifest    *manifest
+	incremental bool
+	previous    map[string]manifestEntry
+	hooks       hooks
+	progress    *progressPrinter
+	audit       *auditLog
+	events      *events
+	conflicts   *conflicts
+	// replace is enc with overwriting, used for the outputs the user agreed
+	// to replace when asked by -on-conflict prompt
+	replace *fileenc.Encryptor
+}
+
+// firstVolume is the extension of the first volume of a split file
+const firstVolume = ".001"
+
+// fileSize returns the size of the file path or of all volumes of a split file
+func fileSize(path string) (int64, bool) {
+	if info, err := os.Stat(path); err == nil {
+		return info.Size(), true
+	}
+	parts := fileenc.SplitParts(path)
+	var size int64
+	for _, part := range parts {
+		info, err := os.Stat(part)
+		if err != nil {
+			return 0, false
+		}
+		size += info.Size()
+	}
+	return size, len(parts) > 0
+}
+
+// result is the report of a processed file written with -json
+type result struct {
+	File     string  `json:"file"`
+	Output   string  `json:"output"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration_seconds"`
+	BytesIn  int64   `json:"bytes_in"`
+	BytesOut int64   `json:"bytes_out"`
+	Error    string  `json:"error,omitempty"`
+	ExitCode int     `json:"exit_code"`
+	Time     string  `json:"time,omitempty"`
+}
+
+// paths returns the input and output file of source. With -out-dir the
+// relative path of the output is mirrored below the directory, absolute paths
+// and paths leaving the working directory are reduced to the file name.
+func (t task) paths(source string) (in, out string) {
+	if t.inPlace && !t.rename {
+		return source, source
+	}
+	in, out = targetPaths(source, t.decrypt, t.suffix)
+	if name, ok := t.names[source]; ok {
+		out = filepath.Join(filepath.Dir(out), name)
+	}
+	switch {
+	case t.out != "":
+		out = t.out
+	case t.outDir != "":
+		if !filepath.IsLocal(out) {
+			out = filepath.Base(out)
+		}
+		out = filepath.Join(t.outDir, out)
+	}
+	return in, out
+}
+
+// logger returns the logger writing the messages of a file to w
+func (t task) logger(w io.Writer) *slog.Logger {
+	return t.logs.logger(w)
+}
+
+// runJSON processes source like run and writes a JSON result to out instead of the messages
+func (t task) runJSON(source string, out io.Writer) error {
+	res, err := t.report(source, t.logger(io.Discard))
+	json.NewEncoder(out).Encode(res)
+	return err
+}
+
+// report processes source like run, writing the messages to log, and returns the result
+func (t task) report(source string, log *slog.Logger) (result, error) {
+	t, err := t.resolve(source, log)
+	in, dst := t.paths(source)
+	res := result{File: in, Output: dst, Status: "ok"}
+	res.BytesIn, _ = fileSize(in)
+	if errors.Is(err, errExists) {
+		res.Status = "exists"
+		return res, err
+	}
+	start := time.Now()
+	if err == nil {
+		err = t.run(source, log)
+	}
+	res.Duration = time.Since(start).Seconds()
+	if errors.Is(err, errUnchanged) {
+		res.Status = "unchanged"
+		res.BytesOut, _ = fileSize(dst)
+	} else if err != nil {
+		res.Status, res.Error, res.ExitCode = "error", err.Error(), exitCode(err, exitFailure)
+	} else {
+		res.BytesOut, _ = fileSize(dst)
+	}
+	return res, err
+}
+
+// run encrypts or decrypts a single source file between the hooks, reports
+// the outcome to log and the events and records it in the audit log. An
+// existing output is handled first as -on-conflict says.
+func (t task) run(source string, log *slog.Logger) error {
+	t, err := t.resolve(source, log)
+	if err != nil {
+		return err
+	}
+	if t.audit == nil && t.events == nil {
+		return t.runHooked(source, log, func() error {
+			return t.process(source, log)
+		})
+	}
+	in, dst := t.paths(source)
+	size, _ := fileSize(in)
+	start := time.Now()
+	t.events.start(log, event{Operation: t.operation(), File: in, Output: dst, BytesIn: size})
+	err = t.runHooked(source, log, func() error {
+		return t.process(source, log)
+	})
+	var written int64
+	if err == nil || errors.Is(err, errUnchanged) {
+		written, _ = fileSize(dst)
+	}
+	if t.audit != nil {
+		if aerr := t.audit.record(t.operation(), in, dst, size, written, start, err); aerr != nil && (err == nil || errors.Is(err, errUnchanged)) {
+			err = aerr
+		}
+	}
+	t.events.finish(log, event{Operation: t.operation(), File: in, Output: dst, BytesIn: size, BytesOut: written}, start, err)
+	return err
+}
+
+// operation names what the task does with a file in the audit log
+func (t task) operation() string {
+	if t.decrypt {
+		return "decrypt"
+	}
+	return "encrypt"
+}
+
+// process encrypts or decrypts a single source file and reports the outcome to log
+func (t task) process(source string, log *slog.Logger) error {
+	if t.inPlace && !t.rename {
+		return t.runInPlace(source, log)
+	}
+	in, dst := t.paths(source)
+	if t.outDir != "" {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			log.Error("Error creating directory", "file", dst, "error", err)
+			return err
+		}
+	}
+	log.Debug("Processing file", "file", in, "output", dst, "decrypt", t.decrypt)
+	start := time.Now()
+	if t.incremental {
+		unchanged, err := t.unchanged(in, dst)
+		if err != nil {
+			log.Error("Error checking for changes", "file", in, "error", err)
+			return err
+		}
+		if unchanged {
+			log.Debug("File unchanged", "file", in, "output", dst)
+			return errUnchanged
+		}
+	}
+
+	if t.decrypt {
+		if err := t.decryptFile(in, dst); err != nil {
+			err = hint(err)
+			log.Error("Error decrypting", "file", in, "error", err)
+			return err
+		}
+		log.Info("File decrypted", "file", in, "output", dst, "duration", time.Since(start).Round(time.Millisecond))
+		return t.removeSource(in, log)
+	}
+
+	entry, err := t.hash(in)
+	if err != nil {
+		log.Error("Error hashing", "file", in, "error", err)
+		return err
+	}
+	var before os.FileInfo
+	if t.deleteSrc {
+		if before, err = os.Lstat(in); err != nil {
+			log.Error("Error reading", "file", in, "error", err)
+			return err
+		}
+	}
+	if err := t.encryptFile(in, dst); err != nil {
+		err = hint(err)
+		log.Error("Error encrypting", "file", in, "error", err)
+		return err
+	}
+	if err := t.checkSource(entry, in, dst); err != nil {
+		log.Error("Error verifying the source", "file", in, "output", dst, "error", err)
+		return err
+	}
+	t.record(entry, dst)
+	log.Info("File encrypted", "file", in, "output", dst, "duration", time.Since(start).Round(time.Millisecond))
+	if t.shares > 0 {
+		log.Info("Key split into shares", "first", fileenc.SharePath(dst, 1),
+			"last", fileenc.SharePath(dst, t.shares), "threshold", t.threshold)
+	}
+
+	// Remove the plaintext only after the encrypted file is in place
+	if t.shred {
+		shred := fileenc.Shred
+		// A preserved link is removed, the file it points to stays
+		if info, err := os.Lstat(in); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			shred = func(path string, _ int) error { return os.Remove(path) }
+		}
+		if err := shred(in, t.shredPasses); err != nil {
+			log.Error("Error shredding", "file", in, "error", err)
+			return err
+		}
+		log.Info("File shredded", "file", in)
+		return nil
+	}
+	if t.deleteSrc {
+		return t.deleteSource(in, dst, before, log)
+	}
+	return t.removeSource(in, log)
+}
+
+// encryptFile encrypts in to dst, through the daemon if -daemon is set
+func (t task) encryptFile(in, dst string) error {
+	if t.daemon != "" {
+		return callDaemon(t.daemon, daemonRequest{Op: "encrypt", Input: in, Output: dst, Overwrite: t.overwrite, Force: t.force})
+	}
+	return t.encryptor().EncryptFileContext(t.context(), in, dst)
+}
+
+// decryptFile decrypts in to dst, through the daemon if -daemon is set
+func (t task) decryptFile(in, dst string) error {
+	if t.daemon != "" {
+		return callDaemon(t.daemon, daemonRequest{Op: "decrypt", Input: in, Output: dst, Overwrite: t.overwrite})
+	}
+	return t.encryptor().DecryptFileContext(t.context(), in, dst)
+}
+
+// encryptor returns the Encryptor for the output, one replacing it only if
+// overwriting was enabled or the user agreed to replace it
+func (t task) encryptor() *fileenc.Encryptor {
+	if t.overwrite && t.replace != nil {
+		return t.replace
+	}
+	return t.enc
+}
+
+// context returns the context aborting the files in progress
+func (t task) context() context.Context {
+	if t.abort == nil {
+		return context.Background()
+	}
+	return t.abort
+}
+
+// runInPlace replaces the source file with its encrypted or decrypted version under the same name
+func (t task) runInPlace(source string, log *slog.Logger) error {
+	var err error
+	action := "encrypted"
+	if t.decrypt {
+		action = "decrypted"
+		err = hint(t.enc.DecryptInPlace(source))
+	} else {
+		var entry manifestEntry
+		if entry, err = t.hash(source); err == nil {
+			if err = hint(t.enc.EncryptInPlace(source)); err == nil {
+				t.record(entry, source)
+			}
+		}
+	}
+	if err != nil {
+		log.Error("Error processing in place", "file", source, "error", err)
+		return err
+	}
+	log.Info("File "+action+" in place", "file", source)
+	return nil
+}
+
+// hash returns the manifest entry of the plaintext file in before it is
+// encrypted, nothing is read without -manifest or -verify-source
+func (t task) hash(in string) (manifestEntry, error) {
+	if t.manifest == nil && !t.verifySrc {
+		return manifestEntry{}, nil
+	}
+	return hashFile(in)
+}
+
+// checkSource decrypts dst without writing the plaintext and compares it with
+// the hash of the source in entry. A mismatching output is removed, the source
+// is kept. Preserved links are not checked, their target is encrypted.
+func (t task) checkSource(entry manifestEntry, in, dst string) error {
+	if !t.verifySrc {
+		return nil
+	}
+	if info, err := os.Lstat(in); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	sum, err := hex.DecodeString(entry.SHA256)
+	if err != nil {
+		return err
+	}
+	err = t.enc.VerifySource(dst, sum)
+	if errors.Is(err, fileenc.ErrSourceMismatch) {
+		os.Remove(dst)
+		for _, part := range fileenc.SplitParts(dst) {
+			os.Remove(part)
+		}
+	}
+	return err
+}
+
+// record adds the encrypted file to the manifest if there is one
+func (t task) record(entry manifestEntry, out string) {
+	if t.manifest != nil {
+		entry.Output = out
+		t.manifest.add(entry)
+	}
+}
+
+// errUnchanged is returned by run for files skipped by -incremental, it is no failure
+var errUnchanged = errors.New("unchanged")
+
+// unchanged reports if the encrypted file dst is up to date: it is newer than
+// in, or the manifest of the previous run lists the same hash for in. The
+// manifest entry of a file is kept.
+func (t task) unchanged(in, dst string) (bool, error) {
+	source, err := os.Stat(in)
+	if err != nil {
+		return false, err
+	}
+	// Split outputs are checked by their first volume
+	outPath := dst
+	out, err := os.Stat(outPath)
+	if err != nil {
+		outPath = dst + firstVolume
+		if out, err = os.Stat(outPath); err != nil {
+			return false, nil
+		}
+	}
+	prev, listed := t.previous[in]
+	listed = listed && prev.Output == dst
+	if !out.ModTime().Before(source.ModTime()) {
+		if t.manifest != nil && !listed {
+			if prev, err = hashFile(in); err != nil {
+				return false, err
+			}
+		}
+		t.record(prev, dst)
+		return true, nil
+	}
+	if !listed {
+		return false, nil
+	}
+	entry, err := hashFile(in)
+	if err != nil {
+		return false, err
+	}
+	if entry.SHA256 != prev.SHA256 || entry.Size != prev.Size {
+		return false, nil
+	}
+	// Only the time changed, the next run takes the shortcut above
+	now := time.Now()
+	os.Chtimes(outPath, now, now)
+	t.record(entry, dst)
+	return true, nil
+}
+
+// hint points to the flag overriding err if there is one
+func hint(err error) error {
+	switch {
+	case errors.Is(err, fileenc.ErrAlreadyEncrypted):
+		return fmt.Errorf("%w, use -force to encrypt it again", err)
+	case errors.Is(err, fileenc.ErrNotFileenc):
+		return fmt.Errorf("%w, files of fileenc versions without header need -legacy", err)
+	}
+	return err
+}
+
+// removeSource removes the source file after it has been processed with -in-place -rename
+func (t task) removeSource(in string, log *slog.Logger) error {
+	if !t.inPlace {
+		return nil
+	}
+	files := []string{in}
+	if parts := fileenc.SplitParts(in); parts != nil {
+		files = parts
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			log.Error("Error removing", "file", file, "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSource removes the source in after it was encrypted to dst, if all
+// output files exist and are not empty and in is still the same file, with the
+// same size, modification and change time, as when before was taken ahead of
+// the encryption. The outputs were flushed to disk and, with
+// -verify-source, decrypted again before. If a check fails the source is kept.
+func (t task) deleteSource(in, dst string, before os.FileInfo, log *slog.Logger) error {
+	outputs := []string{dst}
+	if parts := fileenc.SplitParts(dst); parts != nil {
+		outputs = parts
+	}
+	for i := 1; i <= t.shares; i++ {
+		outputs = append(outputs, fileenc.SharePath(dst, i))
+	}
+	err := func() error {
+		for _, out := range outputs {
+			info, err := os.Stat(out)
+			if err != nil {
+				return err
+			}
+			if info.Size() == 0 {
+				return fmt.Errorf("%s is empty", out)
+			}
+		}
+		info, err := os.Lstat(in)
+		if err != nil {
+			return err
+		}
+		// A rewrite of the same size within the timestamp granularity still
+		// changes the inode change time, a replaced file the inode
+		if !os.SameFile(info, before) || info.Size() != before.Size() || !info.ModTime().Equal(before.ModTime()) ||
+			!changeTime(info).Equal(changeTime(before)) {
+			return fmt.Errorf("%s changed while it was encrypted", in)
+		}
+		return nil
+	}()
+	if err != nil {
+		err = fmt.Errorf("source kept: %w", err)
+		log.Error("Error deleting the source", "file", in, "output", dst, "error", err)
+		return err
+	}
+	if err := os.Remove(in); err != nil {
+		log.Error("Error deleting the source", "file", in, "error", err)
+		return err
+	}
+	log.Info("Source deleted", "file", in)
+	return nil
+}
+
+// outcome collects the report of a file processed by a worker
+type outcome struct {
+	report bytes.Buffer
+	err    error
+	ran    bool
+	done   chan struct{}
+}
+
+// runAll processes the files with up to jobs workers and writes the messages to
+// stderr in the order of the files. Once ctx is cancelled no further files are started,
+// files in progress are finished. It returns the errors of the failed files,
+// the number of files that were skipped due to the cancellation, the number
+// of files left alone by -incremental and the number of files skipped as their
+// output exists.
+func (t task) runAll(ctx context.Context, files []string, jobs int) (failed []error, skipped, unchanged, kept int) {
+	outcomes := make([]*outcome, len(files))
+	for i := range outcomes {
+		outcomes[i] = &outcome{done: make(chan struct{})}
+	}
+
+	// Start the workers
+	next := make(chan int)
+	for w := 0; w < jobs; w++ {
+		go func() {
+			for i := range next {
+				o := outcomes[i]
+				if t.json {
+					o.err = t.runJSON(files[i], &o.report)
+				} else {
+					o.err = t.run(files[i], t.logger(&o.report))
+				}
+				o.ran = true
+				close(o.done)
+			}
+		}()
+	}
+
+	// Hand out the files until all are started or ctx is cancelled
+	go func() {
+		defer close(next)
+		for i := range files {
+			if ctx.Err() == nil {
+				select {
+				case next <- i:
+					continue
+				case <-ctx.Done():
+				}
+			}
+			close(outcomes[i].done)
+		}
+	}()
+
+	// Report in order as the files are done
+	for i, o := range outcomes {
+		<-o.done
+		if !o.ran {
+			skipped++
+			if t.json {
+				in, dst := t.paths(files[i])
+				json.NewEncoder(os.Stdout).Encode(result{File: in, Output: dst, Status: "skipped"})
+			}
+			continue
+		}
+		if t.progress != nil && o.report.Len() > 0 {
+			t.progress.clear()
+		}
+		if t.json {
+			os.Stdout.Write(o.report.Bytes())
+		} else {
+			os.Stderr.Write(o.report.Bytes())
+		}
+		switch {
+		case errors.Is(o.err, errUnchanged):
+			unchanged++
+		case errors.Is(o.err, errExists):
+			kept++
+		case o.err != nil:
+			failed = append(failed, o.err)
+		}
+	}
+	return failed, skipped, unchanged, kept
+}
@@ -0,0 +1,105 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestDeleteSource removes the source only if the output is complete and the
+// source was not changed after it was encrypted
+func TestDeleteSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, in, dst string)
+		ok     bool
+	}{
+		{"complete", func(*testing.T, string, string) {}, true},
+		{"output missing", func(t *testing.T, _, dst string) {
+			if err := os.Remove(dst); err != nil {
+				t.Fatal(err)
+			}
+		}, false},
+		{"empty output", func(t *testing.T, _, dst string) {
+			if err := os.WriteFile(dst, nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}, false},
+		{"source rewritten", func(t *testing.T, in, _ string) {
+			// Same size and modification time, only the change time differs
+			info, err := os.Stat(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+			if err := os.WriteFile(in, []byte("changed"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(in, info.ModTime(), info.ModTime()); err != nil {
+				t.Fatal(err)
+			}
+		}, false},
+		{"source replaced", func(t *testing.T, in, _ string) {
+			info, err := os.Stat(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			other := in + ".new"
+			if err := os.WriteFile(other, []byte("sources"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(other, info.ModTime(), info.ModTime()); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(other, in); err != nil {
+				t.Fatal(err)
+			}
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			in, dst := filepath.Join(dir, "source"), filepath.Join(dir, "source.enc")
+			if err := os.WriteFile(in, []byte("sources"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			before, err := os.Lstat(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(dst, []byte("encrypted"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			tt.change(t, in, dst)
+
+			err = task{}.deleteSource(in, dst, before, slog.New(slog.DiscardHandler))
+			_, serr := os.Lstat(in)
+			switch {
+			case tt.ok && (err != nil || serr == nil):
+				t.Errorf("deleteSource = %v, source still there: %v", err, serr == nil)
+			case !tt.ok && err == nil:
+				t.Error("deleteSource succeeded")
+			case !tt.ok && serr != nil:
+				t.Errorf("source removed: %v", serr)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// benchCiphers are the ciphers measured by fileenc bench, fastest recommended first
+var benchCiphers = []string{
+	fileenc.CipherAESGCM,
+	fileenc.CipherChaCha20Poly1305,
+	fileenc.CipherXChaCha20Poly1305,
+	fileenc.CipherAESCFB,
+}
+
+// runBench implements "fileenc bench [-size <size>] [-target <duration>] [-kdf <name>] [-write]",
+// measuring the ciphers and calibrating the key derivation to this machine
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	size := byteSize(256 << 20)
+	fs.Var(&size, "size", "amount of data encrypted with every cipher, e.g. 1G")
+	target := fs.Duration("target", 500*time.Millisecond, "time the key derivation should take")
+	kdf := fs.String("kdf", fileenc.KDFArgon2id, "key derivation function to recommend: argon2id, scrypt or pbkdf2")
+	write := fs.Bool("write", false, "store the recommended cipher and kdf settings in the defaults file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc bench [-size <size>] [-target <duration>] [-kdf <name>] [-write]")
+		fmt.Fprintln(fs.Output(), "Measures the cipher throughput and calibrates the key derivation functions to the target time.")
+		fs.PrintDefaults()
+	}
+	if len(parseArgs(fs, args)) != 0 || size <= 0 || *target <= 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *kdf != fileenc.KDFArgon2id && *kdf != fileenc.KDFScrypt && *kdf != fileenc.KDFPBKDF2 {
+		fmt.Fprintf(os.Stderr, "Unknown kdf %q, use argon2id, scrypt or pbkdf2\n", *kdf)
+		os.Exit(exitUsage)
+	}
+
+	fmt.Printf("Cipher throughput (%s):\n", formatBytes(float64(size)))
+	fastest, best := "", 0.0
+	for _, name := range benchCiphers {
+		rate, err := benchCipher(name, int64(size))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error measuring %s: %v\n", name, err)
+			os.Exit(exitFailure)
+		}
+		fmt.Printf("  %-20s %s/s\n", name, formatBytes(rate))
+		// aes-cfb is unauthenticated and never recommended
+		if name != fileenc.CipherAESCFB && rate > best {
+			fastest, best = name, rate
+		}
+	}
+
+	fmt.Printf("Key derivation calibrated to %v:\n", *target)
+	var recommended fileenc.KDFParams
+	for _, name := range []string{fileenc.KDFArgon2id, fileenc.KDFScrypt, fileenc.KDFPBKDF2} {
+		params, took, err := fileenc.CalibrateKDF(name, *target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error calibrating %s: %v\n", name, err)
+			os.Exit(exitFailure)
+		}
+		fmt.Printf("  %-20s %s (%v)\n", name, kdfArgs(params), took.Round(time.Millisecond))
+		if name == *kdf {
+			recommended = params
+		}
+	}
+
+	fmt.Printf("Recommended: -cipher %s %s\n", fastest, kdfArgs(recommended))
+	if *write {
+		path, err := writeDefaults([][2]string{
+			{"cipher", fastest},
+			{"kdf", recommended.Name},
+			{"kdf-time", strconv.FormatUint(uint64(recommended.Time), 10)},
+			{"kdf-memory", strconv.FormatUint(uint64(recommended.Memory), 10)},
+			{"kdf-threads", strconv.FormatUint(uint64(recommended.Threads), 10)},
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitIO)
+		}
+		fmt.Printf("Settings written to %s.\n", path)
+	}
+}
+
+// benchCipher encrypts size bytes with the named cipher and returns the bytes per second
+func benchCipher(name string, size int64) (float64, error) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	enc, err := fileenc.New(key, fileenc.WithCipher(name), fileenc.WithKDF(fileenc.KDFParams{Name: fileenc.KDFNone}))
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if err := enc.Encrypt(io.Discard, io.LimitReader(zeroReader{}, size)); err != nil {
+		return 0, err
+	}
+	return float64(size) / time.Since(start).Seconds(), nil
+}
+
+// kdfArgs returns the flags selecting the KDF parameters
+func kdfArgs(p fileenc.KDFParams) string {
+	args := []string{"-kdf " + p.Name, fmt.Sprintf("-kdf-time %d", p.Time)}
+	if p.Name != fileenc.KDFPBKDF2 {
+		args = append(args, fmt.Sprintf("-kdf-memory %d", p.Memory), fmt.Sprintf("-kdf-threads %d", p.Threads))
+	}
+	return strings.Join(args, " ")
+}
+
+// zeroReader is an endless source of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// runClip implements "fileenc clip encrypt|decrypt [flags]", replacing the
+// clipboard text with its armored encryption or the decrypted text
+func runClip(args []string) {
+	usage := func() {
+		fmt.Println("Usage: fileenc clip encrypt [flags]")
+		fmt.Println("       fileenc clip decrypt [flags]")
+		fmt.Println("Encrypts the clipboard text to ASCII armor or decrypts armored text in the clipboard, in place.")
+	}
+	if len(args) == 0 || (args[0] != "encrypt" && args[0] != "decrypt") {
+		usage()
+		os.Exit(exitUsage)
+	}
+	decrypt := args[0] == "decrypt"
+	action := "encrypt"
+	if decrypt {
+		action = "decrypt"
+	}
+
+	fs := flag.NewFlagSet("clip "+action, flag.ExitOnError)
+	keys := addKeyFlags(fs, !decrypt)
+	var ciphers *cipherFlags
+	if !decrypt {
+		ciphers = addCipherFlags(fs)
+	}
+	printFlag := fs.Bool("print", false, "write the result to stdout and leave the clipboard unchanged")
+	clearAfter := fs.Duration("clear-after", 0, "after decrypting, clear the clipboard after this time if it still holds the text, e.g. 30s")
+	fs.Usage = func() {
+		usage()
+		fs.PrintDefaults()
+	}
+	if rest := parseArgs(fs, args[1:]); len(rest) > 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	var opts []fileenc.Option
+	if !decrypt {
+		cipherOpts, err := ciphers.options()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCode(err, exitUsage))
+		}
+		opts = append(cipherOpts, fileenc.WithArmor())
+	}
+
+	in, err := clipboardRead()
+	if err == nil && len(bytes.TrimSpace(in)) == 0 {
+		err = errors.New("the clipboard holds no text")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading the clipboard: %v\n", err)
+		os.Exit(exitIO)
+	}
+	defer clear(in)
+
+	key, keyOpts, err := keys.load(decrypt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	defer clear(key)
+	enc, err := fileenc.New(key, append(opts, keyOpts...)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid settings: %v\n", err)
+		clear(key)
+		os.Exit(exitCode(err, exitUsage))
+	}
+
+	var out bytes.Buffer
+	if decrypt {
+		err = enc.Decrypt(&out, bytes.NewReader(bytes.TrimSpace(in)))
+	} else {
+		err = enc.Encrypt(&out, bytes.NewReader(in))
+	}
+	defer clear(out.Bytes())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot %s the clipboard: %v\n", action, err)
+		clear(key)
+		os.Exit(exitCode(err, exitFailure))
+	}
+
+	if *printFlag {
+		os.Stdout.Write(out.Bytes())
+		return
+	}
+	if err := clipboardWrite(out.Bytes()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the clipboard: %v\n", err)
+		clear(key)
+		os.Exit(exitIO)
+	}
+	fmt.Printf("Clipboard %sed.\n", action)
+
+	// Remove the plaintext unless something else has been copied meanwhile
+	if decrypt && *clearAfter > 0 {
+		fmt.Printf("Clearing the clipboard in %s.\n", *clearAfter)
+		time.Sleep(*clearAfter)
+		if current, err := clipboardRead(); err == nil && bytes.Equal(current, out.Bytes()) {
+			clipboardWrite(nil)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+//go:build !darwin && !windows
+
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// clipboardTool is a command line tool reading and writing the clipboard
+type clipboardTool struct {
+	read  []string
+	write []string
+}
+
+// clipboardTools are tried in order, wl-clipboard only on Wayland
+var clipboardTools = []clipboardTool{
+	{read: []string{"wl-paste", "--no-newline"}, write: []string{"wl-copy"}},
+	{read: []string{"xclip", "-selection", "clipboard", "-out"}, write: []string{"xclip", "-selection", "clipboard", "-in"}},
+	{read: []string{"xsel", "--clipboard", "--output"}, write: []string{"xsel", "--clipboard", "--input"}},
+}
+
+// findClipboardTool returns the first installed clipboard tool
+func findClipboardTool() (clipboardTool, error) {
+	for _, t := range clipboardTools {
+		if t.read[0] == "wl-paste" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			continue
+		}
+		if _, err := exec.LookPath(t.read[0]); err == nil {
+			return t, nil
+		}
+	}
+	return clipboardTool{}, errors.New("no clipboard tool found, install wl-clipboard, xclip or xsel")
+}
+
+// clipboardRead returns the text in the clipboard
+func clipboardRead() ([]byte, error) {
+	t, err := findClipboardTool()
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.Command(t.read[0], t.read[1:]...).Output()
+	if err != nil {
+		// An empty clipboard is reported as failure by some tools
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && len(out) == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s failed: %w", t.read[0], err)
+	}
+	return out, nil
+}
+
+// clipboardWrite replaces the clipboard text with text
+func clipboardWrite(text []byte) error {
+	t, err := findClipboardTool()
+	if err != nil {
+		return err
+	}
+	// The tools keep running in the background to serve the clipboard, so
+	// their output is not captured, waiting for it would block
+	cmd := exec.Command(t.write[0], t.write[1:]...)
+	cmd.Stdin = bytes.NewReader(text)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w", t.write[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// The clipboard is accessed through PowerShell, which passes the text as UTF-8
+const (
+	clipboardGet = "[Console]::OutputEncoding = [Text.Encoding]::UTF8; $t = Get-Clipboard -Raw; if ($t) { [Console]::Out.Write($t) }"
+	clipboardSet = "[Console]::InputEncoding = [Text.Encoding]::UTF8; $t = [Console]::In.ReadToEnd(); if ($t) { Set-Clipboard -Value $t } else { Set-Clipboard -Value $null }"
+)
+
+// clipboardRead returns the text in the clipboard
+func clipboardRead() ([]byte, error) {
+	out, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", clipboardGet).Output()
+	if err != nil {
+		return nil, fmt.Errorf("powershell failed: %w", err)
+	}
+	return out, nil
+}
+
+// clipboardWrite replaces the clipboard text with text
+func clipboardWrite(text []byte) error {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", clipboardSet)
+	cmd.Stdin = bytes.NewReader(text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("powershell failed: %v %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
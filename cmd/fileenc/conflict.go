@@ -0,0 +1,194 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/itkonzepte-net/fileenc"
+	"golang.org/x/term"
+)
+
+const (
+	// conflictFail fails files whose output exists, the default
+	conflictFail = "fail"
+	// conflictSkip leaves files whose output exists alone
+	conflictSkip = "skip"
+	// conflictOverwrite replaces existing outputs, like -overwrite
+	conflictOverwrite = "overwrite"
+	// conflictRename writes to the first free name with -1, -2, ... appended
+	conflictRename = "rename"
+	// conflictPrompt asks on the terminal what to do
+	conflictPrompt = "prompt"
+)
+
+// errExists is returned by run for files skipped by -on-conflict skip, it is no failure
+var errExists = errors.New("output exists")
+
+// conflicts decides what happens to files whose output already exists. The
+// answers to the prompt are shared by all files, so one applying to all
+// remaining files is kept here.
+type conflicts struct {
+	strategy string
+	mu       sync.Mutex
+	all      string
+}
+
+// newConflicts returns the handling of existing outputs for the -on-conflict strategy
+func newConflicts(strategy string) (*conflicts, error) {
+	switch strategy {
+	case conflictFail, conflictSkip, conflictOverwrite, conflictRename, conflictPrompt:
+		return &conflicts{strategy: strategy}, nil
+	}
+	return nil, fmt.Errorf("unknown conflict strategy %q, use skip, overwrite, rename, prompt or fail", strategy)
+}
+
+// replaces reports if the strategy replaces all existing outputs. Outputs
+// the user agreed to replace at the prompt are written with task.replace.
+func (c *conflicts) replaces() bool {
+	return c != nil && c.strategy == conflictOverwrite
+}
+
+// prompts reports if the strategy asks for every existing output
+func (c *conflicts) prompts() bool {
+	return c != nil && c.strategy == conflictPrompt
+}
+
+// resolve applies the -on-conflict strategy to the output of source. It
+// returns the task writing to the chosen output, errExists if the file is to
+// be skipped and fileenc.ErrFileExists if it fails. Overwriting and failing
+// are left to the Encryptor.
+func (t task) resolve(source string, log *slog.Logger) (task, error) {
+	c := t.conflicts
+	if c == nil || c.strategy == conflictFail || c.strategy == conflictOverwrite || (t.inPlace && !t.rename) {
+		return t, nil
+	}
+	_, dst := t.paths(source)
+	if !t.exists(dst) {
+		return t, nil
+	}
+	action := c.strategy
+	if action == conflictPrompt {
+		var err error
+		if action, err = t.ask(dst); err != nil {
+			return t, fmt.Errorf("%w: %s, %v", fileenc.ErrFileExists, dst, err)
+		}
+	}
+	switch action {
+	case conflictSkip:
+		log.Info("Output exists, file skipped", "file", source, "output", dst)
+		return t, errExists
+	case conflictRename:
+		t.out = t.renamed(dst, t.exists)
+		log.Debug("Output exists, renamed", "output", dst, "renamed", t.out)
+	default:
+		t.out, t.overwrite = dst, true
+	}
+	// The output is settled, later checks must not ask again
+	t.conflicts = nil
+	return t, nil
+}
+
+// exists reports if anything would be in the way of writing dst: the file, its
+// volumes or its key shares
+func (t task) exists(dst string) bool {
+	if _, err := os.Lstat(dst); err == nil {
+		return true
+	}
+	if _, err := os.Lstat(dst + firstVolume); err == nil {
+		return true
+	}
+	if t.shares > 0 {
+		if _, err := os.Lstat(fileenc.SharePath(dst, 1)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// renamed returns the first name derived from dst with -1, -2, ... inserted
+// before the extension of the plaintext name, e.g. report-1.pdf.enc, that
+// taken reports as free
+func (t task) renamed(dst string, taken func(string) bool) string {
+	base, suffix := dst, ""
+	if !t.decrypt && t.suffix != "" {
+		if trimmed, ok := strings.CutSuffix(dst, t.suffix); ok && trimmed != "" {
+			base, suffix = trimmed, t.suffix
+		}
+	}
+	ext := filepath.Ext(base)
+	if ext == filepath.Base(base) {
+		// Hidden files like .profile have no extension
+		ext = ""
+	}
+	base = strings.TrimSuffix(base, ext)
+	for n := 1; ; n++ {
+		name := base + "-" + strconv.Itoa(n) + ext + suffix
+		if !taken(name) {
+			return name
+		}
+	}
+}
+
+// ask prompts on the terminal what to do with the existing dst and returns
+// the strategy chosen. An answer in capitals applies to all remaining files.
+// Prompts of parallel files wait for each other.
+func (t task) ask(dst string) (string, error) {
+	c := t.conflicts
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.all != "" {
+		return c.all, nil
+	}
+
+	tty := os.Stdin
+	if !term.IsTerminal(int(tty.Fd())) {
+		var err error
+		if tty, err = os.OpenFile(ttyPath, os.O_RDWR, 0); err != nil || !term.IsTerminal(int(tty.Fd())) {
+			return "", errors.New("no terminal to ask")
+		}
+		defer tty.Close()
+	}
+	if t.progress != nil {
+		t.progress.clear()
+	}
+	answers := map[string]string{"y": conflictOverwrite, "n": conflictSkip, "r": conflictRename}
+	in := bufio.NewReader(tty)
+	for {
+		fmt.Fprintf(os.Stderr, "%s exists. Overwrite? [y]es, [n]o, [r]ename, in capitals for all remaining files: ", dst)
+		line, err := in.ReadString('\n')
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			return "", fmt.Errorf("failed to read the answer: %w", err)
+		}
+		answer := strings.TrimSpace(line)
+		if action, ok := answers[strings.ToLower(answer)]; ok {
+			if answer != strings.ToLower(answer) {
+				c.all = action
+			}
+			return action, nil
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// conflictTask returns a task encrypting with strategy and the paths of a
+// source and its existing output in a temporary directory
+func conflictTask(t *testing.T, strategy string) (task, string, string) {
+	t.Helper()
+	c, err := newConflicts(strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := []fileenc.Option{fileenc.WithKDF(fileenc.KDFParams{Name: fileenc.KDFPBKDF2, Time: 1})}
+	enc, err := fileenc.New([]byte("conflict test"), append(opts, fileenc.WithOverwrite(c.replaces()))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replace, err := fileenc.New([]byte("conflict test"), append(opts, fileenc.WithOverwrite(true))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := task{enc: enc, suffix: ".enc", conflicts: c, overwrite: c.replaces()}
+	if c.prompts() {
+		tk.replace = replace
+	}
+	dir := t.TempDir()
+	in, dst := filepath.Join(dir, "report.pdf"), filepath.Join(dir, "report.pdf.enc")
+	if err := os.WriteFile(in, []byte("plaintext"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("existing"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return tk, in, dst
+}
+
+// unchanged fails t if the file at path does not hold "existing" anymore
+func unchanged(t *testing.T, path string) {
+	t.Helper()
+	if data, err := os.ReadFile(path); err != nil || string(data) != "existing" {
+		t.Errorf("%s was replaced: %v", path, err)
+	}
+}
+
+// TestConflictFail fails a file whose output exists and keeps the output
+func TestConflictFail(t *testing.T) {
+	tk, in, dst := conflictTask(t, conflictFail)
+	if err := tk.run(in, slog.New(slog.DiscardHandler)); !errors.Is(err, fileenc.ErrFileExists) {
+		t.Errorf("got %v, want ErrFileExists", err)
+	}
+	unchanged(t, dst)
+}
+
+// TestConflictSkip skips a file whose output exists without failing
+func TestConflictSkip(t *testing.T) {
+	tk, in, dst := conflictTask(t, conflictSkip)
+	if err := tk.run(in, slog.New(slog.DiscardHandler)); !errors.Is(err, errExists) {
+		t.Errorf("got %v, want errExists", err)
+	}
+	unchanged(t, dst)
+	if _, err := os.Stat(in); err != nil {
+		t.Errorf("skipped source removed: %v", err)
+	}
+}
+
+// TestConflictRename writes to the first free name and keeps the output
+func TestConflictRename(t *testing.T) {
+	tk, in, dst := conflictTask(t, conflictRename)
+	renamed := filepath.Join(filepath.Dir(dst), "report-1.pdf.enc")
+	if err := os.WriteFile(renamed, []byte("existing"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := tk.run(in, slog.New(slog.DiscardHandler)); err != nil {
+		t.Fatal(err)
+	}
+	unchanged(t, dst)
+	unchanged(t, renamed)
+	if info, err := fileenc.InspectFile(filepath.Join(filepath.Dir(dst), "report-2.pdf.enc")); err != nil || info.Format != fileenc.FormatFileenc {
+		t.Errorf("report-2.pdf.enc not written: %v", err)
+	}
+}
+
+// TestRenamed checks the names tried for a taken output
+func TestRenamed(t *testing.T) {
+	tests := []struct {
+		decrypt bool
+		dst     string
+		taken   int
+		want    string
+	}{
+		{false, "dir/report.pdf.enc", 0, "dir/report-1.pdf.enc"},
+		{false, "dir/report.pdf.enc", 2, "dir/report-3.pdf.enc"},
+		{false, "notes.enc", 0, "notes-1.enc"},
+		{false, ".profile.enc", 0, ".profile-1.enc"},
+		{false, "archive.tar.gz.enc", 0, "archive.tar-1.gz.enc"},
+		{true, "dir/report.pdf", 1, "dir/report-2.pdf"},
+		{true, "README", 0, "README-1"},
+	}
+	for _, tt := range tests {
+		tried := 0
+		got := task{decrypt: tt.decrypt, suffix: ".enc"}.renamed(tt.dst, func(string) bool {
+			tried++
+			return tried <= tt.taken
+		})
+		if got != tt.want {
+			t.Errorf("renamed(%q) with %d taken = %q, want %q", tt.dst, tt.taken, got, tt.want)
+		}
+	}
+}
+
+// TestConflictPrompt only replaces the outputs the user agreed to replace, an
+// output appearing after the prompt is not overwritten
+func TestConflictPrompt(t *testing.T) {
+	tk, in, dst := conflictTask(t, conflictPrompt)
+	if tk.conflicts.replaces() {
+		t.Fatal("prompt replaces all outputs")
+	}
+	if err := os.Remove(dst); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing to ask for, but then the output appears
+	resolved, err := tk.resolve(in, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("existing"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolved.encryptFile(in, dst); !errors.Is(err, fileenc.ErrFileExists) {
+		t.Errorf("got %v, want ErrFileExists", err)
+	}
+	unchanged(t, dst)
+
+	// An output the user agreed to replace is overwritten
+	resolved.overwrite = true
+	if err := resolved.encryptFile(in, dst); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fileenc.InspectFile(dst); err != nil || info.Format != fileenc.FormatFileenc {
+		t.Errorf("confirmed output not replaced: %v", err)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// daemonSocketEnv names the socket of the daemon if it is not the default one
+const daemonSocketEnv = "FILEENC_DAEMON_SOCKET"
+
+// daemonRequest asks the daemon to encrypt or decrypt the file Input to Output, both absolute
+type daemonRequest struct {
+	Op        string `json:"op"`
+	Input     string `json:"input"`
+	Output    string `json:"output"`
+	Overwrite bool   `json:"overwrite"`
+	Force     bool   `json:"force"`
+}
+
+// daemonResponse reports the outcome of a request, Error is empty on success
+type daemonResponse struct {
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+}
+
+// daemonError is a failure reported by the daemon, it keeps the exit code
+type daemonError struct {
+	msg  string
+	code int
+}
+
+func (e *daemonError) Error() string {
+	return e.msg
+}
+
+// defaultSocket returns the socket path in the private runtime directory of the user
+func defaultSocket() string {
+	if s := os.Getenv(daemonSocketEnv); s != "" {
+		return s
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = tempSocketDir()
+	}
+	return filepath.Join(dir, "fileenc.sock")
+}
+
+// tempSocketDir returns the socket directory used without XDG_RUNTIME_DIR. Its
+// name is predictable, so it is only used if it is private to the user.
+func tempSocketDir() string {
+	name := "fileenc"
+	if uid := os.Getuid(); uid >= 0 {
+		name = fmt.Sprintf("fileenc-%d", uid)
+	}
+	return filepath.Join(os.TempDir(), name)
+}
+
+// checkSocketDir refuses the temporary socket directory unless it is private
+// to the user, another user creating it first could take over or spoof the daemon
+func checkSocketDir(path string) error {
+	dir := filepath.Dir(path)
+	if dir != tempSocketDir() {
+		return nil
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return fmt.Errorf("unsafe socket directory: %w", err)
+	}
+	return nil
+}
+
+// daemon serves encryption and decryption requests with the key loaded at start
+type daemon struct {
+	// opts holds the settings shared by both directions
+	opts    []fileenc.Option
+	key     []byte
+	encOpts []fileenc.Option
+	decOpts []fileenc.Option
+	cache   *fileenc.KeyCache
+	quiet   bool
+	events  *events
+	metrics *metrics
+}
+
+// runDaemon implements "fileenc daemon [-socket <path>]"
+func runDaemon(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	keys := addKeyFlags(fs, true)
+	ciphers := addCipherFlags(fs)
+	metadata := addMetadataFlags(fs)
+	socket := fs.String("socket", defaultSocket(), "path of the Unix socket, also taken from "+daemonSocketEnv)
+	quiet := fs.Bool("quiet", false, "only report errors")
+	eventFlags := addEventFlags(fs)
+	metricsListen := fs.String("metrics-listen", "", "serve Prometheus metrics at /metrics on this address, e.g. 127.0.0.1:9400; the endpoint has no authentication")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc daemon [flags]")
+		fmt.Fprintln(fs.Output(), "Keeps the key in memory and encrypts or decrypts the files sent with fileenc -daemon.")
+		fs.PrintDefaults()
+	}
+	if len(parseArgs(fs, args)) != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	d := &daemon{cache: fileenc.NewKeyCache(), quiet: *quiet}
+	cipherOpts, err := ciphers.options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	d.opts = metadata.options()
+	d.encOpts = cipherOpts
+	if d.events, err = eventFlags.open(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	// A passphrase serves both directions, recipients need identities to decrypt
+	key, opts, err := keys.load(false)
+	if err == nil && key == nil && (len(keys.identities) > 0 || keys.keyName != "" || keys.kms) {
+		var decOpts []fileenc.Option
+		if key, decOpts, err = keys.load(true); err == nil {
+			d.decOpts = decOpts
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	d.key, d.encOpts = key, append(d.encOpts, opts...)
+	defer clear(key)
+	defer d.cache.Clear()
+	if _, err := d.encryptor("encrypt", false, false); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid settings: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+
+	if *metricsListen != "" {
+		if err := d.serveMetrics(*metricsListen); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitIO)
+		}
+	}
+	ln, err := listenSocket(*socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitIO)
+	}
+	defer os.Remove(*socket)
+	if !*quiet {
+		fmt.Printf("Listening on %s, press Ctrl-C to stop.\n", *socket)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "Error accepting connection: %v\n", err)
+			}
+			return
+		}
+		go d.serve(conn)
+	}
+}
+
+// serveMetrics serves the metrics of the daemon on addr in the background
+func (d *daemon) serveMetrics(addr string) error {
+	d.metrics = newMetrics()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", d.metrics)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	if !d.quiet {
+		fmt.Printf("Serving metrics on http://%s/metrics\n", ln.Addr())
+	}
+	return nil
+}
+
+// listenSocket listens on the Unix socket at path. The default directory is
+// created private to the user, a stale socket of a crashed daemon is removed.
+func listenSocket(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := checkSocketDir(path); err != nil {
+		return nil, err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %s", path)
+	}
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to protect socket: %w", err)
+	}
+	return ln, nil
+}
+
+// encryptor returns the Encryptor for a request, all share the key cache
+func (d *daemon) encryptor(op string, overwrite, force bool) (*fileenc.Encryptor, error) {
+	opts := append([]fileenc.Option{fileenc.WithKeyCache(d.cache), fileenc.WithOverwrite(overwrite)}, d.opts...)
+	if force {
+		opts = append(opts, fileenc.WithForce())
+	}
+	switch op {
+	case "encrypt":
+		opts = append(opts, d.encOpts...)
+	case "decrypt":
+		if d.key == nil && d.decOpts == nil {
+			return nil, errors.New("the daemon has no identities to decrypt")
+		}
+		opts = append(opts, d.decOpts...)
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op)
+	}
+	return fileenc.New(d.key, opts...)
+}
+
+// serve answers the request on conn
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+	var req daemonRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	e, start := event{Operation: req.Op, File: req.Input, Output: req.Output}, time.Now()
+	e.BytesIn, _ = fileSize(req.Input)
+	d.events.start(slog.Default(), e)
+	d.metrics.begin()
+	err := d.process(req)
+	if err == nil {
+		e.BytesOut, _ = fileSize(req.Output)
+	}
+	d.metrics.observe(req.Op, e.BytesIn, e.BytesOut, time.Since(start), err)
+	d.events.finish(slog.Default(), e, start, err)
+	var resp daemonResponse
+	if err != nil {
+		resp = daemonResponse{Error: hint(err).Error(), ExitCode: exitCode(err, exitFailure)}
+		fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", req.Input, err)
+	} else if !d.quiet {
+		fmt.Printf("File %s %sed successfully.\n", req.Input, req.Op)
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// process encrypts or decrypts the file of req
+func (d *daemon) process(req daemonRequest) error {
+	if !filepath.IsAbs(req.Input) || !filepath.IsAbs(req.Output) {
+		return errors.New("paths must be absolute")
+	}
+	enc, err := d.encryptor(req.Op, req.Overwrite, req.Force)
+	if err != nil {
+		return err
+	}
+	if req.Op == "decrypt" {
+		return enc.DecryptFile(req.Input, req.Output)
+	}
+	return enc.EncryptFile(req.Input, req.Output)
+}
+
+// callDaemon sends the request to the daemon listening on socket and returns its error
+func callDaemon(socket string, req daemonRequest) error {
+	var err error
+	if req.Input, err = filepath.Abs(req.Input); err != nil {
+		return err
+	}
+	if req.Output, err = filepath.Abs(req.Output); err != nil {
+		return err
+	}
+	if err := checkSocketDir(socket); err != nil {
+		return err
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the daemon, start it with fileenc daemon: %w", err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	var resp daemonResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return &daemonError{msg: resp.Error, code: resp.ExitCode}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// defaultsEnv is the environment variable overriding the defaults file location
+const defaultsEnv = "FILEENC_DEFAULTS"
+
+// defaultsPath returns the location of the defaults file,
+// ~/.config/fileenc/defaults on Linux unless FILEENC_DEFAULTS is set
+func defaultsPath() (string, error) {
+	if path := os.Getenv(defaultsEnv); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the defaults file: %w", err)
+	}
+	return filepath.Join(dir, "fileenc", "defaults"), nil
+}
+
+// readDefaults returns the name=value lines of the defaults file in order, a
+// missing file has none. Empty lines and lines starting with # are skipped.
+func readDefaults(path string) ([][2]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read defaults: %w", err)
+	}
+	defer file.Close()
+	var defaults [][2]string
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected name=value", path, n)
+		}
+		defaults = append(defaults, [2]string{strings.TrimPrefix(strings.TrimSpace(name), "-"), strings.TrimSpace(value)})
+	}
+	return defaults, scanner.Err()
+}
+
+// applyDefaults sets the flags of fs named in the defaults file, flags given
+// on the command line override them
+func applyDefaults(fs *flag.FlagSet) error {
+	path, err := defaultsPath()
+	if err != nil {
+		return err
+	}
+	defaults, err := readDefaults(path)
+	if err != nil {
+		return err
+	}
+	for _, d := range defaults {
+		f := fs.Lookup(d[0])
+		if f == nil {
+			continue
+		}
+		if err := f.Value.Set(d[1]); err != nil {
+			return fmt.Errorf("%s: invalid value %q for %s: %w", path, d[1], d[0], err)
+		}
+		f.DefValue = d[1]
+	}
+	return nil
+}
+
+// writeDefaults sets the values in the defaults file, keeping its other lines
+func writeDefaults(values [][2]string) (string, error) {
+	path, err := defaultsPath()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("failed to read defaults: %w", err)
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	for _, v := range values {
+		line := v[0] + "=" + v[1]
+		i := slices.IndexFunc(lines, func(l string) bool {
+			name, _, ok := strings.Cut(l, "=")
+			return ok && strings.TrimPrefix(strings.TrimSpace(name), "-") == v[0]
+		})
+		if i >= 0 {
+			lines[i] = line
+		} else {
+			lines = append(lines, line)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create defaults directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write defaults: %w", err)
+	}
+	return path, nil
+}
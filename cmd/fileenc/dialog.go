@@ -0,0 +1,101 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// promptDialog is set by -dialog, readPassword then asks in a window instead
+// of on the terminal, for the shell integration started without terminal
+var promptDialog bool
+
+// readPasswordDialog asks for the passphrase called name in a window. With
+// confirm set the passphrase has to be entered twice.
+func readPasswordDialog(name string, confirm bool) ([]byte, error) {
+	pass, err := dialogPassword("Enter " + name + ":")
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("empty %s", name)
+	}
+	if !confirm {
+		return pass, nil
+	}
+	again, err := dialogPassword("Confirm " + name + ":")
+	if err != nil {
+		clear(pass)
+		return nil, err
+	}
+	defer clear(again)
+	if !bytes.Equal(pass, again) {
+		clear(pass)
+		return nil, fmt.Errorf("%ss do not match", name)
+	}
+	return pass, nil
+}
+
+// dialogHandler shows the errors logged before the files are processed in a
+// window and passes all records on
+type dialogHandler struct {
+	slog.Handler
+}
+
+// Handle shows error records in a window
+func (h dialogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		text := r.Message
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == "error" {
+				text += ": " + a.Value.String()
+			}
+			return true
+		})
+		dialogMessage(text, true)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs keeps showing the errors of the derived handler
+func (h dialogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return dialogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup keeps showing the errors of the derived handler
+func (h dialogHandler) WithGroup(name string) slog.Handler {
+	return dialogHandler{h.Handler.WithGroup(name)}
+}
+
+// dialogSummary returns the text reporting the processed files in a window
+func dialogSummary(action string, files int, failed []error) string {
+	if len(failed) == 0 {
+		if files == 1 {
+			return "The file was " + action + " successfully."
+		}
+		return fmt.Sprintf("%d files were %s successfully.", files, action)
+	}
+	lines := make([]string, len(failed))
+	for i, err := range failed {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("%d of %d files failed:\n\n%s", len(failed), files, strings.Join(lines, "\n"))
+}
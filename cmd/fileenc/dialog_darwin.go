@@ -0,0 +1,57 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+const (
+	// dialogAskScript asks for a hidden answer, the prompt is its argument
+	dialogAskScript = `on run argv
+	text returned of (display dialog (item 1 of argv) default answer "" with hidden answer with title "fileenc")
+end run`
+	// dialogShowScript shows its first argument with the icon named by the second
+	dialogShowScript = `on run argv
+	if item 2 of argv is "stop" then
+		display dialog (item 1 of argv) buttons {"OK"} default button 1 with title "fileenc" with icon stop
+	else
+		display dialog (item 1 of argv) buttons {"OK"} default button 1 with title "fileenc" with icon note
+	end if
+end run`
+)
+
+// dialogPassword asks for a passphrase in a window without showing it
+func dialogPassword(text string) ([]byte, error) {
+	out, err := exec.Command("osascript", "-e", dialogAskScript, text).Output()
+	if err != nil {
+		clear(out)
+		return nil, fmt.Errorf("dialog cancelled or failed: %w", err)
+	}
+	return bytes.TrimSuffix(out, []byte("\n")), nil
+}
+
+// dialogMessage shows text in a window, as an error if failed is set
+func dialogMessage(text string, failed bool) error {
+	icon := "note"
+	if failed {
+		icon = "stop"
+	}
+	return exec.Command("osascript", "-e", dialogShowScript, text, icon).Run()
+}
@@ -0,0 +1,74 @@
+//go:build !darwin && !windows
+
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// dialogTool returns the installed dialog tool, zenity or kdialog
+func dialogTool() (string, error) {
+	for _, name := range []string{"zenity", "kdialog"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return name, nil
+		}
+	}
+	return "", errors.New("no dialog tool found, install zenity or kdialog")
+}
+
+// dialogPassword asks for a passphrase in a window without showing it
+func dialogPassword(text string) ([]byte, error) {
+	tool, err := dialogTool()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--password", text}
+	if tool == "zenity" {
+		args = []string{"--entry", "--hide-text", "--title", "fileenc", "--text", text}
+	}
+	out, err := exec.Command(tool, args...).Output()
+	if err != nil {
+		clear(out)
+		return nil, fmt.Errorf("%s cancelled or failed: %w", tool, err)
+	}
+	return bytes.TrimSuffix(out, []byte("\n")), nil
+}
+
+// dialogMessage shows text in a window, as an error if failed is set
+func dialogMessage(text string, failed bool) error {
+	tool, err := dialogTool()
+	if err != nil {
+		return err
+	}
+	var args []string
+	switch {
+	case tool == "zenity" && failed:
+		args = []string{"--error", "--title", "fileenc", "--no-markup", "--text", text}
+	case tool == "zenity":
+		args = []string{"--info", "--title", "fileenc", "--no-markup", "--text", text}
+	case failed:
+		args = []string{"--title", "fileenc", "--error", text}
+	default:
+		args = []string{"--title", "fileenc", "--msgbox", text}
+	}
+	return exec.Command(tool, args...).Run()
+}
@@ -0,0 +1,67 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+const (
+	// dialogTextEnv passes the text to the PowerShell scripts, avoiding quoting
+	dialogTextEnv = "FILEENC_DIALOG_TEXT"
+
+	// dialogAskScript shows a form with a masked text box and writes the entry to stdout
+	dialogAskScript = `Add-Type -AssemblyName System.Windows.Forms
+[Console]::OutputEncoding = [Text.Encoding]::UTF8
+$f = New-Object Windows.Forms.Form -Property @{Text='fileenc'; Width=360; Height=150; FormBorderStyle='FixedDialog'; StartPosition='CenterScreen'; TopMost=$true; MaximizeBox=$false; MinimizeBox=$false}
+$l = New-Object Windows.Forms.Label -Property @{Text=$env:FILEENC_DIALOG_TEXT; Left=10; Top=10; Width=320}
+$t = New-Object Windows.Forms.TextBox -Property @{Left=10; Top=35; Width=320; UseSystemPasswordChar=$true}
+$o = New-Object Windows.Forms.Button -Property @{Text='OK'; Left=170; Top=70; DialogResult='OK'}
+$c = New-Object Windows.Forms.Button -Property @{Text='Cancel'; Left=255; Top=70; DialogResult='Cancel'}
+$f.Controls.AddRange(@($l, $t, $o, $c)); $f.AcceptButton = $o; $f.CancelButton = $c
+if ($f.ShowDialog() -ne 'OK') { exit 1 }
+[Console]::Out.Write($t.Text)`
+	// dialogShowScript shows the text in a message box with the icon in $env:FILEENC_DIALOG_ICON
+	dialogShowScript = `Add-Type -AssemblyName System.Windows.Forms
+[void][Windows.Forms.MessageBox]::Show($env:FILEENC_DIALOG_TEXT, 'fileenc', 'OK', $env:FILEENC_DIALOG_ICON)`
+)
+
+// dialogPassword asks for a passphrase in a window without showing it
+func dialogPassword(text string) ([]byte, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-WindowStyle", "Hidden", "-Command", dialogAskScript)
+	cmd.Env = append(os.Environ(), dialogTextEnv+"="+text)
+	out, err := cmd.Output()
+	if err != nil {
+		clear(out)
+		return nil, fmt.Errorf("dialog cancelled or failed: %w", err)
+	}
+	return bytes.TrimSuffix(out, []byte("\r\n")), nil
+}
+
+// dialogMessage shows text in a window, as an error if failed is set
+func dialogMessage(text string, failed bool) error {
+	icon := "Information"
+	if failed {
+		icon = "Error"
+	}
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-WindowStyle", "Hidden", "-Command", dialogShowScript)
+	cmd.Env = append(os.Environ(), dialogTextEnv+"="+text, "FILEENC_DIALOG_ICON="+icon)
+	return cmd.Run()
+}
@@ -0,0 +1,128 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// dryRun reports what processing the files would do and the conflicts it would
+// run into, without touching any file. It returns the conflicts.
+func (t task) dryRun(files []string) []error {
+	action := "encrypt"
+	if t.decrypt {
+		action = "decrypt"
+	}
+	var conflicts []error
+	outputs := make(map[string]string)
+	for _, source := range files {
+		in, dst := t.paths(source)
+		msg, err := t.plan(in, dst, outputs)
+		if t.json {
+			res := result{File: in, Output: dst, Status: "planned"}
+			if info, serr := os.Stat(in); serr == nil {
+				res.BytesIn = info.Size()
+			}
+			if err != nil {
+				res.Status, res.Error, res.ExitCode = "conflict", err.Error(), exitCode(err, exitFailure)
+			}
+			json.NewEncoder(os.Stdout).Encode(res)
+		} else if err != nil {
+			fmt.Printf("Conflict: cannot %s %s: %v\n", action, in, err)
+		} else {
+			fmt.Printf("Would %s %s\n", action, msg)
+		}
+		if err != nil {
+			conflicts = append(conflicts, err)
+		}
+	}
+	if !t.json {
+		fmt.Printf("Dry run: %d of %d files would be processed, %d conflicts.\n", len(files)-len(conflicts), len(files), len(conflicts))
+	}
+	return conflicts
+}
+
+// plan describes how in would be processed into dst. outputs collects the
+// planned outputs to detect files written twice.
+func (t task) plan(in, dst string, outputs map[string]string) (string, error) {
+	// Split files are checked by their first volume
+	if parts := fileenc.SplitParts(in); parts != nil {
+		in = parts[0]
+	}
+	if t.split > 0 {
+		dst += firstVolume
+	}
+	info, err := os.Stat(in)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", errors.New("not a regular file")
+	}
+	if t.decrypt && !t.legacy {
+		if _, err := fileenc.InspectFile(in); err != nil {
+			return "", err
+		}
+	}
+	// Files renamed on conflict take the next name not planned already
+	var renamed string
+	if base := strings.TrimSuffix(dst, firstVolume); t.conflicts != nil && t.conflicts.strategy == conflictRename && in != dst && t.exists(base) {
+		volume := strings.TrimPrefix(dst, base)
+		dst = t.renamed(base, func(name string) bool {
+			_, planned := outputs[name+volume]
+			return planned || t.exists(name)
+		}) + volume
+		renamed = base
+	}
+	if other, ok := outputs[dst]; ok {
+		return "", fmt.Errorf("%s is written for %s as well", dst, other)
+	}
+	outputs[dst] = in
+
+	msg := fmt.Sprintf("%s to %s", in, dst)
+	if in == dst {
+		msg = in + " in place"
+	} else if renamed != "" {
+		msg += fmt.Sprintf(" as %s exists", renamed)
+	} else if _, err := os.Lstat(dst); err == nil {
+		switch {
+		case t.conflicts != nil && t.conflicts.strategy == conflictSkip:
+			msg += ", but skip it as the output exists"
+		case t.conflicts != nil && t.conflicts.strategy == conflictPrompt:
+			msg += ", asking whether to overwrite it"
+		case !t.overwrite:
+			return "", fmt.Errorf("%w: %s, use -overwrite or -on-conflict", fileenc.ErrFileExists, dst)
+		default:
+			msg += ", overwriting it"
+		}
+	}
+	switch {
+	case t.shred && !t.decrypt:
+		msg += fmt.Sprintf(", then shred %s", in)
+	case t.deleteSrc:
+		msg += fmt.Sprintf(", then delete %s", in)
+	case t.inPlace && in != dst:
+		msg += fmt.Sprintf(", then remove %s", in)
+	}
+	return msg, nil
+}
@@ -0,0 +1,256 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// journalSocket is where systemd-journald receives native messages
+	journalSocket = "/run/systemd/journal/socket"
+	// webhookAuthEnv holds the Authorization header sent to webhooks, e.g. "Bearer <token>"
+	webhookAuthEnv = "FILEENC_WEBHOOK_AUTH"
+)
+
+// event describes the start or the outcome of an operation on a file
+type event struct {
+	Event     string  `json:"event"`
+	Time      string  `json:"time"`
+	Host      string  `json:"host"`
+	User      string  `json:"user"`
+	PID       int     `json:"pid"`
+	Operation string  `json:"operation"`
+	File      string  `json:"file"`
+	Output    string  `json:"output"`
+	Client    string  `json:"client,omitempty"`
+	Duration  float64 `json:"duration_seconds,omitempty"`
+	BytesIn   int64   `json:"bytes_in"`
+	BytesOut  int64   `json:"bytes_out,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	ExitCode  int     `json:"exit_code,omitempty"`
+}
+
+// message returns a line describing e for humans
+func (e event) message() string {
+	switch e.Event {
+	case "start":
+		return fmt.Sprintf("%s %s started", e.Operation, e.File)
+	case "failure":
+		return fmt.Sprintf("%s %s failed: %s", e.Operation, e.File, e.Error)
+	case "unchanged":
+		return fmt.Sprintf("%s %s skipped, unchanged", e.Operation, e.File)
+	}
+	return fmt.Sprintf("%s %s succeeded after %.3fs, %d bytes written", e.Operation, e.File, e.Duration, e.BytesOut)
+}
+
+// eventSink delivers events to syslog, journald or a webhook
+type eventSink interface {
+	send(e event) error
+}
+
+// eventFlags holds the destinations of -events
+type eventFlags struct {
+	dests stringList
+}
+
+// addEventFlags registers the -events flag on fs
+func addEventFlags(fs *flag.FlagSet) *eventFlags {
+	f := &eventFlags{}
+	fs.Var(&f.dests, "events", "send start, success and failure events of every file to syslog, syslog://host[:port], syslog+tcp://host[:port], journald or an http(s):// webhook, may be repeated")
+	return f
+}
+
+// open connects to the destinations, it returns nil without any
+func (f *eventFlags) open() (*events, error) {
+	if len(f.dests) == 0 {
+		return nil, nil
+	}
+	ev := &events{}
+	for _, dest := range f.dests {
+		sink, err := newEventSink(dest)
+		if err != nil {
+			return nil, fmt.Errorf("-events %s: %w", dest, err)
+		}
+		ev.sinks = append(ev.sinks, sink)
+	}
+	return ev, nil
+}
+
+// newEventSink returns the sink for the destination dest
+func newEventSink(dest string) (eventSink, error) {
+	if dest == "syslog" {
+		return newSyslogSink("", "")
+	}
+	if dest == "journald" {
+		return &journalSink{}, nil
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+udp", "syslog+tcp":
+		network, addr := "udp", u.Host
+		if u.Scheme == "syslog+tcp" {
+			network = "tcp"
+		}
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "514")
+		}
+		return newSyslogSink(network, addr)
+	case "http", "https":
+		return &webhookSink{url: dest, auth: os.Getenv(webhookAuthEnv)}, nil
+	}
+	return nil, errors.New("unknown destination, use syslog, syslog://host, syslog+tcp://host, journald or an http(s):// URL")
+}
+
+// events sends the events of the operations to all sinks. A nil *events
+// sends nothing. Failed deliveries are reported but fail no file.
+type events struct {
+	sinks []eventSink
+}
+
+// emit completes e and sends it to every sink
+func (ev *events) emit(log *slog.Logger, e event) {
+	if ev == nil {
+		return
+	}
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	e.Host, _ = os.Hostname()
+	e.User, e.PID = auditUser(), os.Getpid()
+	e.File, e.Output = auditPath(e.File), auditPath(e.Output)
+	for _, sink := range ev.sinks {
+		if err := sink.send(e); err != nil {
+			log.Warn("Error sending the event", "event", e.Event, "file", e.File, "error", err)
+		}
+	}
+}
+
+// start reports that the operation described by e begins
+func (ev *events) start(log *slog.Logger, e event) {
+	e.Event = "start"
+	ev.emit(log, e)
+}
+
+// finish reports the outcome err of the operation described by e, started at start
+func (ev *events) finish(log *slog.Logger, e event, start time.Time, err error) {
+	e.Event, e.Duration = "success", time.Since(start).Seconds()
+	switch {
+	case errors.Is(err, errUnchanged):
+		e.Event = "unchanged"
+	case err != nil:
+		e.Event, e.Error, e.ExitCode = "failure", err.Error(), exitCode(err, exitFailure)
+	}
+	ev.emit(log, e)
+}
+
+// journalSink writes events to journald with the native protocol, the
+// fields are searchable, e.g. journalctl FILEENC_EVENT=failure
+type journalSink struct{}
+
+func (journalSink) send(e event) error {
+	priority := "6"
+	if e.Event == "failure" {
+		priority = "3"
+	}
+	var msg bytes.Buffer
+	field := func(name, value string) {
+		if !strings.Contains(value, "\n") {
+			fmt.Fprintf(&msg, "%s=%s\n", name, value)
+			return
+		}
+		// Values with line breaks are sent with their length
+		msg.WriteString(name + "\n")
+		binary.Write(&msg, binary.LittleEndian, uint64(len(value)))
+		msg.WriteString(value + "\n")
+	}
+	field("MESSAGE", e.message())
+	field("PRIORITY", priority)
+	field("SYSLOG_IDENTIFIER", "fileenc")
+	field("FILEENC_EVENT", e.Event)
+	field("FILEENC_OPERATION", e.Operation)
+	field("FILEENC_FILE", e.File)
+	field("FILEENC_OUTPUT", e.Output)
+	field("FILEENC_USER", e.User)
+	if e.Client != "" {
+		field("FILEENC_CLIENT", e.Client)
+	}
+	field("FILEENC_BYTES_IN", strconv.FormatInt(e.BytesIn, 10))
+	if e.Event != "start" {
+		field("FILEENC_DURATION", strconv.FormatFloat(e.Duration, 'f', 3, 64))
+		field("FILEENC_BYTES_OUT", strconv.FormatInt(e.BytesOut, 10))
+	}
+	if e.Error != "" {
+		field("FILEENC_ERROR", e.Error)
+		field("FILEENC_EXIT_CODE", strconv.Itoa(e.ExitCode))
+	}
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write(msg.Bytes())
+	return err
+}
+
+// webhookSink posts every event as JSON object to a URL
+type webhookSink struct {
+	url  string
+	auth string
+}
+
+// webhookClient gives up on webhooks that do not answer
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func (s *webhookSink) send(e event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.auth != "" {
+		req.Header.Set("Authorization", s.auth)
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
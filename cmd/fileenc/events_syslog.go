@@ -0,0 +1,49 @@
+//go:build !windows && !plan9
+
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// syslogSink writes events to syslog as JSON, failures with priority err
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+// newSyslogSink connects to the syslog server at addr, the local one if network is empty
+func newSyslogSink(network, addr string) (eventSink, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "fileenc")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) send(e event) error {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if e.Event == "failure" {
+		return s.w.Err(string(msg))
+	}
+	return s.w.Info(string(msg))
+}
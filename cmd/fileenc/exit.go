@@ -0,0 +1,110 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// Exit codes, scripts can branch on them
+const (
+	// exitFailure is returned for failures without a more specific code
+	exitFailure = 1
+	// exitUsage is returned for invalid flags and arguments
+	exitUsage = 2
+	// exitBadKey is returned for malformed keys, e.g. a raw key of the wrong length
+	exitBadKey = 3
+	// exitFileExists is returned if an output file exists and -on-conflict is fail
+	exitFileExists = 4
+	// exitIO is returned if a file cannot be read or written or is in use
+	exitIO = 5
+	// exitCorrupt is returned for files that are damaged, truncated or no fileenc files
+	exitCorrupt = 6
+	// exitWrongKey is returned if the key or identity does not match the file
+	exitWrongKey = 7
+	// exitExpired is returned if the expiry date of the file has passed
+	exitExpired = 8
+	// exitPolicy is returned if the settings or the key violate the policy file
+	exitPolicy = 9
+	// exitInterrupted is returned if Ctrl-C or SIGTERM stopped the processing,
+	// 128 + SIGINT like shells report it
+	exitInterrupted = 130
+)
+
+// exitCode returns the exit code describing err, fallback if there is no specific one
+func exitCode(err error, fallback int) int {
+	var daemonErr *daemonError
+	switch {
+	case errors.As(err, &daemonErr):
+		return daemonErr.code
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.Is(err, errPolicy):
+		return exitPolicy
+	case errors.Is(err, fileenc.ErrNoIdentity), errors.Is(err, fileenc.ErrWrongPassword):
+		return exitWrongKey
+	case errors.Is(err, fileenc.ErrInvalidKey):
+		return exitBadKey
+	case errors.Is(err, fileenc.ErrExpired):
+		return exitExpired
+	case errors.Is(err, fileenc.ErrFileExists):
+		return exitFileExists
+	case errors.Is(err, fileenc.ErrAuthFailed), errors.Is(err, fileenc.ErrMalformedHeader),
+		errors.Is(err, fileenc.ErrNotFileenc), errors.Is(err, fileenc.ErrInvalidArmor), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, errChecksum), errors.Is(err, fileenc.ErrSignature), errors.Is(err, errRepoDamaged),
+		errors.Is(err, fileenc.ErrSourceMismatch):
+		return exitCorrupt
+	case errors.Is(err, fileenc.ErrFileInUse),
+		errors.As(err, new(*fs.PathError)), errors.As(err, new(*os.LinkError)), errors.As(err, new(*os.SyscallError)):
+		return exitIO
+	}
+	return fallback
+}
+
+// batchExitCode returns the exit code for the failures of several files, the
+// code they share or exitFailure if they failed for different reasons
+func batchExitCode(errs []error) int {
+	code := 0
+	for _, err := range errs {
+		c := exitCode(err, exitFailure)
+		if code != 0 && c != code {
+			return exitFailure
+		}
+		code = c
+	}
+	return code
+}
+
+// interruptContext returns a context that is cancelled on Ctrl-C or SIGTERM,
+// making the library remove partial outputs. Only the first signal is caught,
+// a second one terminates the process right away.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
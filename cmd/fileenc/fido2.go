@@ -0,0 +1,261 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+const (
+	// fido2Prefix starts the text form of a FIDO2 identity
+	fido2Prefix = "FILEENC-FIDO2-"
+	// fido2DeviceEnv selects the FIDO2 device if several are connected
+	fido2DeviceEnv = "FILEENC_FIDO2_DEVICE"
+	// fido2RelyingParty is the relying party id of the credentials
+	fido2RelyingParty = "fileenc"
+	// fido2SaltSize is the length of the hmac-secret salt
+	fido2SaltSize = 32
+)
+
+// fido2Identity is an X25519 identity whose private key is derived from the
+// hmac-secret of a FIDO2 credential, so it only exists while the security key
+// is present. The identity file holds the credential id and the salt.
+type fido2Identity struct {
+	credential []byte
+	salt       []byte
+
+	once sync.Once
+	id   *fileenc.X25519Identity
+	err  error
+}
+
+// Unwrap asks the security key for the secret on first use and unwraps the
+// file key with the derived X25519 identity
+func (f *fido2Identity) Unwrap(stanzas []fileenc.Stanza) ([]byte, error) {
+	f.once.Do(func() {
+		fmt.Fprintln(os.Stderr, "Touch your security key to decrypt.")
+		var secret []byte
+		if secret, f.err = fido2Secret(f.credential, f.salt); f.err == nil {
+			f.id, f.err = fido2X25519(secret)
+			clear(secret)
+		}
+	})
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.id.Unwrap(stanzas)
+}
+
+// String returns the text form stored in identity files
+func (f *fido2Identity) String() string {
+	return fido2Prefix + base64.RawURLEncoding.EncodeToString(append(append([]byte{}, f.salt...), f.credential...))
+}
+
+// parseFIDO2Identities parses the FIDO2 identities in data read from name
+func parseFIDO2Identities(data []byte, name string) ([]fileenc.Identity, error) {
+	var ids []fileenc.Identity
+	for n, line := range lines(data) {
+		raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(line, fido2Prefix))
+		if !strings.HasPrefix(line, fido2Prefix) || err != nil || len(raw) <= fido2SaltSize {
+			return nil, fmt.Errorf("%s: line %d is no FIDO2 identity", name, n)
+		}
+		ids = append(ids, &fido2Identity{salt: raw[:fido2SaltSize], credential: raw[fido2SaltSize:]})
+	}
+	return ids, nil
+}
+
+// publicKeyComments returns the public keys noted in the comments of a FIDO2
+// or TPM identity file
+func publicKeyComments(data []byte) []string {
+	var keys []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if key, ok := strings.CutPrefix(strings.TrimSpace(sc.Text()), "# public key: "); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// fido2X25519 derives the X25519 identity from the hmac-secret
+func fido2X25519(secret []byte) (*fileenc.X25519Identity, error) {
+	key, err := hkdf.Key(sha256.New, secret, nil, "fileenc fido2 x25519", 32)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	return fileenc.NewX25519Identity(key)
+}
+
+// runFIDO2 implements "fileenc fido2 [-o <file>]", enrolling a FIDO2 security key
+func runFIDO2(args []string) {
+	fs := flag.NewFlagSet("fido2", flag.ExitOnError)
+	output := fs.String("o", "", "write the identity to this file instead of stdout, it must not exist")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc fido2 [-o <file>]")
+		fmt.Fprintln(fs.Output(), "Creates a credential with the hmac-secret extension on a FIDO2 security key, "+fido2DeviceEnv+" selects the device.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	id, err := enrollFIDO2()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error enrolling security key: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	pub := id.id.Recipient().String()
+
+	out := os.Stdout
+	if *output != "" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating identity file: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		defer file.Close()
+		out = file
+	}
+	fmt.Fprintf(out, "# created: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(out, "# public key: %s\n", pub)
+	fmt.Fprintf(out, "%s\n", id)
+	if *output != "" {
+		fmt.Printf("Public key: %s\n", pub)
+	}
+}
+
+// enrollFIDO2 creates a resident-less credential with hmac-secret and derives
+// the identity from it, which needs a second touch
+func enrollFIDO2() (*fido2Identity, error) {
+	device, err := fido2Device()
+	if err != nil {
+		return nil, err
+	}
+	userID := make([]byte, 32)
+	rand.Read(userID)
+	fmt.Fprintln(os.Stderr, "Touch your security key to create the credential.")
+	input := strings.Join([]string{randomHash(), fido2RelyingParty, "fileenc", base64.StdEncoding.EncodeToString(userID)}, "\n") + "\n"
+	out, err := fido2Tool("fido2-cred", input, "-M", "-h", device)
+	if err != nil {
+		return nil, err
+	}
+	// The output holds client data hash, relying party, format, authenticator data and credential id
+	outLines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(outLines) < 5 {
+		return nil, errors.New("unexpected output of fido2-cred")
+	}
+	credential, err := base64.StdEncoding.DecodeString(outLines[4])
+	if err != nil {
+		return nil, fmt.Errorf("malformed credential id: %w", err)
+	}
+
+	id := &fido2Identity{credential: credential, salt: make([]byte, fido2SaltSize)}
+	rand.Read(id.salt)
+	fmt.Fprintln(os.Stderr, "Touch your security key again to derive the key.")
+	secret, err := fido2Secret(credential, id.salt)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(secret)
+	if id.id, err = fido2X25519(secret); err != nil {
+		return nil, err
+	}
+	id.once.Do(func() {})
+	return id, nil
+}
+
+// fido2Secret asks the security key for the hmac-secret of the credential and salt
+func fido2Secret(credential, salt []byte) ([]byte, error) {
+	device, err := fido2Device()
+	if err != nil {
+		return nil, err
+	}
+	input := strings.Join([]string{
+		randomHash(), fido2RelyingParty,
+		base64.StdEncoding.EncodeToString(credential), base64.StdEncoding.EncodeToString(salt),
+	}, "\n") + "\n"
+	out, err := fido2Tool("fido2-assert", input, "-G", "-h", device)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(out)
+	// The hmac-secret is the last line of the assertion
+	outLines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	secret, err := base64.StdEncoding.DecodeString(outLines[len(outLines)-1])
+	if err != nil || len(secret) != 32 {
+		return nil, errors.New("security key returned no hmac-secret")
+	}
+	return secret, nil
+}
+
+// fido2Device returns the device from FILEENC_FIDO2_DEVICE or the first one found
+func fido2Device() (string, error) {
+	if device := os.Getenv(fido2DeviceEnv); device != "" {
+		return device, nil
+	}
+	out, err := fido2Tool("fido2-token", "", "-L")
+	if err != nil {
+		return "", err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	if sc.Scan() {
+		// Lines look like "/dev/hidraw0: vendor=0x1050, product=0x0407 (Yubico YubiKey OTP+FIDO+CCID)"
+		if device, _, ok := strings.Cut(sc.Text(), ": "); ok {
+			return device, nil
+		}
+	}
+	return "", errors.New("no FIDO2 security key found")
+}
+
+// fido2Tool runs a libfido2 command line tool with input on stdin and returns
+// its output. PIN prompts of the tool go to the terminal.
+func fido2Tool(name, input string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found, install the libfido2 tools for FIDO2 support", name)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", name, err)
+	}
+	return out, nil
+}
+
+// randomHash returns a random client data hash, the credentials are not used
+// for authentication to a server so no challenge has to be signed
+func randomHash() string {
+	hash := make([]byte, 32)
+	rand.Read(hash)
+	return base64.StdEncoding.EncodeToString(hash)
+}
@@ -0,0 +1,409 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// keyFlags holds the flags selecting the key, the recipients and the identities
+type keyFlags struct {
+	env        string
+	name       string
+	pass       string
+	keyFile    string
+	keyName    string
+	keychain   bool
+	recipients stringList
+	identities stringList
+	shares     stringList
+	// kmsKeys wrap the file key with cloud KMS keys, kms unwraps it on decryption
+	kmsKeys stringList
+	kms     bool
+	// signer signs on encryption, trustedSigners must have signed on decryption
+	signer         string
+	trustedSigners stringList
+	// fingerprint is the expected fingerprint of the key, showFingerprint prints it
+	fingerprint     string
+	showFingerprint bool
+	// ignoreExpiry decrypts files after their expiry date
+	ignoreExpiry bool
+	// noKey skips reading the key when no recipients are given, for key shares
+	noKey bool
+	// minEntropy is the estimated strength in bits a passphrase for encryption
+	// needs unless it is typed at the prompt
+	minEntropy float64
+	// prompted is set once the key was typed at the prompt
+	prompted bool
+}
+
+// addKeyFlags registers the key flags on fs, -recipient only if encrypt is set
+func addKeyFlags(fs *flag.FlagSet, encrypt bool) *keyFlags {
+	k := &keyFlags{env: keyEnv, name: "key"}
+	fs.StringVar(&k.pass, "key", "", "password or key, visible in the process list; prefer -keyfile, "+keyEnv+" or the prompt")
+	fs.StringVar(&k.keyFile, "keyfile", "", "read the key from this file, a trailing line break is ignored")
+	fs.StringVar(&k.keyName, "key-name", "", "use the key or identity of this name from the keyring, see fileenc keyring")
+	fs.BoolVar(&k.keychain, "use-keychain", false, "take the key from the OS keychain, -key-name selects the entry, see fileenc key")
+	if encrypt {
+		fs.Var(&k.recipients, "recipient", "encrypt for this fileenc or age public key or the public keys in this file instead of a key, may be repeated")
+		fs.Var(&k.kmsKeys, "kms-key-id", "wrap the file key with this AWS KMS key ARN or alias/<name>, GCP Cloud KMS key, Azure Key Vault key URL or Vault transit key vault:<mount>/<key> instead of a key, may be repeated")
+		fs.Float64Var(&k.minEntropy, "min-entropy", 0, "refuse passphrases for encryption from -key, -keyfile or "+keyEnv+" estimated weaker than this many bits, e.g. 60; typed passphrases below it are warned about")
+		fs.StringVar(&k.signer, "sign", "", "sign the plaintext with the signing key in this file created by fileenc keygen -signing")
+	}
+	fs.Var(&k.trustedSigners, "trusted-signer", "decrypt only files signed by this public key or one of the public keys in this file, may be repeated")
+	fs.BoolVar(&k.ignoreExpiry, "ignore-expiry", false, "decrypt files even after the expiry date set with -expires")
+	fs.Var(&k.identities, "identity", "decrypt with the identities in this file created by fileenc keygen or age-keygen, may be repeated")
+	fs.BoolVar(&k.kms, "kms", false, "decrypt by having the cloud KMS named in the file unwrap the file key")
+	fs.Var(&k.shares, "share", "decrypt with the key shares in this file written with -shares, may be repeated and contain glob patterns")
+	fs.StringVar(&k.fingerprint, "fingerprint", "", "fail unless the key has this fingerprint, as printed by -show-fingerprint")
+	fs.BoolVar(&k.showFingerprint, "show-fingerprint", false, "print the fingerprint of the key on stderr to compare it with the other party")
+	return k
+}
+
+// load reads the recipients or identities and, if none are given, the key from
+// the flags, the keychain, the keyring, the environment or the terminal, asking twice when
+// encrypting. The key may be nil and should be cleared by the caller. The
+// fingerprint of the key is printed or checked if requested.
+func (k *keyFlags) load(decrypt bool) ([]byte, []fileenc.Option, error) {
+	signOpts, err := k.signOptions(decrypt)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, opts, err := k.read(decrypt)
+	if err == nil && !decrypt {
+		err = checkKeyPolicy(key)
+		if err != nil {
+			clear(key)
+			key = nil
+		}
+	}
+	opts = append(opts, signOpts...)
+	if decrypt && k.ignoreExpiry {
+		opts = append(opts, fileenc.WithIgnoreExpiry())
+	}
+	if err != nil || (k.fingerprint == "" && !k.showFingerprint) {
+		return key, opts, err
+	}
+	if key == nil {
+		return nil, nil, errors.New("-fingerprint and -show-fingerprint need a key, not recipients or identities")
+	}
+	fp, err := fileenc.Fingerprint(key)
+	if err == nil && k.fingerprint != "" && !fileenc.FingerprintsEqual(fp, k.fingerprint) {
+		err = fileenc.ErrFingerprintMismatch
+	}
+	if err != nil {
+		clear(key)
+		return nil, nil, err
+	}
+	if k.showFingerprint {
+		fmt.Fprintf(os.Stderr, "Key fingerprint: %s\n", fp)
+	}
+	return key, opts, nil
+}
+
+// signOptions returns the options for -sign when encrypting and -trusted-signer when decrypting
+func (k *keyFlags) signOptions(decrypt bool) ([]fileenc.Option, error) {
+	if decrypt {
+		trusted, err := loadTrustedSigners(k.trustedSigners)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trusted signers: %w", err)
+		}
+		return []fileenc.Option{fileenc.WithTrustedSigners(trusted...)}, nil
+	}
+	if k.signer == "" {
+		return nil, nil
+	}
+	signer, err := loadSigner(k.signer)
+	if err != nil {
+		return nil, err
+	}
+	return []fileenc.Option{fileenc.WithSigner(signer)}, nil
+}
+
+// read implements load without signers and the fingerprint
+func (k *keyFlags) read(decrypt bool) ([]byte, []fileenc.Option, error) {
+	if k.keychain {
+		name := k.keyName
+		if name == "" {
+			name = keychainDefault
+		}
+		key, err := keychainGet(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s %q from the keychain: %w", k.name, name, err)
+		}
+		return key, nil, nil
+	}
+
+	// A named identity stands in for -identity or its public keys for -recipient
+	var named *keyringEntry
+	if k.keyName != "" {
+		var err error
+		if named, err = loadKeyringEntry(k.keyName); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var opts []fileenc.Option
+	n := 0
+	if decrypt {
+		identities, ageIdentities, err := loadIdentities(k.identities)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read identities: %w", err)
+		}
+		if named != nil && named.Identity != "" {
+			ids, ageIDs, err := parseIdentities([]byte(named.Identity), k.keyName)
+			if err != nil {
+				return nil, nil, err
+			}
+			identities, ageIdentities = append(identities, ids...), append(ageIdentities, ageIDs...)
+		}
+		if len(k.shares) > 0 {
+			shares, err := loadShares(k.shares)
+			if err != nil {
+				return nil, nil, err
+			}
+			identities = append(identities, shares)
+		}
+		if k.kms {
+			identities = append(identities, kmsIdentity{})
+		}
+		n = len(identities) + len(ageIdentities)
+		opts = append(opts, fileenc.WithIdentities(identities...), fileenc.WithAgeIdentities(ageIdentities...))
+	} else {
+		values := k.recipients
+		if named != nil {
+			values = append(slices.Clone(values), named.Recipients...)
+		}
+		recipients, ageRecipients, err := loadRecipients(values)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read recipients: %w", err)
+		}
+		for _, keyID := range k.kmsKeys {
+			r, err := newKMSRecipient(keyID)
+			if err != nil {
+				return nil, nil, err
+			}
+			recipients = append(recipients, r)
+		}
+		n = len(recipients) + len(ageRecipients)
+		opts = append(opts, fileenc.WithRecipients(recipients...), fileenc.WithAgeRecipients(ageRecipients...))
+	}
+	if n > 0 || (k.noKey && !decrypt) {
+		return nil, opts, nil
+	}
+	if named != nil {
+		if named.Key == "" {
+			return nil, nil, fmt.Errorf("keyring entry %q holds no key or public key", k.keyName)
+		}
+		return lockKey([]byte(named.Key)), opts, nil
+	}
+	interactive := k.pass == "" && k.keyFile == "" && os.Getenv(k.env) == ""
+	warnEntropy = max(warnEntropy, k.minEntropy)
+	key, err := loadKey(k.pass, k.keyFile, k.env, k.name, !decrypt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", k.name, err)
+	}
+	k.prompted = interactive
+	if !decrypt && !interactive {
+		if err := checkEntropy(k.name, key, k.minEntropy); err != nil {
+			clear(key)
+			return nil, nil, err
+		}
+	}
+	return key, opts, nil
+}
+
+// cipherFlags holds the flags selecting format, cipher, key derivation and compression for encryption
+type cipherFlags struct {
+	format     string
+	cipher     string
+	kdf        string
+	compress   string
+	kdfTime    uint
+	kdfMemory  uint
+	kdfThreads uint
+	convergent bool
+	armor      bool
+	expires    string
+	fields     stringList
+}
+
+// addCipherFlags registers the encryption flags on fs
+func addCipherFlags(fs *flag.FlagSet) *cipherFlags {
+	c := &cipherFlags{}
+	fs.StringVar(&c.format, "format", fileenc.FormatFileenc, "file format for encryption: fileenc, age (decryptable with age) or openpgp (decryptable with gpg, passphrase only)")
+	fs.StringVar(&c.cipher, "cipher", fileenc.CipherAESGCM, "cipher to use for encryption, "+fileenc.CipherAESGCM+", "+fileenc.CipherChaCha20Poly1305+", "+fileenc.CipherXChaCha20Poly1305+" (authenticated) or "+fileenc.CipherAESCFB+" (unauthenticated)")
+	fs.StringVar(&c.kdf, "kdf", fileenc.KDFArgon2id, "key derivation function for encryption, argon2id, scrypt, pbkdf2 or none (raw 16, 24 or 32 byte key)")
+	fs.StringVar(&c.compress, "compress", fileenc.CompressionNone, "compress before encryption: none, gzip or zstd")
+	fs.UintVar(&c.kdfTime, "kdf-time", 0, "kdf time cost: argon2id passes, scrypt log2(N), pbkdf2 iterations (0 = default)")
+	fs.UintVar(&c.kdfMemory, "kdf-memory", 0, "kdf memory cost: argon2id memory in KiB, scrypt r (0 = default)")
+	fs.UintVar(&c.kdfThreads, "kdf-threads", 0, "kdf parallelism: argon2id threads, scrypt p (0 = default)")
+	fs.BoolVar(&c.armor, "armor", false, "write the encrypted data as Base64 text between BEGIN and END lines, for mails and tickets")
+	fs.BoolVar(&c.convergent, "convergent", false, "encrypt deterministically so equal files give equal output for deduplicating storage; reveals which files are equal")
+	fs.Var(&c.fields, "field", "store name=value encrypted in the header, e.g. ticket=OPS-1234, shown by inspect -fields; may be repeated")
+	fs.StringVar(&c.expires, "expires", "", "refuse decryption after this date (2006-01-02 or RFC 3339) or duration from now (e.g. 72h or 30d); advisory, see -ignore-expiry")
+	return c
+}
+
+// options returns the encryption options selected by the flags
+func (c *cipherFlags) options() ([]fileenc.Option, error) {
+	kdf, err := fileenc.DefaultKDFParams(c.kdf)
+	if err != nil {
+		return nil, fmt.Errorf("unknown kdf %q, use argon2id, scrypt, pbkdf2 or none", c.kdf)
+	}
+	if c.kdfTime != 0 {
+		kdf.Time = uint32(c.kdfTime)
+	}
+	if c.kdfMemory != 0 {
+		kdf.Memory = uint32(c.kdfMemory)
+	}
+	if c.kdfThreads != 0 {
+		kdf.Threads = uint8(c.kdfThreads)
+	}
+	p, err := currentPolicy()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkEncryption(c.format, c.cipher, kdf); err != nil {
+		return nil, err
+	}
+	opts := []fileenc.Option{
+		fileenc.WithFormat(c.format),
+		fileenc.WithCipher(c.cipher),
+		fileenc.WithKDF(kdf),
+		fileenc.WithCompression(c.compress),
+	}
+	if c.armor {
+		opts = append(opts, fileenc.WithArmor())
+	}
+	if c.convergent {
+		opts = append(opts, fileenc.WithConvergent())
+	}
+	if c.expires != "" {
+		t, err := parseExpiry(c.expires, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, fileenc.WithExpiry(t))
+	}
+	if len(c.fields) > 0 {
+		fields := make(map[string]string, len(c.fields))
+		for _, f := range c.fields {
+			name, value, ok := strings.Cut(f, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid field %q, use name=value", f)
+			}
+			fields[name] = value
+		}
+		opts = append(opts, fileenc.WithFields(fields))
+	}
+	return opts, nil
+}
+
+// parseExpiry parses the value of -expires, a date, a time in RFC 3339 or a
+// duration added to now, which may be given in days
+func parseExpiry(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		// A date expires at its end
+		return t.AddDate(0, 0, 1), nil
+	}
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid expiry %q, use a date, RFC 3339 time or duration", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return time.Time{}, fmt.Errorf("invalid expiry %q, use a date, RFC 3339 time or duration", s)
+		}
+	}
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid expiry %q, the duration must be positive", s)
+	}
+	return now.Add(d), nil
+}
+
+// metadataFlags holds the flags controlling the stored file metadata
+type metadataFlags struct {
+	metadata  bool
+	storeName bool
+	owner     bool
+}
+
+// addMetadataFlags registers the metadata flags on fs
+func addMetadataFlags(fs *flag.FlagSet) *metadataFlags {
+	m := &metadataFlags{}
+	fs.BoolVar(&m.metadata, "metadata", true, "store modification time and permissions on encryption and restore them on decryption")
+	fs.BoolVar(&m.storeName, "store-name", true, "store the original file name in the header, it is readable without the key")
+	fs.BoolVar(&m.owner, "owner", false, "also store and restore the file owner, restoring requires root")
+	return m
+}
+
+// options returns the metadata options selected by the flags
+func (m *metadataFlags) options() []fileenc.Option {
+	if !m.metadata {
+		return nil
+	}
+	return []fileenc.Option{fileenc.WithFileMetadata(m.storeName, m.owner)}
+}
+
+// sizeUnits maps the unit suffixes of sizes to their factor
+var sizeUnits = map[string]int64{
+	"": 1, "B": 1,
+	"K": 1 << 10, "KIB": 1 << 10, "KB": 1e3,
+	"M": 1 << 20, "MIB": 1 << 20, "MB": 1e6,
+	"G": 1 << 30, "GIB": 1 << 30, "GB": 1e9,
+	"T": 1 << 40, "TIB": 1 << 40, "TB": 1e12,
+}
+
+// byteSize is a flag holding a size in bytes, given as a number with an
+// optional unit: K, M, G, T or KiB, MiB, ... count in powers of 1024, KB, MB, ... in powers of 1000
+type byteSize int64
+
+// String returns the size in bytes
+func (s *byteSize) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+// Set parses a size like 700M or 4.7GB
+func (s *byteSize) Set(v string) error {
+	num := strings.TrimRightFunc(v, unicode.IsLetter)
+	unit, ok := sizeUnits[strings.ToUpper(v[len(num):])]
+	f, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if !ok || err != nil || f < 0 || f*float64(unit) >= math.MaxInt64 {
+		return fmt.Errorf("invalid size %q, use a number with an optional unit like 700M or 4.7GB", v)
+	}
+	*s = byteSize(f * float64(unit))
+	return nil
+}
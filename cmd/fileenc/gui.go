@@ -0,0 +1,286 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// guiKeyHeader carries the passphrase of a request from the page
+const guiKeyHeader = "X-Fileenc-Key"
+
+// runGUI implements "fileenc gui", a page in the browser for encrypting and
+// decrypting single files with a passphrase, served on the loopback interface
+func runGUI(args []string) {
+	fs := flag.NewFlagSet("gui", flag.ExitOnError)
+	ciphers := addCipherFlags(fs)
+	noBrowser := fs.Bool("no-browser", false, "only print the address of the page instead of opening the browser")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc gui [flags]")
+		fmt.Fprintln(fs.Output(), "Opens a page in the browser encrypting and decrypting files with a passphrase, until it is closed with Quit or Ctrl-C.")
+		fs.PrintDefaults()
+	}
+	if len(parseArgs(fs, args)) != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	opts, err := ciphers.options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+
+	// Other local users and web sites must not use the page, so its address
+	// holds a random token
+	token := make([]byte, 16)
+	rand.Read(token)
+	g := &gui{prefix: "/" + hex.EncodeToString(token) + "/", opts: opts, quit: make(chan struct{})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitIO)
+	}
+	url := "http://" + ln.Addr().String() + g.prefix
+	fmt.Printf("fileenc is running at %s, press Ctrl-C to stop.\n", url)
+	if !*noBrowser {
+		if err := openBrowser(url); err != nil {
+			fmt.Printf("Open the address in a browser, starting one failed: %v\n", err)
+		}
+	}
+
+	srv := &http.Server{Handler: g}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-g.quit:
+		}
+		srv.Shutdown(context.Background())
+	}()
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitIO)
+	}
+}
+
+// gui serves the page and processes the uploaded files
+type gui struct {
+	prefix string
+	opts   []fileenc.Option
+	quit   chan struct{}
+	closed sync.Once
+}
+
+// ServeHTTP dispatches the requests below the secret prefix
+func (g *gui) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path, ok := strings.CutPrefix(r.URL.Path, g.prefix)
+	switch {
+	case !ok:
+		http.NotFound(w, r)
+	case path == "" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+		io.WriteString(w, guiPage)
+	case (path == "encrypt" || path == "decrypt") && r.Method == http.MethodPost:
+		g.process(w, r, path == "decrypt")
+	case path == "quit" && r.Method == http.MethodPost:
+		w.WriteHeader(http.StatusNoContent)
+		// A repeated quit must not close the channel twice
+		g.closed.Do(func() { close(g.quit) })
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// process encrypts or decrypts the request body to a temporary file and sends
+// it once it is complete, so a failing decryption sends no partial plaintext
+func (g *gui) process(w http.ResponseWriter, r *http.Request, decrypt bool) {
+	key := []byte(r.Header.Get(guiKeyHeader))
+	defer clear(key)
+	if len(key) == 0 {
+		http.Error(w, "enter a passphrase", http.StatusBadRequest)
+		return
+	}
+	if !decrypt {
+		if err := checkKeyPolicy(key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	opts := g.opts
+	if decrypt {
+		opts = nil
+	}
+	enc, err := fileenc.New(key, opts...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmp, err := os.CreateTemp("", "fileenc-gui-*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if decrypt {
+		err = enc.DecryptContext(r.Context(), tmp, r.Body)
+	} else {
+		err = enc.EncryptContext(r.Context(), tmp, r.Body)
+	}
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		if exitCode(err, exitFailure) == exitWrongKey {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, name := targetPaths(filepath.Base(r.URL.Query().Get("name")), decrypt, encExt)
+	if decrypt && !strings.HasSuffix(r.URL.Query().Get("name"), encExt) {
+		name += ".decrypted"
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprint(size))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	io.Copy(w, tmp)
+}
+
+// openBrowser opens url in the default browser
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
+
+// guiPage is the page of fileenc gui, it uploads the chosen file with the
+// passphrase and saves the response
+const guiPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>fileenc</title>
+<style>
+body { font-family: sans-serif; max-width: 28em; margin: 3em auto; padding: 0 1em; }
+label, input, button, progress { display: block; width: 100%; box-sizing: border-box; margin: .4em 0; }
+.buttons { display: flex; gap: .5em; }
+button { padding: .6em; }
+#status { min-height: 1.5em; white-space: pre-wrap; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>fileenc</h1>
+<label>File <input type="file" id="file"></label>
+<label>Passphrase <input type="password" id="key" autocomplete="off"></label>
+<label id="confirmLabel">Confirm passphrase for encryption <input type="password" id="confirm" autocomplete="off"></label>
+<div class="buttons"><button id="encrypt">Encrypt</button><button id="decrypt">Decrypt</button></div>
+<progress id="progress" max="1" value="0"></progress>
+<div id="status"></div>
+<button id="quit">Quit</button>
+<script>
+"use strict";
+const $ = id => document.getElementById(id);
+
+function status(text, error) {
+	$("status").textContent = text;
+	$("status").className = error ? "error" : "";
+}
+
+function busy(on) {
+	for (const id of ["encrypt", "decrypt", "file"]) $(id).disabled = on;
+}
+
+function run(op) {
+	const file = $("file").files[0];
+	const key = $("key").value;
+	if (!file) return status("Choose a file.", true);
+	if (!key) return status("Enter the passphrase.", true);
+	if (op === "encrypt" && key !== $("confirm").value) return status("The passphrases do not match.", true);
+
+	const xhr = new XMLHttpRequest();
+	xhr.open("POST", op + "?name=" + encodeURIComponent(file.name));
+	xhr.setRequestHeader("X-Fileenc-Key", key);
+	xhr.responseType = "blob";
+	xhr.upload.onprogress = e => { $("progress").value = e.loaded / e.total / 2; };
+	xhr.upload.onload = () => { $("progress").removeAttribute("value"); status(op === "encrypt" ? "Encrypting..." : "Decrypting..."); };
+	xhr.onprogress = e => { if (e.lengthComputable) $("progress").value = .5 + e.loaded / e.total / 2; };
+	xhr.onerror = () => { busy(false); status("fileenc is not running anymore.", true); };
+	xhr.onload = async () => {
+		busy(false);
+		$("progress").value = 1;
+		if (xhr.status !== 200) return status(await xhr.response.text(), true);
+		const disposition = xhr.getResponseHeader("Content-Disposition") || "";
+		const encoded = disposition.match(/filename\*=utf-8''([^;]+)/i);
+		const plain = disposition.match(/filename="?([^";]+)"?/);
+		const a = document.createElement("a");
+		a.href = URL.createObjectURL(xhr.response);
+		a.download = encoded ? decodeURIComponent(encoded[1]) : plain ? plain[1] : "output";
+		a.click();
+		setTimeout(() => URL.revokeObjectURL(a.href), 60000);
+		status("Saved " + a.download + ".");
+	};
+	busy(true);
+	$("progress").value = 0;
+	status("Uploading...");
+	xhr.send(file);
+}
+
+$("encrypt").onclick = () => run("encrypt");
+$("decrypt").onclick = () => run("decrypt");
+$("quit").onclick = () => fetch("quit", {method: "POST"}).then(() => { document.body.textContent = "fileenc has been closed."; });
+</script>
+</body>
+</html>
+`
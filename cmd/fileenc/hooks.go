@@ -0,0 +1,106 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// hooks holds the commands run before and after every file
+type hooks struct {
+	pre  string
+	post string
+}
+
+// addHookFlags registers the hook flags on fs
+func addHookFlags(fs *flag.FlagSet) *hooks {
+	h := &hooks{}
+	fs.StringVar(&h.pre, "pre-hook", "", "shell command run before every file, with FILEENC_SOURCE, FILEENC_OUTPUT and FILEENC_OPERATION set; the file is skipped if it fails")
+	fs.StringVar(&h.post, "post-hook", "", "shell command run after every file, additionally with FILEENC_STATUS, FILEENC_EXIT_CODE and FILEENC_ERROR set; the file counts as failed if it fails")
+	return h
+}
+
+// enabled reports whether any hook is set
+func (h hooks) enabled() bool {
+	return h.pre != "" || h.post != ""
+}
+
+// runHooked runs the pre hook, processes source with run and runs the post
+// hook with the outcome. A failing pre hook skips the file, a failing post
+// hook fails it.
+func (t task) runHooked(source string, log *slog.Logger, run func() error) error {
+	if !t.hooks.enabled() {
+		return run()
+	}
+	in, dst := t.paths(source)
+	if err := t.runHook(t.hooks.pre, in, dst, nil); err != nil {
+		err = fmt.Errorf("pre hook failed: %w", err)
+		log.Error("Error running the pre hook", "file", in, "error", err)
+		return err
+	}
+	err := run()
+	if herr := t.runHook(t.hooks.post, in, dst, &err); herr != nil {
+		herr = fmt.Errorf("post hook failed: %w", herr)
+		log.Error("Error running the post hook", "file", in, "error", herr)
+		if err == nil || errors.Is(err, errUnchanged) {
+			err = herr
+		}
+	}
+	return err
+}
+
+// runHook runs the hook command through the shell with the file described in
+// the environment. The outcome is only set for the post hook. The output of
+// the command goes to stderr so stdout stays clean.
+func (t task) runHook(command, in, out string, outcome *error) error {
+	if command == "" {
+		return nil
+	}
+	operation := "encrypt"
+	if t.decrypt {
+		operation = "decrypt"
+	}
+	cmd := shellCommand(command)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Env = append(os.Environ(), "FILEENC_OPERATION="+operation, "FILEENC_SOURCE="+in, "FILEENC_OUTPUT="+out)
+	if outcome != nil {
+		status, code, message := "ok", 0, ""
+		switch err := *outcome; {
+		case errors.Is(err, errUnchanged):
+			status = "unchanged"
+		case err != nil:
+			status, code, message = "error", exitCode(err, exitFailure), err.Error()
+		}
+		cmd.Env = append(cmd.Env, "FILEENC_STATUS="+status, "FILEENC_EXIT_CODE="+strconv.Itoa(code), "FILEENC_ERROR="+message)
+	}
+	return cmd.Run()
+}
+
+// shellCommand returns the command running command through the shell of the OS
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
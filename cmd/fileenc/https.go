@@ -0,0 +1,211 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// httpRetries is the number of times an interrupted download is resumed
+const httpRetries = 5
+
+// errChecksum is returned if the digest of the source does not match -checksum
+var errChecksum = errors.New("checksum mismatch")
+
+// httpsStorage is the storage backend of https:// URLs, which can only be read
+type httpsStorage struct{}
+
+// Open downloads u. If the connection breaks, the download continues
+// where it stopped with a range request.
+func (httpsStorage) Open(u *url.URL) (io.ReadCloser, int64, error) {
+	r := &httpReader{u: u}
+	resp, err := r.get("")
+	if err != nil {
+		return nil, 0, err
+	}
+	// Resuming needs the server to support ranges and the file to stay the same
+	r.body, r.size = resp.Body, resp.ContentLength
+	if resp.Header.Get("Accept-Ranges") == "bytes" {
+		if r.validator = resp.Header.Get("ETag"); r.validator == "" || strings.HasPrefix(r.validator, "W/") {
+			r.validator = resp.Header.Get("Last-Modified")
+		}
+	}
+	return r, r.size, nil
+}
+
+// Create refuses to write, downloads are the only use of https URLs
+func (httpsStorage) Create(u *url.URL, overwrite bool) (fileenc.StorageWriter, error) {
+	return nil, fmt.Errorf("cannot write to %s, https URLs are only supported as source", u.Redacted())
+}
+
+// Stat returns the size of the file at u, -1 if the server does not tell
+func (httpsStorage) Stat(u *url.URL) (int64, error) {
+	resp, err := http.Head(u.String())
+	if err != nil {
+		return 0, remoteError("head", u, err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, nil
+	case http.StatusNotFound:
+		return 0, remoteError("head", u, fs.ErrNotExist)
+	}
+	return 0, remoteError("head", u, errors.New(resp.Status))
+}
+
+// List refuses, https has no directory listings
+func (httpsStorage) List(u *url.URL) ([]string, error) {
+	return nil, fmt.Errorf("https URLs cannot be listed: %w", errors.ErrUnsupported)
+}
+
+// Remove refuses, https URLs are read only
+func (httpsStorage) Remove(u *url.URL) error {
+	return fmt.Errorf("https URLs cannot delete files: %w", errors.ErrUnsupported)
+}
+
+// httpReader reads a download and resumes it after errors
+type httpReader struct {
+	u         *url.URL
+	body      io.ReadCloser
+	size      int64
+	offset    int64
+	validator string
+	retries   int
+}
+
+// get requests the file, from the byte at offset on if rng is set
+func (r *httpReader) get(rng string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, r.u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if rng != "" {
+		req.Header.Set("Range", rng)
+		req.Header.Set("If-Range", r.validator)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, remoteError("get", r.u, err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, remoteError("get", r.u, fs.ErrNotExist)
+		}
+		return nil, remoteError("get", r.u, errors.New(resp.Status))
+	}
+	return resp, nil
+}
+
+// Read reads from the download, reconnecting after errors
+func (r *httpReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	if r.validator == "" || r.retries == httpRetries {
+		return n, remoteError("get", r.u, err)
+	}
+	if rerr := r.resume(); rerr != nil {
+		return n, remoteError("get", r.u, fmt.Errorf("%w, resuming failed: %w", err, rerr))
+	}
+	return n, nil
+}
+
+// resume requests the rest of the file, waiting longer after every attempt
+func (r *httpReader) resume() error {
+	r.body.Close()
+	r.retries++
+	time.Sleep(time.Duration(r.retries) * time.Second)
+	resp, err := r.get("bytes=" + strconv.FormatInt(r.offset, 10) + "-")
+	if err != nil {
+		return err
+	}
+	// The server answers with the whole file if it changed or ignores the range
+	if resp.StatusCode != http.StatusPartialContent || !strings.HasPrefix(resp.Header.Get("Content-Range"), "bytes "+strconv.FormatInt(r.offset, 10)+"-") {
+		resp.Body.Close()
+		return errors.New("the file changed or the server does not support ranges")
+	}
+	r.body = resp.Body
+	return nil
+}
+
+// Close ends the download
+func (r *httpReader) Close() error {
+	return r.body.Close()
+}
+
+// parseChecksum parses a -checksum value, sha256:<hex>, sha512:<hex> or a bare
+// hex digest of either length
+func parseChecksum(s string) (func() hash.Hash, []byte, error) {
+	algorithm, digest, ok := strings.Cut(s, ":")
+	if !ok {
+		algorithm, digest = "", s
+	}
+	sum, err := hex.DecodeString(digest)
+	switch {
+	case err != nil:
+		return nil, nil, fmt.Errorf("invalid checksum %q: %w", s, err)
+	case (algorithm == "sha256" || algorithm == "") && len(sum) == sha256.Size:
+		return sha256.New, sum, nil
+	case (algorithm == "sha512" || algorithm == "") && len(sum) == sha512.Size:
+		return sha512.New, sum, nil
+	}
+	return nil, nil, fmt.Errorf("invalid checksum %q, use sha256:<hex> or sha512:<hex>", s)
+}
+
+// checksumReader hashes the data read through it
+type checksumReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want []byte
+}
+
+// Read reads and hashes the data
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	return n, err
+}
+
+// verify reads the rest of the data and compares the digest
+func (c *checksumReader) verify() error {
+	if _, err := io.Copy(io.Discard, c); err != nil {
+		return err
+	}
+	if got := c.h.Sum(nil); !bytes.Equal(got, c.want) {
+		return fmt.Errorf("%w: got %x", errChecksum, got)
+	}
+	return nil
+}
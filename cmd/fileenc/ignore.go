@@ -0,0 +1,149 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile holds gitignore-style patterns of the files -recursive skips in
+// its directory and below
+const ignoreFile = ".fileencignore"
+
+// ignoreRule is a single pattern of an ignore file or -exclude
+type ignoreRule struct {
+	// base is the directory of the ignore file relative to the source, "" for the source itself
+	base string
+	// parts are the slash separated parts of the pattern
+	parts []string
+	// anchored patterns match the path below base, others the name at any depth
+	anchored bool
+	dirOnly  bool
+	negate   bool
+}
+
+// ignoreRules is an ordered list of rules, the last matching rule decides
+type ignoreRules []ignoreRule
+
+// parseIgnoreRule parses a pattern for the directory base, ok is false for
+// empty lines and comments
+func parseIgnoreRule(pattern, base string) (ignoreRule, bool, error) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return ignoreRule{}, false, nil
+	}
+	r := ignoreRule{base: base}
+	if p, ok := strings.CutPrefix(pattern, "!"); ok {
+		r.negate, pattern = true, p
+	}
+	pattern = strings.TrimPrefix(pattern, `\`)
+	if p, ok := strings.CutSuffix(pattern, "/"); ok {
+		r.dirOnly, pattern = true, p
+	}
+	r.anchored = strings.Contains(pattern, "/")
+	r.parts = strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	for _, part := range r.parts {
+		if _, err := path.Match(part, ""); err != nil {
+			return ignoreRule{}, false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return r, true, nil
+}
+
+// readIgnoreFile appends the rules of the ignore file in dir, if there is one,
+// to rules. base is dir relative to the source.
+func readIgnoreFile(rules ignoreRules, dir, base string) (ignoreRules, error) {
+	f, err := os.Open(filepath.Join(dir, ignoreFile))
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// Rules added here must not change the rules of the parent directories
+	rules = rules[:len(rules):len(rules)]
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		r, ok, err := parseIgnoreRule(sc.Text(), base)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Join(dir, ignoreFile), err)
+		}
+		if ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules, sc.Err()
+}
+
+// ignored reports whether the file at rel, slash separated and relative to the
+// source, is to be skipped
+func (rules ignoreRules) ignored(rel string, dir bool) bool {
+	ignored := false
+	for _, r := range rules {
+		if r.matches(rel, dir) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// matches reports whether the rule applies to rel
+func (r ignoreRule) matches(rel string, dir bool) bool {
+	if r.dirOnly && !dir {
+		return false
+	}
+	if r.base != "" {
+		var ok bool
+		if rel, ok = strings.CutPrefix(rel, r.base+"/"); !ok {
+			return false
+		}
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.parts[0], path.Base(rel))
+		return ok
+	}
+	return matchParts(r.parts, strings.Split(rel, "/"))
+}
+
+// matchParts matches the path parts against the pattern parts, ** matches any
+// number of parts
+func matchParts(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchParts(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
@@ -0,0 +1,160 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// runInspect implements "fileenc inspect <file>..."
+func runInspect(args []string) {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	keys := addKeyFlags(fs, false)
+	showFields := fs.Bool("fields", false, "read the key and show the fields stored encrypted with -field")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc inspect [-fields [-key <key> | -keyfile <file> | -identity <file>]] <file>...")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+
+	files, err := expandSources(args, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	if len(files) == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	// The fields are encrypted, showing them requires the key
+	inspectFile := fileenc.InspectFile
+	if *showFields {
+		key, opts, err := keys.load(true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCode(err, exitUsage))
+		}
+		defer clear(key)
+		enc, err := fileenc.New(key, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid settings: %v\n", err)
+			os.Exit(exitCode(err, exitUsage))
+		}
+		inspectFile = enc.InspectFile
+	}
+
+	var failed []error
+	for i, path := range files {
+		info, err := inspectFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error inspecting %s: %v\n", path, err)
+			failed = append(failed, err)
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printInfo(path, info)
+	}
+	if len(failed) > 0 {
+		os.Exit(batchExitCode(failed))
+	}
+}
+
+// printInfo prints the description of an encrypted file
+func printInfo(path string, info fileenc.Info) {
+	fmt.Printf("%s:\n", path)
+	if info.Format != fileenc.FormatFileenc {
+		fmt.Printf("  format:      %s\n", info.Format)
+	} else {
+		if info.Armored {
+			fmt.Printf("  format:      fileenc version %d, ASCII armored\n", info.Version)
+		} else {
+			fmt.Printf("  format:      fileenc version %d\n", info.Version)
+		}
+		fmt.Printf("  cipher:      %s\n", info.Cipher)
+		fmt.Printf("  kdf:         %s\n", formatKDF(info.KDF))
+		fmt.Printf("  compression: %s\n", info.Compression)
+		fmt.Printf("  header:      %d bytes\n", info.HeaderSize)
+	}
+	md := info.Metadata
+	if md.Name != "" {
+		fmt.Printf("  name:        %s\n", md.Name)
+	}
+	if md.Archive {
+		fmt.Printf("  content:     tar archive of a directory\n")
+	}
+	if md.Sparse {
+		fmt.Printf("  content:     sparse file, holes are not stored\n")
+	}
+	if !md.ModTime.IsZero() {
+		fmt.Printf("  original:    modified %s\n", md.ModTime.Format(time.RFC3339))
+	}
+	if md.Mode != 0 {
+		fmt.Printf("  permissions: %s\n", md.Mode)
+	}
+	if md.UID >= 0 {
+		fmt.Printf("  owner:       %d:%d\n", md.UID, md.GID)
+	}
+	if len(info.Recipients) > 0 {
+		fmt.Printf("  recipients:  %d (%s)\n", len(info.Recipients), strings.Join(info.Recipients, ", "))
+	} else if info.Format == fileenc.FormatFileenc {
+		fmt.Printf("  recipients:  none, passphrase encrypted\n")
+	}
+	if info.Signer != nil {
+		fmt.Printf("  signed by:   %s, checked on decryption\n", info.Signer)
+	}
+	if !info.Expires.IsZero() {
+		state := "decryption refused afterwards"
+		if time.Now().After(info.Expires) {
+			state = "expired, decrypt with -ignore-expiry"
+		}
+		fmt.Printf("  expires:     %s, %s\n", info.Expires.Local().Format(time.RFC3339), state)
+	}
+	for _, name := range slices.Sorted(maps.Keys(info.Fields)) {
+		fmt.Printf("  field:       %s=%q\n", name, info.Fields[name])
+	}
+	if info.Size >= 0 {
+		fmt.Printf("  size:        %d bytes plaintext, %d bytes encrypted\n", info.Size, info.EncryptedSize)
+	} else {
+		fmt.Printf("  size:        %d bytes encrypted\n", info.EncryptedSize)
+	}
+	fmt.Printf("  encrypted:   modified %s\n", info.ModTime.Format(time.RFC3339))
+}
+
+// formatKDF describes the key derivation function and its parameters
+func formatKDF(p fileenc.KDFParams) string {
+	switch p.Name {
+	case fileenc.KDFArgon2id:
+		return fmt.Sprintf("argon2id, time=%d memory=%d KiB threads=%d", p.Time, p.Memory, p.Threads)
+	case fileenc.KDFScrypt:
+		return fmt.Sprintf("scrypt, N=2^%d r=%d p=%d", p.Time, p.Memory, p.Threads)
+	case fileenc.KDFPBKDF2:
+		return fmt.Sprintf("pbkdf2-sha256, %d iterations", p.Time)
+	default:
+		return "none, raw key or recipients"
+	}
+}
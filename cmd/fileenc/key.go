@@ -0,0 +1,227 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"filippo.io/age"
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// keyEnv is the environment variable holding the key for scripted use
+const keyEnv = "FILEENC_KEY"
+
+// loadKey returns the key from the first available source in this order:
+// the key flag, the key file, the environment variable envName and finally an
+// interactive prompt asking for name. The key is moved to memory locked
+// against swapping, the caller should zero it with clear once it is no longer
+// needed.
+func loadKey(flagKey, keyFile, envName, name string, confirm bool) ([]byte, error) {
+	key, err := readKey(flagKey, keyFile, envName, name, confirm)
+	if err != nil {
+		return nil, err
+	}
+	return lockKey(key), nil
+}
+
+// readKey reads the key for loadKey from its first available source
+func readKey(flagKey, keyFile, envName, name string, confirm bool) ([]byte, error) {
+	if flagKey != "" {
+		p, err := currentPolicy()
+		if err == nil {
+			err = p.checkKeyFlag()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []byte(flagKey), nil
+	}
+	if keyFile != "" {
+		return readKeyFile(keyFile)
+	}
+	if env, ok := os.LookupEnv(envName); ok && env != "" {
+		// Do not pass the key on to child processes
+		os.Unsetenv(envName)
+		return []byte(env), nil
+	}
+	return readPassword(name, confirm)
+}
+
+// lockKey moves key into a fileenc.SecureBuffer and returns its content. The
+// buffer is zeroed by clearing the returned slice; the few locked pages are
+// only released when the process exits.
+func lockKey(key []byte) []byte {
+	return fileenc.SecureCopy(key).Bytes()
+}
+
+// readKeyFile reads the key from the file at path, a single trailing line break is removed
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key := bytes.TrimSuffix(data, []byte("\n"))
+	key = bytes.TrimSuffix(key, []byte("\r"))
+	if len(key) == 0 {
+		clear(data)
+		return nil, errors.New("key file is empty")
+	}
+	return key, nil
+}
+
+// loadRecipients parses the -recipient values, each is a fileenc or age public key or a file with one
+// public key per line
+func loadRecipients(values []string) ([]fileenc.Recipient, []age.Recipient, error) {
+	var recipients []fileenc.Recipient
+	var ageRecipients []age.Recipient
+	for _, v := range values {
+		if r, err := fileenc.ParseX25519Recipient(v); err == nil {
+			recipients = append(recipients, r)
+			continue
+		}
+		if r, err := parsePKCS11Recipient(v); err == nil {
+			recipients = append(recipients, r)
+			continue
+		}
+		if r, err := parseAgeRecipient(v); err == nil {
+			ageRecipients = append(ageRecipients, r)
+			continue
+		}
+		data, err := os.ReadFile(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%q is neither a public key nor a readable file: %w", v, err)
+		}
+		if rs, err := fileenc.ParseRecipients(bytes.NewReader(data)); err == nil {
+			recipients = append(recipients, rs...)
+			continue
+		}
+		rs, err := parseAgeRecipients(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: no fileenc or age public keys: %w", v, err)
+		}
+		ageRecipients = append(ageRecipients, rs...)
+	}
+	return recipients, ageRecipients, nil
+}
+
+// loadSigner reads the signer from the file at path written by fileenc keygen -signing
+func loadSigner(path string) (*fileenc.Ed25519Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signer: %w", err)
+	}
+	defer clear(data)
+	return fileenc.ParseSigner(bytes.NewReader(data))
+}
+
+// loadTrustedSigners parses the values of -trusted-signer, each a public key or a file of public keys
+func loadTrustedSigners(values []string) ([]*fileenc.Ed25519PublicKey, error) {
+	var keys []*fileenc.Ed25519PublicKey
+	for _, v := range values {
+		if k, err := fileenc.ParseEd25519PublicKey(v); err == nil {
+			keys = append(keys, k)
+			continue
+		}
+		data, err := os.ReadFile(v)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither a public key nor a readable file: %w", v, err)
+		}
+		// A signing key file stands in for its public key
+		if signer, err := fileenc.ParseSigner(bytes.NewReader(data)); err == nil {
+			clear(data)
+			keys = append(keys, signer.PublicKey())
+			continue
+		}
+		ks, err := fileenc.ParseEd25519PublicKeys(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", v, err)
+		}
+		keys = append(keys, ks...)
+	}
+	return keys, nil
+}
+
+// loadIdentities reads the fileenc or age identity files given with -identity
+func loadIdentities(paths []string) ([]fileenc.Identity, []age.Identity, error) {
+	var ids []fileenc.Identity
+	var ageIDs []age.Identity
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read identity file: %w", err)
+		}
+		is, ais, err := parseIdentities(data, path)
+		clear(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, is...)
+		ageIDs = append(ageIDs, ais...)
+	}
+	return ids, ageIDs, nil
+}
+
+// loadShares reads the key shares from the files matching the patterns and
+// returns an identity combining them
+func loadShares(patterns []string) (*fileenc.ShareIdentity, error) {
+	paths, err := expandSources(patterns, "")
+	if err != nil {
+		return nil, err
+	}
+	var shares []fileenc.Share
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read share file: %w", err)
+		}
+		s, err := fileenc.ParseShares(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		shares = append(shares, s...)
+	}
+	return fileenc.NewShareIdentity(shares...), nil
+}
+
+// parseIdentities parses the fileenc, FIDO2, TPM, PKCS#11 or age identities in data read from name
+func parseIdentities(data []byte, name string) ([]fileenc.Identity, []age.Identity, error) {
+	if bytes.Contains(data, []byte(fido2Prefix)) {
+		ids, err := parseFIDO2Identities(data, name)
+		return ids, nil, err
+	}
+	if bytes.Contains(data, []byte(tpmPrefix)) {
+		ids, err := parseTPMIdentities(data, name)
+		return ids, nil, err
+	}
+	if bytes.Contains(data, []byte(pkcs11Prefix)) {
+		ids, err := parsePKCS11Identities(data, name)
+		return ids, nil, err
+	}
+	if ids, err := fileenc.ParseIdentities(bytes.NewReader(data)); err == nil {
+		return ids, nil, nil
+	}
+	ageIDs, err := parseAgeIdentities(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: no fileenc or age identities: %w", name, err)
+	}
+	return nil, ageIDs, nil
+}
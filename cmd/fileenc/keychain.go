@@ -0,0 +1,85 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const (
+	// keychainService is the service the keys are stored under in the OS credential store
+	keychainService = "fileenc"
+	// keychainDefault is the entry used when no -key-name is given
+	keychainDefault = "default"
+)
+
+// errKeychainNotFound is returned when the OS credential store holds no entry of the name
+var errKeychainNotFound = errors.New("key not found in the keychain")
+
+// runKey implements "fileenc key store|delete [name]"
+func runKey(args []string) {
+	usage := func() {
+		fmt.Println("Usage: fileenc key store [-keyfile <file>] [name]")
+		fmt.Println("       fileenc key delete [name]")
+		fmt.Println("Stores keys in the OS keychain, use them with -use-keychain [-key-name <name>]. The name defaults to " + keychainDefault + ".")
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	fs := flag.NewFlagSet("key "+args[0], flag.ExitOnError)
+	keyFile := fs.String("keyfile", "", "read the key from this file instead of the prompt or "+keyEnv)
+	fs.Usage = usage
+	rest := parseArgs(fs, args[1:])
+	if len(rest) > 1 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	name := keychainDefault
+	if len(rest) == 1 {
+		name = rest[0]
+	}
+
+	switch args[0] {
+	case "store":
+		key, err := loadKey("", *keyFile, keyEnv, "key for "+name, true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		err = keychainSet(name, key)
+		clear(key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error storing key: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		fmt.Printf("Key %s stored in the keychain.\n", name)
+	case "delete":
+		if err := keychainDelete(name); err != nil {
+			fmt.Fprintf(os.Stderr, "Error deleting key: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		fmt.Printf("Key %s deleted from the keychain.\n", name)
+	default:
+		usage()
+		os.Exit(exitUsage)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// securityPath is the macOS tool managing the keychain
+const securityPath = "/usr/bin/security"
+
+// keychainSet stores key in the login keychain. The command is passed on stdin
+// so the key does not show up in the process list, the key is stored hex
+// encoded to keep arbitrary bytes intact.
+func keychainSet(name string, key []byte) error {
+	cmd := exec.Command(securityPath, "-i")
+	enc := hex.EncodeToString(key)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", quote(keychainService), quote(name), enc))
+	if out, err := cmd.CombinedOutput(); err != nil || len(bytes.TrimSpace(out)) > 0 {
+		return fmt.Errorf("security failed: %v %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// keychainGet returns the key stored under name
+func keychainGet(name string) ([]byte, error) {
+	out, err := exec.Command(securityPath, "find-generic-password", "-s", keychainService, "-a", name, "-w").Output()
+	defer clear(out)
+	if commandExitCode(err) == 44 {
+		return nil, errKeychainNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("security failed: %w", err)
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(out)))
+	if err != nil {
+		return nil, errors.New("malformed key in the keychain")
+	}
+	return key, nil
+}
+
+// keychainDelete removes the key stored under name
+func keychainDelete(name string) error {
+	err := exec.Command(securityPath, "delete-generic-password", "-s", keychainService, "-a", name).Run()
+	if commandExitCode(err) == 44 {
+		return errKeychainNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("security failed: %w", err)
+	}
+	return nil
+}
+
+// commandExitCode returns the exit code of a failed command, 0 for other errors
+func commandExitCode(err error) int {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode()
+	}
+	return 0
+}
+
+// quote quotes s for the command parser of security -i
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
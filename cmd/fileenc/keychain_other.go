@@ -0,0 +1,84 @@
+//go:build !darwin && !windows
+
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// secretTool is the libsecret command line tool talking to the Secret Service
+// of GNOME Keyring, KWallet and compatible stores
+const secretTool = "secret-tool"
+
+// keychainSet stores key with the Secret Service, it is passed on stdin
+func keychainSet(name string, key []byte) error {
+	cmd, err := secretToolCommand("store", "--label", "fileenc "+name, "service", keychainService, "account", name)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = bytes.NewReader(key)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool failed: %v %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// keychainGet returns the key stored under name
+func keychainGet(name string) ([]byte, error) {
+	cmd, err := secretToolCommand("lookup", "service", keychainService, "account", name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := cmd.Output()
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit) || (err == nil && len(key) == 0):
+		return nil, errKeychainNotFound
+	case err != nil:
+		return nil, fmt.Errorf("secret-tool failed: %w", err)
+	}
+	return key, nil
+}
+
+// keychainDelete removes the key stored under name
+func keychainDelete(name string) error {
+	if _, err := keychainGet(name); err != nil {
+		return err
+	}
+	cmd, err := secretToolCommand("clear", "service", keychainService, "account", name)
+	if err != nil {
+		return err
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool failed: %v %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// secretToolCommand returns the secret-tool command with args
+func secretToolCommand(args ...string) (*exec.Cmd, error) {
+	path, err := exec.LookPath(secretTool)
+	if err != nil {
+		return nil, errors.New("secret-tool not found, install libsecret-tools for keychain support")
+	}
+	return exec.Command(path, args...), nil
+}
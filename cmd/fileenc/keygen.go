@@ -0,0 +1,180 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// runKeygen implements "fileenc keygen [-signing] [-o <file>]" and
+// "fileenc keygen -symmetric [-encoding hex|base64] [-from-passphrase] [-o <file>]"
+func runKeygen(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	output := fs.String("o", "", "write the identity or key to this file instead of stdout, it must not exist")
+	symmetric := fs.Bool("symmetric", false, "generate a random 256 bit key for -kdf none instead of an identity")
+	signing := fs.Bool("signing", false, "generate an Ed25519 signing key for -sign instead of an identity")
+	encoding := fs.String("encoding", "hex", "text form of the symmetric key, hex or base64")
+	fromPass := fs.Bool("from-passphrase", false, "derive the symmetric key from a passphrase once instead of generating it, read like -key")
+	kdf := fs.String("kdf", fileenc.KDFArgon2id, "key derivation function for -from-passphrase, argon2id, scrypt or pbkdf2 with its default cost")
+	salt := fs.String("salt", "", "salt in hex for -from-passphrase to derive the same key again, random by default")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc keygen [-signing] [-o <file>]")
+		fmt.Fprintln(fs.Output(), "       fileenc keygen -symmetric [-encoding hex|base64] [-from-passphrase [-kdf <kdf>] [-salt <hex>]] [-o <file>]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if !*symmetric {
+		if *fromPass || *salt != "" {
+			fmt.Fprintln(os.Stderr, "-from-passphrase and -salt require -symmetric")
+			os.Exit(exitUsage)
+		}
+		if *signing {
+			generateSigner(*output)
+		} else {
+			generateIdentity(*output)
+		}
+		return
+	}
+	if *signing {
+		fmt.Fprintln(os.Stderr, "-signing and -symmetric cannot be combined")
+		os.Exit(exitUsage)
+	}
+	if *encoding != "hex" && *encoding != "base64" {
+		fmt.Fprintf(os.Stderr, "Unknown encoding %q, use hex or base64\n", *encoding)
+		os.Exit(exitUsage)
+	}
+	key, params, err := symmetricKey(*fromPass, *kdf, *salt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating key: %v\n", err)
+		os.Exit(exitCode(err, exitFailure))
+	}
+	defer key.Destroy()
+
+	// Encode into a buffer that can be cleared, not into a string
+	var text []byte
+	if *encoding == "hex" {
+		text = hex.AppendEncode(nil, key.Bytes())
+	} else {
+		text = base64.StdEncoding.AppendEncode(nil, key.Bytes())
+	}
+	text = append(text, '\n')
+	defer clear(text)
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating key file: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		defer file.Close()
+		out = file
+	}
+	if _, err := out.Write(text); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing key: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	info := io.Writer(os.Stderr)
+	if *output != "" {
+		info = os.Stdout
+		fmt.Printf("Key written to %s, use it with -kdf none -keyfile %s\n", *output, *output)
+	}
+	if *fromPass {
+		fmt.Fprintf(info, "Derived with %s, salt %x\n", params.Name, params.Salt)
+	}
+}
+
+// symmetricKey generates a random 256 bit key or derives it from a passphrase
+// with the default cost of the KDF and the salt in hex, a random one if empty
+func symmetricKey(fromPass bool, kdf, salt string) (*fileenc.SecureBuffer, fileenc.KDFParams, error) {
+	if !fromPass {
+		key := fileenc.NewSecureBuffer(32)
+		rand.Read(key.Bytes())
+		return key, fileenc.KDFParams{}, nil
+	}
+	params, err := fileenc.DefaultKDFParams(kdf)
+	if err != nil || kdf == fileenc.KDFNone {
+		return nil, fileenc.KDFParams{}, fmt.Errorf("unknown kdf %q", kdf)
+	}
+	if salt != "" {
+		if params.Salt, err = hex.DecodeString(salt); err != nil || len(params.Salt) == 0 {
+			return nil, fileenc.KDFParams{}, fmt.Errorf("invalid salt %q", salt)
+		}
+	} else {
+		params.Salt = make([]byte, 16)
+		rand.Read(params.Salt)
+	}
+	pass, err := loadKey("", "", keyEnv, "passphrase", salt == "")
+	if err != nil {
+		return nil, fileenc.KDFParams{}, err
+	}
+	defer clear(pass)
+	key, err := params.DeriveKey(pass)
+	return key, params, err
+}
+
+// generateIdentity writes a new X25519 identity to output or stdout
+func generateIdentity(output string) {
+	id, err := fileenc.GenerateX25519Identity()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating identity: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	writeKeyPair(output, "identity", id.Recipient().String(), id.String())
+}
+
+// generateSigner writes a new Ed25519 signing key to output or stdout
+func generateSigner(output string) {
+	signer, err := fileenc.GenerateEd25519Signer()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating signing key: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	writeKeyPair(output, "signing key", signer.PublicKey().String(), signer.String())
+}
+
+// writeKeyPair writes the secret key with its public key in a comment to
+// output or stdout, kind names the key in messages
+func writeKeyPair(output, kind, pub, secret string) {
+	out := io.Writer(os.Stdout)
+	if output != "" {
+		file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating %s file: %v\n", kind, err)
+			os.Exit(exitFailure)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	fmt.Fprintf(out, "# created: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(out, "# public key: %s\n", pub)
+	fmt.Fprintf(out, "%s\n", secret)
+	if output != "" {
+		fmt.Printf("Public key: %s\n", pub)
+	}
+}
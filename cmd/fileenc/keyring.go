@@ -0,0 +1,340 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"filippo.io/age"
+	"filippo.io/age/plugin"
+	"github.com/itkonzepte-net/fileenc"
+)
+
+const (
+	// keyringEnv is the environment variable overriding the keyring location
+	keyringEnv = "FILEENC_KEYRING"
+	// keyringKeyEnv is the environment variable holding the keyring passphrase
+	keyringKeyEnv = "FILEENC_KEYRING_KEY"
+)
+
+// keyringEntry is a named key or identity stored in the keyring
+type keyringEntry struct {
+	Name string `json:"name"`
+	// Key is the passphrase or raw key, empty for identities
+	Key string `json:"key,omitempty"`
+	// Identity holds the identity file and Recipients its public keys
+	Identity   string   `json:"identity,omitempty"`
+	Recipients []string `json:"recipients,omitempty"`
+}
+
+// keyring is the decrypted content of the keyring file, which is encrypted
+// with its own passphrase
+type keyring struct {
+	Entries []keyringEntry `json:"entries"`
+
+	path string
+	pass []byte
+}
+
+// keyringPath returns the location of the keyring, ~/.config/fileenc/config
+// on Linux unless FILEENC_KEYRING is set
+func keyringPath() (string, error) {
+	if path := os.Getenv(keyringEnv); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the keyring: %w", err)
+	}
+	return filepath.Join(dir, "fileenc", "config"), nil
+}
+
+// openKeyring reads and decrypts the keyring. A missing keyring is an error
+// unless create is set, then an empty one is returned with a new passphrase.
+func openKeyring(create bool) (*keyring, error) {
+	path, err := keyringPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if !create {
+			return nil, fmt.Errorf("no keyring at %s, add a key with fileenc keyring add", path)
+		}
+		pass, err := loadKey("", "", keyringKeyEnv, "new keyring passphrase", true)
+		if err != nil {
+			return nil, err
+		}
+		return &keyring{path: path, pass: pass}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	pass, err := loadKey("", "", keyringKeyEnv, "keyring passphrase", false)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := fileenc.New(pass)
+	if err != nil {
+		clear(pass)
+		return nil, err
+	}
+	var plain bytes.Buffer
+	defer func() { clear(plain.Bytes()) }()
+	if err := enc.Decrypt(&plain, bytes.NewReader(data)); err != nil {
+		clear(pass)
+		return nil, fmt.Errorf("failed to decrypt keyring %s: %w", path, err)
+	}
+	kr := &keyring{path: path, pass: pass}
+	if err := json.Unmarshal(plain.Bytes(), kr); err != nil {
+		clear(pass)
+		return nil, fmt.Errorf("malformed keyring %s: %w", path, err)
+	}
+	return kr, nil
+}
+
+// save encrypts the keyring and replaces the keyring file
+func (kr *keyring) save() error {
+	plain, err := json.Marshal(kr)
+	if err != nil {
+		return err
+	}
+	defer clear(plain)
+	enc, err := fileenc.New(kr.pass)
+	if err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	if err := enc.Encrypt(&data, bytes.NewReader(plain)); err != nil {
+		return err
+	}
+
+	// Write a temporary file first so a failure keeps the old keyring
+	if err := os.MkdirAll(filepath.Dir(kr.path), 0700); err != nil {
+		return fmt.Errorf("failed to create keyring directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(kr.path), "."+filepath.Base(kr.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	_, err = tmp.Write(data.Bytes())
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), kr.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	return nil
+}
+
+// find returns the index of the entry called name, -1 if there is none
+func (kr *keyring) find(name string) int {
+	return slices.IndexFunc(kr.Entries, func(e keyringEntry) bool { return e.Name == name })
+}
+
+// close clears the keyring passphrase
+func (kr *keyring) close() {
+	clear(kr.pass)
+}
+
+// loadKeyringEntry returns the keyring entry called name
+func loadKeyringEntry(name string) (*keyringEntry, error) {
+	kr, err := openKeyring(false)
+	if err != nil {
+		return nil, err
+	}
+	defer kr.close()
+	i := kr.find(name)
+	if i < 0 {
+		return nil, fmt.Errorf("no key %q in the keyring", name)
+	}
+	return &kr.Entries[i], nil
+}
+
+// runKeyring implements "fileenc keyring add|list|remove"
+func runKeyring(args []string) {
+	usage := func() {
+		fmt.Println("Usage: fileenc keyring add [-keyfile <file> | -identity <file>] <name>")
+		fmt.Println("       fileenc keyring list")
+		fmt.Println("       fileenc keyring remove <name>")
+		fmt.Println("The keyring is encrypted with its own passphrase, taken from " + keyringKeyEnv + " or the prompt.")
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	var err error
+	switch args[0] {
+	case "add":
+		err = keyringAdd(args[1:])
+	case "list":
+		err = keyringList()
+	case "remove":
+		if len(args) != 2 {
+			usage()
+			os.Exit(exitUsage)
+		}
+		err = keyringRemove(args[1])
+	default:
+		usage()
+		os.Exit(exitUsage)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+}
+
+// keyringAdd stores a key or identity in the keyring
+func keyringAdd(args []string) error {
+	fs := flag.NewFlagSet("keyring add", flag.ExitOnError)
+	keyFile := fs.String("keyfile", "", "read the key from this file instead of the prompt or "+keyEnv)
+	identity := fs.String("identity", "", "store the identity file created by fileenc keygen or age-keygen")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc keyring add [-keyfile <file> | -identity <file>] <name>")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) != 1 || args[0] == "" || (*keyFile != "" && *identity != "") {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	name := args[0]
+
+	kr, err := openKeyring(true)
+	if err != nil {
+		return err
+	}
+	defer kr.close()
+	if kr.find(name) >= 0 {
+		return fmt.Errorf("key %q already exists, remove it first", name)
+	}
+
+	entry := keyringEntry{Name: name}
+	if *identity != "" {
+		data, err := os.ReadFile(*identity)
+		if err != nil {
+			return fmt.Errorf("failed to read identity file: %w", err)
+		}
+		defer clear(data)
+		if entry.Recipients, err = identityRecipients(data, *identity); err != nil {
+			return err
+		}
+		entry.Identity = string(data)
+	} else {
+		key, err := loadKey("", *keyFile, keyEnv, "key for "+name, true)
+		if err != nil {
+			return err
+		}
+		entry.Key = string(key)
+		clear(key)
+	}
+	kr.Entries = append(kr.Entries, entry)
+	if err := kr.save(); err != nil {
+		return err
+	}
+	fmt.Printf("Key %s added to %s.\n", name, kr.path)
+	return nil
+}
+
+// keyringList prints the names of the keys and the public keys of the identities
+func keyringList() error {
+	kr, err := openKeyring(false)
+	if err != nil {
+		return err
+	}
+	defer kr.close()
+	for _, e := range kr.Entries {
+		if e.Identity == "" {
+			fmt.Printf("%s\tkey\n", e.Name)
+			continue
+		}
+		fmt.Printf("%s\tidentity", e.Name)
+		for _, r := range e.Recipients {
+			fmt.Printf("\t%s", r)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// keyringRemove deletes the entry called name from the keyring
+func keyringRemove(name string) error {
+	kr, err := openKeyring(false)
+	if err != nil {
+		return err
+	}
+	defer kr.close()
+	i := kr.find(name)
+	if i < 0 {
+		return fmt.Errorf("no key %q in the keyring", name)
+	}
+	kr.Entries = slices.Delete(kr.Entries, i, i+1)
+	if err := kr.save(); err != nil {
+		return err
+	}
+	fmt.Printf("Key %s removed.\n", name)
+	return nil
+}
+
+// identityRecipients parses the identity file data and returns the public keys
+// of its identities, so a stored identity can be used for encryption as well
+func identityRecipients(data []byte, name string) ([]string, error) {
+	ids, ageIDs, err := parseIdentities(data, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		switch ids[0].(type) {
+		case *fido2Identity, *tpmIdentity:
+			// The key is only known with the device, take the public key from the comment
+			return publicKeyComments(data), nil
+		}
+	}
+	var recipients []string
+	for _, id := range ids {
+		switch x := id.(type) {
+		case *fileenc.X25519Identity:
+			recipients = append(recipients, x.Recipient().String())
+		case *pkcs11Identity:
+			recipients = append(recipients, x.pkcs11Recipient.String())
+		}
+	}
+	for _, id := range ageIDs {
+		switch x := id.(type) {
+		case *age.X25519Identity:
+			recipients = append(recipients, x.Recipient().String())
+		case *plugin.Identity:
+			recipients = append(recipients, x.Recipient().String())
+		}
+	}
+	return recipients, nil
+}
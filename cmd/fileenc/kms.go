@@ -0,0 +1,397 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+const (
+	// kmsStanzaType is the recipient stanza type of file keys wrapped by a cloud KMS
+	kmsStanzaType = "kms"
+	// kmsContext is bound to every wrapped file key as encryption context or
+	// additional data, it shows up in the audit logs of the KMS
+	kmsContext = "fileenc file key"
+	// kmsTokenLifetime is how long an access token of gcloud or az is reused
+	kmsTokenLifetime = 30 * time.Minute
+)
+
+// kmsClient sends the requests to the key management services
+var kmsClient = &http.Client{Timeout: time.Minute}
+
+// awsRegionPattern matches AWS region names, a region taken from a key ARN in
+// a file header must not be able to change the host of the endpoint
+var awsRegionPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// azureVaultHostsEnv names further host suffixes key URLs may point to, e.g.
+// .vault.azure.cn for other Azure clouds, comma separated
+const azureVaultHostsEnv = "FILEENC_AZURE_VAULT_HOSTS"
+
+// kmsProvider wraps and unwraps file keys with a key held by a cloud key
+// management service. wrap returns the key ID to store, which may name the
+// key version used.
+type kmsProvider interface {
+	wrap(keyID string, fileKey []byte) (string, []byte, error)
+	unwrap(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// kmsProviderFor returns the provider of a -kms-key-id value: an AWS KMS key
+// ARN or alias, a GCP Cloud KMS key name, an Azure Key Vault key URL or a
+// Vault transit key
+func kmsProviderFor(keyID string) (kmsProvider, error) {
+	switch {
+	case strings.HasPrefix(keyID, "arn:aws:kms:") || strings.HasPrefix(keyID, "alias/"):
+		return awsKMS{}, nil
+	case strings.HasPrefix(keyID, "projects/") && strings.Contains(keyID, "/cryptoKeys/"):
+		return gcpKMS{}, nil
+	case strings.HasPrefix(keyID, "https://") && strings.Contains(keyID, "/keys/"):
+		return azureKeyVault{}, nil
+	case strings.HasPrefix(keyID, vaultKeyPrefix):
+		return vaultTransit{}, nil
+	}
+	return nil, fmt.Errorf("unknown KMS key %q, use an AWS KMS key ARN or alias/<name>, a GCP key projects/.../cryptoKeys/<name>, an Azure Key Vault key URL or vault:<mount>/<key>", keyID)
+}
+
+// kmsRecipient wraps the file key with a KMS key
+type kmsRecipient struct {
+	keyID    string
+	provider kmsProvider
+}
+
+// newKMSRecipient returns the recipient of the KMS key keyID
+func newKMSRecipient(keyID string) (*kmsRecipient, error) {
+	p, err := kmsProviderFor(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return &kmsRecipient{keyID: keyID, provider: p}, nil
+}
+
+// Wrap has the KMS encrypt the file key. The stanza holds the key ID and the
+// wrapped key.
+func (r *kmsRecipient) Wrap(fileKey []byte) (fileenc.Stanza, error) {
+	keyID, wrapped, err := r.provider.wrap(r.keyID, fileKey)
+	if err != nil {
+		return fileenc.Stanza{}, fmt.Errorf("KMS key %s: %w", r.keyID, err)
+	}
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(keyID)))
+	body = append(body, keyID...)
+	return fileenc.Stanza{Type: kmsStanzaType, Body: append(body, wrapped...)}, nil
+}
+
+// kmsIdentity has the KMS named in the stanzas unwrap the file key, access is
+// granted and audited by the KMS
+type kmsIdentity struct{}
+
+// Unwrap tries the KMS stanzas in turn and returns the first file key unwrapped
+func (kmsIdentity) Unwrap(stanzas []fileenc.Stanza) ([]byte, error) {
+	err := fileenc.ErrNoIdentity
+	for _, st := range stanzas {
+		if st.Type != kmsStanzaType {
+			continue
+		}
+		if len(st.Body) < 2 || len(st.Body) < 2+int(binary.BigEndian.Uint16(st.Body)) {
+			return nil, fmt.Errorf("%w: invalid KMS stanza", fileenc.ErrMalformedHeader)
+		}
+		n := 2 + int(binary.BigEndian.Uint16(st.Body))
+		keyID, wrapped := string(st.Body[2:n]), st.Body[n:]
+		p, perr := kmsProviderFor(keyID)
+		if perr != nil {
+			err = perr
+			continue
+		}
+		key, uerr := p.unwrap(keyID, wrapped)
+		if uerr == nil {
+			return key, nil
+		}
+		err = fmt.Errorf("KMS key %s: %w", keyID, uerr)
+	}
+	return nil, err
+}
+
+// kmsDo sends req and decodes the JSON answer into out
+func kmsDo(req *http.Request, out any) error {
+	resp, err := kmsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// AWS reports __type and message, GCP and Azure an error object, Vault a list
+		var e struct {
+			Type    string   `json:"__type"`
+			Message string   `json:"message"`
+			Errors  []string `json:"errors"`
+			Error   struct {
+				Code    string `json:"code"`
+				Status  string `json:"status"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		msg := strings.TrimSpace(e.Type + " " + e.Message + " " + e.Error.Code + e.Error.Status + " " + e.Error.Message + " " + strings.Join(e.Errors, ", "))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return errors.New(msg)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid KMS response: %w", err)
+	}
+	return nil
+}
+
+// awsKMS wraps file keys with AWS KMS. Credentials and region are taken like
+// for S3, the region of a key ARN takes precedence.
+type awsKMS struct{}
+
+// call sends a KMS API request for the action
+func (awsKMS) call(action, keyID string, in, out any) error {
+	c, err := newS3Client()
+	if err != nil {
+		return err
+	}
+	c.service = "kms"
+	if parts := strings.Split(keyID, ":"); len(parts) > 3 && strings.HasPrefix(keyID, "arn:") {
+		c.region = parts[3]
+	}
+	if !awsRegionPattern.MatchString(c.region) {
+		return fmt.Errorf("invalid AWS region %q", c.region)
+	}
+	endpoint := envOr("AWS_ENDPOINT_URL_KMS", "https://kms."+c.region+".amazonaws.com")
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid KMS endpoint %q", endpoint)
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, u.Scheme+"://"+u.Host+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.URL.Opaque = "//" + u.Host + "/"
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	sum := sha256.Sum256(body)
+	c.sign(req, hex.EncodeToString(sum[:]), time.Now().UTC())
+	return kmsDo(req, out)
+}
+
+// wrap encrypts the file key, the key ARN is stored so an alias can change
+func (k awsKMS) wrap(keyID string, fileKey []byte) (string, []byte, error) {
+	var out struct {
+		KeyId          string
+		CiphertextBlob []byte
+	}
+	in := map[string]any{"KeyId": keyID, "Plaintext": fileKey, "EncryptionContext": map[string]string{"purpose": kmsContext}}
+	if err := k.call("Encrypt", keyID, in, &out); err != nil {
+		return "", nil, err
+	}
+	if out.KeyId != "" {
+		keyID = out.KeyId
+	}
+	return keyID, out.CiphertextBlob, nil
+}
+
+// unwrap decrypts the file key
+func (k awsKMS) unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	var out struct{ Plaintext []byte }
+	in := map[string]any{"KeyId": keyID, "CiphertextBlob": wrapped, "EncryptionContext": map[string]string{"purpose": kmsContext}}
+	if err := k.call("Decrypt", keyID, in, &out); err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// gcpKMS wraps file keys with Google Cloud KMS. The access token is taken from
+// GOOGLE_OAUTH_ACCESS_TOKEN or gcloud auth print-access-token.
+type gcpKMS struct{}
+
+// gcpToken caches the access token for Cloud KMS
+var gcpToken = &kmsToken{env: "GOOGLE_OAUTH_ACCESS_TOKEN", command: []string{"gcloud", "auth", "print-access-token"}}
+
+// call posts in to the method of the key
+func (gcpKMS) call(keyID, method string, in, out any) error {
+	token, err := gcpToken.get()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, "https://cloudkms.googleapis.com/v1/"+keyID+":"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	return kmsDo(req, out)
+}
+
+// wrap encrypts the file key with the primary version of the key
+func (k gcpKMS) wrap(keyID string, fileKey []byte) (string, []byte, error) {
+	var out struct{ Ciphertext []byte }
+	in := map[string][]byte{"plaintext": fileKey, "additionalAuthenticatedData": []byte(kmsContext)}
+	if err := k.call(keyID, "encrypt", in, &out); err != nil {
+		return "", nil, err
+	}
+	return keyID, out.Ciphertext, nil
+}
+
+// unwrap decrypts the file key, Cloud KMS finds the key version itself
+func (k gcpKMS) unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	var out struct{ Plaintext []byte }
+	in := map[string][]byte{"ciphertext": wrapped, "additionalAuthenticatedData": []byte(kmsContext)}
+	if err := k.call(keyID, "decrypt", in, &out); err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// azureKeyVault wraps file keys with an RSA key in Azure Key Vault. The access
+// token is taken from AZURE_ACCESS_TOKEN or az account get-access-token.
+type azureKeyVault struct{}
+
+// azureToken caches the access token for Key Vault
+var azureToken = &kmsToken{env: "AZURE_ACCESS_TOKEN", command: []string{"az", "account", "get-access-token",
+	"--resource", "https://vault.azure.net", "--query", "accessToken", "--output", "tsv"}}
+
+// checkHost makes sure the key URL points to Key Vault before the access
+// token is sent there, the URL of a file being decrypted comes from its header
+func (azureKeyVault) checkHost(keyID string) error {
+	u, err := url.Parse(keyID)
+	if err != nil || u.Scheme != "https" || u.User != nil || u.Port() != "" {
+		return fmt.Errorf("invalid Key Vault key URL %q", keyID)
+	}
+	suffixes := []string{".vault.azure.net"}
+	for _, s := range strings.Split(os.Getenv(azureVaultHostsEnv), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			suffixes = append(suffixes, "."+strings.TrimPrefix(s, "."))
+		}
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Key Vault key URL %q is not on a Key Vault host, allow it with %s", keyID, azureVaultHostsEnv)
+}
+
+// call posts the value to the operation of the key and returns the result
+func (k azureKeyVault) call(keyID, op string, value []byte) (string, []byte, error) {
+	if err := k.checkHost(keyID); err != nil {
+		return "", nil, err
+	}
+	token, err := azureToken.get()
+	if err != nil {
+		return "", nil, err
+	}
+	body, err := json.Marshal(map[string]string{"alg": "RSA-OAEP-256", "value": base64.RawURLEncoding.EncodeToString(value)})
+	if err != nil {
+		return "", nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(keyID, "/")+"/"+op+"?api-version=7.4", bytes.NewReader(body))
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	var out struct {
+		Kid   string `json:"kid"`
+		Value string `json:"value"`
+	}
+	if err := kmsDo(req, &out); err != nil {
+		return "", nil, err
+	}
+	result, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(out.Value, "="))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid Key Vault response: %w", err)
+	}
+	return out.Kid, result, nil
+}
+
+// wrap encrypts the file key, the stored key ID names the key version used
+func (k azureKeyVault) wrap(keyID string, fileKey []byte) (string, []byte, error) {
+	kid, wrapped, err := k.call(keyID, "wrapkey", fileKey)
+	if err != nil {
+		return "", nil, err
+	}
+	if kid != "" {
+		keyID = kid
+	}
+	return keyID, wrapped, nil
+}
+
+// unwrap decrypts the file key
+func (k azureKeyVault) unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	_, key, err := k.call(keyID, "unwrapkey", wrapped)
+	return key, err
+}
+
+// kmsToken is an access token read from an environment variable or printed
+// by a command, it is fetched again after kmsTokenLifetime
+type kmsToken struct {
+	env     string
+	command []string
+
+	mu      sync.Mutex
+	token   string
+	fetched time.Time
+}
+
+// get returns the cached token or fetches a new one
+func (t *kmsToken) get() (string, error) {
+	if v := os.Getenv(t.env); v != "" {
+		return v, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Since(t.fetched) < kmsTokenLifetime {
+		return t.token, nil
+	}
+	out, err := exec.Command(t.command[0], t.command[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("no access token, set %s or log in with %s: %w", t.env, t.command[0], err)
+	}
+	t.token, t.fetched = strings.TrimSpace(string(out)), time.Now()
+	return t.token, nil
+}
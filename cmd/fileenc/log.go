@@ -0,0 +1,83 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logFlags holds the flags controlling the diagnostics written to stderr
+type logFlags struct {
+	quiet   bool
+	verbose bool
+	format  string
+}
+
+// addLogFlags registers -quiet, -verbose and -log-format on fs
+func addLogFlags(fs *flag.FlagSet) *logFlags {
+	l := &logFlags{}
+	fs.BoolVar(&l.quiet, "quiet", false, "only report errors, no progress or success messages")
+	fs.BoolVar(&l.verbose, "verbose", false, "also report the settings and the details of every file")
+	fs.StringVar(&l.format, "log-format", logFormatText, "format of the messages on stderr: text (key=value) or json (one object per line)")
+	return l
+}
+
+// check validates the combination of the flags
+func (l *logFlags) check() error {
+	if l.format != logFormatText && l.format != logFormatJSON {
+		return fmt.Errorf("unknown log format %q, use text or json", l.format)
+	}
+	if l.quiet && l.verbose {
+		return fmt.Errorf("-quiet and -verbose cannot be combined")
+	}
+	return nil
+}
+
+// level returns the lowest level reported
+func (l *logFlags) level() slog.Level {
+	switch {
+	case l.quiet:
+		return slog.LevelError
+	case l.verbose:
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+// logger returns a logger writing to w in the selected format. The text
+// format leaves out the time, the JSON format keeps it for log collectors.
+func (l *logFlags) logger(w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: l.level()}
+	if l.format == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.TimeKey && len(groups) == 0 {
+			return slog.Attr{}
+		}
+		return a
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
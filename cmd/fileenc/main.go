@@ -0,0 +1,594 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+/* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE. */
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime"
+	"slices"
+	"syscall"
+
+	"github.com/itkonzepte-net/fileenc"
+	"golang.org/x/term"
+)
+
+// commands maps the subcommand names to their implementations, called with the remaining arguments
+var commands = map[string]func(args []string){
+	"archive":       runArchive,
+	"audit":         runAudit,
+	"backup":        runBackup,
+	"bench":         runBench,
+	"cat":           runCat,
+	"clip":          runClip,
+	"daemon":        runDaemon,
+	"extract":       runExtract,
+	"fido2":         runFIDO2,
+	"gui":           runGUI,
+	"hsm":           runHSM,
+	"inspect":       runInspect,
+	"install-shell": runInstallShell,
+	"key":           runKey,
+	"keygen":        runKeygen,
+	"keyring":       runKeyring,
+	"mount":         runMount,
+	"policy":        runPolicy,
+	"rekey":         runRekey,
+	"repo":          runRepo,
+	"restore":       runRestore,
+	"serve":         runServe,
+	"sfx":           runSFX,
+	"shred":         runShred,
+	"text":          runText,
+	"tpm":           runTPM,
+	"verify":        runVerify,
+	"watch":         runWatch,
+}
+
+func main() {
+	// A self-decrypting executable only decrypts its payload
+	if file, payload, ok := sfxPayload(); ok {
+		code := runSelfDecrypt(payload, os.Args[1:])
+		file.Close()
+		os.Exit(code)
+	}
+	// encrypt and decrypt may be given as commands as well
+	if len(os.Args) > 1 && (os.Args[1] == "encrypt" || os.Args[1] == "decrypt") {
+		os.Args[1] = "-" + os.Args[1]
+	}
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
+	keys := addKeyFlags(flag.CommandLine, true)
+	var sources stringList
+	flag.Var(&sources, "source", "file subject for processing, no .enc extension! May be repeated and contain glob patterns, further files can follow the flags; - reads from stdin and writes to stdout, s3://, sftp:// and https:// URLs are downloaded")
+	encryptFlag := flag.Bool("encrypt", false, "run encryption, the default")
+	decryptFlag := flag.Bool("decrypt", false, "run decryption, default encryption")
+	resumeFlag := flag.Bool("resume", false, "write encrypted files to <file>.partial first and continue an interrupted encryption from there")
+	forceFlag := flag.Bool("force", false, "encrypt files that already are fileenc, age or OpenPGP files")
+	overwriteFlag := flag.Bool("overwrite", false, "if not set, will not overwrite existing files; if set, files are overwritten with encrypted/decrypted data!")
+	onConflictFlag := flag.String("on-conflict", conflictFail, "what to do if an output file exists: fail, skip, overwrite (like -overwrite), rename (append -1, -2, ...) or prompt")
+	ciphers := addCipherFlags(flag.CommandLine)
+	metadata := addMetadataFlags(flag.CommandLine)
+	checksumFlag := flag.String("checksum", "", "verify the digest of a source URL, sha256:<hex> or sha512:<hex>; the output is discarded on mismatch")
+	outFlag := flag.String("out", "", "write the output of the single source file to this path or an s3://bucket/key or sftp://user@host/path URL")
+	outDirFlag := flag.String("out-dir", "", "write the outputs to this directory, mirroring the relative paths of the sources")
+	suffixFlag := flag.String("suffix", encExt, "extension of encrypted files, added when encrypting and removed when decrypting")
+	inPlaceFlag := flag.Bool("in-place", false, "replace every file with its encrypted or decrypted version under the same name")
+	renameFlag := flag.Bool("rename", false, "with -in-place, add .enc when encrypting and remove it when decrypting; the source is removed once the new file is complete")
+	sharesFlag := flag.Int("shares", 0, "split a random key of every file into this many shares written to <file>.enc.share1, ...; no passphrase is used")
+	thresholdFlag := flag.Int("threshold", 0, "number of the -shares needed to decrypt")
+	var splitSize byteSize
+	flag.Var(&splitSize, "split-size", "write encrypted files in volumes of at most this size, e.g. 4G or 650MB, named <file>.enc.001, .002, ...")
+	var bufSize byteSize
+	flag.Var(&bufSize, "bufsize", "size of the buffers data is copied through, e.g. 1M, default 256K; larger buffers can help slow network file systems")
+	fsyncFlag := flag.Bool("fsync", false, "flush every output file and its directory to disk before reporting success, so a power loss cannot lose it")
+	dropCacheFlag := flag.Bool("drop-cache", false, "keep the files out of the OS page cache so large jobs do not evict the cache of other programs; outputs are synced to disk")
+	var bwLimit byteSize
+	flag.Var(&bwLimit, "bwlimit", "limit reading and writing to this many bytes per second each, e.g. 20M, shared by all -jobs")
+	recursiveFlag := flag.Bool("recursive", false, "process the files in the directories among the sources and their subdirectories")
+	includeHiddenFlag := flag.Bool("include-hidden", false, "with -recursive, also process hidden files and directories, whose names start with a dot")
+	var exclude stringList
+	flag.Var(&exclude, "exclude", "with -recursive, skip the files matching this gitignore-style pattern, e.g. node_modules/ or *.tmp, may be repeated; "+ignoreFile+" files add patterns per directory")
+	followFlag := flag.Bool("follow-symlinks", false, "with -recursive, process the files and directories symbolic links point to; links are skipped by default")
+	preserveFlag := flag.Bool("preserve-symlinks", false, "encrypt symbolic links as links, decryption recreates them; links are skipped by default with -recursive")
+	encryptNamesFlag := flag.Bool("encrypt-names", false, "replace the file names by their encryption with the passphrase and restore them on decryption; directory names are kept")
+	shredFlag := flag.Bool("shred", false, "after successful encryption overwrite the source file with random data and remove it")
+	shredPasses := flag.Int("shred-passes", fileenc.DefaultShredPasses, "number of random overwrites for -shred")
+	verifySourceFlag := flag.Bool("verify-source", false, "hash every source before encrypting it and decrypt the encrypted file again without writing the plaintext, a mismatch fails the file before -shred or -delete-source removes the source")
+	deleteSourceFlag := flag.Bool("delete-source", false, "after successful encryption remove the source file, only once the output is complete, flushed to disk and, with -verify-source, decrypted again")
+	jobs := flag.Int("jobs", 1, "number of files processed in parallel, 0 uses all CPU cores")
+	threads := flag.Int("threads", 0, "number of chunks of a file encrypted or decrypted in parallel, 0 uses all CPU cores, 1 processes them one after the other")
+	logs := addLogFlags(flag.CommandLine)
+	hookFlags := addHookFlags(flag.CommandLine)
+	eventFlags := addEventFlags(flag.CommandLine)
+	incrementalFlag := flag.Bool("incremental", false, "only encrypt files changed since their encrypted file was written or whose hash differs from the -manifest of the last run; outdated encrypted files are replaced")
+	manifestFlag := flag.String("manifest", "", "write the names, sizes and SHA-256 hashes of the encrypted files to this file, encrypted with the same key; check them with fileenc verify -manifest")
+	dryRunFlag := flag.Bool("dry-run", false, "only report which files would be processed, created or overwritten and the conflicts, without changing anything")
+	jsonFlag := flag.Bool("json", false, "report one JSON object per file on stdout instead of messages, for scripts")
+	progressFlag := flag.String("progress", progressAuto, "progress reporting on stderr: auto (bar on terminals), bar, json or none")
+	daemonFlag := flag.Bool("daemon", false, "let the running fileenc daemon encrypt or decrypt the files with its key and settings")
+	dialogFlag := flag.Bool("dialog", false, "ask for the key and report the result in windows instead of the terminal, used by fileenc install-shell")
+	auditFlag := flag.String("audit-log", os.Getenv(auditLogEnv), "append who encrypted or decrypted which file when to this hash-chained log, check it with fileenc audit verify")
+	attemptsFlag := flag.Int("attempts", defaultAttempts, "how often a passphrase typed for decryption is asked for when it is wrong, waiting a second longer after every failure")
+	legacyFlag := flag.Bool("legacy", false, "decrypt a headerless file created by older fileenc versions using the raw 16, 24 or 32 byte key")
+	args := parseArgs(flag.CommandLine, os.Args[1:])
+
+	// Diagnostics go to stderr, stdout only carries data and -json results
+	if err := logs.check(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	log := logs.logger(os.Stderr)
+	if *dialogFlag {
+		promptDialog = true
+		log = slog.New(dialogHandler{log.Handler()})
+	}
+	if *encryptFlag && *decryptFlag {
+		log.Error("-encrypt and -decrypt cannot be combined")
+		os.Exit(exitUsage)
+	}
+
+	// Without files or with - the data is read from stdin and written to stdout
+	inputs := append(sources, args...)
+	streaming := (len(inputs) == 0 && !term.IsTerminal(int(os.Stdin.Fd()))) || (len(inputs) == 1 && inputs[0] == "-")
+	if !streaming && slices.Contains(inputs, "-") {
+		log.Error("- cannot be combined with other files")
+		os.Exit(exitUsage)
+	}
+	// A URL as source or output streams a single file from or to object storage
+	remote := slices.ContainsFunc(inputs, isRemote) || isRemote(*outFlag)
+	if remote && (len(inputs) != 1 || streaming || *shredFlag || *inPlaceFlag || *jsonFlag || *dryRunFlag || *resumeFlag || *outDirFlag != "") {
+		log.Error("a URL needs a single source and cannot be used with -shred, -in-place, -json, -dry-run, -resume or -out-dir")
+		os.Exit(exitUsage)
+	}
+	if *checksumFlag != "" {
+		if !remote {
+			log.Error("-checksum needs a URL as source or output")
+			os.Exit(exitUsage)
+		}
+		if _, _, err := parseChecksum(*checksumFlag); err != nil {
+			log.Error("Invalid checksum", "error", err)
+			os.Exit(exitUsage)
+		}
+	}
+	if streaming && (*shredFlag || *inPlaceFlag || *jsonFlag || *dryRunFlag || *outFlag != "" || *outDirFlag != "") {
+		log.Error("-shred, -in-place, -json, -dry-run, -out and -out-dir cannot be used with stdin")
+		os.Exit(exitUsage)
+	}
+	if *resumeFlag && (*decryptFlag || streaming) {
+		log.Error("-resume only applies to encrypting files")
+		os.Exit(exitUsage)
+	}
+	if *daemonFlag && (streaming || remote || (*inPlaceFlag && !*renameFlag) || *resumeFlag || *legacyFlag) {
+		log.Error("-daemon works with files only, not with stdin, URLs, -in-place without -rename, -resume or -legacy")
+		os.Exit(exitUsage)
+	}
+	if splitSize > 0 && (*decryptFlag || streaming || remote || *inPlaceFlag || *resumeFlag || *daemonFlag) {
+		log.Error("-split-size only applies to encrypting files, not with stdin, URLs, -in-place, -resume or -daemon")
+		os.Exit(exitUsage)
+	}
+	if (*sharesFlag > 0 || *thresholdFlag > 0) && (*decryptFlag || streaming || remote || *daemonFlag || *sharesFlag < 2 || *thresholdFlag < 2 || *thresholdFlag > *sharesFlag) {
+		log.Error("-shares and -threshold need 2 <= threshold <= shares and apply to encrypting files, not stdin, URLs or -daemon")
+		os.Exit(exitUsage)
+	}
+	if *suffixFlag == "" {
+		log.Error("-suffix must not be empty")
+		os.Exit(exitUsage)
+	}
+	if (*outFlag != "" || *outDirFlag != "") && (*inPlaceFlag || (*outFlag != "" && *outDirFlag != "")) {
+		log.Error("-out, -out-dir and -in-place cannot be combined")
+		os.Exit(exitUsage)
+	}
+	if *encryptNamesFlag && (streaming || remote || *daemonFlag || *dryRunFlag || *outFlag != "" || *sharesFlag > 0 || (*inPlaceFlag && !*renameFlag)) {
+		log.Error("-encrypt-names cannot be used with stdin, URLs, -daemon, -dry-run, -out, -shares or -in-place without -rename")
+		os.Exit(exitUsage)
+	}
+	if *manifestFlag != "" && (*decryptFlag || streaming || remote || *daemonFlag || *sharesFlag > 0) {
+		log.Error("-manifest only applies to encrypting files, not with stdin, URLs, -daemon or -shares")
+		os.Exit(exitUsage)
+	}
+	if *incrementalFlag && (*decryptFlag || streaming || remote || *inPlaceFlag || *daemonFlag || *dryRunFlag || *sharesFlag > 0) {
+		log.Error("-incremental only applies to encrypting files, not with stdin, URLs, -in-place, -daemon, -dry-run or -shares")
+		os.Exit(exitUsage)
+	}
+	if (*includeHiddenFlag || len(exclude) > 0 || *followFlag) && !*recursiveFlag {
+		log.Error("-include-hidden, -exclude and -follow-symlinks need -recursive")
+		os.Exit(exitUsage)
+	}
+	var excludeRules ignoreRules
+	for _, p := range exclude {
+		r, ok, err := parseIgnoreRule(p, "")
+		if err != nil {
+			log.Error("Invalid -exclude", "error", err)
+			os.Exit(exitUsage)
+		}
+		if ok {
+			excludeRules = append(excludeRules, r)
+		}
+	}
+	if *followFlag && *preserveFlag {
+		log.Error("-follow-symlinks and -preserve-symlinks exclude each other")
+		os.Exit(exitUsage)
+	}
+	if *preserveFlag && (*decryptFlag || streaming || remote || *daemonFlag) {
+		log.Error("-preserve-symlinks only applies to encrypting files, not with stdin, URLs or -daemon")
+		os.Exit(exitUsage)
+	}
+	if (*dropCacheFlag || *fsyncFlag) && (streaming || remote || *daemonFlag) {
+		log.Error("-drop-cache and -fsync work with files only, not with stdin, URLs or -daemon")
+		os.Exit(exitUsage)
+	}
+	if bufSize != 0 && (bufSize < 4<<10 || bufSize > 64<<20) {
+		log.Error("-bufsize must be between 4K and 64M")
+		os.Exit(exitUsage)
+	}
+	if bwLimit > 0 && *daemonFlag {
+		log.Error("-bwlimit cannot be used with -daemon, the daemon processes the files")
+		os.Exit(exitUsage)
+	}
+	if hookFlags.enabled() && (streaming || remote || *daemonFlag || *dryRunFlag) {
+		log.Error("-pre-hook and -post-hook only apply to files, not with stdin, URLs, -daemon or -dry-run")
+		os.Exit(exitUsage)
+	}
+	if *verifySourceFlag && (*decryptFlag || streaming || remote || *daemonFlag || *sharesFlag > 0 || len(keys.recipients) > 0 || len(keys.kmsKeys) > 0 || (*inPlaceFlag && !*renameFlag)) {
+		log.Error("-verify-source only applies to encrypting files with a key, not with stdin, URLs, -daemon, -shares, -recipient, -kms-key-id or -in-place without -rename")
+		os.Exit(exitUsage)
+	}
+	if *overwriteFlag && *onConflictFlag != conflictFail && *onConflictFlag != conflictOverwrite {
+		log.Error("-overwrite is the same as -on-conflict overwrite and cannot be combined with other strategies")
+		os.Exit(exitUsage)
+	}
+	if *overwriteFlag {
+		*onConflictFlag = conflictOverwrite
+	}
+	conflicts, err := newConflicts(*onConflictFlag)
+	if err != nil {
+		log.Error("Invalid -on-conflict", "error", err)
+		os.Exit(exitUsage)
+	}
+	if *onConflictFlag != conflictFail && *onConflictFlag != conflictOverwrite && (streaming || remote || (*inPlaceFlag && !*renameFlag) || *incrementalFlag) {
+		log.Error("-on-conflict skip, rename and prompt only apply to files, not with stdin, URLs, -in-place without -rename or -incremental")
+		os.Exit(exitUsage)
+	}
+	if *deleteSourceFlag && (*decryptFlag || streaming || remote || *daemonFlag || *inPlaceFlag || *shredFlag) {
+		log.Error("-delete-source only applies to encrypting files, not with stdin, URLs, -daemon, -in-place or -shred")
+		os.Exit(exitUsage)
+	}
+	if *inPlaceFlag && !*renameFlag && *shredFlag {
+		log.Error("-shred needs -rename with -in-place, a file replaced under the same name cannot be shredded")
+		os.Exit(exitUsage)
+	}
+
+	// Collect the files from -source and the remaining arguments
+	var files []string
+	if !streaming && !remote {
+		var err error
+		// Files decrypted in place under the same name carry no suffix
+		suffix := ""
+		if *decryptFlag && !(*inPlaceFlag && !*renameFlag) {
+			suffix = *suffixFlag
+		}
+		if files, err = expandSources(inputs, suffix); err != nil {
+			log.Error("Invalid source", "error", err)
+			os.Exit(exitUsage)
+		}
+		if *recursiveFlag {
+			walk := walkOptions{
+				followLinks: *followFlag, preserveLinks: *preserveFlag,
+				includeHidden: *includeHiddenFlag, exclude: excludeRules, log: log,
+			}
+			if files, err = expandDirs(files, *decryptFlag, *suffixFlag, walk); err != nil {
+				log.Error("Invalid source", "error", err)
+				os.Exit(exitIO)
+			}
+		}
+		if len(files) == 0 {
+			log.Error("no source file present, use -source flag")
+			os.Exit(exitUsage)
+		}
+		if *outFlag != "" && len(files) > 1 {
+			log.Error("-out needs a single source file, use -out-dir for several")
+			os.Exit(exitUsage)
+		}
+	}
+
+	t := task{
+		decrypt: *decryptFlag, legacy: *legacyFlag, overwrite: *onConflictFlag == conflictOverwrite, conflicts: conflicts,
+		suffix: *suffixFlag, out: *outFlag, outDir: *outDirFlag,
+		inPlace: *inPlaceFlag, rename: *renameFlag, shred: *shredFlag, shredPasses: *shredPasses,
+		logs: logs, json: *jsonFlag, checksum: *checksumFlag, force: *forceFlag, verifySrc: *verifySourceFlag,
+		split: int64(splitSize), shares: *sharesFlag, threshold: *thresholdFlag, deleteSrc: *deleteSourceFlag,
+		dialog: *dialogFlag, incremental: *incrementalFlag, hooks: *hookFlags,
+	}
+	if *auditFlag != "" {
+		t.audit = &auditLog{path: *auditFlag}
+	}
+
+	// A dry run needs no key as nothing is encrypted or decrypted
+	if *dryRunFlag {
+		if conflicts := t.dryRun(files); len(conflicts) > 0 {
+			os.Exit(batchExitCode(conflicts))
+		}
+		return
+	}
+
+	events, err := eventFlags.open()
+	if err != nil {
+		log.Error("Error opening the event destinations", "error", err)
+		os.Exit(exitUsage)
+	}
+	t.events = events
+
+	// The daemon holds the key and the settings
+	if *daemonFlag {
+		t.daemon = defaultSocket()
+		os.Exit(t.runBatch(files, *jobs))
+	}
+
+	// Incremental runs replace the outdated encrypted files
+	opts := []fileenc.Option{
+		fileenc.WithOverwrite(conflicts.replaces() || *incrementalFlag),
+	}
+	if *encryptNamesFlag {
+		// The header would reveal the name, the key for the names is derived once
+		metadata.storeName = false
+		opts = append(opts, fileenc.WithKeyCache(fileenc.NewKeyCache()))
+	}
+	opts = append(opts, metadata.options()...)
+	if *forceFlag {
+		opts = append(opts, fileenc.WithForce())
+	}
+	if *resumeFlag {
+		opts = append(opts, fileenc.WithResume())
+	}
+	if *preserveFlag {
+		opts = append(opts, fileenc.WithPreserveSymlinks())
+	}
+	if *dropCacheFlag {
+		opts = append(opts, fileenc.WithDropCache())
+	}
+	// The source is only deleted once the output is on disk
+	if *fsyncFlag || *deleteSourceFlag {
+		opts = append(opts, fileenc.WithDurability())
+	}
+	if splitSize > 0 {
+		opts = append(opts, fileenc.WithSplit(int64(splitSize)))
+	}
+	if bwLimit > 0 {
+		opts = append(opts, fileenc.WithBandwidthLimit(int64(bwLimit)))
+	}
+	opts = append(opts, fileenc.WithThreads(*threads), fileenc.WithBufferSize(int(bufSize)))
+	if *sharesFlag > 0 {
+		opts = append(opts, fileenc.WithShares(*sharesFlag, *thresholdFlag))
+		keys.noKey = true
+	}
+	if *decryptFlag {
+		if *legacyFlag {
+			p, err := currentPolicy()
+			if err == nil {
+				err = p.checkLegacy()
+			}
+			if err != nil {
+				log.Error("Invalid settings", "error", err)
+				os.Exit(exitCode(err, exitUsage))
+			}
+			opts = append(opts, fileenc.WithLegacy())
+		}
+	} else {
+		cipherOpts, err := ciphers.options()
+		if err != nil {
+			log.Error("Invalid cipher settings", "error", err)
+			os.Exit(exitCode(err, exitUsage))
+		}
+		opts = append(opts, cipherOpts...)
+	}
+
+	// Get the recipients, identities or the key
+	key, keyOpts, err := keys.load(*decryptFlag)
+	if err != nil {
+		log.Error("Error loading the key", "error", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	// The key may be replaced when the passphrase is asked for again
+	defer func() { clear(key) }()
+	opts = append(opts, keyOpts...)
+
+	// Report the progress on stderr so it does not mix with the results
+	progress, err := newProgressPrinter(*progressFlag, logs.quiet)
+	if err != nil {
+		log.Error("Invalid progress mode", "error", err)
+		os.Exit(exitUsage)
+	}
+	if progress != nil {
+		opts = append(opts, fileenc.WithProgress(progress.update))
+	}
+
+	enc, err := fileenc.New(key, opts...)
+	if err == nil && *decryptFlag && keys.prompted {
+		// A mistyped passphrase is asked for again instead of failing every file
+		attempts := 1
+		if !streaming && !remote && *attemptsFlag > 1 {
+			if enc, key, attempts, err = retryPassphrase(key, opts, files, keys.name, *attemptsFlag); err != nil {
+				log.Error("Error reading the key", "error", err)
+				os.Exit(exitCode(err, exitBadKey))
+			}
+		}
+		if t.audit != nil {
+			t.audit.attempts = attempts
+		}
+	}
+	if err != nil {
+		log.Error("Invalid settings", "error", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	if *decryptFlag {
+		log.Debug("Decrypting", "legacy", *legacyFlag, "on-conflict", *onConflictFlag, "jobs", *jobs, "threads", *threads)
+	} else {
+		log.Debug("Encrypting", "format", ciphers.format, "cipher", ciphers.cipher, "kdf", ciphers.kdf,
+			"compress", ciphers.compress, "on-conflict", *onConflictFlag, "jobs", *jobs, "threads", *threads)
+	}
+
+	// Ctrl-C and SIGTERM stop a single stream, the partial output of a URL is discarded
+	ctx, stop := interruptContext()
+	defer stop()
+
+	// Stdout carries the data, so only errors are reported, on stderr
+	if streaming {
+		if err := runStream(ctx, enc, t); err != nil {
+			log.Error("Error processing stdin", "error", err)
+			clear(key)
+			os.Exit(exitCode(err, exitFailure))
+		}
+		return
+	}
+
+	if remote {
+		t.progress, t.abort = progress, ctx
+		if err := runRemote(enc, t, inputs[0]); err != nil {
+			log.Error("Error processing", "file", inputs[0], "error", err)
+			clear(key)
+			os.Exit(exitCode(err, exitFailure))
+		}
+		return
+	}
+
+	// runBatch handles the signals itself, finishing the files in progress
+	stop()
+
+	if *encryptNamesFlag {
+		if t.names, err = encryptedNames(enc, files, *decryptFlag, *suffixFlag); err != nil {
+			log.Error("Error processing the names", "error", err)
+			clear(key)
+			os.Exit(exitCode(err, exitFailure))
+		}
+	}
+
+	// Process every file and keep going on errors
+	t.enc, t.progress = enc, progress
+	if conflicts.prompts() {
+		if t.replace, err = fileenc.New(key, append(slices.Clip(opts), fileenc.WithOverwrite(true))...); err != nil {
+			log.Error("Invalid settings", "error", err)
+			clear(key)
+			os.Exit(exitCode(err, exitUsage))
+		}
+	}
+	if *manifestFlag != "" {
+		t.manifest = &manifest{}
+		if *incrementalFlag {
+			if t.previous, err = previousManifest(enc, *manifestFlag); err != nil {
+				log.Error("Error reading the manifest", "file", *manifestFlag, "error", err)
+				clear(key)
+				os.Exit(exitCode(err, exitFailure))
+			}
+		}
+	}
+	code := t.runBatch(files, *jobs)
+	if t.manifest != nil {
+		// The manifest lists the files encrypted successfully
+		if err := t.manifest.write(enc, *manifestFlag); err != nil {
+			log.Error("Error writing the manifest", "file", *manifestFlag, "error", err)
+			code = cmp.Or(code, exitCode(err, exitIO))
+		} else {
+			log.Info("Manifest written", "file", *manifestFlag, "files", len(t.manifest.Files))
+		}
+	}
+	if code != 0 {
+		clear(key)
+		os.Exit(code)
+	}
+}
+
+// runBatch processes the files with up to jobs workers and returns the exit code
+func (t task) runBatch(files []string, jobs int) int {
+	log := t.logger(os.Stderr)
+	if t.overwrite && !t.json {
+		log.Warn("Overwrite enabled")
+	}
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	// Stop starting new files on Ctrl-C, files in progress are finished. A
+	// second Ctrl-C aborts them, their partial outputs are removed.
+	ctx, stopStarting := context.WithCancel(context.Background())
+	defer stopStarting()
+	abort, abortRunning := context.WithCancel(context.Background())
+	defer abortRunning()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		stopStarting()
+		if !t.json {
+			log.Warn("Interrupted, finishing the files in progress, press Ctrl-C again to abort them")
+		}
+		<-signals
+		abortRunning()
+	}()
+	t.abort = abort
+
+	failed, skipped, unchanged, kept := t.runAll(ctx, files, jobs)
+	if t.progress != nil {
+		t.progress.clear()
+	}
+	if unchanged > 0 && !t.json {
+		log.Info("Unchanged files skipped", "unchanged", unchanged, "files", len(files))
+	}
+	if kept > 0 && !t.json {
+		log.Info("Files with existing outputs skipped", "skipped", kept, "files", len(files))
+	}
+	if skipped > 0 && !t.json {
+		log.Warn("Interrupted, files not processed", "skipped", skipped, "files", len(files))
+	}
+	if len(failed) > 0 && len(files) > 1 && !t.json {
+		log.Error("Files failed", "failed", len(failed), "files", len(files))
+	}
+	if t.dialog && skipped == 0 {
+		action := "encrypted"
+		if t.decrypt {
+			action = "decrypted"
+		}
+		dialogMessage(dialogSummary(action, len(files), failed), len(failed) > 0)
+	}
+	// An interrupted run is reported as such, whatever the files finished did
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+	if len(failed) > 0 {
+		return batchExitCode(failed)
+	}
+	return 0
+}
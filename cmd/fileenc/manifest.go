@@ -0,0 +1,187 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// manifest lists the files encrypted in a run, it is stored encrypted with
+// the key of the files, which also authenticates it
+type manifest struct {
+	Created time.Time       `json:"created"`
+	Files   []manifestEntry `json:"files"`
+
+	mu sync.Mutex
+}
+
+// manifestEntry describes an encrypted file and its plaintext
+type manifestEntry struct {
+	File     string    `json:"file"`
+	Output   string    `json:"output"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Modified time.Time `json:"modified"`
+}
+
+// hashFile returns the entry for the plaintext file at path, Output is left empty
+func hashFile(path string) (manifestEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return manifestEntry{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return manifestEntry{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	return manifestEntry{File: path, Size: size, SHA256: hex.EncodeToString(h.Sum(nil)), Modified: info.ModTime().UTC()}, nil
+}
+
+// add records an encrypted file, it is safe for concurrent use
+func (m *manifest) add(e manifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Files = append(m.Files, e)
+}
+
+// write encrypts the manifest sorted by file name with enc and replaces the file at path
+func (m *manifest) write(enc *fileenc.Encryptor, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	slices.SortFunc(m.Files, func(a, b manifestEntry) int { return cmp.Compare(a.File, b.File) })
+	m.Created = time.Now().UTC()
+	// Paths are stored with slashes, so the manifest can be verified on other systems
+	stored := &manifest{Created: m.Created, Files: slices.Clone(m.Files)}
+	for i := range stored.Files {
+		stored.Files[i].File = filepath.ToSlash(stored.Files[i].File)
+		stored.Files[i].Output = filepath.ToSlash(stored.Files[i].Output)
+	}
+	plain, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	if err := enc.Encrypt(&data, bytes.NewReader(plain)); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readManifest decrypts the manifest at path with enc
+func readManifest(enc *fileenc.Encryptor, path string) (*manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer file.Close()
+	var plain bytes.Buffer
+	if err := enc.Decrypt(&plain, file); err != nil {
+		return nil, fmt.Errorf("failed to decrypt manifest: %w", err)
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(plain.Bytes(), m); err != nil {
+		return nil, fmt.Errorf("malformed manifest: %w", err)
+	}
+	for i := range m.Files {
+		m.Files[i].File = filepath.FromSlash(m.Files[i].File)
+		m.Files[i].Output = filepath.FromSlash(m.Files[i].Output)
+	}
+	return m, nil
+}
+
+// previousManifest returns the entries of the manifest at path by file, none
+// if there is no manifest yet
+func previousManifest(enc *fileenc.Encryptor, path string) (map[string]manifestEntry, error) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	// Files encrypted for recipients cannot be read back, only the times count
+	m, err := readManifest(enc, path)
+	if errors.Is(err, fileenc.ErrNoIdentity) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]manifestEntry, len(m.Files))
+	for _, e := range m.Files {
+		entries[e.File] = e
+	}
+	return entries, nil
+}
+
+// verifyManifest checks that the files of the manifest below dir match their
+// sizes and hashes and returns the failures
+func verifyManifest(m *manifest, dir string, quiet bool) []error {
+	var failed []error
+	for _, e := range m.Files {
+		path := e.File
+		if dir != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		got, err := hashFile(path)
+		switch {
+		case err != nil:
+		case got.Size != e.Size:
+			err = fmt.Errorf("%w: size is %d bytes, the manifest lists %d", errChecksum, got.Size, e.Size)
+		case got.SHA256 != e.SHA256:
+			err = fmt.Errorf("%w: SHA-256 differs from the manifest", errChecksum)
+		}
+		if err != nil {
+			fmt.Printf("File %s FAILED: %v\n", path, err)
+			failed = append(failed, err)
+			continue
+		}
+		if !quiet {
+			fmt.Printf("File %s OK.\n", path)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,188 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds of the duration histogram in seconds
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// errorTypes names the exit codes in the error counter
+var errorTypes = map[int]string{
+	exitFailure:     "other",
+	exitUsage:       "usage",
+	exitBadKey:      "bad_key",
+	exitFileExists:  "file_exists",
+	exitIO:          "io",
+	exitCorrupt:     "corrupt",
+	exitWrongKey:    "wrong_key",
+	exitExpired:     "expired",
+	exitPolicy:      "policy",
+	exitInterrupted: "interrupted",
+}
+
+// metrics counts the operations of fileenc daemon and fileenc serve and
+// serves them in the Prometheus text format. A nil *metrics counts nothing.
+type metrics struct {
+	mu         sync.Mutex
+	start      time.Time
+	inProgress int
+	ops        map[string]*operationMetrics
+}
+
+// operationMetrics holds the counters of encrypt or decrypt
+type operationMetrics struct {
+	ok, failed    int64
+	errors        map[string]int64
+	read, written int64
+	buckets       []int64
+	count         int64
+	sum           float64
+}
+
+// newMetrics returns empty metrics
+func newMetrics() *metrics {
+	return &metrics{start: time.Now(), ops: map[string]*operationMetrics{}}
+}
+
+// begin counts an operation in progress until observe is called for it
+func (m *metrics) begin() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inProgress++
+}
+
+// observe counts the outcome err of an operation that took d, the bytes are
+// only counted for successful operations
+func (m *metrics) observe(operation string, bytesIn, bytesOut int64, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inProgress--
+	o := m.ops[operation]
+	if o == nil {
+		o = &operationMetrics{errors: map[string]int64{}, buckets: make([]int64, len(durationBuckets))}
+		m.ops[operation] = o
+	}
+	if err != nil && !errors.Is(err, errUnchanged) {
+		o.failed++
+		o.errors[errorType(err)]++
+	} else {
+		o.ok++
+		o.read += bytesIn
+		o.written += bytesOut
+	}
+	seconds := d.Seconds()
+	for i, le := range durationBuckets {
+		if seconds <= le {
+			o.buckets[i]++
+		}
+	}
+	o.count++
+	o.sum += seconds
+}
+
+// errorType names the kind of err for the error counter
+func errorType(err error) string {
+	if errors.Is(err, errOutputTooLarge) || errors.As(err, new(*http.MaxBytesError)) {
+		return "too_large"
+	}
+	if t, ok := errorTypes[exitCode(err, exitFailure)]; ok {
+		return t
+	}
+	return "other"
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// write writes the metrics in the Prometheus text format to w
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+	family := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	ops := make([]string, 0, len(m.ops))
+	for op := range m.ops {
+		ops = append(ops, op)
+	}
+	slices.Sort(ops)
+
+	family("fileenc_start_time_seconds", "gauge", "Start time of the process since the Unix epoch in seconds.")
+	fmt.Fprintf(&b, "fileenc_start_time_seconds %d\n", m.start.Unix())
+	family("fileenc_files_in_progress", "gauge", "Files or requests being encrypted or decrypted.")
+	fmt.Fprintf(&b, "fileenc_files_in_progress %d\n", m.inProgress)
+	family("fileenc_files_total", "counter", "Files or requests processed by operation and status.")
+	for _, op := range ops {
+		fmt.Fprintf(&b, "fileenc_files_total{operation=%q,status=\"ok\"} %d\n", op, m.ops[op].ok)
+		fmt.Fprintf(&b, "fileenc_files_total{operation=%q,status=\"error\"} %d\n", op, m.ops[op].failed)
+	}
+	family("fileenc_errors_total", "counter", "Failed files or requests by operation and error type.")
+	for _, op := range ops {
+		types := make([]string, 0, len(m.ops[op].errors))
+		for t := range m.ops[op].errors {
+			types = append(types, t)
+		}
+		slices.Sort(types)
+		for _, t := range types {
+			fmt.Fprintf(&b, "fileenc_errors_total{operation=%q,type=%q} %d\n", op, t, m.ops[op].errors[t])
+		}
+	}
+	family("fileenc_read_bytes_total", "counter", "Bytes read by successful operations.")
+	for _, op := range ops {
+		fmt.Fprintf(&b, "fileenc_read_bytes_total{operation=%q} %d\n", op, m.ops[op].read)
+	}
+	family("fileenc_written_bytes_total", "counter", "Bytes written by successful operations.")
+	for _, op := range ops {
+		fmt.Fprintf(&b, "fileenc_written_bytes_total{operation=%q} %d\n", op, m.ops[op].written)
+	}
+	family("fileenc_duration_seconds", "histogram", "Duration of the operations in seconds.")
+	for _, op := range ops {
+		o := m.ops[op]
+		for i, le := range durationBuckets {
+			fmt.Fprintf(&b, "fileenc_duration_seconds_bucket{operation=%q,le=%q} %d\n", op, strconv.FormatFloat(le, 'g', -1, 64), o.buckets[i])
+		}
+		fmt.Fprintf(&b, "fileenc_duration_seconds_bucket{operation=%q,le=\"+Inf\"} %d\n", op, o.count)
+		fmt.Fprintf(&b, "fileenc_duration_seconds_sum{operation=%q} %s\n", op, strconv.FormatFloat(o.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "fileenc_duration_seconds_count{operation=%q} %d\n", op, o.count)
+	}
+	io.WriteString(w, b.String())
+}
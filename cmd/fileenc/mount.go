@@ -0,0 +1,264 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// runMount implements "fileenc mount [flags] <dir> <mountpoint>", showing the
+// decrypted files of dir read-only at mountpoint until it is unmounted
+func runMount(args []string) {
+	flags := flag.NewFlagSet("mount", flag.ExitOnError)
+	keys := addKeyFlags(flags, false)
+	suffix := flags.String("suffix", encExt, "extension of the encrypted files, removed in the mounted view")
+	allowOther := flags.Bool("allow-other", false, "let other users access the mount, needs user_allow_other in /etc/fuse.conf")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: fileenc mount [flags] <dir> <mountpoint>")
+		fmt.Fprintln(flags.Output(), "Shows the encrypted files of dir decrypted and read-only at mountpoint, until unmounted or interrupted.")
+		fmt.Fprintln(flags.Output(), "Only files that support random access are shown: authenticated cipher, no compression.")
+		flags.PrintDefaults()
+	}
+	args = parseArgs(flags, args)
+	if len(args) != 2 || *suffix == "" {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	dir, err := filepath.Abs(args[0])
+	if err != nil || !isDir(dir) {
+		fmt.Fprintf(os.Stderr, "Error: %s is no directory\n", args[0])
+		os.Exit(exitUsage)
+	}
+
+	key, opts, err := keys.load(true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	defer clear(key)
+	// The key is derived once per salt, not on every open
+	cache := fileenc.NewKeyCache()
+	defer cache.Clear()
+	enc, err := fileenc.New(key, append(opts, fileenc.WithKeyCache(cache))...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid settings: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+
+	root := &mountNode{m: &mountFS{enc: enc, suffix: *suffix}, path: dir}
+	timeout := time.Second
+	server, err := fs.Mount(args[1], root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:     dir,
+			Name:       "fileenc",
+			AllowOther: *allowOther,
+			Options:    []string{"ro"},
+		},
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error mounting %s: %v\n", args[1], err)
+		clear(key)
+		os.Exit(exitIO)
+	}
+	fmt.Printf("Mounted %s at %s, press Ctrl-C or unmount to stop.\n", args[0], args[1])
+
+	// Unmount on Ctrl-C, Wait also returns when unmounted from outside
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		if err := server.Unmount(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error unmounting %s: %v\n", args[1], err)
+		}
+	}()
+	server.Wait()
+}
+
+// mountFS holds the settings shared by all nodes of a mount
+type mountFS struct {
+	enc    *fileenc.Encryptor
+	suffix string
+}
+
+// mountNode is a directory or an encrypted file of the mounted tree
+type mountNode struct {
+	fs.Inode
+	m    *mountFS
+	path string
+	// info describes an encrypted file, it is not set for directories
+	info *fileenc.Info
+}
+
+var (
+	_ fs.NodeLookuper  = (*mountNode)(nil)
+	_ fs.NodeReaddirer = (*mountNode)(nil)
+	_ fs.NodeGetattrer = (*mountNode)(nil)
+	_ fs.NodeOpener    = (*mountNode)(nil)
+)
+
+// seekable describes the encrypted file at path if it can be shown, nil otherwise
+func (m *mountFS) seekable(path string) *fileenc.Info {
+	info, err := fileenc.InspectFile(path)
+	if err != nil || info.Format != fileenc.FormatFileenc || info.Cipher == fileenc.CipherAESCFB ||
+		info.Compression != fileenc.CompressionNone || info.Size < 0 {
+		return nil
+	}
+	return &info
+}
+
+// Readdir lists the subdirectories and the files that can be shown
+func (n *mountNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	entries, err := os.ReadDir(n.path)
+	if err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	var list []fuse.DirEntry
+	for _, e := range entries {
+		switch {
+		case e.IsDir():
+			list = append(list, fuse.DirEntry{Name: e.Name(), Mode: fuse.S_IFDIR})
+		case e.Type().IsRegular() && strings.HasSuffix(e.Name(), n.m.suffix) && len(e.Name()) > len(n.m.suffix):
+			if n.m.seekable(filepath.Join(n.path, e.Name())) != nil {
+				list = append(list, fuse.DirEntry{Name: strings.TrimSuffix(e.Name(), n.m.suffix), Mode: fuse.S_IFREG})
+			}
+		}
+	}
+	return fs.NewListDirStream(list), 0
+}
+
+// Lookup finds the directory name or the encrypted file name with suffix
+func (n *mountNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	child := &mountNode{m: n.m, path: filepath.Join(n.path, name)}
+	mode := uint32(fuse.S_IFDIR)
+	if !isDir(child.path) {
+		child.path += n.m.suffix
+		if child.info = n.m.seekable(child.path); child.info == nil {
+			return nil, syscall.ENOENT
+		}
+		mode = fuse.S_IFREG
+	}
+	if errno := child.attr(&out.Attr); errno != 0 {
+		return nil, errno
+	}
+	return n.NewInode(ctx, child, fs.StableAttr{Mode: mode}), 0
+}
+
+// Getattr reports the plaintext size and the stored metadata, without write permissions
+func (n *mountNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	if n.info != nil {
+		// The file may have been replaced since the lookup
+		if n.info = n.m.seekable(n.path); n.info == nil {
+			return syscall.ENOENT
+		}
+	}
+	return n.attr(&out.Attr)
+}
+
+// attr fills out from the encrypted file or the directory
+func (n *mountNode) attr(out *fuse.Attr) syscall.Errno {
+	stat, err := os.Stat(n.path)
+	if err != nil {
+		return fs.ToErrno(err)
+	}
+	mtime, perm := stat.ModTime(), stat.Mode().Perm()
+	if n.info == nil {
+		out.Mode = fuse.S_IFDIR | uint32(perm&0555)
+		out.Nlink = 2
+		out.SetTimes(nil, &mtime, nil)
+		return 0
+	}
+	if md := n.info.Metadata; md.Mode != 0 {
+		perm = md.Mode.Perm()
+	}
+	if md := n.info.Metadata; !md.ModTime.IsZero() {
+		mtime = md.ModTime
+	}
+	out.Mode = fuse.S_IFREG | uint32(perm&0444)
+	out.Nlink = 1
+	out.Size = uint64(n.info.Size)
+	out.Blocks = (out.Size + 511) / 512
+	out.SetTimes(nil, &mtime, nil)
+	return 0
+}
+
+// Open decrypts the file on access, writing is refused
+func (n *mountNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if n.info == nil {
+		return nil, 0, syscall.EISDIR
+	}
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	file, err := os.Open(n.path)
+	if err != nil {
+		return nil, 0, fs.ToErrno(err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fs.ToErrno(err)
+	}
+	ra, err := n.m.enc.NewReaderAt(file, stat.Size())
+	if err != nil {
+		file.Close()
+		fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", n.path, err)
+		return nil, 0, syscall.EACCES
+	}
+	return &mountHandle{file: file, ra: ra}, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+// mountHandle is an open encrypted file
+type mountHandle struct {
+	file *os.File
+	ra   *fileenc.ReaderAt
+}
+
+var (
+	_ fs.FileReader   = (*mountHandle)(nil)
+	_ fs.FileReleaser = (*mountHandle)(nil)
+)
+
+// Read decrypts the requested range, modified chunks fail with EIO
+func (h *mountHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := h.ra.ReadAt(dest, off)
+	if err != nil && n == 0 && off < h.ra.Size() {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", h.file.Name(), err)
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+// Release closes the encrypted file
+func (h *mountHandle) Release(ctx context.Context) syscall.Errno {
+	return fs.ToErrno(h.file.Close())
+}
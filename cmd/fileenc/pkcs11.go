@@ -0,0 +1,423 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+const (
+	// pkcs11Prefix starts the text form of a PKCS#11 identity, pkcs11PublicPrefix its recipient
+	pkcs11Prefix       = "FILEENC-PKCS11-"
+	pkcs11PublicPrefix = "FILEENC-PKCS11-PUBLIC-"
+	// pkcs11StanzaType is the stanza type of file keys wrapped for a PKCS#11 RSA key
+	pkcs11StanzaType = "pkcs11-rsa"
+	// pkcs11TagSize is the length of the public key hash identifying the key in stanzas
+	pkcs11TagSize = 8
+	// pkcs11ModuleEnv names the PKCS#11 module, pkcs11SlotEnv selects a slot by
+	// index instead of the token label and pkcs11PINEnv holds the user PIN
+	pkcs11ModuleEnv = "FILEENC_PKCS11_MODULE"
+	pkcs11SlotEnv   = "FILEENC_PKCS11_SLOT"
+	pkcs11PINEnv    = "FILEENC_PKCS11_PIN"
+	// pkcs11ToolPINEnv passes the PIN to pkcs11-tool, only in its environment
+	// as the command line is readable by every user
+	pkcs11ToolPINEnv = "FILEENC_PKCS11_TOOL_PIN"
+)
+
+// pkcs11Recipient wraps the file key with the RSA public key of a key pair
+// stored in an HSM or smartcard, encryption does not need the device
+type pkcs11Recipient struct {
+	der []byte
+	pub *rsa.PublicKey
+}
+
+// parsePKCS11Recipient parses the text form of a PKCS#11 recipient
+func parsePKCS11Recipient(s string) (*pkcs11Recipient, error) {
+	data, ok := strings.CutPrefix(s, pkcs11PublicPrefix)
+	if !ok {
+		return nil, errors.New("no PKCS#11 recipient")
+	}
+	der, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("malformed PKCS#11 recipient: %w", err)
+	}
+	return newPKCS11Recipient(der)
+}
+
+// newPKCS11Recipient returns the recipient of the DER encoded RSA public key
+func newPKCS11Recipient(der []byte) (*pkcs11Recipient, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("malformed PKCS#11 public key: %w", err)
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("only RSA keys are supported for PKCS#11")
+	}
+	return &pkcs11Recipient{der: der, pub: pub}, nil
+}
+
+// tag returns the hash of the public key stored in front of the wrapped key
+func (r *pkcs11Recipient) tag() []byte {
+	sum := sha256.Sum256(r.der)
+	return sum[:pkcs11TagSize]
+}
+
+// Wrap encrypts the file key with RSA-OAEP-SHA256
+func (r *pkcs11Recipient) Wrap(fileKey []byte) (fileenc.Stanza, error) {
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, r.pub, fileKey, nil)
+	if err != nil {
+		return fileenc.Stanza{}, err
+	}
+	return fileenc.Stanza{Type: pkcs11StanzaType, Body: append(r.tag(), wrapped...)}, nil
+}
+
+// String returns the text form given to -recipient
+func (r *pkcs11Recipient) String() string {
+	return pkcs11PublicPrefix + base64.RawURLEncoding.EncodeToString(r.der)
+}
+
+// pkcs11Identity is an RSA key pair in an HSM or smartcard, the private key
+// never leaves the device. The identity file holds the token label, the key
+// ID and the public key.
+type pkcs11Identity struct {
+	token string
+	id    []byte
+	*pkcs11Recipient
+}
+
+// Unwrap has the device decrypt the stanza wrapped for its key
+func (p *pkcs11Identity) Unwrap(stanzas []fileenc.Stanza) ([]byte, error) {
+	tag := p.tag()
+	for _, st := range stanzas {
+		if st.Type != pkcs11StanzaType || len(st.Body) <= pkcs11TagSize || !bytes.Equal(st.Body[:pkcs11TagSize], tag) {
+			continue
+		}
+		pin, err := pkcs11PIN()
+		if err != nil {
+			return nil, err
+		}
+		args := append(p.slotArgs(), "--login", "--pin", "env:"+pkcs11ToolPINEnv, "--decrypt", "--id", hex.EncodeToString(p.id),
+			"--mechanism", "RSA-PKCS-OAEP", "--hash-algorithm", "SHA256", "--mgf", "MGF1-SHA256")
+		key, err := pkcs11Tool(args, st.Body[pkcs11TagSize:], pkcs11ToolPINEnv+"="+string(pin))
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	return nil, fileenc.ErrNoIdentity
+}
+
+// slotArgs selects the slot of FILEENC_PKCS11_SLOT or the token by its label
+func (p *pkcs11Identity) slotArgs() []string {
+	if slot := os.Getenv(pkcs11SlotEnv); slot != "" {
+		return []string{"--slot-index", slot}
+	}
+	return []string{"--token-label", p.token}
+}
+
+// String returns the text form stored in identity files
+func (p *pkcs11Identity) String() string {
+	raw := append([]byte{byte(len(p.token))}, p.token...)
+	raw = append(raw, byte(len(p.id)))
+	raw = append(raw, p.id...)
+	return pkcs11Prefix + base64.RawURLEncoding.EncodeToString(append(raw, p.der...))
+}
+
+// parsePKCS11Identities parses the PKCS#11 identities in data read from name
+func parsePKCS11Identities(data []byte, name string) ([]fileenc.Identity, error) {
+	var ids []fileenc.Identity
+	for n, line := range lines(data) {
+		raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(line, pkcs11Prefix))
+		if !strings.HasPrefix(line, pkcs11Prefix) || err != nil || len(raw) < 1 || len(raw) < 2+int(raw[0]) ||
+			len(raw) < 2+int(raw[0])+int(raw[1+int(raw[0])]) {
+			return nil, fmt.Errorf("%s: line %d is no PKCS#11 identity", name, n)
+		}
+		token, rest := string(raw[1:1+int(raw[0])]), raw[1+int(raw[0]):]
+		id, der := rest[1:1+int(rest[0])], rest[1+int(rest[0]):]
+		r, err := newPKCS11Recipient(der)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", name, n, err)
+		}
+		ids = append(ids, &pkcs11Identity{token: token, id: id, pkcs11Recipient: r})
+	}
+	return ids, nil
+}
+
+// pkcs11PIN returns the user PIN from FILEENC_PKCS11_PIN or asks for it once per run
+var pkcs11PIN = sync.OnceValues(func() ([]byte, error) {
+	if pin, ok := os.LookupEnv(pkcs11PINEnv); ok {
+		os.Unsetenv(pkcs11PINEnv)
+		return lockKey([]byte(pin)), nil
+	}
+	pin, err := readPassword("HSM PIN", false)
+	if err != nil {
+		return nil, err
+	}
+	return lockKey(pin), nil
+})
+
+// pkcs11Tool runs pkcs11-tool of OpenSC with the module of FILEENC_PKCS11_MODULE,
+// passing input as input file and returning what it writes to the output
+// file. The output goes through a pipe so decrypted keys never touch the disk.
+// env is added to the environment of the command.
+func pkcs11Tool(args []string, input []byte, env ...string) ([]byte, error) {
+	path, err := exec.LookPath("pkcs11-tool")
+	if err != nil {
+		return nil, errors.New("pkcs11-tool not found, install OpenSC for PKCS#11 support")
+	}
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("PKCS#11 keys are not supported on Windows")
+	}
+	if module := os.Getenv(pkcs11ModuleEnv); module != "" {
+		args = append([]string{"--module", module}, args...)
+	}
+	if input != nil {
+		dir, err := os.MkdirTemp("", "fileenc-pkcs11-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		in := filepath.Join(dir, "input")
+		if err := os.WriteFile(in, input, 0600); err != nil {
+			return nil, err
+		}
+		args = append(args, "--input-file", in)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	cmd := exec.Command(path, append(args, "--output-file", "/dev/fd/3")...)
+	cmd.ExtraFiles = []*os.File{w}
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return nil, err
+	}
+	w.Close()
+	out, readErr := io.ReadAll(r)
+	if err := cmd.Wait(); err != nil {
+		clear(out)
+		return nil, fmt.Errorf("pkcs11-tool failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, readErr
+}
+
+// pkcs11Run runs pkcs11-tool for its text output
+func pkcs11Run(args ...string) (string, error) {
+	path, err := exec.LookPath("pkcs11-tool")
+	if err != nil {
+		return "", errors.New("pkcs11-tool not found, install OpenSC for PKCS#11 support")
+	}
+	if module := os.Getenv(pkcs11ModuleEnv); module != "" {
+		args = append([]string{"--module", module}, args...)
+	}
+	cmd := exec.Command(path, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("pkcs11-tool failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// pkcs11Key is a key pair listed by pkcs11-tool
+type pkcs11Key struct {
+	slot  int
+	token string
+	id    string
+	label string
+	kind  string
+}
+
+// listPKCS11Keys returns the public keys on the tokens of all slots, or of
+// the slot with the given index if it is not negative
+func listPKCS11Keys(slot int) ([]pkcs11Key, error) {
+	out, err := pkcs11Run("--list-token-slots")
+	if err != nil {
+		return nil, err
+	}
+	// Slots are listed as "Slot 0 (0x1): name" followed by indented properties
+	tokens := map[int]string{}
+	var order []int
+	index := -1
+	for line := range strings.Lines(out) {
+		if strings.HasPrefix(line, "Slot ") {
+			index++
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(k) == "token label" && index >= 0 {
+			tokens[index] = strings.TrimSpace(v)
+			order = append(order, index)
+		}
+	}
+
+	var keys []pkcs11Key
+	for _, i := range order {
+		if slot >= 0 && i != slot {
+			continue
+		}
+		out, err := pkcs11Run("--slot-index", fmt.Sprint(i), "--list-objects", "--type", "pubkey")
+		if err != nil {
+			return nil, err
+		}
+		// Objects start with "Public Key Object; RSA 2048 bits" followed by label and ID
+		var key *pkcs11Key
+		sc := bufio.NewScanner(strings.NewReader(out))
+		for sc.Scan() {
+			line := sc.Text()
+			if kind, ok := strings.CutPrefix(line, "Public Key Object; "); ok {
+				keys = append(keys, pkcs11Key{slot: i, token: tokens[i], kind: kind})
+				key = &keys[len(keys)-1]
+				continue
+			}
+			k, v, ok := strings.Cut(line, ":")
+			if !ok || key == nil {
+				continue
+			}
+			switch strings.TrimSpace(k) {
+			case "label":
+				key.label = strings.TrimSpace(v)
+			case "ID":
+				key.id = strings.TrimSpace(v)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// runHSM implements "fileenc hsm list [-slot <index>]" and "fileenc hsm
+// identity -id <hex> [-slot <index>] [-o <file>]"
+func runHSM(args []string) {
+	fs := flag.NewFlagSet("hsm", flag.ExitOnError)
+	slot := fs.Int("slot", -1, "only use the token in the slot with this index, as listed by fileenc hsm list")
+	id := fs.String("id", "", "hex ID of the RSA key pair for identity, as listed by fileenc hsm list")
+	output := fs.String("o", "", "write the identity to this file instead of stdout, it must not exist")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc hsm list [-slot <index>]")
+		fmt.Fprintln(fs.Output(), "       fileenc hsm identity -id <hex> [-slot <index>] [-o <file>]")
+		fmt.Fprintln(fs.Output(), "Lists the key pairs on PKCS#11 tokens and writes identities for them, using pkcs11-tool of OpenSC.")
+		fmt.Fprintln(fs.Output(), pkcs11ModuleEnv+" names the PKCS#11 module, e.g. /usr/lib/softhsm/libsofthsm2.so.")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || (args[0] != "list" && args[0] != "identity") {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	cmd := args[0]
+	parseArgs(fs, args[1:])
+
+	keys, err := listPKCS11Keys(*slot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	if cmd == "list" {
+		if len(keys) == 0 {
+			fmt.Println("No keys found.")
+			return
+		}
+		fmt.Printf("%-5s %-20s %-10s %-20s %s\n", "SLOT", "TOKEN", "ID", "LABEL", "TYPE")
+		for _, k := range keys {
+			fmt.Printf("%-5d %-20s %-10s %-20s %s\n", k.slot, k.token, k.id, k.label, k.kind)
+		}
+		return
+	}
+
+	if *id == "" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	var found []pkcs11Key
+	for _, k := range keys {
+		if strings.EqualFold(k.id, *id) {
+			found = append(found, k)
+		}
+	}
+	if len(found) != 1 {
+		fmt.Fprintf(os.Stderr, "Error: %d keys with ID %s found, select the token with -slot\n", len(found), *id)
+		os.Exit(exitFailure)
+	}
+	identity, err := newPKCS11Identity(found[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating identity file: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		defer file.Close()
+		out = file
+	}
+	pub := identity.pkcs11Recipient.String()
+	fmt.Fprintf(out, "# created: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(out, "# token: %s, key: %s %s\n", found[0].token, found[0].id, found[0].label)
+	fmt.Fprintf(out, "# public key: %s\n", pub)
+	fmt.Fprintf(out, "%s\n", identity)
+	if *output != "" {
+		fmt.Printf("Public key: %s\n", pub)
+	}
+}
+
+// newPKCS11Identity reads the public key of the listed key pair
+func newPKCS11Identity(k pkcs11Key) (*pkcs11Identity, error) {
+	id, err := hex.DecodeString(k.id)
+	if err != nil || len(id) > 255 || len(k.token) > 255 {
+		return nil, fmt.Errorf("unusable key ID %q or token label %q", k.id, k.token)
+	}
+	der, err := pkcs11Tool([]string{"--slot-index", fmt.Sprint(k.slot), "--read-object", "--type", "pubkey", "--id", k.id}, nil)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newPKCS11Recipient(der)
+	if err != nil {
+		return nil, err
+	}
+	return &pkcs11Identity{token: k.token, id: id, pkcs11Recipient: r}, nil
+}
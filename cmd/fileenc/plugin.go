@@ -0,0 +1,108 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/plugin"
+)
+
+// pluginUI lets age plugins such as age-plugin-yubikey ask for a PIN or a
+// touch on the terminal, bypassing stdin and stdout which may carry data
+var pluginUI = plugin.NewTerminalUI(
+	func(format string, v ...any) { fmt.Fprintf(os.Stderr, format+"\n", v...) },
+	func(format string, v ...any) { fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", v...) },
+)
+
+// parseAgeRecipient parses an age X25519 public key or the recipient of an age
+// plugin, e.g. age1yubikey1... for a YubiKey PIV slot handled by age-plugin-yubikey
+func parseAgeRecipient(s string) (age.Recipient, error) {
+	if r, err := age.ParseX25519Recipient(s); err == nil {
+		return r, nil
+	}
+	if name, _, err := plugin.ParseRecipient(s); err == nil && name != "" {
+		return plugin.NewRecipient(s, pluginUI)
+	}
+	return nil, errors.New("not an age public key")
+}
+
+// parseAgeRecipients parses the age recipients in data, one per line. Empty
+// lines and lines starting with # are ignored.
+func parseAgeRecipients(data []byte) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for n, line := range lines(data) {
+		r, err := parseAgeRecipient(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		recipients = append(recipients, r)
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients found")
+	}
+	return recipients, nil
+}
+
+// parseAgeIdentities parses the age identities in data, X25519 secret keys or
+// identities of age plugins such as AGE-PLUGIN-YUBIKEY-..., one per line
+func parseAgeIdentities(data []byte) ([]age.Identity, error) {
+	var ids []age.Identity
+	for n, line := range lines(data) {
+		if strings.HasPrefix(line, "AGE-PLUGIN-") {
+			id, err := plugin.NewIdentity(line, pluginUI)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			ids = append(ids, id)
+			continue
+		}
+		id, err := age.ParseX25519Identity(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: not an age identity", n)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no identities found")
+	}
+	return ids, nil
+}
+
+// lines yields the line numbers and the lines of data, skipping empty lines
+// and lines starting with #
+func lines(data []byte) iter.Seq2[int, string] {
+	return func(yield func(int, string) bool) {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if !yield(n, line) {
+				return
+			}
+		}
+	}
+}
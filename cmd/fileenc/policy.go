@@ -0,0 +1,288 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// policyEnv overrides the location of the policy file
+const policyEnv = "FILEENC_POLICY"
+
+// errPolicy is wrapped by the errors reporting policy violations
+var errPolicy = errors.New("policy violation")
+
+// policy holds the minimum security settings an administrator requires for
+// encryption, read from the policy file. Settings not in the file are not
+// restricted.
+type policy struct {
+	path string
+	// minKeyLength is the minimum length of passphrases in characters and raw keys in bytes
+	minKeyLength int
+	// minEntropy is the minimum estimated strength of passphrases in bits
+	minEntropy float64
+	// formats, ciphers and kdfs list the allowed choices, nil allows all
+	formats []string
+	ciphers []string
+	kdfs    []string
+	// requireAEAD forbids unauthenticated encryption and -legacy
+	requireAEAD bool
+	// minCost holds the minimum time and memory cost by KDF name
+	minCost map[string]fileenc.KDFParams
+	// forbidKeyFlag rejects -key, whose value shows in the process list
+	forbidKeyFlag bool
+}
+
+// policyPath returns the location of the policy file, /etc/fileenc/policy or
+// %ProgramData%\fileenc\policy unless FILEENC_POLICY is set
+func policyPath() string {
+	if path := os.Getenv(policyEnv); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "fileenc", "policy")
+	}
+	return "/etc/fileenc/policy"
+}
+
+// loadPolicy reads the policy file once, nil means there is none
+var loadPolicy = sync.OnceValues(func() (*policy, error) {
+	return readPolicy(policyPath())
+})
+
+// readPolicy reads the policy file at path, nil means there is none. A
+// damaged policy file is an error, so typos cannot weaken it.
+func readPolicy(path string) (*policy, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	lines, err := readDefaults(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the policy: %w", err)
+	}
+	p := &policy{path: path, minCost: map[string]fileenc.KDFParams{}}
+	for _, l := range lines {
+		if err := p.set(l[0], l[1]); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, l[0], err)
+		}
+	}
+	return p, nil
+}
+
+// set applies the setting name=value of the policy file
+func (p *policy) set(name, value string) error {
+	var err error
+	var n uint64
+	switch name {
+	case "min-key-length":
+		n, err = strconv.ParseUint(value, 10, 16)
+		p.minKeyLength = int(n)
+	case "min-entropy":
+		p.minEntropy, err = strconv.ParseFloat(value, 64)
+		if err == nil && !(p.minEntropy >= 0) {
+			err = errors.New("must be a number of bits")
+		}
+	case "allowed-formats":
+		p.formats, err = splitList(value)
+	case "allowed-ciphers":
+		p.ciphers, err = splitList(value)
+	case "allowed-kdfs":
+		p.kdfs, err = splitList(value)
+	case "require-aead":
+		p.requireAEAD, err = strconv.ParseBool(value)
+	case "argon2id-min-time", "argon2id-min-memory", "scrypt-min-log-n", "pbkdf2-min-iterations":
+		n, err = strconv.ParseUint(value, 10, 32)
+		kdf, cost, _ := strings.Cut(name, "-min-")
+		floor := p.minCost[kdf]
+		if cost == "memory" {
+			floor.Memory = uint32(n)
+		} else {
+			floor.Time = uint32(n)
+		}
+		p.minCost[kdf] = floor
+	case "forbid-key-flag":
+		p.forbidKeyFlag, err = strconv.ParseBool(value)
+	default:
+		return errors.New("unknown setting")
+	}
+	return err
+}
+
+// splitList splits a comma separated list. An empty list is an error, as it
+// would allow everything.
+func splitList(s string) ([]string, error) {
+	var list []string
+	for v := range strings.SplitSeq(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	if list == nil {
+		return nil, errors.New("empty list")
+	}
+	return list, nil
+}
+
+// violation returns the error for the broken rules
+func (p *policy) violation(rules []string) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s (see %s)", errPolicy, strings.Join(rules, "; "), p.path)
+}
+
+// checkEncryption checks the format, cipher and key derivation used for
+// encryption. The cipher and key derivation of age and OpenPGP files are
+// fixed by their formats.
+func (p *policy) checkEncryption(format, cipher string, kdf fileenc.KDFParams) error {
+	if p == nil {
+		return nil
+	}
+	var rules []string
+	if p.formats != nil && !slices.Contains(p.formats, format) {
+		rules = append(rules, fmt.Sprintf("format %s is not allowed, use %s", format, strings.Join(p.formats, ", ")))
+	}
+	if format != fileenc.FormatFileenc {
+		return p.violation(rules)
+	}
+	if p.ciphers != nil && !slices.Contains(p.ciphers, cipher) {
+		rules = append(rules, fmt.Sprintf("cipher %s is not allowed, use %s", cipher, strings.Join(p.ciphers, ", ")))
+	}
+	if p.requireAEAD && cipher == fileenc.CipherAESCFB {
+		rules = append(rules, fmt.Sprintf("cipher %s is not authenticated", cipher))
+	}
+	if p.kdfs != nil && !slices.Contains(p.kdfs, kdf.Name) {
+		rules = append(rules, fmt.Sprintf("kdf %s is not allowed, use %s", kdf.Name, strings.Join(p.kdfs, ", ")))
+	}
+	floor := p.minCost[kdf.Name]
+	if kdf.Time < floor.Time {
+		rules = append(rules, fmt.Sprintf("%s time cost %d is below %d, raise -kdf-time", kdf.Name, kdf.Time, floor.Time))
+	}
+	if kdf.Memory < floor.Memory {
+		rules = append(rules, fmt.Sprintf("%s memory cost %d KiB is below %d KiB, raise -kdf-memory", kdf.Name, kdf.Memory, floor.Memory))
+	}
+	return p.violation(rules)
+}
+
+// checkKey checks the length and strength of a passphrase or raw key used for encryption
+func (p *policy) checkKey(key []byte) error {
+	if p == nil || key == nil {
+		return nil
+	}
+	var rules []string
+	if n := utf8.RuneCount(key); n < p.minKeyLength {
+		rules = append(rules, fmt.Sprintf("the key has %d characters, at least %d are required", n, p.minKeyLength))
+	}
+	if bits := passphraseEntropy(key); bits < p.minEntropy {
+		rules = append(rules, fmt.Sprintf("the key is %s, about %.0f bits estimated, at least %.0f are required", strengthLabel(bits), bits, p.minEntropy))
+	}
+	return p.violation(rules)
+}
+
+// checkKeyFlag checks whether -key may be used
+func (p *policy) checkKeyFlag() error {
+	if p == nil || !p.forbidKeyFlag {
+		return nil
+	}
+	return p.violation([]string{"-key is forbidden as it shows the key in the process list, use -keyfile, " + keyEnv + " or the prompt"})
+}
+
+// checkLegacy checks whether headerless files may be decrypted with -legacy
+func (p *policy) checkLegacy() error {
+	if p == nil || !p.requireAEAD {
+		return nil
+	}
+	return p.violation([]string{"-legacy decrypts without authentication"})
+}
+
+// currentPolicy returns the policy, reporting a damaged policy file as violation
+func currentPolicy() (*policy, error) {
+	p, err := loadPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errPolicy, err)
+	}
+	return p, nil
+}
+
+// checkKeyPolicy checks the length of the key for encryption against the policy
+func checkKeyPolicy(key []byte) error {
+	p, err := currentPolicy()
+	if err != nil {
+		return err
+	}
+	return p.checkKey(key)
+}
+
+// runPolicy implements "fileenc policy", showing the policy and checking the
+// encryption settings of the defaults file against it
+func runPolicy(args []string) {
+	fs := flag.NewFlagSet("policy", flag.ExitOnError)
+	ciphers := addCipherFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc policy [cipher flags]")
+		fmt.Fprintln(fs.Output(), "Shows the policy of "+policyPath()+" and checks the encryption settings, from the defaults file and the flags, against it.")
+		fs.PrintDefaults()
+	}
+	if len(parseArgs(fs, args)) != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	p, err := currentPolicy()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitPolicy)
+	}
+	if p == nil {
+		fmt.Printf("No policy, %s does not exist.\n", policyPath())
+		return
+	}
+	allowed := func(list []string) string {
+		if list == nil {
+			return "any"
+		}
+		return strings.Join(list, ", ")
+	}
+	fmt.Printf("Policy %s:\n", p.path)
+	fmt.Printf("  min-key-length        %d\n", p.minKeyLength)
+	fmt.Printf("  min-entropy           %.0f bits\n", p.minEntropy)
+	fmt.Printf("  allowed-formats       %s\n", allowed(p.formats))
+	fmt.Printf("  allowed-ciphers       %s\n", allowed(p.ciphers))
+	fmt.Printf("  allowed-kdfs          %s\n", allowed(p.kdfs))
+	fmt.Printf("  require-aead          %t\n", p.requireAEAD)
+	fmt.Printf("  argon2id-min-time     %d\n", p.minCost[fileenc.KDFArgon2id].Time)
+	fmt.Printf("  argon2id-min-memory   %d KiB\n", p.minCost[fileenc.KDFArgon2id].Memory)
+	fmt.Printf("  scrypt-min-log-n      %d\n", p.minCost[fileenc.KDFScrypt].Time)
+	fmt.Printf("  pbkdf2-min-iterations %d\n", p.minCost[fileenc.KDFPBKDF2].Time)
+	fmt.Printf("  forbid-key-flag       %t\n", p.forbidKeyFlag)
+	if _, err := ciphers.options(); err != nil {
+		fmt.Fprintf(os.Stderr, "The encryption settings violate the policy: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	fmt.Println("The encryption settings comply with the policy.")
+}
@@ -0,0 +1,183 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// writePolicy writes a policy file with the given lines and returns its path
+func writePolicy(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testPolicy reads a policy file with the given lines
+func testPolicy(t *testing.T, lines ...string) *policy {
+	t.Helper()
+	p, err := readPolicy(writePolicy(t, lines...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// usePolicy makes the policy file with the given lines the current policy
+// for the test
+func usePolicy(t *testing.T, lines ...string) {
+	t.Helper()
+	path := writePolicy(t, lines...)
+	saved := loadPolicy
+	t.Cleanup(func() { loadPolicy = saved })
+	loadPolicy = func() (*policy, error) { return readPolicy(path) }
+}
+
+// TestPolicyEncryption rejects formats, ciphers and key derivations the
+// policy does not allow and key derivation costs below its minimum
+func TestPolicyEncryption(t *testing.T) {
+	p := testPolicy(t,
+		"# only authenticated ciphers and expensive key derivation",
+		"allowed-formats = fileenc, age",
+		"allowed-ciphers = "+fileenc.CipherAESGCM+", "+fileenc.CipherXChaCha20Poly1305+", "+fileenc.CipherAESCFB,
+		"allowed-kdfs = argon2id, pbkdf2",
+		"require-aead = true",
+		"argon2id-min-time = 3",
+		"argon2id-min-memory = 65536",
+		"pbkdf2-min-iterations = 600000",
+	)
+	argon2id := fileenc.KDFParams{Name: fileenc.KDFArgon2id, Time: 3, Memory: 65536, Threads: 4}
+	tests := []struct {
+		name   string
+		format string
+		cipher string
+		kdf    func(*fileenc.KDFParams)
+		broken string
+	}{
+		{"allowed", fileenc.FormatFileenc, fileenc.CipherAESGCM, func(*fileenc.KDFParams) {}, ""},
+		{"higher costs", fileenc.FormatFileenc, fileenc.CipherXChaCha20Poly1305, func(k *fileenc.KDFParams) { k.Time, k.Memory = 4, 1<<20 }, ""},
+		{"age", fileenc.FormatAge, fileenc.CipherChaCha20Poly1305, func(*fileenc.KDFParams) {}, ""},
+		{"format", fileenc.FormatOpenPGP, fileenc.CipherAESGCM, func(*fileenc.KDFParams) {}, "format openpgp is not allowed"},
+		{"cipher", fileenc.FormatFileenc, fileenc.CipherChaCha20Poly1305, func(*fileenc.KDFParams) {}, "cipher " + fileenc.CipherChaCha20Poly1305 + " is not allowed"},
+		{"unauthenticated", fileenc.FormatFileenc, fileenc.CipherAESCFB, func(*fileenc.KDFParams) {}, "is not authenticated"},
+		{"kdf", fileenc.FormatFileenc, fileenc.CipherAESGCM, func(k *fileenc.KDFParams) {
+			*k = fileenc.KDFParams{Name: fileenc.KDFScrypt, Time: 20, Memory: 8, Threads: 1}
+		}, "kdf scrypt is not allowed"},
+		{"time", fileenc.FormatFileenc, fileenc.CipherAESGCM, func(k *fileenc.KDFParams) { k.Time = 2 }, "argon2id time cost 2 is below 3"},
+		{"memory", fileenc.FormatFileenc, fileenc.CipherAESGCM, func(k *fileenc.KDFParams) { k.Memory = 65535 }, "argon2id memory cost 65535 KiB is below 65536 KiB"},
+		{"iterations", fileenc.FormatFileenc, fileenc.CipherAESGCM, func(k *fileenc.KDFParams) { *k = fileenc.KDFParams{Name: fileenc.KDFPBKDF2, Time: 599999} }, "pbkdf2 time cost 599999 is below 600000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kdf := argon2id
+			tt.kdf(&kdf)
+			err := p.checkEncryption(tt.format, tt.cipher, kdf)
+			if tt.broken == "" {
+				if err != nil {
+					t.Fatalf("rejected: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errPolicy) || !strings.Contains(err.Error(), tt.broken) {
+				t.Fatalf("got %v, want a violation of %q", err, tt.broken)
+			}
+		})
+	}
+}
+
+// TestPolicyKey rejects keys that are too short or too weak
+func TestPolicyKey(t *testing.T) {
+	usePolicy(t, "min-key-length = 12", "min-entropy = 60")
+	tests := []struct {
+		key    string
+		broken string
+	}{
+		{"wF7#kq2!Zr9@xLp4", ""},
+		{"Tr0ub4dor&3", "the key has 11 characters, at least 12 are required"},
+		{"aaaaaaaaaaaaaaaaaaaa", "bits estimated, at least 60 are required"},
+		{"password1234", "bits estimated, at least 60 are required"},
+	}
+	for _, tt := range tests {
+		err := checkKeyPolicy([]byte(tt.key))
+		if tt.broken == "" {
+			if err != nil {
+				t.Errorf("%s rejected: %v", tt.key, err)
+			}
+			continue
+		}
+		if !errors.Is(err, errPolicy) || !strings.Contains(err.Error(), tt.broken) {
+			t.Errorf("%s: got %v, want a violation of %q", tt.key, err, tt.broken)
+		}
+	}
+}
+
+// TestPolicyMalformed fails closed: a damaged policy file rejects every key
+// and setting instead of being ignored
+func TestPolicyMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"unknown setting", "min-key-lenght = 12"},
+		{"no value", "min-key-length"},
+		{"bad number", "min-key-length = twelve"},
+		{"negative number", "pbkdf2-min-iterations = -1"},
+		{"too large", "argon2id-min-time = 4294967296"},
+		{"nan entropy", "min-entropy = NaN"},
+		{"negative entropy", "min-entropy = -Inf"},
+		{"empty list", "allowed-ciphers = ,"},
+		{"bad bool", "require-aead = maybe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usePolicy(t, "min-key-length = 12", tt.line)
+			if p, err := currentPolicy(); !errors.Is(err, errPolicy) {
+				t.Fatalf("policy %+v, error %v", p, err)
+			}
+			if err := checkKeyPolicy([]byte("wF7#kq2!Zr9@xLp4")); !errors.Is(err, errPolicy) {
+				t.Fatalf("key accepted: %v", err)
+			}
+			c := &cipherFlags{format: fileenc.FormatFileenc, cipher: fileenc.CipherAESGCM, kdf: fileenc.KDFArgon2id}
+			if _, err := c.options(); exitCode(err, exitUsage) != exitPolicy {
+				t.Fatalf("settings accepted: %v", err)
+			}
+		})
+	}
+}
+
+// TestPolicyMissing does not restrict anything without a policy file
+func TestPolicyMissing(t *testing.T) {
+	p, err := readPolicy(filepath.Join(t.TempDir(), "policy"))
+	if p != nil || err != nil {
+		t.Fatalf("policy %+v, error %v", p, err)
+	}
+	if err := p.checkKey([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.checkEncryption(fileenc.FormatFileenc, fileenc.CipherAESCFB, fileenc.KDFParams{Name: fileenc.KDFPBKDF2, Time: 1}); err != nil {
+		t.Fatal(err)
+	}
+}
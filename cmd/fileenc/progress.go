@@ -0,0 +1,141 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/itkonzepte-net/fileenc"
+	"golang.org/x/term"
+)
+
+const (
+	// progressAuto renders a bar if stderr is a terminal
+	progressAuto = "auto"
+	// progressBar renders a single updating line for terminals
+	progressBar = "bar"
+	// progressJSON writes one JSON object per report for other programs
+	progressJSON = "json"
+	// progressNone disables progress reports
+	progressNone = "none"
+
+	// barWidth is the number of characters of the bar itself
+	barWidth = 30
+)
+
+// progressPrinter renders progress reports of the processed files
+type progressPrinter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	mode    string
+	lineLen int
+}
+
+// newProgressPrinter returns a printer writing to stderr for the mode, nil if
+// progress is disabled or quiet is set
+func newProgressPrinter(mode string, quiet bool) (*progressPrinter, error) {
+	flagMode := mode
+	if mode == progressAuto {
+		mode = progressNone
+		if term.IsTerminal(int(os.Stderr.Fd())) {
+			mode = progressBar
+		}
+	}
+	switch {
+	case mode != progressBar && mode != progressJSON && mode != progressNone:
+		return nil, fmt.Errorf("unknown progress mode %q, use auto, bar, json or none", flagMode)
+	case mode == progressNone || quiet:
+		return nil, nil
+	}
+	return &progressPrinter{w: os.Stderr, mode: mode}, nil
+}
+
+// update renders a progress report
+func (p *progressPrinter) update(pr fileenc.Progress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.mode == progressJSON {
+		eta := pr.ETA().Seconds()
+		if pr.ETA() < 0 {
+			eta = -1
+		}
+		json.NewEncoder(p.w).Encode(struct {
+			File       string  `json:"file"`
+			Bytes      int64   `json:"bytes"`
+			Total      int64   `json:"total"`
+			Rate       float64 `json:"bytes_per_second"`
+			ETASeconds float64 `json:"eta_seconds"`
+		}{pr.Name, pr.Done, pr.Total, pr.Rate(), eta})
+		return
+	}
+
+	// Without a known size only the amount processed can be shown
+	if pr.Total < 0 {
+		p.writeLine(fmt.Sprintf("%s %s %s/s", pr.Name, formatBytes(float64(pr.Done)), formatBytes(pr.Rate())))
+		return
+	}
+
+	percent := 100.0
+	if pr.Total > 0 {
+		percent = float64(pr.Done) * 100 / float64(pr.Total)
+	}
+	filled := int(percent / 100 * barWidth)
+	eta := "--:--"
+	if d := pr.ETA(); d >= 0 {
+		eta = fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	line := fmt.Sprintf("%s [%s%s] %5.1f%% %s/s ETA %s",
+		pr.Name, strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+		percent, formatBytes(pr.Rate()), eta)
+	p.writeLine(line)
+}
+
+// clear removes the progress line so other output can be printed
+func (p *progressPrinter) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mode == progressBar {
+		p.writeLine("")
+	}
+}
+
+// writeLine replaces the current terminal line, the caller holds the lock
+func (p *progressPrinter) writeLine(line string) {
+	pad := ""
+	if n := p.lineLen - len(line); n > 0 {
+		pad = strings.Repeat(" ", n)
+	}
+	fmt.Fprintf(p.w, "\r%s%s\r%s", line, pad, line)
+	p.lineLen = len(line)
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
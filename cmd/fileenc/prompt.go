@@ -0,0 +1,126 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+	"golang.org/x/term"
+)
+
+// defaultAttempts is how often a passphrase typed for decryption is asked for
+const defaultAttempts = 3
+
+// readPassword prompts for the passphrase called name on the terminal without
+// echoing it. With confirm set the passphrase is new and has to be entered
+// twice, a weak one is warned about first.
+func readPassword(name string, confirm bool) ([]byte, error) {
+	if promptDialog {
+		return readPasswordDialog(name, confirm)
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		// Stdin may carry the data, ask on the controlling terminal instead
+		tty, err := os.OpenFile(ttyPath, os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("no %s present and no terminal available", name)
+		}
+		defer tty.Close()
+		fd = int(tty.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, fmt.Errorf("no %s present and no terminal available", name)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter %s: ", name)
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("empty %s", name)
+	}
+	if !confirm {
+		return pass, nil
+	}
+
+	// A new passphrase can still be replaced before it is confirmed
+	warnWeakPassphrase(name, pass)
+	fmt.Fprintf(os.Stderr, "Confirm %s: ", name)
+	again, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	defer clear(again)
+	if err != nil {
+		clear(pass)
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if !bytes.Equal(pass, again) {
+		clear(pass)
+		return nil, fmt.Errorf("%ss do not match", name)
+	}
+	return pass, nil
+}
+
+// retryPassphrase checks the passphrase typed for decrypting files against
+// the first of them that can tell, and asks again while it is wrong, up to
+// attempts times with a delay growing by a second per failure. It returns the
+// Encryptor, the key and the number of attempts; once they are used up the
+// last key is returned and the files fail with the wrong key as usual. The
+// derived key of the checked file is kept for its decryption. Errors concern
+// the key typed again, opts is left unchanged.
+func retryPassphrase(key []byte, opts []fileenc.Option, files []string, name string, attempts int) (*fileenc.Encryptor, []byte, int, error) {
+	opts = append(slices.Clip(opts), fileenc.WithKeyCache(fileenc.NewKeyCache()))
+	for n := 1; ; n++ {
+		enc, err := fileenc.New(key, opts...)
+		if err != nil || n >= attempts || !wrongPassphrase(enc, files) {
+			return enc, key, n, err
+		}
+		delay := time.Duration(n) * time.Second
+		fmt.Fprintf(os.Stderr, "Wrong %s, %d of %d attempts used, try again in %s.\n", name, n, attempts, delay)
+		time.Sleep(delay)
+		clear(key)
+		pass, err := readPassword(name, false)
+		if err != nil {
+			return nil, nil, n, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		key = lockKey(pass)
+		clear(pass)
+	}
+}
+
+// wrongPassphrase reports whether the first file enc can check the key of
+// rejects it. Files without a key check value and those of other formats
+// cannot tell, errors are left to the decryption.
+func wrongPassphrase(enc *fileenc.Encryptor, files []string) bool {
+	for _, f := range files {
+		info, err := enc.InspectFile(f)
+		if errors.Is(err, fileenc.ErrWrongPassword) {
+			return true
+		}
+		if err == nil && info.Format == fileenc.FormatFileenc {
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// newKeyEnv is the environment variable holding the new key for rekey
+const newKeyEnv = "FILEENC_NEW_KEY"
+
+// runRekey implements "fileenc rekey [options] <file or dir>..."
+func runRekey(args []string) {
+	fs := flag.NewFlagSet("rekey", flag.ExitOnError)
+	oldKeys := &keyFlags{env: keyEnv, name: "old key"}
+	fs.StringVar(&oldKeys.pass, "old-key", "", "current password or key, visible in the process list; prefer -old-keyfile, "+keyEnv+" or the prompt")
+	fs.StringVar(&oldKeys.keyFile, "old-keyfile", "", "read the current key from this file")
+	fs.StringVar(&oldKeys.keyName, "old-key-name", "", "use the current key or identity of this name from the keyring")
+	fs.Var(&oldKeys.identities, "identity", "decrypt with the identities in this file, may be repeated")
+	newKeys := &keyFlags{env: newKeyEnv, name: "new key"}
+	fs.StringVar(&newKeys.pass, "new-key", "", "new password or key, visible in the process list; prefer -new-keyfile, "+newKeyEnv+" or the prompt")
+	fs.StringVar(&newKeys.keyFile, "new-keyfile", "", "read the new key from this file")
+	fs.StringVar(&newKeys.keyName, "new-key-name", "", "use the new key or the public keys of the identity of this name from the keyring")
+	fs.Var(&newKeys.recipients, "recipient", "encrypt for this public key or the public keys in this file, may be repeated")
+	ciphers := addCipherFlags(fs)
+	quiet := fs.Bool("quiet", false, "only report errors, no progress or success messages")
+	progressFlag := fs.String("progress", progressAuto, "progress reporting on stderr: auto (bar on terminals), bar, json or none")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc rekey [options] <file or dir>...")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	// Keep the compression of every file unless one is given
+	keepCompression := true
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "compress" {
+			keepCompression = false
+		}
+	})
+
+	from, oldKey := newEncryptor(oldKeys, true, *progressFlag, *quiet, nil)
+	defer clear(oldKey)
+	opts, err := ciphers.options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	newKey, newOpts, err := newKeys.load(false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	defer clear(newKey)
+	opts = append(opts, newOpts...)
+	if _, err := fileenc.New(newKey, opts...); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid settings: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+
+	// Rekey the files and every fileenc file found in directories
+	var failed []error
+	rekey := func(path string) {
+		fileOpts := opts
+		if keepCompression {
+			if info, err := fileenc.InspectFile(path); err == nil && info.Compression != "" {
+				fileOpts = append(fileOpts[:len(fileOpts):len(fileOpts)], fileenc.WithCompression(info.Compression))
+			}
+		}
+		to, err := fileenc.New(newKey, fileOpts...)
+		if err == nil {
+			err = from.RekeyFile(path, to)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error rekeying %s: %v\n", path, err)
+			failed = append(failed, err)
+			return
+		}
+		if !*quiet {
+			fmt.Printf("File %s rekeyed successfully.\n", path)
+		}
+	}
+	for _, arg := range args {
+		err := filepath.WalkDir(arg, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			// Files named explicitly are rekeyed, others only if they are fileenc files
+			if path == arg {
+				rekey(path)
+				return nil
+			}
+			if _, err := fileenc.InspectFile(path); errors.Is(err, fileenc.ErrNotFileenc) {
+				return nil
+			}
+			rekey(path)
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		clear(oldKey)
+		clear(newKey)
+		os.Exit(batchExitCode(failed))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+func init() {
+	fileenc.RegisterStorage("s3", s3Storage{})
+	fileenc.RegisterStorage("sftp", sftpStorage{})
+	fileenc.RegisterStorage("https", httpsStorage{})
+}
+
+// isRemote reports if s is a URL of a registered storage backend
+func isRemote(s string) bool {
+	_, _, ok := fileenc.StorageURL(s)
+	return ok
+}
+
+// runRemote encrypts or decrypts a single source when the source or the
+// output is a URL. The data is streamed, neither side needs a local copy.
+func runRemote(enc *fileenc.Encryptor, t task, source string) (err error) {
+	in, out := targetPaths(source, t.decrypt, t.suffix)
+	if t.out != "" {
+		out = t.out
+	} else if u, _, ok := fileenc.StorageURL(in); ok {
+		// A remote source is written to the current directory
+		name := path.Base(u.Path)
+		if name == "/" || name == "." || name == t.suffix {
+			return fmt.Errorf("%s names no file, use -out", source)
+		}
+		_, out = targetPaths(name, t.decrypt, t.suffix)
+	}
+
+	read, written := &auditCounter{}, &auditCounter{}
+	if t.audit != nil || t.events != nil {
+		start, log := time.Now(), t.logger(os.Stderr)
+		t.events.start(log, event{Operation: t.operation(), File: in, Output: out})
+		defer func() {
+			if t.audit != nil {
+				if aerr := t.audit.record(t.operation(), in, out, read.n, written.n, start, err); err == nil {
+					err = aerr
+				}
+			}
+			t.events.finish(log, event{Operation: t.operation(), File: in, Output: out, BytesIn: read.n, BytesOut: written.n}, start, err)
+		}()
+	}
+
+	// Check the output first so nothing is downloaded for an existing file
+	w, err := createOutput(out, t.overwrite)
+	if err != nil {
+		return err
+	}
+	r, size, err := openInput(in)
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	defer r.Close()
+
+	read.r, written.w = r, w
+	var src io.Reader = read
+	var checksum *checksumReader
+	if t.checksum != "" {
+		newHash, sum, _ := parseChecksum(t.checksum)
+		checksum = &checksumReader{r: read, h: newHash(), want: sum}
+		src = checksum
+	}
+	if t.progress != nil {
+		src = fileenc.NewProgressReader(src, in, size, 200*time.Millisecond, t.progress.update)
+		defer t.progress.clear()
+	}
+	if t.decrypt {
+		err = enc.DecryptContext(t.context(), written, src)
+	} else {
+		err = enc.EncryptContext(t.context(), written, src)
+	}
+	if err == nil && checksum != nil {
+		err = checksum.verify()
+	}
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		w.Abort()
+		return err
+	}
+	if t.decrypt {
+		t.logger(os.Stderr).Info("File decrypted", "file", in, "output", out)
+	} else {
+		t.logger(os.Stderr).Info("File encrypted", "file", in, "output", out)
+	}
+	return nil
+}
+
+// storageOf returns the backend of the URL name or the local file system
+func storageOf(name string) (*url.URL, fileenc.StorageBackend) {
+	if u, b, ok := fileenc.StorageURL(name); ok {
+		return u, b
+	}
+	return &url.URL{Path: name}, fileenc.LocalStorage{}
+}
+
+// openInput opens the local file or URL
+func openInput(name string) (io.ReadCloser, int64, error) {
+	u, b := storageOf(name)
+	return b.Open(u)
+}
+
+// listObjects returns the names of the files in the local directory or the
+// directory of a backend that can list, e.g. s3://bucket/prefix/
+func listObjects(dir string) ([]string, error) {
+	u, b := storageOf(dir)
+	return b.List(u)
+}
+
+// removeObject deletes the local file or URL
+func removeObject(name string) error {
+	u, b := storageOf(name)
+	return b.Remove(u)
+}
+
+// joinObject returns the name of the file name in the local directory or URL dir
+func joinObject(dir, name string) string {
+	if isRemote(dir) {
+		return strings.TrimSuffix(dir, "/") + "/" + url.PathEscape(name)
+	}
+	return filepath.Join(dir, name)
+}
+
+// createOutput creates the local file or URL
+func createOutput(name string, overwrite bool) (fileenc.StorageWriter, error) {
+	u, b := storageOf(name)
+	return b.Create(u, overwrite)
+}
+
+// remoteError describes a failed operation on the object at u, it is reported as I/O error
+func remoteError(op string, u *url.URL, err error) error {
+	return &fs.PathError{Op: op, Path: u.Redacted(), Err: err}
+}
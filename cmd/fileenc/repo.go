@@ -0,0 +1,451 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// runRepo implements "fileenc repo <command>" for deduplicating repositories
+func runRepo(args []string) {
+	usage := func() {
+		fmt.Println("Usage: fileenc repo init [-keyfile <file> | -recipient <key>] <repo>")
+		fmt.Println("       fileenc repo put [options] <repo> <path>...")
+		fmt.Println("       fileenc repo get [options] [-snapshot <id>] [-C <dir>] <repo> [<path>...]")
+		fmt.Println("       fileenc repo list [options] <repo> [<snapshot>]")
+		fmt.Println("       fileenc repo find [options] <repo> <path>...")
+		fmt.Println("       fileenc repo prune [options] [-keep-daily <n>] [-keep-weekly <n>] [-keep-monthly <n>] <repo>")
+		fmt.Println("       fileenc repo check [options] [-read-data <percent>] <repo>")
+		fmt.Println("       fileenc repo unlock [options] <repo>")
+		fmt.Println("Run a command with -h for its options.")
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	var err error
+	switch args[0] {
+	case "init":
+		err = repoInit(args[1:])
+	case "put":
+		err = repoPut(args[1:])
+	case "get":
+		err = repoGet(args[1:])
+	case "list":
+		err = repoList(args[1:])
+	case "find":
+		err = repoFind(args[1:])
+	case "prune":
+		err = repoPrune(args[1:])
+	case "check":
+		err = repoCheck(args[1:])
+	case "unlock":
+		err = repoUnlock(args[1:])
+	default:
+		usage()
+		os.Exit(exitUsage)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.Is(err, fileenc.ErrLocked) {
+			fmt.Println("If no other fileenc uses the repository, remove the lock with fileenc repo unlock.")
+		}
+		os.Exit(exitCode(err, exitFailure))
+	}
+}
+
+// repoInit implements "fileenc repo init"
+func repoInit(args []string) error {
+	fs := flag.NewFlagSet("repo init", flag.ExitOnError)
+	keys := addKeyFlags(fs, true)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc repo init [options] <repo>")
+		fmt.Fprintln(fs.Output(), "The repository key is encrypted with the key or for the recipients, which are needed to open it.")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	enc, key := newEncryptor(keys, false, progressNone, true, nil)
+	defer clear(key)
+	repo, err := fileenc.InitRepository(args[0], enc)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	fmt.Printf("Repository %s created in %s.\n", repo.ID(), args[0])
+	return nil
+}
+
+// openRepo opens the repository at path with the key given by the flags, it exits on key errors
+func openRepo(keys *keyFlags, path, progressMode string, quiet bool, opts []fileenc.Option) (*fileenc.Repository, []byte, error) {
+	enc, key := newEncryptor(keys, true, progressMode, quiet, opts)
+	repo, err := fileenc.OpenRepository(path, enc)
+	if err != nil {
+		clear(key)
+		return nil, nil, err
+	}
+	return repo, key, nil
+}
+
+// repoPut implements "fileenc repo put"
+func repoPut(args []string) error {
+	fs := flag.NewFlagSet("repo put", flag.ExitOnError)
+	keys := addKeyFlags(fs, false)
+	quiet := fs.Bool("quiet", false, "only report errors, no progress or success messages")
+	progressFlag := fs.String("progress", progressAuto, "progress reporting on stderr: auto (bar on terminals), bar, json or none")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc repo put [options] <repo> <path>...")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) < 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	opts := []fileenc.Option{fileenc.WithSkipFunc(func(path, reason string) {
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s, %s\n", path, reason)
+	})}
+	repo, key, err := openRepo(keys, args[0], *progressFlag, *quiet, opts)
+	if err != nil {
+		return err
+	}
+	defer clear(key)
+	defer repo.Close()
+	snap, err := repo.Put(args[1:]...)
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		fmt.Printf("Snapshot %s saved: %d entries, %d bytes, %d bytes added to the repository.\n", snap.ID, len(snap.Files), snap.Size, snap.Added)
+	}
+	return nil
+}
+
+// repoGet implements "fileenc repo get"
+func repoGet(args []string) error {
+	fs := flag.NewFlagSet("repo get", flag.ExitOnError)
+	keys := addKeyFlags(fs, false)
+	id := fs.String("snapshot", "latest", "snapshot to restore, its id or a unique prefix of it")
+	dir := fs.String("C", ".", "directory to restore into")
+	overwrite := fs.Bool("overwrite", false, "replace existing files")
+	quiet := fs.Bool("quiet", false, "only report errors, no progress or success messages")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc repo get [options] <repo> [<path>...]")
+		fmt.Fprintln(fs.Output(), "Without paths the whole snapshot is restored, paths are given as listed by fileenc repo list <repo> <snapshot>.")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) < 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	opts := []fileenc.Option{fileenc.WithOverwrite(*overwrite), fileenc.WithSkipFunc(func(path, reason string) {
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s, %s\n", path, reason)
+	})}
+	repo, key, err := openRepo(keys, args[0], progressNone, *quiet, opts)
+	if err != nil {
+		return err
+	}
+	defer clear(key)
+	defer repo.Close()
+	snap, err := loadSnapshot(repo, *id)
+	if err != nil {
+		return err
+	}
+
+	// A path selects itself and everything below it
+	paths := args[1:]
+	var match func(string) bool
+	if len(paths) > 0 {
+		match = func(name string) bool {
+			for _, p := range paths {
+				p = strings.Trim(strings.ReplaceAll(p, `\`, "/"), "/")
+				if name == p || strings.HasPrefix(name, p+"/") || strings.HasPrefix(p, name+"/") {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if err := repo.Restore(snap, *dir, match); err != nil {
+		return err
+	}
+	if !*quiet {
+		fmt.Printf("Snapshot %s restored to %s successfully.\n", snap.ID, *dir)
+	}
+	return nil
+}
+
+// loadSnapshot reads the snapshot id, "latest" is the most recent one
+func loadSnapshot(repo *fileenc.Repository, id string) (*fileenc.Snapshot, error) {
+	if id == "latest" {
+		snaps, err := repo.History()
+		if err != nil {
+			return nil, err
+		}
+		if len(snaps) == 0 {
+			return nil, fmt.Errorf("the repository holds no snapshots: %w", os.ErrNotExist)
+		}
+		id = snaps[len(snaps)-1].ID
+	}
+	return repo.Snapshot(id)
+}
+
+// repoList implements "fileenc repo list"
+func repoList(args []string) error {
+	fs := flag.NewFlagSet("repo list", flag.ExitOnError)
+	keys := addKeyFlags(fs, false)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc repo list [options] <repo> [<snapshot>]")
+		fmt.Fprintln(fs.Output(), "Lists the snapshots or the files of a snapshot, given by its id, a unique prefix of it or latest.")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) < 1 || len(args) > 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	repo, key, err := openRepo(keys, args[0], progressNone, true, nil)
+	if err != nil {
+		return err
+	}
+	defer clear(key)
+	defer repo.Close()
+
+	if len(args) == 2 {
+		snap, err := loadSnapshot(repo, args[1])
+		if err != nil {
+			return err
+		}
+		for _, f := range snap.Files {
+			fmt.Printf("%s %10d %s %s\n", f.Mode, f.Size, f.ModTime.Local().Format(time.DateTime), f.Name)
+		}
+		return nil
+	}
+
+	snaps, err := repo.History()
+	if err != nil {
+		return err
+	}
+	for _, snap := range snaps {
+		fmt.Printf("%s  %s  %s  %10d bytes  %s\n", snap.ID, snap.Time.Local().Format(time.DateTime), snap.Host, snap.Size, strings.Join(snap.Paths, " "))
+	}
+	return nil
+}
+
+// repoFind implements "fileenc repo find"
+func repoFind(args []string) error {
+	fs := flag.NewFlagSet("repo find", flag.ExitOnError)
+	keys := addKeyFlags(fs, false)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc repo find [options] <repo> <path>...")
+		fmt.Fprintln(fs.Output(), "Lists every version of the paths and everything below them with the snapshots holding it.")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) < 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	repo, key, err := openRepo(keys, args[0], progressNone, true, nil)
+	if err != nil {
+		return err
+	}
+	defer clear(key)
+	defer repo.Close()
+
+	found := false
+	for _, path := range args[1:] {
+		versions, err := repo.Versions(strings.ReplaceAll(path, `\`, "/"))
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			fmt.Printf("%s %10d %s %s\n", v.Mode, v.Size, v.ModTime.Local().Format(time.DateTime), v.Name)
+			fmt.Printf("    in %s\n", strings.Join(v.Snapshots, " "))
+		}
+		found = found || len(versions) > 0
+	}
+	if !found {
+		return fmt.Errorf("no snapshot holds %s: %w", strings.Join(args[1:], ", "), os.ErrNotExist)
+	}
+	return nil
+}
+
+// repoPrune implements "fileenc repo prune"
+func repoPrune(args []string) error {
+	fs := flag.NewFlagSet("repo prune", flag.ExitOnError)
+	keys := addKeyFlags(fs, false)
+	keep := addRetentionFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "only list the snapshots that would be removed")
+	quiet := fs.Bool("quiet", false, "only report errors, no success messages")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc repo prune [options] <repo>")
+		fmt.Fprintln(fs.Output(), "Removes the snapshots no -keep- rule keeps and the chunks no snapshot uses anymore.")
+		fmt.Fprintln(fs.Output(), "Without -keep- flags all snapshots are kept and only unused chunks are removed.")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	repo, key, err := openRepo(keys, args[0], progressNone, true, nil)
+	if err != nil {
+		return err
+	}
+	defer clear(key)
+	defer repo.Close()
+
+	var forget []string
+	if keep.set() {
+		history, err := repo.History()
+		if err != nil {
+			return err
+		}
+		snaps := make([]snapshot, len(history))
+		for i, s := range history {
+			snaps[i] = snapshot{file: s.ID, time: s.Time}
+		}
+		for _, s := range keep.expired(snaps) {
+			forget = append(forget, s.file)
+		}
+	}
+	if *dryRun {
+		for _, id := range forget {
+			fmt.Printf("Would remove snapshot %s.\n", id)
+		}
+		return nil
+	}
+
+	stats, err := repo.Prune(forget...)
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		fmt.Printf("Removed %d snapshots and %d unused chunks, %d bytes freed.\n", stats.Snapshots, stats.Chunks, stats.Bytes)
+	}
+	return nil
+}
+
+// repoUnlock implements "fileenc repo unlock"
+func repoUnlock(args []string) error {
+	fs := flag.NewFlagSet("repo unlock", flag.ExitOnError)
+	keys := addKeyFlags(fs, false)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc repo unlock [options] <repo>")
+		fmt.Fprintln(fs.Output(), "Removes the locks left by interrupted runs, only use it when no other fileenc uses the repository.")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	repo, key, err := openRepo(keys, args[0], progressNone, true, nil)
+	if err != nil {
+		return err
+	}
+	defer clear(key)
+	defer repo.Close()
+	n, err := repo.RemoveLocks()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d locks.\n", n)
+	return nil
+}
+
+// errRepoDamaged is returned by repo check if objects are missing or damaged
+var errRepoDamaged = errors.New("the repository is damaged")
+
+// repoCheck implements "fileenc repo check"
+func repoCheck(args []string) error {
+	fs := flag.NewFlagSet("repo check", flag.ExitOnError)
+	keys := addKeyFlags(fs, false)
+	readData := fs.Float64("read-data", 100, "percentage of the chunks to read and authenticate, the others are only checked to exist")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc repo check [options] <repo>")
+		fmt.Fprintln(fs.Output(), "Verifies the snapshots, the chunks they use and the index and lists the files affected by problems.")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) != 1 || *readData < 0 || *readData > 100 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	repo, key, err := openRepo(keys, args[0], progressNone, true, nil)
+	if err != nil {
+		return err
+	}
+	defer clear(key)
+	defer repo.Close()
+
+	// Damaged objects fail the check, a stale index is rebuilt when it is read next
+	damaged := 0
+	stats, err := repo.Check(*readData/100, func(p fileenc.CheckProblem) {
+		if p.Object == "index" {
+			fmt.Fprintf(os.Stderr, "Warning: %v, it is rebuilt by the next list, find or put\n", p.Err)
+			return
+		}
+		damaged++
+		if errors.Is(p.Err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Error: %s is missing\n", p.Object)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %s is damaged, %v\n", p.Object, p.Err)
+		}
+		for i, f := range p.Files {
+			if i == 10 {
+				fmt.Printf("    and %d more files\n", len(p.Files)-i)
+				break
+			}
+			fmt.Printf("    needed by %s\n", f)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Checked %d snapshots and %d chunks, %d of them read.\n", stats.Snapshots, stats.Chunks, stats.Read)
+	if stats.Unused > 0 {
+		fmt.Printf("%d chunks are not used by any snapshot, fileenc repo prune removes them.\n", stats.Unused)
+	}
+	if damaged > 0 {
+		fmt.Println("To repair the repository, delete the damaged chunks and put the files needing them again,")
+		fmt.Println("put stores the chunks it does not find. Snapshots that cannot be read can only be deleted.")
+		return fmt.Errorf("%w, %d problems found", errRepoDamaged, damaged)
+	}
+	return nil
+}
@@ -0,0 +1,540 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+const (
+	// s3PartSize is the size of the first parts of multipart uploads, it grows
+	// every 1000 parts so the 10000 parts allowed hold about 850 GiB
+	s3PartSize = 16 << 20
+	// s3MaxParts is the number of parts S3 allows per upload
+	s3MaxParts = 10000
+	// emptySHA256 is the payload hash of requests without body
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// s3Client sends requests signed with AWS signature version 4, for S3 and,
+// with another service, for AWS KMS
+type s3Client struct {
+	endpoint  *url.URL
+	pathStyle bool
+	service   string
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+}
+
+// newS3Client configures the client like the AWS SDKs: credentials, region
+// and endpoint come from the AWS_* environment variables or the shared
+// config and credentials files of the profile in AWS_PROFILE. Only static keys
+// are read, SSO, credential_process, assumed roles and IMDSv2 are not supported.
+func newS3Client() (*s3Client, error) {
+	home, _ := os.UserHomeDir()
+	profile := envOr("AWS_PROFILE", "default")
+	credentials := iniSection(envOr("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(home, ".aws", "credentials")), profile)
+	configSection := "profile " + profile
+	if profile == "default" {
+		configSection = profile
+	}
+	config := iniSection(envOr("AWS_CONFIG_FILE", filepath.Join(home, ".aws", "config")), configSection)
+
+	c := &s3Client{
+		service:   "s3",
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		region:    envOr("AWS_REGION", envOr("AWS_DEFAULT_REGION", config["region"])),
+	}
+	if c.accessKey == "" {
+		c.accessKey, c.secretKey, c.token = credentials["aws_access_key_id"], credentials["aws_secret_access_key"], credentials["aws_session_token"]
+	}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil, errors.New("no AWS credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or configure ~/.aws/credentials")
+	}
+	if c.region == "" {
+		c.region = "us-east-1"
+	}
+
+	// Other S3 compatible stores are addressed with the bucket in the path
+	endpoint := envOr("AWS_ENDPOINT_URL_S3", envOr("AWS_ENDPOINT_URL", config["endpoint_url"]))
+	if endpoint == "" {
+		endpoint = "https://s3." + c.region + ".amazonaws.com"
+	} else {
+		c.pathStyle = true
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	c.endpoint = u
+	return c, nil
+}
+
+// envOr returns the environment variable name or fallback if it is empty
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// iniSection returns the keys of section in the ini file at path, nil if it cannot be read
+func iniSection(path, section string) map[string]string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var keys map[string]string
+	current := ""
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok && current == section {
+			if keys == nil {
+				keys = map[string]string{}
+			}
+			keys[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return keys
+}
+
+// s3Object splits an s3://bucket/key URL
+func s3Object(u *url.URL) (bucket, key string, err error) {
+	bucket, key = u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" || strings.HasSuffix(key, "/") {
+		return "", "", fmt.Errorf("%s is no object, use s3://bucket/key", u.Redacted())
+	}
+	return bucket, key, nil
+}
+
+// do sends a signed request for the object key in bucket
+func (c *s3Client) do(method, bucket, key string, query url.Values, body []byte) (*http.Response, error) {
+	host, objectPath := bucket+"."+c.endpoint.Host, "/"+key
+	if c.pathStyle || strings.Contains(bucket, ".") {
+		host, objectPath = c.endpoint.Host, strings.TrimSuffix(c.endpoint.Path, "/")+"/"+bucket+"/"+key
+	}
+	req, err := http.NewRequest(method, c.endpoint.Scheme+"://"+host, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// Send the path escaped as signed
+	req.URL.Opaque = "//" + host + s3Escape(objectPath, false)
+	req.URL.RawQuery = s3Query(query)
+
+	hash := emptySHA256
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		hash = hex.EncodeToString(sum[:])
+	}
+	c.sign(req, hash, time.Now().UTC())
+	return http.DefaultClient.Do(req)
+}
+
+// sign adds the signature version 4 authorization header to req, all headers are signed
+func (c *s3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	stamp := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.token != "" {
+		req.Header.Set("X-Amz-Security-Token", c.token)
+	}
+
+	canonical, signedHeaders := canonicalRequest(req, payloadHash)
+	scope := stamp[:8] + "/" + c.region + "/" + c.service + "/aws4_request"
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, c.signature(stamp, stringToSign(stamp, scope, canonical))))
+}
+
+// canonicalRequest returns the canonical form of req signed by version 4 and
+// the names of the signed headers. Canonical headers are lower case and
+// sorted, the host is always signed.
+func canonicalRequest(req *http.Request, payloadHash string) (canonical, signedHeaders string) {
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := slices.Sorted(maps.Keys(headers))
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders = strings.Join(names, ";")
+	path, _ := strings.CutPrefix(req.URL.Opaque, "//"+req.URL.Host)
+	canonical = strings.Join([]string{req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	return canonical, signedHeaders
+}
+
+// stringToSign returns the string signed for the canonical request in scope
+func stringToSign(stamp, scope, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	return "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+}
+
+// signature returns the signature of toSign with the key derived from the
+// secret key for the day, region and service
+func (c *s3Client) signature(stamp, toSign string) string {
+	key := []byte("AWS4" + c.secretKey)
+	for _, part := range []string{stamp[:8], c.region, c.service, "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	return hex.EncodeToString(key)
+}
+
+// s3Escape escapes s as required for signing, / is kept in paths
+func s3Escape(s string, query bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !query:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query returns the canonical query string, sorted by key
+func s3Query(query url.Values) string {
+	var params []string
+	for k, vs := range query {
+		for _, v := range vs {
+			params = append(params, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	slices.Sort(params)
+	return strings.Join(params, "&")
+}
+
+// s3Error returns the error of a failed response, missing objects wrap fs.ErrNotExist
+func s3Error(resp *http.Response) error {
+	var e struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+	switch {
+	case resp.StatusCode == http.StatusNotFound && (e.Code == "" || e.Code == "NoSuchKey"):
+		return fs.ErrNotExist
+	case e.Code != "":
+		return fmt.Errorf("%s: %s", e.Code, e.Message)
+	}
+	return errors.New(resp.Status)
+}
+
+// s3Storage is the storage backend of s3://bucket/key URLs
+type s3Storage struct{}
+
+// Stat returns the size of the object at u
+func (s3Storage) Stat(u *url.URL) (int64, error) {
+	bucket, key, err := s3Object(u)
+	if err != nil {
+		return 0, err
+	}
+	c, err := newS3Client()
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.do(http.MethodHead, bucket, key, nil, nil)
+	if err != nil {
+		return 0, remoteError("head", u, err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, nil
+	case http.StatusNotFound:
+		return 0, remoteError("head", u, fs.ErrNotExist)
+	}
+	return 0, remoteError("head", u, errors.New(resp.Status))
+}
+
+// Open streams the object at u
+func (s3Storage) Open(u *url.URL) (io.ReadCloser, int64, error) {
+	bucket, key, err := s3Object(u)
+	if err != nil {
+		return nil, 0, err
+	}
+	c, err := newS3Client()
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.do(http.MethodGet, bucket, key, nil, nil)
+	if err != nil {
+		return nil, 0, remoteError("get", u, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, 0, remoteError("get", u, s3Error(resp))
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// List returns the names of the objects below the prefix s3://bucket/prefix/
+// at u, without the prefix and without those in deeper "directories"
+func (s3Storage) List(u *url.URL) ([]string, error) {
+	bucket, prefix := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("%s names no bucket, use s3://bucket/prefix/", u.Redacted())
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	c, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+	for {
+		var result struct {
+			Contents              []struct{ Key string }
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		resp, err := c.do(http.MethodGet, bucket, "", query, nil)
+		if err == nil {
+			err = s3Result(resp, &result)
+		}
+		if err != nil {
+			return nil, remoteError("list", u, err)
+		}
+		for _, obj := range result.Contents {
+			names = append(names, strings.TrimPrefix(obj.Key, prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// Remove deletes the object at u
+func (s3Storage) Remove(u *url.URL) error {
+	bucket, key, err := s3Object(u)
+	if err != nil {
+		return err
+	}
+	c, err := newS3Client()
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(http.MethodDelete, bucket, key, nil, nil)
+	if err != nil {
+		return remoteError("delete", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return remoteError("delete", u, s3Error(resp))
+	}
+	return nil
+}
+
+// Create starts the upload of the object at u
+func (s3Storage) Create(u *url.URL, overwrite bool) (fileenc.StorageWriter, error) {
+	bucket, key, err := s3Object(u)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+	if !overwrite {
+		resp, err := c.do(http.MethodHead, bucket, key, nil, nil)
+		if err != nil {
+			return nil, remoteError("head", u, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return nil, remoteError("put", u, fmt.Errorf("%w, overwrite is disabled", fileenc.ErrFileExists))
+		}
+	}
+	return &s3Writer{c: c, u: u, bucket: bucket, key: key, buf: make([]byte, 0, s3PartSize)}, nil
+}
+
+// s3Writer uploads an object. Objects up to one part are uploaded with a
+// single request, larger ones as multipart upload, which is only completed on
+// Close so a failed upload never shows up as object.
+type s3Writer struct {
+	c        *s3Client
+	u        *url.URL
+	bucket   string
+	key      string
+	buf      []byte
+	uploadID string
+	etags    []string
+}
+
+// Write buffers p and uploads every full part
+func (w *s3Writer) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf, p, n = w.buf[:len(w.buf)+m], p[m:], n+m
+		if len(w.buf) == cap(w.buf) {
+			if err := w.uploadPart(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// uploadPart uploads the buffer as next part, starting the multipart upload first
+func (w *s3Writer) uploadPart() error {
+	if w.uploadID == "" {
+		resp, err := w.c.do(http.MethodPost, w.bucket, w.key, url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return remoteError("upload", w.u, err)
+		}
+		var result struct{ UploadId string }
+		err = s3Result(resp, &result)
+		if err == nil && result.UploadId == "" {
+			err = errors.New("no upload id")
+		}
+		if err != nil {
+			return remoteError("upload", w.u, err)
+		}
+		w.uploadID = result.UploadId
+	}
+	if len(w.etags) == s3MaxParts {
+		return remoteError("upload", w.u, errors.New("object too large"))
+	}
+
+	query := url.Values{"partNumber": {strconv.Itoa(len(w.etags) + 1)}, "uploadId": {w.uploadID}}
+	resp, err := w.c.do(http.MethodPut, w.bucket, w.key, query, w.buf)
+	if err != nil {
+		return remoteError("upload", w.u, err)
+	}
+	if err := s3Result(resp, nil); err != nil {
+		return remoteError("upload", w.u, err)
+	}
+	w.etags = append(w.etags, resp.Header.Get("ETag"))
+	w.buf = w.buf[:0]
+	if size := s3PartSize * (1 + len(w.etags)/1000); size > cap(w.buf) {
+		w.buf = make([]byte, 0, size)
+	}
+	return nil
+}
+
+// Close uploads the remaining data and completes the upload
+func (w *s3Writer) Close() error {
+	if w.uploadID == "" {
+		resp, err := w.c.do(http.MethodPut, w.bucket, w.key, nil, w.buf)
+		if err != nil {
+			return remoteError("put", w.u, err)
+		}
+		if err := s3Result(resp, nil); err != nil {
+			return remoteError("put", w.u, err)
+		}
+		return nil
+	}
+	if len(w.buf) > 0 {
+		if err := w.uploadPart(); err != nil {
+			return err
+		}
+	}
+
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, etag := range w.etags {
+		complete.Parts = append(complete.Parts, part{PartNumber: i + 1, ETag: etag})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err := w.c.do(http.MethodPost, w.bucket, w.key, url.Values{"uploadId": {w.uploadID}}, body)
+	if err != nil {
+		return remoteError("complete", w.u, err)
+	}
+	if err := s3Result(resp, nil); err != nil {
+		return remoteError("complete", w.u, err)
+	}
+	w.uploadID = ""
+	return nil
+}
+
+// Abort cancels a multipart upload so its parts are not kept and billed
+func (w *s3Writer) Abort() {
+	if w.uploadID == "" {
+		return
+	}
+	resp, err := w.c.do(http.MethodDelete, w.bucket, w.key, url.Values{"uploadId": {w.uploadID}}, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+	w.uploadID = ""
+}
+
+// s3Result checks the response and decodes its XML body into v if not nil.
+// A complete request may fail with status 200 and an error in the body.
+func s3Result(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(body, []byte("<Error>")) {
+		return s3Error(&http.Response{StatusCode: resp.StatusCode, Status: resp.Status, Body: io.NopCloser(bytes.NewReader(body))})
+	}
+	if v != nil {
+		return xml.Unmarshal(body, v)
+	}
+	return nil
+}
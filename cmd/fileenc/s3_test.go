@@ -0,0 +1,141 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSigV4 signs requests of the AWS signature version 4 test suite and
+// compares the canonical request, the string to sign and the signature
+func TestSigV4(t *testing.T) {
+	c := &s3Client{service: "service", region: "us-east-1", accessKey: "AKIDEXAMPLE", secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	const stamp = "20150830T123600Z"
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		query     string
+		canonical string
+		toSign    string
+		signature string
+	}{
+		{
+			name:   "get-vanilla",
+			method: http.MethodGet, path: "/",
+			canonical: "GET\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\n" + emptySHA256,
+			toSign:    "AWS4-HMAC-SHA256\n20150830T123600Z\n20150830/us-east-1/service/aws4_request\nbb579772317eb040ac9ed261061d46c1f17a8133879d6129b6e1c25292927e63",
+			signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-vanilla-query-order-key-case",
+			method: http.MethodGet, path: "/", query: "Param1=value1&Param2=value2",
+			canonical: "GET\n/\nParam1=value1&Param2=value2\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\n" + emptySHA256,
+			toSign:    "AWS4-HMAC-SHA256\n20150830T123600Z\n20150830/us-east-1/service/aws4_request\n816cd5b414d056048ba4f7c5386d6e0533120fb1fcfa93762cf0fc39e2cf19e0",
+			signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:   "post-vanilla",
+			method: http.MethodPost, path: "/",
+			canonical: "POST\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\n" + emptySHA256,
+			toSign:    "AWS4-HMAC-SHA256\n20150830T123600Z\n20150830/us-east-1/service/aws4_request\n553f88c9e4d10fc9e109e2aeb65f030801b70c2f6468faca261d401ae622fc87",
+			signature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "https://example.amazonaws.com", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.URL.Opaque = "//example.amazonaws.com" + tt.path
+			req.URL.RawQuery = tt.query
+			req.Header.Set("X-Amz-Date", stamp)
+
+			canonical, signed := canonicalRequest(req, emptySHA256)
+			if canonical != tt.canonical {
+				t.Errorf("canonical request = %q, want %q", canonical, tt.canonical)
+			}
+			if signed != "host;x-amz-date" {
+				t.Errorf("signed headers = %q, want host;x-amz-date", signed)
+			}
+			toSign := stringToSign(stamp, "20150830/us-east-1/service/aws4_request", canonical)
+			if toSign != tt.toSign {
+				t.Errorf("string to sign = %q, want %q", toSign, tt.toSign)
+			}
+			if sig := c.signature(stamp, toSign); sig != tt.signature {
+				t.Errorf("signature = %s, want %s", sig, tt.signature)
+			}
+		})
+	}
+}
+
+// TestS3Escape checks the URI encoding of paths and query values for signing
+func TestS3Escape(t *testing.T) {
+	tests := []struct {
+		in    string
+		query bool
+		want  string
+	}{
+		{"/dir/file name.txt", false, "/dir/file%20name.txt"},
+		{"a/b", true, "a%2Fb"},
+		{"-_.~", true, "-_.~"},
+		{"ä+=", false, "%C3%A4%2B%3D"},
+	}
+	for _, tt := range tests {
+		if got := s3Escape(tt.in, tt.query); got != tt.want {
+			t.Errorf("s3Escape(%q, %v) = %q, want %q", tt.in, tt.query, got, tt.want)
+		}
+	}
+}
+
+// TestIniSection reads sections of AWS credentials and config files
+func TestIniSection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	data := "# comment\n[default]\naws_access_key_id = AKID\naws_secret_access_key=secret=with=equals\n\n" +
+		"[ profile work ]\nregion = eu-central-1\n[other]\nregion=us-west-2\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		section string
+		want    map[string]string
+	}{
+		{"default", map[string]string{"aws_access_key_id": "AKID", "aws_secret_access_key": "secret=with=equals"}},
+		{"profile work", map[string]string{"region": "eu-central-1"}},
+		{"other", map[string]string{"region": "us-west-2"}},
+		{"missing", nil},
+	}
+	for _, tt := range tests {
+		got := iniSection(path, tt.section)
+		if len(got) != len(tt.want) {
+			t.Errorf("section %q = %v, want %v", tt.section, got, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("section %q: %s = %q, want %q", tt.section, k, got[k], v)
+			}
+		}
+	}
+	if got := iniSection(filepath.Join(t.TempDir(), "none"), "default"); got != nil {
+		t.Errorf("missing file = %v, want nil", got)
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// errOutputTooLarge reports a decrypted file exceeding -max-output
+var errOutputTooLarge = errors.New("output exceeds the maximum size")
+
+// errKDFCost reports a file to decrypt whose key derivation exceeds -max-kdf-cost
+var errKDFCost = errors.New("key derivation too expensive")
+
+// errBusy reports a decryption refused as -max-decrypts are running
+var errBusy = errors.New("too many decryptions running, retry later")
+
+// runServe implements "fileenc serve", an HTTP API encrypting, decrypting
+// and inspecting the request bodies for other services
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	ciphers := addCipherFlags(fs)
+	listen := fs.String("listen", "127.0.0.1:8400", "address to listen on")
+	apiKeys := fs.String("api-keys", "", "file with the accepted API keys, one per line; clients send one as Authorization: Bearer <key>")
+	noAuth := fs.Bool("no-auth", false, "accept requests without an API key, only for a listener reachable by trusted clients")
+	certFile := fs.String("tls-cert", "", "serve HTTPS with this certificate file, needs -tls-key")
+	keyFile := fs.String("tls-key", "", "private key file of -tls-cert")
+	var maxSize, maxOutput byteSize
+	fs.Var(&maxSize, "max-size", "reject request bodies larger than this, e.g. 1G; 0 accepts any size")
+	fs.Var(&maxOutput, "max-output", "abort responses growing larger than this, e.g. 4G, against compressed files expanding on decryption; 0 allows any size")
+	maxKDFCost := fs.Float64("max-kdf-cost", 4, "reject files to decrypt whose key derivation costs more than this many times the default of its KDF, as the uploaded header chooses the memory and time spent; 0 allows any cost")
+	maxDecrypts := fs.Int("max-decrypts", 4, "decrypt at most this many requests at once, others are answered with 503")
+	logs := addLogFlags(fs)
+	eventFlags := addEventFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc serve [flags]")
+		fmt.Fprintln(fs.Output(), "Serves POST /v1/encrypt, /v1/decrypt and /v1/inspect with the key in the X-Fileenc-Key header, until interrupted.")
+		fmt.Fprintln(fs.Output(), "GET /metrics returns Prometheus metrics, GET /v1/health needs no API key.")
+		fs.PrintDefaults()
+	}
+	if len(parseArgs(fs, args)) != 0 || (*certFile == "") != (*keyFile == "") || !(*maxKDFCost >= 0) || *maxDecrypts < 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if err := logs.check(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	log := logs.logger(os.Stderr)
+	if (*apiKeys == "") == !*noAuth {
+		log.Error("use either -api-keys or -no-auth")
+		os.Exit(exitUsage)
+	}
+	opts, err := ciphers.options()
+	if err != nil {
+		log.Error("Invalid cipher settings", "error", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	s := &server{
+		opts: opts, maxSize: int64(maxSize), maxOutput: int64(maxOutput), maxKDFCost: *maxKDFCost,
+		decrypts: make(chan struct{}, *maxDecrypts), logger: log, metrics: newMetrics(),
+	}
+	if s.events, err = eventFlags.open(); err != nil {
+		log.Error("Error opening the event destinations", "error", err)
+		os.Exit(exitUsage)
+	}
+	if *apiKeys != "" {
+		if s.apiKeys, err = readAPIKeys(*apiKeys); err != nil {
+			log.Error("Error reading the API keys", "file", *apiKeys, "error", err)
+			os.Exit(exitIO)
+		}
+	}
+
+	srv := &http.Server{Addr: *listen, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	log.Info("Serving", "address", *listen, "tls", *certFile != "")
+	if *certFile != "" {
+		err = srv.ListenAndServeTLS(*certFile, *keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("Error serving", "error", err)
+		os.Exit(exitIO)
+	}
+}
+
+// readAPIKeys reads the API keys from path, one per line, ignoring empty lines and # comments
+func readAPIKeys(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys [][]byte
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			keys = append(keys, []byte(line))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no API key in %s", path)
+	}
+	return keys, nil
+}
+
+// server serves the API of fileenc serve
+type server struct {
+	opts       []fileenc.Option
+	apiKeys    [][]byte
+	maxSize    int64
+	maxOutput  int64
+	maxKDFCost float64
+	// decrypts holds a token for every running decryption
+	decrypts chan struct{}
+	logger   *slog.Logger
+	events   *events
+	metrics  *metrics
+}
+
+// apiError is the body of a failed request
+type apiError struct {
+	Error    string `json:"error"`
+	ExitCode int    `json:"exit_code,omitempty"`
+}
+
+// apiInfo is the body of an inspect response
+type apiInfo struct {
+	Format      string   `json:"format"`
+	Version     int      `json:"version,omitempty"`
+	Cipher      string   `json:"cipher,omitempty"`
+	KDF         string   `json:"kdf,omitempty"`
+	Compression string   `json:"compression,omitempty"`
+	Recipients  []string `json:"recipients,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	Modified    string   `json:"modified,omitempty"`
+	Armored     bool     `json:"armored,omitempty"`
+	Size        int64    `json:"size"`
+}
+
+// ServeHTTP checks the API key and dispatches the request
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &streamWriter{ResponseWriter: w, limit: s.maxOutput}
+	err := s.serve(sw, r)
+	log := s.logger.With("method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+	if err != nil {
+		log.Error("Request failed", "error", err, "bytes", sw.n, "duration", time.Since(start).Round(time.Millisecond))
+		if sw.started {
+			// The status is sent already, breaking the connection tells the client the body is incomplete
+			panic(http.ErrAbortHandler)
+		}
+		status, code := httpStatus(err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(apiError{Error: err.Error(), ExitCode: code})
+		return
+	}
+	log.Info("Request served", "bytes", sw.n, "duration", time.Since(start).Round(time.Millisecond))
+}
+
+// serve processes a request, the response body is only written on success
+func (s *server) serve(w *streamWriter, r *http.Request) error {
+	// Health checks of orchestrators need no API key
+	if r.URL.Path == "/v1/health" && r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := io.WriteString(w, `{"status":"ok"}`+"\n")
+		return err
+	}
+	if !s.authorized(r) {
+		return errUnauthorized
+	}
+	if r.URL.Path == "/metrics" && r.Method == http.MethodGet {
+		s.metrics.ServeHTTP(w, r)
+		return nil
+	}
+	if r.Method != http.MethodPost {
+		return errNotFound
+	}
+	if s.maxSize > 0 {
+		// Bodies of known length are rejected before anything is sent
+		if r.ContentLength > s.maxSize {
+			return &http.MaxBytesError{Limit: s.maxSize}
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxSize)
+	}
+	switch r.URL.Path {
+	case "/v1/inspect":
+		info, err := fileenc.Inspect(r.Body, r.ContentLength)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(newAPIInfo(info))
+	case "/v1/encrypt", "/v1/decrypt":
+		return s.observe(w, r, r.URL.Path == "/v1/decrypt")
+	}
+	return errNotFound
+}
+
+// observe processes the request, counts it in the metrics and reports it to the events
+func (s *server) observe(w *streamWriter, r *http.Request, decrypt bool) error {
+	operation := "encrypt"
+	if decrypt {
+		operation = "decrypt"
+	}
+	in := &auditCounter{r: r.Body}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{in, r.Body}
+	e, start := event{Operation: operation, File: "-", Output: "-", Client: r.RemoteAddr}, time.Now()
+	s.events.start(s.logger, e)
+	s.metrics.begin()
+	err := s.process(w, r, decrypt)
+	e.BytesIn, e.BytesOut = in.n, w.n
+	s.metrics.observe(operation, e.BytesIn, e.BytesOut, time.Since(start), err)
+	s.events.finish(s.logger, e, start, err)
+	return err
+}
+
+// process streams the request body through encryption or decryption into the
+// response. Decrypted chunks are only sent once they are authenticated, a
+// failure later on breaks the connection.
+func (s *server) process(w *streamWriter, r *http.Request, decrypt bool) error {
+	key := []byte(r.Header.Get(guiKeyHeader))
+	defer clear(key)
+	if len(key) == 0 {
+		return fmt.Errorf("%w: missing %s header", fileenc.ErrInvalidKey, guiKeyHeader)
+	}
+	if !decrypt {
+		if err := checkKeyPolicy(key); err != nil {
+			return err
+		}
+	} else {
+		select {
+		case s.decrypts <- struct{}{}:
+			defer func() { <-s.decrypts }()
+		default:
+			w.Header().Set("Retry-After", "1")
+			return errBusy
+		}
+		if err := s.checkKDF(r); err != nil {
+			return err
+		}
+	}
+	opts := s.opts
+	if decrypt {
+		opts = nil
+	}
+	enc, err := fileenc.New(key, opts...)
+	if err != nil {
+		return err
+	}
+	// Reading the body while the response is written needs full duplex HTTP/1
+	http.NewResponseController(w.ResponseWriter).EnableFullDuplex()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if decrypt {
+		return enc.DecryptContext(r.Context(), w, r.Body)
+	}
+	return enc.EncryptContext(r.Context(), w, r.Body)
+}
+
+// checkKDF rejects files whose key derivation costs more than -max-kdf-cost
+// before the key is derived. The header read is put back in front of the body.
+func (s *server) checkKDF(r *http.Request) error {
+	if s.maxKDFCost == 0 {
+		return nil
+	}
+	var head bytes.Buffer
+	info, err := fileenc.Inspect(io.TeeReader(r.Body, &head), -1)
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&head, r.Body), r.Body}
+	// Damaged headers are reported by the decryption, age files are
+	// limited by age itself
+	if err != nil || info.Format != fileenc.FormatFileenc {
+		return nil
+	}
+	if cost := kdfCost(info.KDF); cost > s.maxKDFCost {
+		return fmt.Errorf("%w: %s costs %.1f times the default, more than -max-kdf-cost %g", errKDFCost, formatKDF(info.KDF), cost, s.maxKDFCost)
+	}
+	return nil
+}
+
+// kdfCost returns the cost of the key derivation relative to the defaults of
+// its KDF, as memory times time so that trading one for the other gains nothing
+func kdfCost(p fileenc.KDFParams) float64 {
+	d, err := fileenc.DefaultKDFParams(p.Name)
+	if err != nil || p.Name == fileenc.KDFNone {
+		return 0
+	}
+	ratio := func(v, def uint32) float64 {
+		return float64(max(v, 1)) / float64(max(def, 1))
+	}
+	switch p.Name {
+	case fileenc.KDFScrypt:
+		// N = 2^Time, the memory grows with N·r and the time with N·r·p
+		return math.Exp2(float64(p.Time)-float64(d.Time)) * ratio(p.Memory, d.Memory) * ratio(uint32(p.Threads), uint32(d.Threads))
+	case fileenc.KDFPBKDF2:
+		return ratio(p.Time, d.Time)
+	}
+	// Argon2id and registered KDFs, the threads share the work
+	return ratio(p.Time, d.Time) * ratio(p.Memory, d.Memory)
+}
+
+// authorized reports whether the request carries one of the API keys
+func (s *server) authorized(r *http.Request) bool {
+	if s.apiKeys == nil {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	match := 0
+	for _, k := range s.apiKeys {
+		match |= subtle.ConstantTimeCompare([]byte(token), k)
+	}
+	return match == 1
+}
+
+var (
+	errUnauthorized = errors.New("missing or unknown API key")
+	errNotFound     = errors.New("unknown endpoint, use POST /v1/encrypt, /v1/decrypt, /v1/inspect or GET /v1/health, /metrics")
+)
+
+// httpStatus returns the HTTP status and the exit code of fileenc for err
+func httpStatus(err error) (int, int) {
+	code := exitCode(err, exitFailure)
+	switch {
+	case errors.Is(err, errUnauthorized):
+		return http.StatusUnauthorized, 0
+	case errors.Is(err, errNotFound):
+		return http.StatusNotFound, 0
+	case errors.As(err, new(*http.MaxBytesError)):
+		return http.StatusRequestEntityTooLarge, 0
+	case errors.Is(err, errKDFCost):
+		return http.StatusUnprocessableEntity, 0
+	case errors.Is(err, errBusy):
+		return http.StatusServiceUnavailable, 0
+	case code == exitWrongKey:
+		return http.StatusForbidden, code
+	case code == exitBadKey, code == exitPolicy:
+		return http.StatusBadRequest, code
+	case code == exitCorrupt:
+		return http.StatusUnprocessableEntity, code
+	}
+	return http.StatusInternalServerError, code
+}
+
+// newAPIInfo converts the description of an encrypted file for the response
+func newAPIInfo(info fileenc.Info) apiInfo {
+	a := apiInfo{
+		Format: info.Format, Version: info.Version, Cipher: info.Cipher, Compression: info.Compression,
+		Recipients: info.Recipients, Name: info.Metadata.Name, Armored: info.Armored, Size: info.Size,
+	}
+	if info.Format == fileenc.FormatFileenc {
+		a.KDF = formatKDF(info.KDF)
+	}
+	if !info.Metadata.ModTime.IsZero() {
+		a.Modified = info.Metadata.ModTime.Format(time.RFC3339)
+	}
+	return a
+}
+
+// streamWriter counts the bytes of the response body, limits them and
+// remembers whether the status was sent
+type streamWriter struct {
+	http.ResponseWriter
+	limit   int64
+	n       int64
+	started bool
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.limit > 0 && w.n+int64(len(p)) > w.limit {
+		return 0, errOutputTooLarge
+	}
+	w.started = true
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
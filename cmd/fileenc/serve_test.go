@@ -0,0 +1,129 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// testServer returns a server without API keys decrypting up to decrypts
+// requests at once
+func testServer(maxKDFCost float64, decrypts int) *server {
+	return &server{
+		maxKDFCost: maxKDFCost, decrypts: make(chan struct{}, decrypts),
+		logger: slog.New(slog.DiscardHandler), metrics: newMetrics(),
+	}
+}
+
+// serveDecrypt posts ciphertext to /v1/decrypt
+func serveDecrypt(s *server, ciphertext []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/v1/decrypt", bytes.NewReader(ciphertext))
+	r.Header.Set(guiKeyHeader, testPass)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// testPass is the passphrase of the encrypted test files
+const testPass = "correct horse battery staple"
+
+// encryptPBKDF2 encrypts plaintext with the given PBKDF2 iterations
+func encryptPBKDF2(t *testing.T, plaintext []byte, iterations uint32, opts ...fileenc.Option) []byte {
+	t.Helper()
+	enc, err := fileenc.New([]byte(testPass), append(opts, fileenc.WithKDF(fileenc.KDFParams{Name: fileenc.KDFPBKDF2, Time: iterations}))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := enc.Encrypt(&out, bytes.NewReader(plaintext)); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// TestKDFCost compares the costs of key derivations to the defaults
+func TestKDFCost(t *testing.T) {
+	tests := []struct {
+		kdf  fileenc.KDFParams
+		cost float64
+	}{
+		{fileenc.KDFParams{Name: fileenc.KDFNone}, 0},
+		{fileenc.KDFParams{Name: fileenc.KDFArgon2id, Time: 3, Memory: 64 * 1024, Threads: 4}, 1},
+		{fileenc.KDFParams{Name: fileenc.KDFArgon2id, Time: 6, Memory: 64 * 1024, Threads: 1}, 2},
+		{fileenc.KDFParams{Name: fileenc.KDFArgon2id, Time: 1, Memory: 768 * 1024, Threads: 4}, 4},
+		{fileenc.KDFParams{Name: fileenc.KDFArgon2id, Time: 1024, Memory: 4 << 20, Threads: 4}, 1024.0 / 3 * 64},
+		{fileenc.KDFParams{Name: fileenc.KDFScrypt, Time: 15, Memory: 8, Threads: 1}, 1},
+		{fileenc.KDFParams{Name: fileenc.KDFScrypt, Time: 17, Memory: 8, Threads: 1}, 4},
+		{fileenc.KDFParams{Name: fileenc.KDFScrypt, Time: 15, Memory: 16, Threads: 2}, 4},
+		{fileenc.KDFParams{Name: fileenc.KDFScrypt, Time: 10, Memory: 8, Threads: 1}, 1.0 / 32},
+		{fileenc.KDFParams{Name: fileenc.KDFPBKDF2, Time: 600000}, 1},
+		{fileenc.KDFParams{Name: fileenc.KDFPBKDF2, Time: 100_000_000}, 1000.0 / 6},
+	}
+	for _, tt := range tests {
+		if got := kdfCost(tt.kdf); math.Abs(got-tt.cost) > 1e-9*tt.cost {
+			t.Errorf("%s: cost %g, want %g", formatKDF(tt.kdf), got, tt.cost)
+		}
+	}
+}
+
+// TestServeKDFCost rejects files to decrypt whose key derivation is too
+// expensive and decrypts the others from the header read for the check
+func TestServeKDFCost(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("fileenc serve "), 10000)
+	s := testServer(0.01, 1)
+	for _, armor := range []bool{false, true} {
+		var opts []fileenc.Option
+		if armor {
+			opts = append(opts, fileenc.WithArmor())
+		}
+		w := serveDecrypt(s, encryptPBKDF2(t, plaintext, 60000, opts...))
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), errKDFCost.Error()) {
+			t.Errorf("armor %t: expensive key derivation answered with %d %s", armor, w.Code, w.Body)
+		}
+		w = serveDecrypt(s, encryptPBKDF2(t, plaintext, 1000, opts...))
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plaintext) {
+			t.Errorf("armor %t: cheap key derivation answered with %d, %d bytes", armor, w.Code, w.Body.Len())
+		}
+	}
+}
+
+// TestServeBusy refuses decryptions beyond -max-decrypts
+func TestServeBusy(t *testing.T) {
+	plaintext := []byte("fileenc serve")
+	ciphertext := encryptPBKDF2(t, plaintext, 1000)
+	s := testServer(4, 1)
+	s.decrypts <- struct{}{}
+	w := serveDecrypt(s, ciphertext)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("decryption beyond the limit answered with %d %s", w.Code, w.Body)
+	}
+	<-s.decrypts
+	if w := serveDecrypt(s, ciphertext); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plaintext) {
+		t.Fatalf("decryption within the limit answered with %d %s", w.Code, w.Body)
+	}
+	if len(s.decrypts) != 0 {
+		t.Fatal("the decryption did not return its token")
+	}
+}
@@ -0,0 +1,785 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/itkonzepte-net/fileenc"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTP version 3 packet types, see draft-ietf-secsh-filexfer-02
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpFstat    = 8
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRemove   = 13
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpExtended = 200
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+)
+
+// SFTP open flags and status codes
+const (
+	sftpFlagRead  = 0x01
+	sftpFlagWrite = 0x02
+	sftpFlagCreat = 0x08
+	sftpFlagTrunc = 0x10
+	sftpFlagExcl  = 0x20
+
+	sftpOK         = 0
+	sftpEOF        = 1
+	sftpNoSuchFile = 2
+	sftpDenied     = 3
+)
+
+const (
+	// sftpChunkSize is the size of the read and write requests
+	sftpChunkSize = 32 << 10
+	// sftpInflight is the number of requests sent without waiting for the answers
+	sftpInflight = 64
+	// sshKeyEnv names a private key file used for SSH besides the agent and the default keys
+	sshKeyEnv = "FILEENC_SSH_KEY"
+)
+
+// sftpClient is a minimal SFTP client for streaming single files
+type sftpClient struct {
+	conn        *ssh.Client
+	w           io.WriteCloser
+	r           *bufio.Reader
+	id          uint32
+	responses   map[uint32][]byte
+	posixRename bool
+}
+
+// dialSFTP connects to the host of u and returns the client and the remote
+// path. Paths starting with /~/ are relative to the home directory. With dir
+// the path names a directory, which may end with a slash or be empty for the
+// home directory.
+func dialSFTP(u *url.URL, dir bool) (*sftpClient, string, error) {
+	name := u.User.Username()
+	if name == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, "", fmt.Errorf("no user name in %s", u.Redacted())
+		}
+		name = current.Username
+	}
+	remote, _ := strings.CutPrefix(u.Path, "/~/")
+	if dir {
+		if remote = strings.TrimSuffix(remote, "/"); remote == "" || remote == "/~" {
+			remote = "."
+		}
+	} else if remote == "" || strings.HasSuffix(remote, "/") {
+		return nil, "", fmt.Errorf("%s is no file, use sftp://user@host/path", u.Redacted())
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	hostKeys, algorithms, err := sshHostKeys(addr)
+	if err != nil {
+		return nil, "", err
+	}
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:              name,
+		Auth:              []ssh.AuthMethod{ssh.PublicKeysCallback(sshSigners)},
+		HostKeyCallback:   hostKeys,
+		HostKeyAlgorithms: algorithms,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	c, err := newSFTPClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("failed to start sftp on %s: %w", addr, err)
+	}
+	return c, remote, nil
+}
+
+// sshHostKeys returns the host key check against ~/.ssh/known_hosts and the
+// key algorithms known for addr, so the server offers a key that can be checked
+func sshHostKeys(addr string) (ssh.HostKeyCallback, []string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	known, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+	check := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := known(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return fmt.Errorf("host key of %s is unknown, connect once with ssh to check and add it", hostname)
+		}
+		return err
+	}
+
+	// Ask with a key that cannot match for the known keys of the host
+	var algorithms []string
+	dummy, _ := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	tcp, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	var keyErr *knownhosts.KeyError
+	if errors.As(known(addr, tcp, dummy), &keyErr) {
+		for _, k := range keyErr.Want {
+			if k.Key.Type() == ssh.KeyAlgoRSA {
+				algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+			}
+			algorithms = append(algorithms, k.Key.Type())
+		}
+	}
+	return check, algorithms, nil
+}
+
+// sshSigners returns the keys of the SSH agent and the unencrypted default
+// key files. Encrypted key files are only unlocked if there is no other key.
+func sshSigners() ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			if s, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, s...)
+			}
+		}
+	}
+
+	home, _ := os.UserHomeDir()
+	paths := []string{os.Getenv(sshKeyEnv)}
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		paths = append(paths, filepath.Join(home, ".ssh", name))
+	}
+	var encrypted []string
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if p == "" || err != nil {
+			continue
+		}
+		s, err := ssh.ParsePrivateKey(data)
+		clear(data)
+		switch {
+		case err == nil:
+			signers = append(signers, s)
+		case errors.As(err, new(*ssh.PassphraseMissingError)):
+			encrypted = append(encrypted, p)
+		}
+	}
+	if len(signers) > 0 {
+		return signers, nil
+	}
+
+	for _, p := range encrypted {
+		pass, err := readPassword("passphrase for "+p, false)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			clear(pass)
+			return nil, err
+		}
+		s, err := ssh.ParsePrivateKeyWithPassphrase(data, pass)
+		clear(pass)
+		clear(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unlock %s: %w", p, err)
+		}
+		signers = append(signers, s)
+	}
+	return signers, nil
+}
+
+// newSFTPClient starts the sftp subsystem on conn
+func newSFTPClient(conn *ssh.Client) (*sftpClient, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, err
+	}
+	c := &sftpClient{conn: conn, w: w, r: bufio.NewReaderSize(r, 64<<10), responses: map[uint32][]byte{}}
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// init negotiates version 3 and notes the extensions of the server
+func (c *sftpClient) init() error {
+	// The init packet carries the version instead of a request id
+	if err := c.writePacket(binary.BigEndian.AppendUint32([]byte{sftpInit}, 3)); err != nil {
+		return err
+	}
+	p, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	if p[0] != sftpVersion {
+		return errors.New("unexpected response to init")
+	}
+	b := sftpBuffer(p[5:])
+	for len(b) > 0 {
+		name, _ := b.string(), b.string()
+		if name == "posix-rename@openssh.com" {
+			c.posixRename = true
+		}
+	}
+	return nil
+}
+
+// Close ends the connection
+func (c *sftpClient) Close() error {
+	c.w.Close()
+	return c.conn.Close()
+}
+
+// writePacket sends the packet with its length
+func (c *sftpClient) writePacket(p []byte) error {
+	_, err := c.w.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(p))), p...))
+	return err
+}
+
+// readPacket reads the next packet without its length. A connection closed
+// by the server is no end of file, the answers are still missing.
+func (c *sftpClient) readPacket() ([]byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(c.r, n[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size < 5 || size > 1<<20 {
+		return nil, errors.New("malformed sftp packet")
+	}
+	p := make([]byte, size)
+	if _, err := io.ReadFull(c.r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// send sends a request with the fields, uint32, uint64, string or []byte, and returns its id
+func (c *sftpClient) send(typ byte, fields ...any) (uint32, error) {
+	c.id++
+	p := binary.BigEndian.AppendUint32([]byte{typ}, c.id)
+	for _, f := range fields {
+		switch v := f.(type) {
+		case uint32:
+			p = binary.BigEndian.AppendUint32(p, v)
+		case uint64:
+			p = binary.BigEndian.AppendUint64(p, v)
+		case string:
+			p = append(binary.BigEndian.AppendUint32(p, uint32(len(v))), v...)
+		case []byte:
+			p = append(binary.BigEndian.AppendUint32(p, uint32(len(v))), v...)
+		}
+	}
+	return c.id, c.writePacket(p)
+}
+
+// recv returns the type and data of the response to request id. Responses to
+// other requests arriving first are kept.
+func (c *sftpClient) recv(id uint32) (byte, sftpBuffer, error) {
+	for {
+		p, ok := c.responses[id]
+		if ok {
+			delete(c.responses, id)
+			return p[0], sftpBuffer(p[5:]), nil
+		}
+		p, err := c.readPacket()
+		if err != nil {
+			return 0, nil, err
+		}
+		// Only answers to requests sent are kept, so a server cannot fill the memory
+		rid := binary.BigEndian.Uint32(p[1:5])
+		if _, dup := c.responses[rid]; dup || rid == 0 || rid > c.id {
+			return 0, nil, fmt.Errorf("unexpected sftp response to request %d", rid)
+		}
+		c.responses[rid] = p
+	}
+}
+
+// call sends a request and waits for its response
+func (c *sftpClient) call(typ byte, fields ...any) (byte, sftpBuffer, error) {
+	id, err := c.send(typ, fields...)
+	if err != nil {
+		return 0, nil, err
+	}
+	return c.recv(id)
+}
+
+// status returns the error of a request answered with a status
+func (c *sftpClient) status(typ byte, b sftpBuffer, err error) error {
+	if err != nil {
+		return err
+	}
+	if typ != sftpStatus {
+		return fmt.Errorf("unexpected sftp response %d", typ)
+	}
+	return b.status()
+}
+
+// open opens the file at name and returns its handle
+func (c *sftpClient) open(name string, flags uint32) (string, error) {
+	// New files are created readable by the owner only, attributes hold the permissions
+	typ, b, err := c.call(sftpOpen, name, flags, uint32(0x04), uint32(0o600))
+	if err != nil {
+		return "", err
+	}
+	if typ != sftpHandle {
+		return "", c.status(typ, b, nil)
+	}
+	return b.string(), nil
+}
+
+// close closes the handle
+func (c *sftpClient) close(handle string) error {
+	return c.status(c.call(sftpClose, handle))
+}
+
+// stat returns the size of the file name or, if handle is set, of the open file
+func (c *sftpClient) stat(name, handle string) (int64, error) {
+	typ, b, err := c.call(sftpFstat, handle)
+	if handle == "" {
+		typ, b, err = c.call(sftpStat, name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if typ != sftpAttrs {
+		return 0, c.status(typ, b, nil)
+	}
+	if b.uint32()&0x01 == 0 {
+		return -1, nil
+	}
+	return int64(b.uint64()), nil
+}
+
+// remove deletes the file name
+func (c *sftpClient) remove(name string) error {
+	return c.status(c.call(sftpRemove, name))
+}
+
+// readDir returns the names of the regular files in the directory name
+func (c *sftpClient) readDir(name string) ([]string, error) {
+	typ, b, err := c.call(sftpOpendir, name)
+	if err != nil {
+		return nil, err
+	}
+	if typ != sftpHandle {
+		return nil, c.status(typ, b, nil)
+	}
+	handle := b.string()
+	defer c.close(handle)
+
+	var names []string
+	for {
+		typ, b, err := c.call(sftpReaddir, handle)
+		if err != nil {
+			return nil, err
+		}
+		if typ != sftpName {
+			if err := c.status(typ, b, nil); err != io.EOF {
+				return nil, err
+			}
+			return names, nil
+		}
+		for n := b.uint32(); n > 0 && len(b) > 0; n-- {
+			file, _ := b.string(), b.string()
+			if mode, ok := b.attrsMode(); !ok || mode&0o170000 == 0o100000 {
+				names = append(names, file)
+			}
+		}
+	}
+}
+
+// rename renames oldName to newName. With overwrite set newName is replaced,
+// with the OpenSSH extension atomically or, without it, by removing it first.
+func (c *sftpClient) rename(oldName, newName string, overwrite bool) error {
+	if !overwrite {
+		return c.status(c.call(sftpRename, oldName, newName))
+	}
+	if c.posixRename {
+		return c.status(c.call(sftpExtended, "posix-rename@openssh.com", oldName, newName))
+	}
+	if err := c.remove(newName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return c.status(c.call(sftpRename, oldName, newName))
+}
+
+// sftpBuffer decodes the fields of a response, missing data reads as zero
+type sftpBuffer []byte
+
+func (b *sftpBuffer) uint32() uint32 {
+	if len(*b) < 4 {
+		*b = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(*b)
+	*b = (*b)[4:]
+	return v
+}
+
+func (b *sftpBuffer) uint64() uint64 {
+	return uint64(b.uint32())<<32 | uint64(b.uint32())
+}
+
+func (b *sftpBuffer) string() string {
+	n := b.uint32()
+	if uint32(len(*b)) < n {
+		*b = nil
+		return ""
+	}
+	s := string((*b)[:n])
+	*b = (*b)[n:]
+	return s
+}
+
+// attrsMode skips the file attributes and returns the permissions and type if they are included
+func (b *sftpBuffer) attrsMode() (uint32, bool) {
+	flags := b.uint32()
+	if flags&0x01 != 0 {
+		b.uint64()
+	}
+	if flags&0x02 != 0 {
+		b.uint32()
+		b.uint32()
+	}
+	var mode uint32
+	if flags&0x04 != 0 {
+		mode = b.uint32()
+	}
+	if flags&0x08 != 0 {
+		b.uint32()
+		b.uint32()
+	}
+	if flags&0x80000000 != 0 {
+		for n := b.uint32(); n > 0 && len(*b) > 0; n-- {
+			b.string()
+			b.string()
+		}
+	}
+	return mode, flags&0x04 != 0
+}
+
+// status returns the error of a status response, nil if it reports success
+func (b *sftpBuffer) status() error {
+	code, msg := b.uint32(), b.string()
+	switch code {
+	case sftpOK:
+		return nil
+	case sftpEOF:
+		return io.EOF
+	case sftpNoSuchFile:
+		return fs.ErrNotExist
+	case sftpDenied:
+		return fs.ErrPermission
+	}
+	if msg == "" {
+		msg = fmt.Sprintf("sftp error %d", code)
+	}
+	return errors.New(msg)
+}
+
+// sftpStorage is the storage backend of sftp://user@host/path URLs
+type sftpStorage struct{}
+
+// Stat returns the size of the remote file at u
+func (sftpStorage) Stat(u *url.URL) (int64, error) {
+	c, name, err := dialSFTP(u, false)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	size, err := c.stat(name, "")
+	if err != nil {
+		return 0, remoteError("stat", u, err)
+	}
+	return size, nil
+}
+
+// Open streams the remote file at u
+func (sftpStorage) Open(u *url.URL) (io.ReadCloser, int64, error) {
+	c, name, err := dialSFTP(u, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	handle, err := c.open(name, sftpFlagRead)
+	if err != nil {
+		c.Close()
+		return nil, 0, remoteError("open", u, err)
+	}
+	size, err := c.stat("", handle)
+	if err != nil {
+		size = -1
+	}
+	return &sftpReader{c: c, handle: handle, stale: map[uint32]bool{}}, size, nil
+}
+
+// sftpReader reads a remote file with several read requests in flight
+type sftpReader struct {
+	c      *sftpClient
+	handle string
+	// offset is where the next request reads, queue holds the requests in flight
+	offset uint64
+	queue  []uint32
+	stale  map[uint32]bool
+	buf    []byte
+	err    error
+}
+
+// Read returns the data of the requests in order
+func (r *sftpReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		for len(r.queue) < sftpInflight {
+			id, err := r.c.send(sftpRead, r.handle, r.offset, uint32(sftpChunkSize))
+			if err != nil {
+				r.err = err
+				return 0, err
+			}
+			r.queue = append(r.queue, id)
+			r.offset += sftpChunkSize
+		}
+		id := r.queue[0]
+		r.queue = r.queue[1:]
+		typ, b, err := r.c.recv(id)
+		switch {
+		case err != nil:
+			r.err = err
+		case typ == sftpData:
+			r.buf = []byte(b.string())
+			if len(r.buf) == 0 || len(r.buf) > sftpChunkSize {
+				// The end is reported by status, an empty answer would read forever
+				r.buf, r.err = nil, errors.New("malformed sftp read response")
+				continue
+			}
+			if len(r.buf) < sftpChunkSize {
+				// A short read leaves a gap, read again from its end
+				r.offset -= uint64(len(r.queue)+1) * sftpChunkSize
+				r.offset += uint64(len(r.buf))
+				for _, id := range r.queue {
+					r.stale[id] = true
+				}
+				r.queue = r.queue[:0]
+			}
+		default:
+			r.err = r.c.status(typ, b, nil)
+			if r.err == nil {
+				r.err = errors.New("unexpected sftp response")
+			}
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close closes the file and the connection
+func (r *sftpReader) Close() error {
+	// Wait for the requests in flight so the answer to close is not mixed up
+	for _, id := range r.queue {
+		r.c.recv(id)
+	}
+	for id := range r.stale {
+		r.c.recv(id)
+	}
+	r.c.close(r.handle)
+	return r.c.Close()
+}
+
+// List returns the names of the files in the remote directory at u
+func (sftpStorage) List(u *url.URL) ([]string, error) {
+	c, dir, err := dialSFTP(u, true)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	names, err := c.readDir(dir)
+	if err != nil {
+		return nil, remoteError("list", u, err)
+	}
+	return names, nil
+}
+
+// Remove deletes the remote file at u
+func (sftpStorage) Remove(u *url.URL) error {
+	c, name, err := dialSFTP(u, false)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.remove(name); err != nil {
+		return remoteError("remove", u, err)
+	}
+	return nil
+}
+
+// Create writes the remote file at u. The data goes to a temporary file
+// next to it, which is renamed on Close.
+func (sftpStorage) Create(u *url.URL, overwrite bool) (fileenc.StorageWriter, error) {
+	c, name, err := dialSFTP(u, false)
+	if err != nil {
+		return nil, err
+	}
+	if !overwrite {
+		if _, err := c.stat(name, ""); err == nil {
+			c.Close()
+			return nil, remoteError("create", u, fmt.Errorf("%w, overwrite is disabled", fileenc.ErrFileExists))
+		}
+	}
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	tmp := path.Join(path.Dir(name), "."+path.Base(name)+"."+hex.EncodeToString(suffix)+".tmp")
+	handle, err := c.open(tmp, sftpFlagWrite|sftpFlagCreat|sftpFlagTrunc|sftpFlagExcl)
+	if err != nil {
+		c.Close()
+		return nil, remoteError("create", u, err)
+	}
+	return &sftpWriter{c: c, u: u, name: name, tmp: tmp, handle: handle, overwrite: overwrite}, nil
+}
+
+// sftpWriter writes a remote file with several write requests in flight
+type sftpWriter struct {
+	c         *sftpClient
+	u         *url.URL
+	name      string
+	tmp       string
+	handle    string
+	overwrite bool
+	offset    uint64
+	inflight  []uint32
+}
+
+// Write sends p in chunks, waiting for answers once enough requests are in flight
+func (w *sftpWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), sftpChunkSize)]
+		id, err := w.c.send(sftpWrite, w.handle, w.offset, chunk)
+		if err != nil {
+			return n, remoteError("write", w.u, err)
+		}
+		w.inflight = append(w.inflight, id)
+		w.offset += uint64(len(chunk))
+		n += len(chunk)
+		p = p[len(chunk):]
+		if len(w.inflight) >= sftpInflight {
+			if err := w.wait(1); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// wait waits for the answers to the oldest n writes in flight
+func (w *sftpWriter) wait(n int) error {
+	for range n {
+		id := w.inflight[0]
+		w.inflight = w.inflight[1:]
+		if err := w.c.status(w.c.recv(id)); err != nil {
+			return remoteError("write", w.u, err)
+		}
+	}
+	return nil
+}
+
+// Close waits for the outstanding writes and renames the temporary file
+func (w *sftpWriter) Close() error {
+	if err := w.wait(len(w.inflight)); err != nil {
+		return err
+	}
+	if err := w.c.close(w.handle); err != nil {
+		return remoteError("close", w.u, err)
+	}
+	w.handle = ""
+	// Plain renames fail if the file was created in the meantime
+	if err := w.c.rename(w.tmp, w.name, w.overwrite); err != nil {
+		return remoteError("rename", w.u, err)
+	}
+	w.tmp = ""
+	return w.c.Close()
+}
+
+// Abort removes the temporary file and ends the connection
+func (w *sftpWriter) Abort() {
+	if w.tmp != "" {
+		for _, id := range w.inflight {
+			w.c.recv(id)
+		}
+		if w.handle != "" {
+			w.c.close(w.handle)
+		}
+		w.c.remove(w.tmp)
+	}
+	w.c.Close()
+}
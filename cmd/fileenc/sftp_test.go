@@ -0,0 +1,211 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+// nopWriteCloser collects the packets sent by the client
+type nopWriteCloser struct{ bytes.Buffer }
+
+func (*nopWriteCloser) Close() error { return nil }
+
+// testSFTPClient returns a client reading the server packets from server and
+// the buffer with the packets it sends
+func testSFTPClient(server []byte) (*sftpClient, *nopWriteCloser) {
+	w := &nopWriteCloser{}
+	return &sftpClient{w: w, r: bufio.NewReader(bytes.NewReader(server)), responses: map[uint32][]byte{}}, w
+}
+
+// sftpPacket frames a packet of typ with the fields like the server does
+func sftpPacket(typ byte, fields ...any) []byte {
+	p := []byte{typ}
+	for _, f := range fields {
+		switch v := f.(type) {
+		case uint32:
+			p = binary.BigEndian.AppendUint32(p, v)
+		case uint64:
+			p = binary.BigEndian.AppendUint64(p, v)
+		case string:
+			p = append(binary.BigEndian.AppendUint32(p, uint32(len(v))), v...)
+		case []byte:
+			p = append(p, v...)
+		}
+	}
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(p))), p...)
+}
+
+// TestSFTPSend checks the framing and field encoding of requests
+func TestSFTPSend(t *testing.T) {
+	c, w := testSFTPClient(nil)
+	id, err := c.send(sftpOpen, "a", uint32(2), uint64(3), []byte{4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 1 {
+		t.Errorf("id = %d, want 1", id)
+	}
+	want := []byte{0, 0, 0, 27, sftpOpen, 0, 0, 0, 1, 0, 0, 0, 1, 'a', 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 1, 4}
+	if !bytes.Equal(w.Bytes(), want) {
+		t.Errorf("packet = %v, want %v", w.Bytes(), want)
+	}
+}
+
+// TestSFTPReadPacket feeds short, oversized and truncated packets
+func TestSFTPReadPacket(t *testing.T) {
+	tests := []struct {
+		name   string
+		server []byte
+	}{
+		{"empty", nil},
+		{"short length", []byte{0, 0}},
+		{"too small", []byte{0, 0, 0, 4, sftpStatus, 0, 0, 0}},
+		{"too large", []byte{0x7f, 0xff, 0xff, 0xff}},
+		{"truncated", []byte{0, 0, 0, 9, sftpStatus, 0, 0, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testSFTPClient(tt.server)
+			p, err := c.readPacket()
+			if err == nil {
+				t.Fatalf("packet %v read, want an error", p)
+			}
+			if err == io.EOF {
+				t.Error("closed connection reported as end of file")
+			}
+		})
+	}
+}
+
+// TestSFTPBuffer decodes fields from complete and garbled responses, missing
+// data reads as zero
+func TestSFTPBuffer(t *testing.T) {
+	b := sftpBuffer{0, 0, 0, 5, 'a', 'b'}
+	if s := b.string(); s != "" || b != nil {
+		t.Errorf("string longer than the data = %q, rest %v", s, b)
+	}
+	b = sftpBuffer{0, 0, 0, 1, 0, 0, 0, 2, 0xff}
+	if v := b.uint64(); v != 1<<32|2 {
+		t.Errorf("uint64 = %d", v)
+	}
+	if v := b.uint32(); v != 0 || b != nil {
+		t.Errorf("short uint32 = %d, rest %v", v, b)
+	}
+
+	// Attributes with every flag but truncated data
+	b = sftpBuffer{0x80, 0, 0, 0x0f, 0, 0, 0, 0}
+	if mode, ok := b.attrsMode(); mode != 0 || !ok {
+		t.Errorf("truncated attributes = %o, %v", mode, ok)
+	}
+	b = sftpBuffer{0x80, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 1}
+	b.attrsMode()
+
+	statuses := []struct {
+		data []byte
+		want error
+	}{
+		{[]byte{0, 0, 0, sftpOK}, nil},
+		{[]byte{0, 0, 0, sftpEOF}, io.EOF},
+		{[]byte{0, 0, 0, sftpNoSuchFile}, fs.ErrNotExist},
+		{[]byte{0, 0, 0, sftpDenied}, fs.ErrPermission},
+		{[]byte{0, 0}, nil},
+	}
+	for _, tt := range statuses {
+		b := sftpBuffer(tt.data)
+		if err := b.status(); !errors.Is(err, tt.want) {
+			t.Errorf("status %v = %v, want %v", tt.data, err, tt.want)
+		}
+	}
+	b = sftpBuffer{0, 0, 0, 4, 0, 0, 0, 9, 'f'}
+	if err := b.status(); err == nil || err.Error() != "sftp error 4" {
+		t.Errorf("failure with truncated message = %v", err)
+	}
+}
+
+// TestSFTPInit negotiates the version with well-formed and garbled answers
+func TestSFTPInit(t *testing.T) {
+	c, _ := testSFTPClient(sftpPacket(sftpVersion, uint32(3), "posix-rename@openssh.com", "1", "other@example.com", "1"))
+	if err := c.init(); err != nil || !c.posixRename {
+		t.Errorf("init = %v, posix rename %v", err, c.posixRename)
+	}
+	c, _ = testSFTPClient(sftpPacket(sftpVersion, uint32(3), []byte{0, 0, 0xff, 0xff, 'x'}))
+	if err := c.init(); err != nil {
+		t.Errorf("init with garbled extensions = %v", err)
+	}
+	c, _ = testSFTPClient(sftpPacket(sftpStatus, uint32(0), uint32(sftpOK)))
+	if err := c.init(); err == nil {
+		t.Error("init answered by a status succeeded")
+	}
+}
+
+// TestSFTPRecv refuses answers to requests that were never sent
+func TestSFTPRecv(t *testing.T) {
+	c, _ := testSFTPClient(sftpPacket(sftpStatus, uint32(7), uint32(sftpOK)))
+	c.send(sftpClose, "h")
+	if _, _, err := c.recv(1); err == nil {
+		t.Error("answer to an unknown request accepted")
+	}
+
+	c, _ = testSFTPClient(append(sftpPacket(sftpStatus, uint32(2), uint32(sftpOK)), sftpPacket(sftpHandle, uint32(1), "h")...))
+	c.send(sftpOpen, "a")
+	c.send(sftpClose, "b")
+	if typ, b, err := c.recv(1); err != nil || typ != sftpHandle || b.string() != "h" {
+		t.Errorf("recv(1) = %d, %v", typ, err)
+	}
+	if err := c.status(c.recv(2)); err != nil {
+		t.Errorf("recv(2) = %v", err)
+	}
+}
+
+// TestSFTPReader reads a file answered in a short chunk and the end, and
+// fails on garbled or missing answers instead of ending early
+func TestSFTPReader(t *testing.T) {
+	server := append(sftpPacket(sftpData, uint32(1), "hello"), sftpPacket(sftpStatus, uint32(sftpInflight+1), uint32(sftpEOF))...)
+	c, _ := testSFTPClient(server)
+	data, err := io.ReadAll(&sftpReader{c: c, handle: "h", stale: map[uint32]bool{}})
+	if err != nil || string(data) != "hello" {
+		t.Errorf("read %q, %v", data, err)
+	}
+
+	tests := []struct {
+		name   string
+		server []byte
+	}{
+		{"closed", nil},
+		{"closed after data", sftpPacket(sftpData, uint32(1), "hello")},
+		{"empty data", sftpPacket(sftpData, uint32(1), "")},
+		{"truncated data", sftpPacket(sftpData, uint32(1), []byte{0, 0, 0xff, 0xff, 'x'})},
+		{"oversized data", sftpPacket(sftpData, uint32(1), string(make([]byte, sftpChunkSize+1)))},
+		{"unexpected type", sftpPacket(sftpHandle, uint32(1), "h")},
+		{"status ok", sftpPacket(sftpStatus, uint32(1), uint32(sftpOK))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testSFTPClient(tt.server)
+			if _, err := io.ReadAll(&sftpReader{c: c, handle: "h", stale: map[uint32]bool{}}); err == nil {
+				t.Error("read succeeded")
+			}
+		})
+	}
+}
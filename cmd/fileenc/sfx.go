@@ -0,0 +1,261 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// sfxMagic ends a self-decrypting executable, preceded by the length of the
+// encrypted payload as 8 byte big endian number
+const sfxMagic = "FILEENC-SFX-1\n\x00\x00"
+
+// sfxTrailerSize is the length of the payload length and the magic
+const sfxTrailerSize = 8 + len(sfxMagic)
+
+// runSFX implements "fileenc sfx [options] <file>", writing an executable
+// that decrypts the embedded file after asking for the passphrase
+func runSFX(args []string) {
+	fs := flag.NewFlagSet("sfx", flag.ExitOnError)
+	keys := addKeyFlags(fs, false)
+	ciphers := addCipherFlags(fs)
+	metadata := addMetadataFlags(fs)
+	output := fs.String("o", "", "executable to create, default <file>.sfx or <file>.exe for a Windows stub")
+	stub := fs.String("stub", "", "fileenc executable for the target OS, e.g. fileenc.exe for Windows recipients; default this one")
+	overwrite := fs.Bool("overwrite", false, "replace an existing executable")
+	quiet := fs.Bool("quiet", false, "only report errors, no progress or success messages")
+	progressFlag := fs.String("progress", progressAuto, "progress reporting on stderr: auto (bar on terminals), bar, json or none")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc sfx [options] <file>")
+		fmt.Fprintln(fs.Output(), "Writes an executable holding the encrypted file that decrypts it after asking for the passphrase.")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *stub == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error locating fileenc: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		*stub = exe
+	}
+	stubData, err := os.ReadFile(*stub)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading stub: %v\n", err)
+		os.Exit(exitIO)
+	}
+	if bytes.HasSuffix(stubData, []byte(sfxMagic)) {
+		fmt.Fprintln(os.Stderr, "The stub already holds an encrypted file, use a plain fileenc executable")
+		os.Exit(exitUsage)
+	}
+	if *output == "" {
+		*output = args[0] + ".sfx"
+		// Windows executables start with MZ and need the .exe extension
+		if bytes.HasPrefix(stubData, []byte("MZ")) {
+			*output = args[0] + ".exe"
+		}
+	}
+
+	opts, err := ciphers.options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	opts = append(opts, metadata.options()...)
+	enc, key := newEncryptor(keys, false, *progressFlag, *quiet, opts)
+	err = writeSFX(enc, stubData, args[0], *output, *overwrite)
+	clear(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating %s: %v\n", *output, err)
+		os.Exit(exitCode(err, exitFailure))
+	}
+	if !*quiet {
+		fmt.Printf("File %s encrypted to the executable %s successfully.\n", args[0], *output)
+	}
+}
+
+// writeSFX encrypts src behind the stub into the executable dst. The output is
+// assembled in a temporary file and renamed once it is complete.
+func writeSFX(enc *fileenc.Encryptor, stub []byte, src, dst string, overwrite bool) error {
+	if !overwrite {
+		if _, err := os.Lstat(dst); err == nil {
+			return fmt.Errorf("%w: %s, overwrite is disabled", fileenc.ErrFileExists, dst)
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// EncryptFile stores the metadata of src, the payload is copied from its output
+	payload := tmp.Name() + ".payload"
+	if err := enc.EncryptFile(src, payload); err != nil {
+		return err
+	}
+	defer os.Remove(payload)
+	p, err := os.Open(payload)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	if _, err := tmp.Write(stub); err != nil {
+		return fmt.Errorf("failed to write executable: %w", err)
+	}
+	n, err := io.Copy(tmp, p)
+	if err != nil {
+		return fmt.Errorf("failed to write executable: %w", err)
+	}
+	trailer := binary.BigEndian.AppendUint64(nil, uint64(n))
+	if _, err := tmp.Write(append(trailer, sfxMagic...)); err != nil {
+		return fmt.Errorf("failed to write executable: %w", err)
+	}
+	if err := tmp.Chmod(0755); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write executable: %w", err)
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// sfxPayload returns the encrypted file appended to the running executable,
+// false for a plain fileenc executable
+func sfxPayload() (*os.File, *io.SectionReader, bool) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, nil, false
+	}
+	file, err := os.Open(exe)
+	if err != nil {
+		return nil, nil, false
+	}
+	info, err := file.Stat()
+	trailer := make([]byte, sfxTrailerSize)
+	if err != nil || info.Size() < int64(sfxTrailerSize) {
+		file.Close()
+		return nil, nil, false
+	}
+	if _, err := file.ReadAt(trailer, info.Size()-int64(sfxTrailerSize)); err != nil || string(trailer[8:]) != sfxMagic {
+		file.Close()
+		return nil, nil, false
+	}
+	size := int64(binary.BigEndian.Uint64(trailer))
+	if size <= 0 || size > info.Size()-int64(sfxTrailerSize) {
+		file.Close()
+		return nil, nil, false
+	}
+	return file, io.NewSectionReader(file, info.Size()-int64(sfxTrailerSize)-size, size), true
+}
+
+// runSelfDecrypt decrypts the embedded payload next to the executable, or into
+// the directory given as argument, and returns the exit code
+func runSelfDecrypt(payload *io.SectionReader, args []string) int {
+	// A console opened by double-clicking closes on exit, keep the result readable
+	if runtime.GOOS == "windows" {
+		defer func() {
+			fmt.Print("Press Enter to close.")
+			bufio.NewReader(os.Stdin).ReadString('\n')
+		}()
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error locating the executable: %v\n", err)
+		return exitFailure
+	}
+	dir := filepath.Dir(exe)
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	info, err := fileenc.Inspect(io.NewSectionReader(payload, 0, payload.Size()), payload.Size())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading the encrypted file: %v\n", err)
+		return exitCode(err, exitCorrupt)
+	}
+	name := filepath.Base(info.Metadata.Name)
+	if info.Metadata.Name == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+		name = strings.TrimSuffix(filepath.Base(exe), filepath.Ext(exe))
+	}
+	dst := filepath.Join(dir, name)
+	fmt.Printf("This program decrypts %s.\n", dst)
+
+	key, err := readPassword("passphrase", false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	defer clear(key)
+	enc, err := fileenc.New(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitCode(err, exitUsage)
+	}
+	if err := decryptPayload(enc, payload, dst, info.Metadata); err != nil {
+		fmt.Fprintf(os.Stderr, "Error decrypting %s: %v\n", dst, err)
+		return exitCode(err, exitFailure)
+	}
+	fmt.Printf("File %s decrypted successfully.\n", dst)
+	return 0
+}
+
+// decryptPayload decrypts payload to the new file dst and restores the stored
+// modification time and permissions, dst is removed on failure
+func decryptPayload(enc *fileenc.Encryptor, payload io.Reader, dst string, md fileenc.Metadata) error {
+	mode := md.Mode
+	if mode == 0 {
+		mode = 0600
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w: %s", fileenc.ErrFileExists, dst)
+	}
+	if err != nil {
+		return err
+	}
+	if err := enc.Decrypt(out, payload); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	if !md.ModTime.IsZero() {
+		os.Chtimes(dst, md.ModTime, md.ModTime)
+	}
+	return nil
+}
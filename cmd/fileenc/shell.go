@@ -0,0 +1,70 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// runInstallShell implements "fileenc install-shell [-uninstall]", adding
+// encrypt and decrypt entries to the context menu of the file manager
+func runInstallShell(args []string) {
+	fs := flag.NewFlagSet("install-shell", flag.ExitOnError)
+	uninstall := fs.Bool("uninstall", false, "remove the entries again")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc install-shell [-uninstall]")
+		fmt.Fprintln(fs.Output(), "Adds \"Encrypt with fileenc\" and \"Decrypt with fileenc\" to the context menu of the file manager.")
+		fs.PrintDefaults()
+	}
+	if len(parseArgs(fs, args)) != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if *uninstall {
+		removed, err := uninstallShell()
+		for _, entry := range removed {
+			fmt.Printf("Removed %s\n", entry)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing the context menu entries: %v\n", err)
+			os.Exit(exitIO)
+		}
+		return
+	}
+
+	// The entries call this executable, so it should not be moved afterwards
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error locating fileenc: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	added, err := installShell(exe)
+	for _, entry := range added {
+		fmt.Printf("Added %s\n", entry)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error adding the context menu entries: %v\n", err)
+		os.Exit(exitIO)
+	}
+}
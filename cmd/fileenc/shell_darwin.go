@@ -0,0 +1,32 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import "errors"
+
+// errNoShellIntegration is returned as Finder extensions cannot be installed from the command line
+var errNoShellIntegration = errors.New("not supported on macOS, create a Quick Action in Automator running fileenc -dialog -encrypt instead")
+
+// installShell is not supported on macOS
+func installShell(exe string) ([]string, error) {
+	return nil, errNoShellIntegration
+}
+
+// uninstallShell is not supported on macOS
+func uninstallShell() ([]string, error) {
+	return nil, errNoShellIntegration
+}
@@ -0,0 +1,121 @@
+//go:build !darwin && !windows
+
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shellEntry is a file written for the integration into the file managers
+type shellEntry struct {
+	// dir is the location below the XDG data directory
+	dir     string
+	name    string
+	content string
+	mode    os.FileMode
+}
+
+// shellEntries returns the Dolphin service menu with the encrypt and decrypt
+// actions and the Nautilus scripts running exe
+func shellEntries(exe string) []shellEntry {
+	quoted := "'" + strings.ReplaceAll(exe, "'", `'\''`) + "'"
+	return []shellEntry{
+		{dir: "kio/servicemenus", name: "fileenc.desktop", mode: 0755, content: `[Desktop Entry]
+Type=Service
+MimeType=all/allfiles;
+Actions=encrypt;decrypt;
+X-KDE-Submenu=fileenc
+
+[Desktop Action encrypt]
+Name=Encrypt with fileenc
+Icon=document-encrypt
+Exec=` + quoted + ` -dialog -encrypt %F
+
+[Desktop Action decrypt]
+Name=Decrypt with fileenc
+Icon=document-decrypt
+Exec=` + quoted + ` -dialog -decrypt %F
+`},
+		{dir: "nautilus/scripts", name: "Encrypt with fileenc", mode: 0755,
+			content: "#!/bin/sh\nexec " + quoted + " -dialog -encrypt -- \"$@\"\n"},
+		{dir: "nautilus/scripts", name: "Decrypt with fileenc", mode: 0755,
+			content: "#!/bin/sh\nexec " + quoted + " -dialog -decrypt -- \"$@\"\n"},
+	}
+}
+
+// dataHome returns the XDG data directory, ~/.local/share by default
+func dataHome() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+// installShell writes the service menu and scripts and returns their paths
+func installShell(exe string) ([]string, error) {
+	base, err := dataHome()
+	if err != nil {
+		return nil, err
+	}
+	var added []string
+	for _, e := range shellEntries(exe) {
+		dir := filepath.Join(base, e.dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return added, fmt.Errorf("failed to create directory: %w", err)
+		}
+		path := filepath.Join(dir, e.name)
+		if err := os.WriteFile(path, []byte(e.content), e.mode); err != nil {
+			return added, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		// WriteFile keeps the mode of an existing file
+		if err := os.Chmod(path, e.mode); err != nil {
+			return added, err
+		}
+		added = append(added, path)
+	}
+	return added, nil
+}
+
+// uninstallShell removes the service menu and scripts and returns their paths
+func uninstallShell() ([]string, error) {
+	base, err := dataHome()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, e := range shellEntries("") {
+		path := filepath.Join(base, e.dir, e.name)
+		if err := os.Remove(path); errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
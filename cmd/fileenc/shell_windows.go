@@ -0,0 +1,82 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// shellKey is a context menu entry in the registry of the current user
+type shellKey struct {
+	key     string
+	label   string
+	command string
+}
+
+// shellKeys returns the entries encrypting every file and decrypting .enc files with exe
+func shellKeys(exe string) []shellKey {
+	return []shellKey{
+		{key: `HKCU\Software\Classes\*\shell\fileenc.encrypt`, label: "Encrypt with fileenc",
+			command: `"` + exe + `" -dialog -encrypt -- "%1"`},
+		{key: `HKCU\Software\Classes\SystemFileAssociations\` + encExt + `\shell\fileenc.decrypt`, label: "Decrypt with fileenc",
+			command: `"` + exe + `" -dialog -decrypt -- "%1"`},
+	}
+}
+
+// reg runs reg.exe with args
+func reg(args ...string) error {
+	if out, err := exec.Command("reg", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("reg %s failed: %v %s", args[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// installShell adds the registry keys and returns their names
+func installShell(exe string) ([]string, error) {
+	var added []string
+	for _, k := range shellKeys(exe) {
+		if err := reg("add", k.key, "/ve", "/d", k.label, "/f"); err != nil {
+			return added, err
+		}
+		if err := reg("add", k.key, "/v", "Icon", "/d", exe, "/f"); err != nil {
+			return added, err
+		}
+		if err := reg("add", k.key+`\command`, "/ve", "/d", k.command, "/f"); err != nil {
+			return added, err
+		}
+		added = append(added, k.key)
+	}
+	return added, nil
+}
+
+// uninstallShell removes the registry keys and returns their names
+func uninstallShell() ([]string, error) {
+	var removed []string
+	for _, k := range shellKeys("") {
+		// A missing key is not an error
+		if exec.Command("reg", "query", k.key).Run() != nil {
+			continue
+		}
+		if err := reg("delete", k.key, "/f"); err != nil {
+			return removed, err
+		}
+		removed = append(removed, k.key)
+	}
+	return removed, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// runShred implements "fileenc shred [-passes N] <file>..."
+func runShred(args []string) {
+	fs := flag.NewFlagSet("shred", flag.ExitOnError)
+	passes := fs.Int("passes", fileenc.DefaultShredPasses, "number of random overwrites")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc shred [-passes N] <file>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	for _, path := range fs.Args() {
+		if err := fileenc.Shred(path, *passes); err != nil {
+			fmt.Fprintf(os.Stderr, "Error shredding %s: %v\n", path, err)
+			continue
+		}
+		fmt.Printf("File %s shredded successfully.\n", path)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// streamBufferSize is the size of the stdout buffer in pipeline mode
+const streamBufferSize = 256 * 1024
+
+// runStream encrypts or decrypts stdin to stdout. When decrypting, plaintext
+// written before an authentication error has been authenticated. Once ctx is
+// done the output written so far is flushed and the error of ctx returned.
+// The operation is recorded in the audit log and reported to the events of t.
+func runStream(ctx context.Context, enc *fileenc.Encryptor, t task) error {
+	read, written := &auditCounter{r: os.Stdin}, &auditCounter{w: os.Stdout}
+	start := time.Now()
+	log := t.logger(os.Stderr)
+	t.events.start(log, event{Operation: t.operation(), File: "-", Output: "-"})
+	var in io.Reader = read
+	if t.progress != nil {
+		in = fileenc.NewProgressReader(read, "stdin", -1, 200*time.Millisecond, t.progress.update)
+		defer t.progress.clear()
+	}
+	out := bufio.NewWriterSize(written, streamBufferSize)
+
+	var err error
+	if t.decrypt {
+		err = enc.DecryptContext(ctx, out, in)
+	} else {
+		err = enc.EncryptContext(ctx, out, in)
+	}
+	if ferr := out.Flush(); err == nil && ferr != nil {
+		err = fmt.Errorf("failed to write output: %w", ferr)
+	}
+	if t.audit != nil {
+		if aerr := t.audit.record(t.operation(), "-", "-", read.n, written.n, start, err); err == nil {
+			err = aerr
+		}
+	}
+	t.events.finish(log, event{Operation: t.operation(), File: "-", Output: "-", BytesIn: read.n, BytesOut: written.n}, start, err)
+	return err
+}
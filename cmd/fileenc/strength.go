@@ -0,0 +1,296 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// weakPassphraseBits is the estimated entropy below which a passphrase
+// entered at the prompt is reported as weak
+const weakPassphraseBits = 50
+
+// warnEntropy is the threshold of the warning, -min-entropy raises it
+var warnEntropy float64 = weakPassphraseBits
+
+// warnWeakPassphrase warns on stderr if the new passphrase name typed at the
+// prompt is estimated weaker than warnEntropy
+func warnWeakPassphrase(name string, pass []byte) {
+	if bits := passphraseEntropy(pass); bits < warnEntropy {
+		fmt.Fprintf(os.Stderr, "Warning: the %s is %s, about %.0f bits estimated, at least %.0f are recommended; "+
+			"several random words or a longer mix of characters are stronger. Press Ctrl-C to choose another.\n",
+			name, strengthLabel(bits), bits, warnEntropy)
+	}
+}
+
+// checkEntropy rejects a passphrase given non-interactively if it is
+// estimated weaker than minBits
+func checkEntropy(name string, pass []byte, minBits float64) error {
+	if minBits <= 0 {
+		return nil
+	}
+	if bits := passphraseEntropy(pass); bits < minBits {
+		return fmt.Errorf("%w: the %s is %s, about %.0f bits estimated, -min-entropy requires %.0f", fileenc.ErrInvalidKey, name, strengthLabel(bits), bits, minBits)
+	}
+	return nil
+}
+
+// commonWords are frequent passwords and words, ordered by how early an
+// attacker tries them. The list is short, it catches the worst choices.
+var commonWords = strings.Fields(`
+password 123456 12345678 qwerty abc123 monkey 1234567 letmein trustno1 dragon baseball
+iloveyou master sunshine ashley bailey passw0rd shadow 123123 654321 superman qazwsx michael
+football welcome jesus ninja mustang password1 admin login princess starwars solo hello
+freedom whatever charlie donald aa123456 batman zaq1zaq1 access flower hottie loveme
+secret summer winter spring autumn hunter ranger buster soccer hockey killer george
+thomas jordan harley robert matthew daniel andrew joshua pepper ginger cookie cheese
+computer internet server system default changeme guest root test user office company
+london berlin paris america germany england orange banana apple purple yellow silver
+golden diamond tiger lion eagle falcon phoenix angel devil heaven love money family
+friend friends happy lucky magic music summer1 soccer1 pass word key door house home
+secret1 blink fuckyou asshole pussy mother father sister brother baby sweet honey
+chocolate coffee pizza monday friday sunday january february march april june july
+august september october november december spring1 winter1 qwertz azerty asdf zxcv
+hallo passwort geheim schatz sommer fussball liebe dragon1 master1 admin1 letmein1
+the and for you that with this from have are not but all can her was one our out
+day get has him his how man new now old see two way who boy did its let put say she
+too use time year people good first water long little very after word just where most
+know take than them well only come work life over think also back could should would
+correct horse battery staple
+`)
+
+// commonRanks maps the common words to their position in commonWords
+var commonRanks = func() map[string]int {
+	ranks := make(map[string]int, len(commonWords))
+	for i, w := range commonWords {
+		if _, ok := ranks[w]; !ok {
+			ranks[w] = i + 1
+		}
+	}
+	return ranks
+}()
+
+// keyboardRows are the rows of common keyboard layouts, walks along them are easy to guess
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm", "qwertzuiop", "yxcvbnm", "azertyuiop", "qsdfghjklm", "wxcvbn"}
+
+// leet maps the common substitutions to their letters
+var leet = map[rune]rune{'4': 'a', '@': 'a', '3': 'e', '1': 'i', '!': 'i', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't'}
+
+// passphraseEntropy estimates the entropy of pass in bits like zxcvbn: pass is
+// split into the parts an attacker guesses cheapest, common words with
+// capitals and substitutions, sequences, repeats, keyboard walks and years,
+// and the rest is guessed character by character.
+func passphraseEntropy(pass []byte) float64 {
+	runes := []rune(string(pass))
+	n := len(runes)
+	if n == 0 {
+		return 0
+	}
+	perChar := math.Log2(float64(cardinality(runes)))
+	if n > 128 {
+		// Long passphrases are strong anyway, spare the quadratic search
+		return float64(n) * perChar
+	}
+	lower := []rune(strings.ToLower(string(runes)))
+	if len(lower) != n {
+		lower = runes
+	}
+
+	// best[j] is the cheapest way to guess the first j characters
+	best := make([]float64, n+1)
+	for j := 1; j <= n; j++ {
+		best[j] = best[j-1] + perChar
+		for i := 0; i < j-2; i++ {
+			if bits, ok := patternBits(runes[i:j], lower[i:j]); ok {
+				best[j] = min(best[j], best[i]+bits)
+			}
+		}
+	}
+	return best[n]
+}
+
+// patternBits returns the bits needed to guess part if it is a pattern of at
+// least three characters
+func patternBits(part, lower []rune) (float64, bool) {
+	bits := math.Inf(1)
+	if b, ok := wordBits(part, lower); ok {
+		bits = min(bits, b)
+	}
+	if b, ok := sequenceBits(lower); ok {
+		bits = min(bits, b)
+	}
+	if b, ok := repeatBits(part); ok {
+		bits = min(bits, b)
+	}
+	if b, ok := keyboardBits(lower); ok {
+		bits = min(bits, b)
+	}
+	if len(part) == 4 && (string(part[:2]) == "19" || string(part[:2]) == "20") && isDigits(part) {
+		bits = min(bits, math.Log2(200))
+	}
+	return bits, !math.IsInf(bits, 1)
+}
+
+// wordBits guesses part as a common word, plus a bit per substitution and the capitalization
+func wordBits(part, lower []rune) (float64, bool) {
+	plain := make([]rune, len(lower))
+	subs := 0
+	for i, r := range lower {
+		plain[i] = r
+		if l, ok := leet[r]; ok {
+			plain[i] = l
+			subs++
+		}
+	}
+	rank, ok := commonRanks[string(lower)]
+	if !ok {
+		if rank, ok = commonRanks[string(plain)]; !ok {
+			return 0, false
+		}
+	} else {
+		subs = 0
+	}
+	bits := math.Log2(float64(rank)) + float64(subs)
+	upper := 0
+	for _, r := range part {
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+	switch {
+	case upper == 0:
+	case upper == 1 && unicode.IsUpper(part[0]), upper == len(part):
+		bits++
+	default:
+		bits += float64(len(part))
+	}
+	return bits, true
+}
+
+// sequenceBits guesses s as a run of consecutive characters like abc or 987
+func sequenceBits(s []rune) (float64, bool) {
+	step := s[1] - s[0]
+	if step != 1 && step != -1 {
+		return 0, false
+	}
+	for i := 2; i < len(s); i++ {
+		if s[i]-s[i-1] != step {
+			return 0, false
+		}
+	}
+	bits := math.Log2(26)
+	if s[0] == 'a' || s[0] == '1' || s[0] == '0' {
+		bits = 1
+	} else if unicode.IsDigit(s[0]) {
+		bits = math.Log2(10)
+	}
+	if step < 0 {
+		bits++
+	}
+	return bits + math.Log2(float64(len(s))), true
+}
+
+// repeatBits guesses s as one character repeated
+func repeatBits(s []rune) (float64, bool) {
+	for _, r := range s[1:] {
+		if r != s[0] {
+			return 0, false
+		}
+	}
+	return math.Log2(float64(cardinality(s[:1]))) + math.Log2(float64(len(s))), true
+}
+
+// keyboardBits guesses s as a walk along a keyboard row, forwards or backwards
+func keyboardBits(s []rune) (float64, bool) {
+	if len(s) < 4 {
+		return 0, false
+	}
+	walk := string(s)
+	reversed := []rune(walk)
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	for _, row := range keyboardRows {
+		if strings.Contains(row, walk) {
+			return math.Log2(47) + math.Log2(float64(len(s))), true
+		}
+		if strings.Contains(row, string(reversed)) {
+			return math.Log2(47) + 1 + math.Log2(float64(len(s))), true
+		}
+	}
+	return 0, false
+}
+
+// cardinality returns the size of the character classes used in s
+func cardinality(s []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < 0x80:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	n := 0
+	for _, c := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if c.used {
+			n += c.size
+		}
+	}
+	return n
+}
+
+// isDigits reports whether s consists of digits only
+func isDigits(s []rune) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// strengthLabel describes the estimated entropy for humans
+func strengthLabel(bits float64) string {
+	switch {
+	case bits < 28:
+		return "very weak"
+	case bits < 36:
+		return "weak"
+	case bits < weakPassphraseBits:
+		return "fair"
+	case bits < 80:
+		return "strong"
+	}
+	return "very strong"
+}
@@ -0,0 +1,107 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// runText implements "fileenc text [-decrypt] [flags] [text]", encrypting a
+// short secret to a single line of text and back
+func runText(args []string) {
+	fs := flag.NewFlagSet("text", flag.ExitOnError)
+	decrypt := fs.Bool("decrypt", false, "decrypt a FILEENC: line or armored text instead of encrypting")
+	value := fs.String("value", "", "the text to process, visible in the process list; read from stdin if neither this nor an argument is given")
+	keys := addKeyFlags(fs, true)
+	ciphers := addCipherFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc text [flags] [text]")
+		fmt.Fprintln(fs.Output(), "       fileenc text -decrypt [flags] [FILEENC:...]")
+		fmt.Fprintln(fs.Output(), "Encrypts a short secret like an API token to one line of text, or decrypts such a line.")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) > 1 || (len(args) == 1 && *value != "") {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	// Read the text before the key so a prompt does not wait for stdin
+	var in []byte
+	switch {
+	case len(args) == 1:
+		in = []byte(args[0])
+	case *value != "":
+		in = []byte(*value)
+	default:
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+			os.Exit(exitIO)
+		}
+		// A single line break ends the text, it is not part of it
+		in = bytes.TrimSuffix(bytes.TrimSuffix(data, []byte("\n")), []byte("\r"))
+	}
+	defer clear(in)
+
+	var opts []fileenc.Option
+	if !*decrypt {
+		cipherOpts, err := ciphers.options()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCode(err, exitUsage))
+		}
+		opts = cipherOpts
+	}
+	key, keyOpts, err := keys.load(*decrypt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	defer clear(key)
+	enc, err := fileenc.New(key, append(opts, keyOpts...)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid settings: %v\n", err)
+		clear(key)
+		os.Exit(exitCode(err, exitUsage))
+	}
+
+	if *decrypt {
+		plain, err := enc.DecryptText(string(in))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error decrypting text: %v\n", err)
+			clear(key)
+			os.Exit(exitCode(err, exitFailure))
+		}
+		os.Stdout.Write(append(plain, '\n'))
+		clear(plain)
+		return
+	}
+	text, err := enc.EncryptText(in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encrypting text: %v\n", err)
+		clear(key)
+		os.Exit(exitCode(err, exitFailure))
+	}
+	fmt.Println(text)
+}
@@ -0,0 +1,247 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// tpmPrefix starts the text form of a TPM identity
+const tpmPrefix = "FILEENC-TPM-"
+
+// tpmPCRPattern matches a PCR selection such as sha256:0,2,4,7
+var tpmPCRPattern = regexp.MustCompile(`^(sha1|sha256|sha384):[0-9]+(,[0-9]+)*$`)
+
+// tpmIdentity is an X25519 identity whose private key is derived from a
+// secret sealed to the TPM of this machine, optionally bound to the state of
+// some PCRs. The identity file holds the sealed object, which only this TPM
+// can load, and the PCR selection.
+type tpmIdentity struct {
+	pcrs    string
+	public  []byte
+	private []byte
+
+	once sync.Once
+	id   *fileenc.X25519Identity
+	err  error
+}
+
+// Unwrap unseals the secret on first use and unwraps the file key with the
+// derived X25519 identity
+func (t *tpmIdentity) Unwrap(stanzas []fileenc.Stanza) ([]byte, error) {
+	t.once.Do(func() {
+		var secret []byte
+		if secret, t.err = tpmUnseal(t.public, t.private, t.pcrs); t.err == nil {
+			t.id, t.err = tpmX25519(secret)
+			clear(secret)
+		}
+	})
+	if t.err != nil {
+		return nil, t.err
+	}
+	return t.id.Unwrap(stanzas)
+}
+
+// String returns the text form stored in identity files
+func (t *tpmIdentity) String() string {
+	raw := append([]byte{byte(len(t.pcrs))}, t.pcrs...)
+	raw = binary.BigEndian.AppendUint16(raw, uint16(len(t.public)))
+	raw = append(raw, t.public...)
+	return tpmPrefix + base64.RawURLEncoding.EncodeToString(append(raw, t.private...))
+}
+
+// parseTPMIdentities parses the TPM identities in data read from name
+func parseTPMIdentities(data []byte, name string) ([]fileenc.Identity, error) {
+	var ids []fileenc.Identity
+	for n, line := range lines(data) {
+		raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(line, tpmPrefix))
+		if !strings.HasPrefix(line, tpmPrefix) || err != nil || len(raw) < 1 || len(raw) < 3+int(raw[0]) {
+			return nil, fmt.Errorf("%s: line %d is no TPM identity", name, n)
+		}
+		pcrs, rest := string(raw[1:1+int(raw[0])]), raw[1+int(raw[0]):]
+		size := int(binary.BigEndian.Uint16(rest))
+		if len(rest) <= 2+size || (pcrs != "" && !tpmPCRPattern.MatchString(pcrs)) {
+			return nil, fmt.Errorf("%s: line %d is no TPM identity", name, n)
+		}
+		ids = append(ids, &tpmIdentity{pcrs: pcrs, public: rest[2 : 2+size], private: rest[2+size:]})
+	}
+	return ids, nil
+}
+
+// tpmX25519 derives the X25519 identity from the unsealed secret
+func tpmX25519(secret []byte) (*fileenc.X25519Identity, error) {
+	key, err := hkdf.Key(sha256.New, secret, nil, "fileenc tpm x25519", 32)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	return fileenc.NewX25519Identity(key)
+}
+
+// tpmSeal seals secret under the storage primary key of the TPM, bound to the
+// current values of the PCRs if pcrs is set, and returns the sealed object
+func tpmSeal(secret []byte, pcrs string) (public, private []byte, err error) {
+	dir, err := os.MkdirTemp("", "fileenc-tpm-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+	primary := filepath.Join(dir, "primary.ctx")
+	if _, err := tpmTool("tpm2_createprimary", nil, "-Q", "-C", "o", "-g", "sha256", "-G", "ecc", "-c", primary); err != nil {
+		return nil, nil, err
+	}
+	pub, priv := filepath.Join(dir, "seal.pub"), filepath.Join(dir, "seal.priv")
+	args := []string{"-Q", "-C", primary, "-g", "sha256", "-u", pub, "-r", priv, "-i", "-"}
+	if pcrs != "" {
+		policy := filepath.Join(dir, "pcr.policy")
+		if _, err := tpmTool("tpm2_createpolicy", nil, "-Q", "--policy-pcr", "-l", pcrs, "-L", policy); err != nil {
+			return nil, nil, err
+		}
+		// Without the user with auth attribute the object can only be unsealed with the policy
+		args = append(args, "-L", policy, "-a", "fixedtpm|fixedparent|noda|adminwithpolicy")
+	}
+	if _, err := tpmTool("tpm2_create", secret, args...); err != nil {
+		return nil, nil, err
+	}
+	if public, err = os.ReadFile(pub); err != nil {
+		return nil, nil, err
+	}
+	if private, err = os.ReadFile(priv); err != nil {
+		return nil, nil, err
+	}
+	return public, private, nil
+}
+
+// tpmUnseal loads the sealed object under the storage primary key and unseals
+// it, which fails on another TPM or if the PCRs changed
+func tpmUnseal(public, private []byte, pcrs string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "fileenc-tpm-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	primary, obj := filepath.Join(dir, "primary.ctx"), filepath.Join(dir, "seal.ctx")
+	pub, priv := filepath.Join(dir, "seal.pub"), filepath.Join(dir, "seal.priv")
+	if err := errors.Join(os.WriteFile(pub, public, 0600), os.WriteFile(priv, private, 0600)); err != nil {
+		return nil, err
+	}
+	if _, err := tpmTool("tpm2_createprimary", nil, "-Q", "-C", "o", "-g", "sha256", "-G", "ecc", "-c", primary); err != nil {
+		return nil, err
+	}
+	if _, err := tpmTool("tpm2_load", nil, "-Q", "-C", primary, "-u", pub, "-r", priv, "-c", obj); err != nil {
+		return nil, err
+	}
+	args := []string{"-c", obj}
+	if pcrs != "" {
+		args = append(args, "-p", "pcr:"+pcrs)
+	}
+	secret, err := tpmTool("tpm2_unseal", nil, args...)
+	if err != nil && pcrs != "" {
+		return nil, fmt.Errorf("%w, is this another machine or did the PCRs %s change?", err, pcrs)
+	}
+	return secret, err
+}
+
+// tpmTool runs a tpm2-tools command with input on stdin and returns its output.
+// TPM2TOOLS_TCTI selects the TPM, e.g. the resource manager or a simulator.
+func tpmTool(name string, input []byte, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found, install tpm2-tools for TPM support", name)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// runTPM implements "fileenc tpm [-pcrs <bank>:<list>] [-o <file>]", sealing a
+// new identity to the TPM of this machine
+func runTPM(args []string) {
+	fs := flag.NewFlagSet("tpm", flag.ExitOnError)
+	pcrs := fs.String("pcrs", "", "bind the identity to the current values of these PCRs, e.g. sha256:0,2,4,7 for firmware and Secure Boot state")
+	output := fs.String("o", "", "write the identity to this file instead of stdout, it must not exist")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc tpm [-pcrs <bank>:<list>] [-o <file>]")
+		fmt.Fprintln(fs.Output(), "Seals a new identity to the TPM 2.0 of this machine with tpm2-tools, TPM2TOOLS_TCTI selects the TPM.")
+		fs.PrintDefaults()
+	}
+	parseArgs(fs, args)
+	if *pcrs != "" && !tpmPCRPattern.MatchString(*pcrs) {
+		fmt.Fprintf(os.Stderr, "Error: invalid PCR selection %q, use e.g. sha256:0,7\n", *pcrs)
+		os.Exit(exitUsage)
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	defer clear(secret)
+	public, private, err := tpmSeal(secret, *pcrs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error sealing to the TPM: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	x, err := tpmX25519(secret)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	id := &tpmIdentity{pcrs: *pcrs, public: public, private: private}
+	pub := x.Recipient().String()
+
+	out := os.Stdout
+	if *output != "" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating identity file: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		defer file.Close()
+		out = file
+	}
+	host, _ := os.Hostname()
+	fmt.Fprintf(out, "# created: %s on %s\n", time.Now().Format(time.RFC3339), host)
+	if *pcrs != "" {
+		fmt.Fprintf(out, "# pcrs: %s\n", *pcrs)
+	}
+	fmt.Fprintf(out, "# public key: %s\n", pub)
+	fmt.Fprintf(out, "%s\n", id)
+	if *output != "" {
+		fmt.Printf("Public key: %s\n", pub)
+	}
+}
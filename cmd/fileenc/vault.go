@@ -0,0 +1,223 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultKeyPrefix starts the -kms-key-id of a Vault transit key,
+// vault:<mount>/<key>, e.g. vault:transit/fileenc
+const vaultKeyPrefix = "vault:"
+
+// vaultTransit wraps file keys with the transit secrets engine of HashiCorp
+// Vault. Address, namespace and token or AppRole are taken from the
+// environment like the vault command does.
+type vaultTransit struct{}
+
+// vaultKey splits a vault:<mount>/<key> key ID
+func vaultKey(keyID string) (mount, name string, err error) {
+	path := strings.TrimPrefix(keyID, vaultKeyPrefix)
+	i := strings.LastIndex(path, "/")
+	if i <= 0 || i == len(path)-1 {
+		return "", "", fmt.Errorf("invalid Vault key %q, use vault:<mount>/<key>, e.g. vault:transit/fileenc", keyID)
+	}
+	return path[:i], path[i+1:], nil
+}
+
+// call posts in to the transit operation of the key and decodes the data of
+// the answer into out
+func (vaultTransit) call(keyID, op string, in, out any) error {
+	mount, name, err := vaultKey(keyID)
+	if err != nil {
+		return err
+	}
+	var resp struct{ Data json.RawMessage }
+	if err := vault.do(http.MethodPost, mount+"/"+op+"/"+name, in, &resp); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		return fmt.Errorf("invalid Vault response: %w", err)
+	}
+	return nil
+}
+
+// wrap encrypts the file key, the ciphertext names the key version used
+func (v vaultTransit) wrap(keyID string, fileKey []byte) (string, []byte, error) {
+	var out struct{ Ciphertext string }
+	in := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(fileKey)}
+	if err := v.call(keyID, "encrypt", in, &out); err != nil {
+		return "", nil, err
+	}
+	return keyID, []byte(out.Ciphertext), nil
+}
+
+// unwrap decrypts the file key
+func (v vaultTransit) unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	var out struct{ Plaintext string }
+	if err := v.call(keyID, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &out); err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(out.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Vault response: %w", err)
+	}
+	return key, nil
+}
+
+// vault is the session with the Vault server shared by all requests
+var vault = &vaultSession{}
+
+// vaultSession holds the Vault token. A token from VAULT_TOKEN or
+// ~/.vault-token is looked up once to learn its lifetime, an AppRole login
+// with VAULT_ROLE_ID and VAULT_SECRET_ID returns one. Renewable tokens are
+// renewed once two thirds of their lifetime have passed, AppRole logs in
+// again when renewal is not possible.
+type vaultSession struct {
+	mu        sync.Mutex
+	token     string
+	renewable bool
+	ttl       time.Duration
+	renewAt   time.Time
+}
+
+// vaultAuth is the auth section of login, renewal and lookup answers
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// do sends a request to the API path below /v1/ with a valid token
+func (s *vaultSession) do(method, path string, in, out any) error {
+	token, err := s.currentToken()
+	if err != nil {
+		return err
+	}
+	return vaultRequest(method, path, token, in, out)
+}
+
+// currentToken returns the token, logging in or renewing it if needed
+func (s *vaultSession) currentToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == "" {
+		if err := s.login(); err != nil {
+			return "", err
+		}
+	}
+	if s.ttl > 0 && time.Now().After(s.renewAt) {
+		if !s.renewable || s.renew() != nil {
+			// AppRole can log in again, a fixed token is used until it fails
+			if os.Getenv("VAULT_ROLE_ID") == "" {
+				s.ttl = 0
+			} else if err := s.login(); err != nil {
+				return "", err
+			}
+		}
+	}
+	return s.token, nil
+}
+
+// login obtains the token from the environment, the token helper file or AppRole
+func (s *vaultSession) login() error {
+	if roleID := os.Getenv("VAULT_ROLE_ID"); roleID != "" {
+		var resp struct{ Auth vaultAuth }
+		in := map[string]string{"role_id": roleID, "secret_id": os.Getenv("VAULT_SECRET_ID")}
+		mount := envOr("VAULT_APPROLE_MOUNT", "approle")
+		if err := vaultRequest(http.MethodPost, "auth/"+mount+"/login", "", in, &resp); err != nil {
+			return fmt.Errorf("Vault AppRole login failed: %w", err)
+		}
+		s.set(resp.Auth)
+		return nil
+	}
+
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		home, _ := os.UserHomeDir()
+		data, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+		if err != nil {
+			return errors.New("no Vault token, set VAULT_TOKEN or VAULT_ROLE_ID and VAULT_SECRET_ID or log in with vault login")
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	var resp struct {
+		Data struct {
+			TTL       int64 `json:"ttl"`
+			Renewable bool  `json:"renewable"`
+		}
+	}
+	if err := vaultRequest(http.MethodGet, "auth/token/lookup-self", token, nil, &resp); err != nil {
+		return fmt.Errorf("Vault token lookup failed: %w", err)
+	}
+	s.set(vaultAuth{ClientToken: token, LeaseDuration: resp.Data.TTL, Renewable: resp.Data.Renewable})
+	return nil
+}
+
+// renew extends the lifetime of the token
+func (s *vaultSession) renew() error {
+	var resp struct{ Auth vaultAuth }
+	if err := vaultRequest(http.MethodPost, "auth/token/renew-self", s.token, map[string]string{}, &resp); err != nil {
+		return err
+	}
+	if resp.Auth.ClientToken == "" {
+		resp.Auth.ClientToken = s.token
+	}
+	s.set(resp.Auth)
+	return nil
+}
+
+// set stores the token and when to renew it, tokens without TTL never expire
+func (s *vaultSession) set(auth vaultAuth) {
+	s.token, s.renewable = auth.ClientToken, auth.Renewable
+	s.ttl = time.Duration(auth.LeaseDuration) * time.Second
+	s.renewAt = time.Now().Add(s.ttl * 2 / 3)
+}
+
+// vaultRequest sends a request to the API path below /v1/ of VAULT_ADDR
+func vaultRequest(method, path, token string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	addr := strings.TrimSuffix(envOr("VAULT_ADDR", "https://127.0.0.1:8200"), "/")
+	req, err := http.NewRequest(method, addr+"/v1/"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return kmsDo(req, out)
+}
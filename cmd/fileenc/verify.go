@@ -0,0 +1,99 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// runVerify implements "fileenc verify [-key <key> | -keyfile <file> | -identity <file>] <file>..."
+// and "fileenc verify -manifest <manifest> [<dir>]"
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	keys := addKeyFlags(fs, false)
+	quiet := fs.Bool("quiet", false, "only report failures")
+	manifestPath := fs.String("manifest", "", "check that the decrypted files listed in this manifest, relative to the directory argument, match it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc verify [-key <key> | -keyfile <file> | -identity <file>] <file>...")
+		fmt.Fprintln(fs.Output(), "       fileenc verify -manifest <manifest> [-key <key> | -keyfile <file> | -identity <file>] [<dir>]")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+
+	var files []string
+	var err error
+	if *manifestPath == "" {
+		if files, err = expandSources(args, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+	if (*manifestPath == "" && len(files) == 0) || (*manifestPath != "" && len(args) > 1) {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	key, opts, err := keys.load(true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	enc, err := fileenc.New(key, opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid settings: %v\n", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+
+	// A manifest lists the decrypted files with their hashes
+	if *manifestPath != "" {
+		m, err := readManifest(enc, *manifestPath)
+		clear(key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCode(err, exitFailure))
+		}
+		dir := ""
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		if failed := verifyManifest(m, dir, *quiet); len(failed) > 0 {
+			os.Exit(batchExitCode(failed))
+		}
+		return
+	}
+
+	// Check every file, the exit code reports if any failed
+	var failed []error
+	for _, path := range files {
+		if err := enc.VerifyFile(path); err != nil {
+			fmt.Printf("File %s FAILED: %v\n", path, err)
+			failed = append(failed, err)
+			continue
+		}
+		if !*quiet {
+			fmt.Printf("File %s OK.\n", path)
+		}
+	}
+	clear(key)
+	if len(failed) > 0 {
+		os.Exit(batchExitCode(failed))
+	}
+}
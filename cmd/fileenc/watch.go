@@ -0,0 +1,332 @@
+package main
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// runWatch implements "fileenc watch [flags] <dir>", encrypting the files
+// appearing in a drop folder until it is interrupted
+func runWatch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	keys := addKeyFlags(fs, true)
+	ciphers := addCipherFlags(fs)
+	metadata := addMetadataFlags(fs)
+	var include, exclude stringList
+	fs.Var(&include, "include", "only encrypt files whose name matches this pattern, e.g. *.pdf, may be repeated")
+	fs.Var(&exclude, "exclude", "do not encrypt files whose name matches this pattern, may be repeated")
+	debounce := fs.Duration("debounce", 2*time.Second, "encrypt a file once it was not written to for this long")
+	existing := fs.Bool("existing", false, "also encrypt the files present when watching starts")
+	outDir := fs.String("out-dir", "", "write the encrypted files to this directory, mirroring the subdirectories")
+	suffix := fs.String("suffix", encExt, "extension of encrypted files, files with it are not encrypted again")
+	shred := fs.Bool("shred", false, "overwrite every file with random data and remove it after encryption")
+	shredPasses := fs.Int("shred-passes", fileenc.DefaultShredPasses, "number of random overwrites for -shred")
+	overwrite := fs.Bool("overwrite", false, "overwrite existing encrypted files")
+	force := fs.Bool("force", false, "encrypt files that already are fileenc, age or OpenPGP files")
+	var bwLimit byteSize
+	fs.Var(&bwLimit, "bwlimit", "limit reading and writing to this many bytes per second each, e.g. 20M")
+	logFile := fs.String("log", "", "append a JSON line for every processed file to this file")
+	jsonFlag := fs.Bool("json", false, "report one JSON object per file on stdout instead of messages")
+	logs := addLogFlags(fs)
+	hookFlags := addHookFlags(fs)
+	eventFlags := addEventFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: fileenc watch [flags] <dir>")
+		fmt.Fprintln(fs.Output(), "Encrypts every new file in dir and its subdirectories until interrupted.")
+		fs.PrintDefaults()
+	}
+	args = parseArgs(fs, args)
+	if len(args) != 1 || *suffix == "" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if err := logs.check(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	log := logs.logger(os.Stderr)
+	for _, p := range append(include, exclude...) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			log.Error("Invalid pattern", "pattern", p, "error", err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	opts, err := ciphers.options()
+	if err != nil {
+		log.Error("Invalid cipher settings", "error", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	events, err := eventFlags.open()
+	if err != nil {
+		log.Error("Error opening the event destinations", "error", err)
+		os.Exit(exitUsage)
+	}
+	opts = append(opts, fileenc.WithOverwrite(*overwrite), fileenc.WithBandwidthLimit(int64(bwLimit)))
+	opts = append(opts, metadata.options()...)
+	if *force {
+		opts = append(opts, fileenc.WithForce())
+	}
+	key, keyOpts, err := keys.load(false)
+	if err != nil {
+		log.Error("Error loading the key", "error", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+	defer clear(key)
+	enc, err := fileenc.New(key, append(opts, keyOpts...)...)
+	if err != nil {
+		log.Error("Invalid settings", "error", err)
+		os.Exit(exitCode(err, exitUsage))
+	}
+
+	w := &watcher{
+		t: task{
+			enc: enc, overwrite: *overwrite, suffix: *suffix,
+			shred: *shred, shredPasses: *shredPasses, logs: logs, json: *jsonFlag,
+			hooks: *hookFlags, events: events,
+		},
+		logger: log, dir: args[0], outDir: *outDir, include: include, exclude: exclude, debounce: *debounce,
+		timers: map[string]*time.Timer{}, queue: make(chan string, 64),
+	}
+	if *logFile != "" {
+		if w.log, err = os.OpenFile(*logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+			log.Error("Error opening the -log file", "file", *logFile, "error", err)
+			clear(key)
+			os.Exit(exitIO)
+		}
+		defer w.log.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := w.run(ctx, *existing); err != nil {
+		log.Error("Error watching", "dir", args[0], "error", err)
+		clear(key)
+		os.Exit(exitCode(err, exitIO))
+	}
+}
+
+// watcher encrypts the files written to a directory tree once they are complete
+type watcher struct {
+	t        task
+	dir      string
+	outDir   string
+	include  []string
+	exclude  []string
+	debounce time.Duration
+	log      *os.File
+	logger   *slog.Logger
+
+	ctx    context.Context
+	fsw    *fsnotify.Watcher
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	queue  chan string
+}
+
+// run watches until ctx is cancelled, the file in progress is finished
+func (w *watcher) run(ctx context.Context, existing bool) error {
+	var err error
+	w.ctx = ctx
+	if w.fsw, err = fsnotify.NewWatcher(); err != nil {
+		return err
+	}
+	defer w.fsw.Close()
+	if err := w.add(w.dir, existing); err != nil {
+		return err
+	}
+	if !w.t.json {
+		w.logger.Info("Watching, press Ctrl-C to stop", "dir", w.dir)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case path := <-w.queue:
+				w.process(path)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.mu.Lock()
+			for _, t := range w.timers {
+				t.Stop()
+			}
+			w.mu.Unlock()
+			<-done
+			return nil
+		case err := <-w.fsw.Errors:
+			w.logger.Error("Error watching", "dir", w.dir, "error", err)
+		case ev := <-w.fsw.Events:
+			switch {
+			case ev.Has(fsnotify.Create) && isDir(ev.Name):
+				// Files moved in with a directory cause no events of their own
+				if err := w.add(ev.Name, true); err != nil {
+					w.logger.Error("Error watching", "dir", ev.Name, "error", err)
+				}
+			case ev.Has(fsnotify.Create), ev.Has(fsnotify.Write), ev.Has(fsnotify.Chmod):
+				w.schedule(ev.Name)
+			case ev.Has(fsnotify.Remove), ev.Has(fsnotify.Rename):
+				w.cancel(ev.Name)
+			}
+		}
+	}
+}
+
+// add watches the directory tree at dir, with schedule set its files are encrypted
+func (w *watcher) add(dir string, schedule bool) error {
+	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// Outputs written into the tree are no new files
+			if path != w.dir && (strings.HasPrefix(d.Name(), ".") || (w.outDir != "" && sameFile(path, w.outDir))) {
+				return filepath.SkipDir
+			}
+			return w.fsw.Add(path)
+		}
+		if schedule {
+			w.schedule(path)
+		}
+		return nil
+	})
+}
+
+// wanted reports if the file at path is to be encrypted. Hidden files, which
+// include the temporary files of fileenc, and encrypted files never are.
+func (w *watcher) wanted(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, w.t.suffix) {
+		return false
+	}
+	for _, p := range w.exclude {
+		if ok, _ := filepath.Match(p, name); ok {
+			return false
+		}
+	}
+	for _, p := range w.include {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return len(w.include) == 0
+}
+
+// schedule encrypts the file at path once it was not written to for the debounce time
+func (w *watcher) schedule(path string) {
+	if !w.wanted(path) {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t, ok := w.timers[path]; ok {
+		t.Reset(w.debounce)
+		return
+	}
+	w.timers[path] = time.AfterFunc(w.debounce, func() {
+		w.mu.Lock()
+		delete(w.timers, path)
+		w.mu.Unlock()
+		select {
+		case w.queue <- path:
+		case <-w.ctx.Done():
+		}
+	})
+}
+
+// cancel forgets a file that was removed before it was encrypted
+func (w *watcher) cancel(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t, ok := w.timers[path]; ok {
+		t.Stop()
+		delete(w.timers, path)
+	}
+}
+
+// process encrypts the file at path and reports the result
+func (w *watcher) process(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	t := w.t
+	if w.outDir != "" {
+		rel, err := filepath.Rel(w.dir, path)
+		if err != nil {
+			rel = filepath.Base(path)
+		}
+		t.out = filepath.Join(w.outDir, rel+t.suffix)
+		if err := os.MkdirAll(filepath.Dir(t.out), 0755); err != nil {
+			w.logger.Error("Error creating directory", "file", t.out, "error", err)
+			return
+		}
+	}
+
+	log := w.logger
+	if t.json {
+		log = t.logger(io.Discard)
+	}
+	res, _ := t.report(path, log)
+	res.Time = time.Now().Format(time.RFC3339)
+	if t.json {
+		json.NewEncoder(os.Stdout).Encode(res)
+	}
+	if w.log != nil {
+		json.NewEncoder(w.log).Encode(res)
+	}
+}
+
+// isDir reports if path is a directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// sameFile reports if the paths a and b name the same file
+func sameFile(a, b string) bool {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	ib, err := os.Stat(b)
+	return err == nil && os.SameFile(ia, ib)
+}
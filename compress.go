@@ -0,0 +1,91 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// CompressionNone stores the data as is
+	CompressionNone = "none"
+	// CompressionGzip compresses the data with gzip before encryption
+	CompressionGzip = "gzip"
+	// CompressionZstd compresses the data with Zstandard before encryption
+	CompressionZstd = "zstd"
+)
+
+// compressionIDs maps the compression names to the identifiers stored in the header
+var compressionIDs = map[string]byte{
+	CompressionGzip: 1,
+	CompressionZstd: 2,
+}
+
+// newCompressWriter returns a writer compressing to w, closing it flushes the
+// compressed data but does not close w
+func newCompressWriter(w io.Writer, name string) (io.WriteCloser, error) {
+	switch name {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown compression %q", name)
+	}
+}
+
+// newDecompressReader returns a reader decompressing the data read from r
+func newDecompressReader(r io.Reader, id byte) (io.Reader, error) {
+	switch id {
+	case compressionIDs[CompressionGzip]:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+		return zr, nil
+	case compressionIDs[CompressionZstd]:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+		return zr, nil
+	default:
+		return nil, fmt.Errorf("unknown compression id %d in header", id)
+	}
+}
+
+// chainWriter writes to the first writer of a pipeline and closes all stages
+// in order, so every stage can flush into the next one
+type chainWriter struct {
+	io.Writer
+	closers []io.Closer
+}
+
+// Close closes the stages from first to last and returns the first error
+func (c *chainWriter) Close() error {
+	var first error
+	for _, cl := range c.closers {
+		if err := cl.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
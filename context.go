@@ -0,0 +1,111 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"context"
+	"io"
+)
+
+// EncryptContext is Encrypt, failing with the error of ctx once it is done
+func (e *Encryptor) EncryptContext(ctx context.Context, dst io.Writer, src io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.Encrypt(dst, contextReader(ctx, src))
+}
+
+// DecryptContext is Decrypt, failing with the error of ctx once it is done
+func (e *Encryptor) DecryptContext(ctx context.Context, dst io.Writer, src io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.Decrypt(dst, contextReader(ctx, src))
+}
+
+// EncryptFileContext is EncryptFile, failing with the error of ctx once it is
+// done. The partial output is removed, the destination is left untouched.
+func (e *Encryptor) EncryptFileContext(ctx context.Context, srcPath, dstPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.withContext(ctx).EncryptFile(srcPath, dstPath)
+}
+
+// DecryptFileContext is DecryptFile, failing with the error of ctx once it is
+// done. The partial output is removed, the destination is left untouched.
+func (e *Encryptor) DecryptFileContext(ctx context.Context, srcPath, dstPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.withContext(ctx).DecryptFile(srcPath, dstPath)
+}
+
+// EncryptDirContext is EncryptDir, failing with the error of ctx once it is
+// done. The partial archive is removed, the destination is left untouched.
+func (e *Encryptor) EncryptDirContext(ctx context.Context, srcDir, dstPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.withContext(ctx).EncryptDir(srcDir, dstPath)
+}
+
+// ExtractArchiveContext is ExtractArchive, failing with the error of ctx once
+// it is done. The files extracted so far are left behind.
+func (e *Encryptor) ExtractArchiveContext(ctx context.Context, srcPath, dstDir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.withContext(ctx).ExtractArchive(srcPath, dstDir)
+}
+
+// withContext returns a copy of the Encryptor whose file reads fail once ctx is done
+func (e *Encryptor) withContext(ctx context.Context) *Encryptor {
+	ce := *e
+	ce.ctx = ctx
+	return &ce
+}
+
+// ctxReader fails reads with the error of its context once it is done
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read reads from the underlying reader unless the context is done
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// contextReader wraps r to fail once ctx is done, keeping Seek of an
+// io.ReadSeeker for convergent encryption. A nil ctx returns r.
+func contextReader(ctx context.Context, r io.Reader) io.Reader {
+	if ctx == nil {
+		return r
+	}
+	cr := &ctxReader{ctx: ctx, r: r}
+	if s, ok := r.(io.Seeker); ok {
+		return struct {
+			io.Reader
+			io.Seeker
+		}{cr, s}
+	}
+	return cr
+}
@@ -0,0 +1,131 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/aes"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// errConvergentStream is returned when convergent encryption cannot read the input twice
+var errConvergentStream = errors.New("convergent encryption needs to read the input twice, it does not work with streams")
+
+// WithConvergent makes encryption deterministic, so the same plaintext encrypted
+// with the same passphrase and settings always gives the same file and
+// deduplicating storage keeps copies only once. The salt is fixed per set of KDF
+// parameters and the IV is an HMAC of the header and the plaintext under the key.
+//
+// This reveals which files are equal, lets anyone holding the passphrase confirm
+// that a file is stored, and allows precomputing passphrase guesses for all
+// users of the same KDF parameters, so it should only be used with a strong
+// passphrase or a random key. Encryption reads the input twice: Encrypt needs an
+// io.ReadSeeker and NewWriter is not supported. It requires the fileenc format
+// and a passphrase. Decryption is unchanged.
+func WithConvergent() Option {
+	return func(e *Encryptor) {
+		e.convergent = true
+	}
+}
+
+// validateConvergent checks that the settings allow convergent encryption
+func (e *Encryptor) validateConvergent() error {
+	switch {
+	case !e.convergent:
+		return nil
+	case e.format != FormatFileenc:
+		return errors.New("convergent encryption requires the fileenc format")
+	case len(e.recipients) > 0:
+		return errors.New("convergent encryption requires a passphrase, the file key of recipients is random")
+	case e.resume:
+		return errors.New("convergent encryption cannot be resumed")
+	}
+	// Both passes derive the key, the cache stretches the passphrase only once
+	if e.keyCache == nil {
+		e.keyCache = NewKeyCache()
+	}
+	return nil
+}
+
+// convergentKDF returns params with the fixed salt used for convergent encryption
+func convergentKDF(params KDFParams) KDFParams {
+	if params.Name == KDFNone {
+		return params
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "fileenc convergent salt %s/%d/%d/%d", params.Name, params.Time, params.Memory, params.Threads))
+	params.Salt = sum[:kdfSaltSize]
+	return params
+}
+
+// convergentMAC returns an HMAC keyed for deriving convergent IVs from key
+func convergentMAC(key []byte) (hash.Hash, error) {
+	macKey, err := hkdf.Key(sha256.New, key, nil, "fileenc convergent iv", sha256.Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive IV key: %w", err)
+	}
+	defer clear(macKey)
+	return hmac.New(sha256.New, macKey), nil
+}
+
+// convergentSum reads r to its end and returns the keyed digest of its content,
+// leaving r at the position it started at
+func (e *Encryptor) convergentSum(r io.ReadSeeker) ([]byte, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, errConvergentStream
+	}
+	params := convergentKDF(e.kdf)
+	key, err := e.deriveKey(params)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+	mac, err := convergentMAC(key.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(mac, r); err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind input: %w", err)
+	}
+	return mac.Sum(nil), nil
+}
+
+// convergentIV returns the IV for the content digest sum and the header h,
+// which is hashed with a zero IV so files differing in settings or metadata
+// never share an IV
+func convergentIV(key, sum []byte, h header) ([]byte, error) {
+	h.IV = make([]byte, aes.BlockSize)
+	raw, err := h.marshal()
+	if err != nil {
+		return nil, err
+	}
+	mac, err := convergentMAC(key)
+	if err != nil {
+		return nil, err
+	}
+	mac.Write(sum)
+	mac.Write(raw)
+	return mac.Sum(nil)[:aes.BlockSize], nil
+}
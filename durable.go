@@ -0,0 +1,62 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// WithDurability makes the file functions flush every output file and the
+// directory holding it to disk before they return, so a power loss right after
+// a successful encryption cannot lose the file or its name. This costs a sync
+// per file, which can be slow on network file systems and many small files.
+func WithDurability() Option {
+	return func(e *Encryptor) {
+		e.durable = true
+	}
+}
+
+// syncFile flushes the written data of file to disk if durability is requested
+func (e *Encryptor) syncFile(file *os.File) error {
+	if !e.durable {
+		return nil
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.Name(), err)
+	}
+	return nil
+}
+
+// syncDir flushes the directory dir to disk if durability is requested, so
+// files renamed into it keep their names. Windows cannot sync directories,
+// the renames are written through there.
+func (e *Encryptor) syncDir(dir string) error {
+	if !e.durable || runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+	}
+	return nil
+}
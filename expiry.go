@@ -0,0 +1,81 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrExpired is returned when decrypting a file after the expiry date stored in its header
+var ErrExpired = errors.New("the file has expired")
+
+// WithExpiry stores t as expiry date in the header. Decryption refuses the file
+// after that date unless WithIgnoreExpiry is given. The expiry is advisory: it
+// is authenticated along with the header, but anyone holding the key can
+// decrypt the file regardless. It requires the fileenc format.
+func WithExpiry(t time.Time) Option {
+	return func(e *Encryptor) {
+		e.expires = t
+	}
+}
+
+// WithIgnoreExpiry decrypts files even after their expiry date
+func WithIgnoreExpiry() Option {
+	return func(e *Encryptor) {
+		e.ignoreExpiry = true
+	}
+}
+
+// validateExpiry checks that the expiry can be stored in the chosen format
+func (e *Encryptor) validateExpiry() error {
+	if !e.expires.IsZero() && e.format != FormatFileenc {
+		return errors.New("an expiry date requires the fileenc format")
+	}
+	return nil
+}
+
+// expiryExtension returns the header extension holding t
+func expiryExtension(t time.Time) extension {
+	return extension{Type: extExpiry, Data: binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))}
+}
+
+// expiry returns the expiry date stored in the header, the zero time if there is none
+func (h header) expiry() (time.Time, error) {
+	data, ok := h.extension(extExpiry)
+	if !ok {
+		return time.Time{}, nil
+	}
+	if len(data) != 8 {
+		return time.Time{}, fmt.Errorf("%w: invalid expiry", ErrMalformedHeader)
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data))), nil
+}
+
+// checkExpiry fails with ErrExpired if the expiry date in the header has passed
+func (e *Encryptor) checkExpiry(hdr header) error {
+	t, err := hdr.expiry()
+	if err != nil || t.IsZero() || e.ignoreExpiry {
+		return err
+	}
+	if time.Now().After(t) {
+		return fmt.Errorf("%w on %s", ErrExpired, t.Local().Format(time.RFC3339))
+	}
+	return nil
+}
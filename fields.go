@@ -0,0 +1,144 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// WithFields stores the key-value pairs, e.g. a ticket number or a
+// classification, encrypted in the header of the files written. They are
+// readable with the key only, see Encryptor.Inspect, and carried over by Rekey.
+// Fields require the fileenc format.
+func WithFields(fields map[string]string) Option {
+	return func(e *Encryptor) {
+		if e.fields == nil {
+			e.fields = make(map[string]string, len(fields))
+		}
+		maps.Copy(e.fields, fields)
+	}
+}
+
+// validateFields checks the names and values of the fields
+func (e *Encryptor) validateFields() error {
+	if len(e.fields) == 0 {
+		return nil
+	}
+	if e.format != FormatFileenc {
+		return errors.New("fields require the fileenc format")
+	}
+	for name, value := range e.fields {
+		if name == "" || strings.Contains(name, "=") || strings.IndexFunc(name, unicode.IsSpace) >= 0 ||
+			strings.IndexFunc(name, unicode.IsControl) >= 0 || !utf8.ValidString(name) {
+			return fmt.Errorf("invalid field name %q", name)
+		}
+		if !utf8.ValidString(value) {
+			return fmt.Errorf("field %s is not valid UTF-8", name)
+		}
+	}
+	return nil
+}
+
+// fieldsKeys derives the encryption and nonce keys of the fields from the file key
+func fieldsKeys(key []byte) ([]byte, []byte, error) {
+	keys, err := hkdf.Key(sha256.New, key, nil, "fileenc fields", 2*chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive fields key: %w", err)
+	}
+	return keys[:chacha20poly1305.KeySize], keys[chacha20poly1305.KeySize:], nil
+}
+
+// fieldsExtension returns the header extension holding the fields sealed with
+// XChaCha20-Poly1305. The nonce is a MAC of the IV and the fields and stored in
+// front of them, so convergent encryption, which seals them before its IV is
+// known, stays deterministic without ever reusing a nonce.
+func fieldsExtension(fields map[string]string, key, iv []byte) (extension, error) {
+	var plain []byte
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		plain = binary.AppendUvarint(plain, uint64(len(name)))
+		plain = append(plain, name...)
+		plain = binary.AppendUvarint(plain, uint64(len(fields[name])))
+		plain = append(plain, fields[name]...)
+	}
+	encKey, macKey, err := fieldsKeys(key)
+	if err != nil {
+		return extension{}, err
+	}
+	defer clear(encKey)
+	defer clear(macKey)
+	aead, err := chacha20poly1305.NewX(encKey)
+	if err != nil {
+		return extension{}, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(iv)
+	mac.Write(plain)
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+	return extension{Type: extFields, Data: aead.Seal(nonce, nonce, plain, nil)}, nil
+}
+
+// fields decrypts the fields stored in the header with key, nil if there are none
+func (h header) fields(key []byte) (map[string]string, error) {
+	data, ok := h.extension(extFields)
+	if !ok {
+		return nil, nil
+	}
+	encKey, macKey, err := fieldsKeys(key)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(encKey)
+	defer clear(macKey)
+	aead, err := chacha20poly1305.NewX(encKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid fields", ErrMalformedHeader)
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+
+	fields := make(map[string]string)
+	for len(plain) > 0 {
+		var kv [2]string
+		for i := range kv {
+			n, l := binary.Uvarint(plain)
+			if l <= 0 || n > uint64(len(plain)-l) {
+				return nil, fmt.Errorf("%w: invalid fields", ErrMalformedHeader)
+			}
+			kv[i] = string(plain[l : l+int(n)])
+			plain = plain[l+int(n):]
+		}
+		fields[kv[0]] = kv[1]
+	}
+	return fields, nil
+}
@@ -0,0 +1,267 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrFileExists is returned when the destination file exists and overwriting is disabled
+var ErrFileExists = errors.New("file already exists")
+
+// EncryptFile encrypts the file at srcPath and writes the result to dstPath.
+// The output is written to a temporary file first and only renamed to dstPath
+// on success, so dstPath never holds partially encrypted data. With
+// WithFileMetadata the metadata of srcPath is stored in the header.
+func (e *Encryptor) EncryptFile(srcPath, dstPath string) error {
+	return e.encryptFile(srcPath, dstPath, e.overwrite)
+}
+
+// EncryptInPlace replaces the file at path with its encrypted version. The
+// original is only replaced once the encrypted file is complete, so a failure
+// leaves it untouched.
+func (e *Encryptor) EncryptInPlace(path string) error {
+	return e.encryptFile(path, path, true)
+}
+
+// encryptFile implements EncryptFile. Source and destination are locked
+// against other processes until it is done.
+func (e *Encryptor) encryptFile(srcPath, dstPath string, overwrite bool) error {
+	locks, err := lockFiles(srcPath, dstPath)
+	if err != nil {
+		return err
+	}
+	defer locks.release()
+	return e.encryptLocked(srcPath, dstPath, overwrite)
+}
+
+// encryptLocked implements encryptFile once the files are locked, the source
+// is closed before the output is renamed so it can replace the source
+func (e *Encryptor) encryptLocked(srcPath, dstPath string, overwrite bool) error {
+	if e.preserveSymlinks && e.format == FormatFileenc {
+		if info, err := os.Lstat(srcPath); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return e.encryptSymlink(srcPath, dstPath, overwrite, info)
+		}
+	}
+	if e.shareCount > 0 {
+		return e.encryptShared(srcPath, dstPath, overwrite)
+	}
+	if e.resume {
+		return e.encryptResumable(srcPath, dstPath, overwrite)
+	}
+	write := e.writeAtomic
+	if e.split > 0 {
+		if srcPath == dstPath {
+			return errors.New("a file cannot be split in place")
+		}
+		write = e.writeSplit
+	}
+	return write(dstPath, overwrite, func(w io.Writer) error {
+		// Open the source file
+		file, err := os.Open(srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+		var sum []byte
+		if e.convergent {
+			if sum, err = e.convergentSum(file); err != nil {
+				return err
+			}
+		}
+		src, err := e.progressReader(file, srcPath)
+		if err != nil {
+			return err
+		}
+		md, err := e.sourceMetadata(file, srcPath)
+		if err != nil {
+			return err
+		}
+		// Only the data of sparse files is encrypted, the holes are recorded
+		src, sparse, err := e.sparseSource(file, src)
+		if err != nil {
+			return err
+		}
+		if sparse {
+			m := Metadata{UID: -1, GID: -1}
+			if md != nil {
+				m = *md
+			}
+			m.Sparse = true
+			md = &m
+		}
+		if err := e.encrypt(w, src, md, sum); err != nil {
+			return err
+		}
+		return e.dropOutput(w)
+	})
+}
+
+// sourceMetadata returns the metadata to record for the source file
+func (e *Encryptor) sourceMetadata(file *os.File, path string) (*Metadata, error) {
+	if !e.fileMetadata {
+		return e.metadata, nil
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	md := e.fileMetadataOf(path, stat)
+	return &md, nil
+}
+
+// DecryptFile decrypts the file at srcPath and writes the plaintext to dstPath.
+// The output is written to a temporary file first and only renamed to dstPath
+// once the whole file has been decrypted and authenticated. With
+// WithFileMetadata the stored permissions and modification time are restored.
+func (e *Encryptor) DecryptFile(srcPath, dstPath string) error {
+	return e.decryptFile(srcPath, dstPath, e.overwrite)
+}
+
+// DecryptInPlace replaces the encrypted file at path with its plaintext once it
+// has been decrypted and authenticated completely
+func (e *Encryptor) DecryptInPlace(path string) error {
+	return e.decryptFile(path, path, true)
+}
+
+// decryptFile implements DecryptFile, the source is closed before the output
+// is renamed so it can replace the source. Source and destination are locked
+// against other processes until it is done.
+func (e *Encryptor) decryptFile(srcPath, dstPath string, overwrite bool) error {
+	locks, err := lockFiles(srcPath, dstPath)
+	if err != nil {
+		return err
+	}
+	defer locks.release()
+
+	var hdr header
+	err = e.writeAtomic(dstPath, overwrite, func(w io.Writer) error {
+		// Open the encrypted file or its volumes
+		src, closeSrc, err := e.openEncrypted(srcPath)
+		if err != nil {
+			return err
+		}
+		defer closeSrc()
+		// Holes of sparse files are recreated
+		if f, ok := w.(*os.File); ok {
+			w = &holeWriter{f: f}
+		}
+		if hdr, err = e.decrypt(w, src); err != nil {
+			return err
+		}
+		return e.dropOutput(w)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Recreate links and restore the metadata recorded in the header
+	md, err := hdr.metadata()
+	if err != nil {
+		return err
+	}
+	if md.Symlink {
+		return replaceWithSymlink(dstPath, md, e.fileMetadata && e.storeOwner)
+	}
+	if !e.fileMetadata {
+		return nil
+	}
+	return md.restore(dstPath, e.storeOwner)
+}
+
+// progressReader wraps file to report the progress if a ProgressFunc is set,
+// to keep it out of the page cache with WithDropCache and to fail once the
+// context of the Encryptor is done
+func (e *Encryptor) progressReader(file *os.File, name string) (io.Reader, error) {
+	r := e.uncachedReader(file)
+	if e.progress == nil {
+		return contextReader(e.ctx, r), nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return contextReader(e.ctx, NewProgressReader(r, name, info.Size(), progressInterval, e.progress)), nil
+}
+
+// writeAtomic calls write with a temporary file in the directory of path and
+// moves it to path with publish if write succeeds. On any error the temporary
+// file is removed. With WithDurability the file and the directory are synced.
+func (e *Encryptor) writeAtomic(path string, overwrite bool, write func(w io.Writer) error) (err error) {
+	// Fail early if the destination file already exists and overwrite is not
+	// enabled, publish checks again atomically
+	if !overwrite {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%w: %s, overwrite is disabled", ErrFileExists, path)
+		}
+	}
+
+	// Create the temporary file next to the destination so it can be renamed
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if err := e.syncFile(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := publish(tmp.Name(), path, overwrite); err != nil {
+		return err
+	}
+	return e.syncDir(filepath.Dir(path))
+}
+
+// publish moves the complete temporary file to path. Without overwrite it is
+// hard linked instead of renamed, which fails if path was created by someone
+// else while the file was written. File systems without hard links fall back
+// to a checked rename.
+func publish(tmp, path string, overwrite bool) error {
+	if !overwrite {
+		err := os.Link(tmp, path)
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%w: %s, overwrite is disabled", ErrFileExists, path)
+		}
+		if err == nil {
+			os.Remove(tmp)
+			return nil
+		}
+		if _, err := os.Lstat(path); err == nil {
+			return fmt.Errorf("%w: %s, overwrite is disabled", ErrFileExists, path)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+	return nil
+}
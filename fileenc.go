@@ -0,0 +1,622 @@
+// Package fileenc encrypts and decrypts files and streams with AES.
+//
+// Encrypted data starts with a versioned header holding the cipher and the
+// key derivation parameters, followed by the ciphertext. By default the key is
+// derived from a passphrase with Argon2id and the data is sealed with AES-GCM
+// in chunks, so modifications are detected on decryption.
+//
+//	enc, err := fileenc.New([]byte("passphrase"))
+//	if err != nil {
+//		return err
+//	}
+//	err = enc.EncryptFile("report.pdf", "report.pdf.enc")
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"filippo.io/age"
+)
+
+// ErrAlreadyEncrypted is returned when the input of an encryption already is an encrypted file
+var ErrAlreadyEncrypted = errors.New("input is already encrypted")
+
+// ErrWrongPassword is returned when the passphrase or key does not match the
+// key check value stored in the header
+var ErrWrongPassword = errors.New("wrong password or key")
+
+// Encryptor encrypts and decrypts data with a passphrase or for recipients. It is
+// configured with options when created and safe for concurrent use.
+type Encryptor struct {
+	pass           []byte
+	format         string
+	cipher         string
+	kdf            KDFParams
+	compression    string
+	recipients     []Recipient
+	identities     []Identity
+	legacy         bool
+	overwrite      bool
+	force          bool
+	resume         bool
+	convergent     bool
+	split          int64
+	armor          bool
+	shareCount     int
+	shareThreshold int
+	// ctx is only set on the copies made by withContext
+	ctx           context.Context
+	ageRecipients []age.Recipient
+	ageIdentities []age.Identity
+	metadata      *Metadata
+	fileMetadata  bool
+	storeName     bool
+	storeOwner    bool
+	progress      ProgressFunc
+	keyCache      *KeyCache
+	readLimit     *rateLimiter
+	writeLimit    *rateLimiter
+	// threads seal and open the chunks of a file in parallel, see parallel.go
+	threads int
+	// bufferSize is the size of the pooled copy buffers, see buffer.go
+	bufferSize int
+	// dropCache keeps the files out of the page cache, see cache.go
+	dropCache bool
+	// durable syncs outputs and their directories, see durable.go
+	durable bool
+	// preserveSymlinks and followSymlinks select how links are encrypted, see symlink.go
+	preserveSymlinks bool
+	followSymlinks   bool
+	skipped          SkipFunc
+	selected         SelectFunc
+	// signer signs on encryption, trustedSigners are required on decryption
+	signer         *Ed25519Signer
+	trustedSigners []*Ed25519PublicKey
+	// expires is stored in the header, ignoreExpiry skips its check on decryption
+	expires      time.Time
+	ignoreExpiry bool
+	// fields are stored encrypted in the header, see fields.go
+	fields map[string]string
+	// random is crypto/rand unless replaced by WithRand
+	random io.Reader
+}
+
+// Option configures an Encryptor
+type Option func(*Encryptor)
+
+// WithCipher selects the cipher used for encryption, CipherAESGCM by default.
+// Decryption always uses the cipher recorded in the header.
+func WithCipher(name string) Option {
+	return func(e *Encryptor) {
+		e.cipher = name
+	}
+}
+
+// WithKDF selects the key derivation function and its cost parameters used for
+// encryption, Argon2id with DefaultKDFParams by default. With KDFNone the
+// passphrase is used as raw AES key and must be 16, 24 or 32 bytes long, or a
+// 24 or 32 byte key in hex or a 32 byte key in Base64.
+// Decryption always uses the parameters recorded in the header.
+func WithKDF(params KDFParams) Option {
+	return func(e *Encryptor) {
+		e.kdf = params
+	}
+}
+
+// WithCompression compresses the data with CompressionGzip or CompressionZstd
+// before encryption, CompressionNone by default. The compression is recorded in
+// the header and undone automatically on decryption.
+func WithCompression(name string) Option {
+	return func(e *Encryptor) {
+		e.compression = name
+	}
+}
+
+// WithRecipients encrypts for the recipients instead of the passphrase. A random
+// file key encrypts the data and is stored wrapped for every recipient in the header.
+func WithRecipients(recipients ...Recipient) Option {
+	return func(e *Encryptor) {
+		e.recipients = append(e.recipients, recipients...)
+	}
+}
+
+// WithIdentities decrypts files encrypted for recipients with the identities.
+// Files encrypted with a passphrase still use the passphrase.
+func WithIdentities(identities ...Identity) Option {
+	return func(e *Encryptor) {
+		e.identities = append(e.identities, identities...)
+	}
+}
+
+// WithLegacy makes decryption expect headerless AES-CFB data as written by
+// older fileenc versions, using the passphrase as raw AES key.
+func WithLegacy() Option {
+	return func(e *Encryptor) {
+		e.legacy = true
+	}
+}
+
+// WithForce allows encrypting input that already is a fileenc, age or armored
+// OpenPGP file, which is refused with ErrAlreadyEncrypted otherwise
+func WithForce() Option {
+	return func(e *Encryptor) {
+		e.force = true
+	}
+}
+
+// WithOverwrite allows EncryptFile and DecryptFile to replace existing files
+func WithOverwrite(overwrite bool) Option {
+	return func(e *Encryptor) {
+		e.overwrite = overwrite
+	}
+}
+
+// WithProgress makes EncryptFile and DecryptFile report their progress to fn
+func WithProgress(fn ProgressFunc) Option {
+	return func(e *Encryptor) {
+		e.progress = fn
+	}
+}
+
+// WithRand makes encryption read the IV and then the KDF salt or the file key
+// of recipients from r instead of crypto/rand. It exists for test
+// vectors and reproducible output only: files encrypted with a predictable r
+// are not secure. The keys of recipients, age and OpenPGP always use crypto/rand.
+func WithRand(r io.Reader) Option {
+	return func(e *Encryptor) {
+		e.random = r
+	}
+}
+
+// New returns an Encryptor for the passphrase configured by the options. The
+// passphrase may be nil when only recipients and identities are used.
+func New(pass []byte, opts ...Option) (*Encryptor, error) {
+	kdf, _ := DefaultKDFParams(KDFArgon2id)
+	e := &Encryptor{pass: pass, format: FormatFileenc, cipher: CipherAESGCM, kdf: kdf, compression: CompressionNone, random: rand.Reader, bufferSize: DefaultBufferSize}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	if _, ok := cipherID(e.cipher); !ok {
+		return nil, fmt.Errorf("unknown cipher %q", e.cipher)
+	}
+	if _, ok := compressionIDs[e.compression]; !ok && e.compression != CompressionNone {
+		return nil, fmt.Errorf("unknown compression %q", e.compression)
+	}
+	if err := e.kdf.Validate(); err != nil {
+		return nil, err
+	}
+	if err := e.validateFormat(); err != nil {
+		return nil, err
+	}
+	if err := e.validateResume(); err != nil {
+		return nil, err
+	}
+	if err := e.validateConvergent(); err != nil {
+		return nil, err
+	}
+	if err := e.validateSplit(); err != nil {
+		return nil, err
+	}
+	if err := e.validateShares(); err != nil {
+		return nil, err
+	}
+	if err := e.validateSigner(); err != nil {
+		return nil, err
+	}
+	if err := e.validateExpiry(); err != nil {
+		return nil, err
+	}
+	if err := e.validateFields(); err != nil {
+		return nil, err
+	}
+	if (e.kdf.Name == KDFNone && len(e.recipients) == 0) || e.legacy {
+		key, err := rawKey(pass)
+		if err != nil {
+			return nil, err
+		}
+		key.Destroy()
+	}
+	return e, nil
+}
+
+// NewEncryptingWriter returns a writer encrypting everything written to it to w
+// using the passphrase key and the options. The final chunk and the
+// authentication tag are only written on Close, which does not close w.
+func NewEncryptingWriter(w io.Writer, key []byte, opts ...Option) (io.WriteCloser, error) {
+	e, err := New(key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return e.NewWriter(w)
+}
+
+// NewDecryptingReader returns a reader decrypting the data read from r using
+// the passphrase key and the options. The header is read before it returns.
+func NewDecryptingReader(r io.Reader, key []byte, opts ...Option) (io.Reader, error) {
+	e, err := New(key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return e.NewReader(r)
+}
+
+// NewWriter writes the header to w and returns a writer encrypting everything
+// written to it to w. Close must be called to finish the encrypted data, it
+// does not close w.
+func (e *Encryptor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return e.newWriter(w, e.metadata, nil)
+}
+
+// newWriter implements NewWriter, storing md in the header if it is not nil.
+// With convergent encryption sum is the content digest from convergentSum.
+func (e *Encryptor) newWriter(w io.Writer, md *Metadata, sum []byte) (io.WriteCloser, error) {
+	if !e.armor {
+		return e.newBinaryWriter(w, md, sum)
+	}
+	aw := newArmorWriter(w, e.format)
+	bw, err := e.newBinaryWriter(aw, md, sum)
+	if err != nil {
+		return nil, err
+	}
+	return &chainWriter{Writer: bw, closers: []io.Closer{bw, aw}}, nil
+}
+
+// newBinaryWriter implements newWriter without armor
+func (e *Encryptor) newBinaryWriter(w io.Writer, md *Metadata, sum []byte) (io.WriteCloser, error) {
+	switch e.format {
+	case FormatAge:
+		return e.newAgeWriter(w)
+	case FormatOpenPGP:
+		return e.newOpenPGPWriter(w)
+	}
+	if e.shareCount > 0 {
+		return nil, errors.New("key shares are only written by EncryptFile")
+	}
+
+	// Generate a random IV, for the authenticated ciphers it salts the per-file subkey.
+	// Convergent encryption derives it from the content once the key is known.
+	iv := make([]byte, aes.BlockSize)
+	if e.convergent {
+		if sum == nil {
+			return nil, errConvergentStream
+		}
+	} else if _, err := io.ReadFull(e.random, iv); err != nil {
+		return nil, fmt.Errorf("failed to generate IV: %w", err)
+	}
+	h := header{Version: formatVersion, Cipher: e.cipher, IV: iv}
+	if e.compression != CompressionNone {
+		h.Extensions = append(h.Extensions, extension{Type: extCompression, Data: []byte{compressionIDs[e.compression]}})
+	}
+	if md != nil {
+		h.Extensions = append(h.Extensions, md.extensions()...)
+	}
+	if e.signer != nil {
+		h.Extensions = append(h.Extensions, extension{Type: extSignature, Data: e.signer.PublicKey().pub})
+	}
+	if !e.expires.IsZero() {
+		h.Extensions = append(h.Extensions, expiryExtension(e.expires))
+	}
+
+	var key *SecureBuffer
+	if len(e.recipients) > 0 {
+		// Use a random file key and store it wrapped for every recipient
+		fileKey, stanzas, err := wrapFileKey(e.random, e.recipients)
+		if err != nil {
+			return nil, err
+		}
+		key = fileKey
+		h.KDF = KDFParams{Name: KDFNone}
+		h.Extensions = append(h.Extensions, stanzas...)
+		defer key.Destroy()
+	} else {
+		// Derive the key from the passphrase using a fresh salt or the salt of the key cache
+		h.KDF = e.kdf
+		var err error
+		switch {
+		case h.KDF.Name == KDFNone:
+		case e.convergent:
+			h.KDF = convergentKDF(e.kdf)
+		case e.keyCache != nil:
+			h.KDF, err = e.keyCache.salted(e.kdf)
+		default:
+			err = h.KDF.newKDFSalt(e.random)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key, err = e.deriveKey(h.KDF); err != nil {
+			return nil, err
+		}
+		defer key.Destroy()
+	}
+
+	// The fields are sealed before the convergent IV is derived from the header
+	if len(e.fields) > 0 {
+		ext, err := fieldsExtension(e.fields, key.Bytes(), iv)
+		if err != nil {
+			return nil, err
+		}
+		h.Extensions = append(h.Extensions, ext)
+	}
+	if len(e.recipients) == 0 {
+		if e.convergent {
+			var err error
+			if iv, err = convergentIV(key.Bytes(), sum, h); err != nil {
+				return nil, err
+			}
+			h.IV = iv
+		}
+		kcv, err := keyCheck(key.Bytes(), iv)
+		if err != nil {
+			return nil, err
+		}
+		h.Extensions = append(h.Extensions, extension{Type: extKeyCheck, Data: kcv})
+	}
+
+	hdr, err := h.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the header in front of the encrypted data
+	if _, err := w.Write(hdr); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	var cw io.WriteCloser
+	switch e.cipher {
+	case CipherAESCFB:
+		cw, err = newCFBWriter(w, key.Bytes(), iv)
+	default:
+		if e.threads > 1 {
+			cw, err = newParallelChunkWriter(w, e.cipher, key.Bytes(), iv, hdr, e.threads)
+			break
+		}
+		cw, err = newChunkWriter(w, e.cipher, key.Bytes(), iv, hdr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Compress in front of the encryption
+	if e.compression != CompressionNone {
+		zw, err := newCompressWriter(cw, e.compression)
+		if err != nil {
+			return nil, err
+		}
+		cw = &chainWriter{Writer: zw, closers: []io.Closer{zw, cw}}
+	}
+	// Sign in front of everything
+	if e.signer != nil {
+		cw = &signWriter{w: cw, h: sha512.New(), priv: e.signer.priv}
+	}
+	return cw, nil
+}
+
+// NewReader reads the header from r and returns a reader decrypting the data
+// following it. With an authenticated cipher the reader only returns authenticated plaintext
+// and fails with ErrAuthFailed on modified or truncated data. Files in the age
+// format are recognized and decrypted with the age identities or the passphrase.
+func (e *Encryptor) NewReader(r io.Reader) (io.Reader, error) {
+	cr, hdr, err := e.newReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return plaintext(cr, hdr)
+}
+
+// newReader implements NewReader and also returns the header, which is empty for age files
+func (e *Encryptor) newReader(r io.Reader) (io.Reader, header, error) {
+	// Read the header, legacy files only carry the IV
+	var hdr header
+	var rawHdr []byte
+	var err error
+	if e.legacy {
+		hdr = header{Cipher: CipherAESCFB, KDF: KDFParams{Name: KDFNone}, IV: make([]byte, aes.BlockSize)}
+		if _, err := io.ReadFull(r, hdr.IV); err != nil {
+			return nil, header{}, fmt.Errorf("failed to read IV: %w", err)
+		}
+	} else {
+		// age files are handed over to age
+		br := bufio.NewReader(r)
+		if isAge, armored := detectAge(br); isAge {
+			ar, err := e.newAgeReader(br, armored)
+			if err == nil {
+				// age files carry no signature
+				ar, err = e.verified(ar, header{})
+			}
+			return ar, header{}, err
+		}
+		if isOpenPGP(br) {
+			return nil, header{}, errors.New("OpenPGP messages are decrypted with gpg")
+		}
+		r = br
+		if isArmored(br) {
+			if r, err = newArmorReader(br); err != nil {
+				return nil, header{}, err
+			}
+		}
+		if hdr, rawHdr, err = readHeader(r); err != nil {
+			return nil, header{}, err
+		}
+		if err := e.checkExpiry(hdr); err != nil {
+			return nil, header{}, err
+		}
+	}
+
+	key, err := e.headerKey(hdr)
+	if err != nil {
+		return nil, header{}, err
+	}
+	defer key.Destroy()
+	if hdr.Fields, err = hdr.fields(key.Bytes()); err != nil {
+		return nil, header{}, err
+	}
+
+	var cr io.Reader
+	switch hdr.Cipher {
+	case CipherAESCFB:
+		cr, err = newCFBReader(r, key.Bytes(), hdr.IV)
+	default:
+		if e.threads > 1 {
+			cr, err = newParallelChunkReader(r, hdr.Cipher, key.Bytes(), hdr.IV, rawHdr, e.threads)
+			break
+		}
+		cr, err = newChunkReader(r, hdr.Cipher, key.Bytes(), hdr.IV, rawHdr)
+	}
+	if err != nil {
+		return nil, header{}, err
+	}
+
+	// Undo the compression recorded in the header
+	if id, ok := hdr.extension(extCompression); ok {
+		if len(id) != 1 {
+			return nil, header{}, fmt.Errorf("%w: invalid compression", ErrMalformedHeader)
+		}
+		if cr, err = newDecompressReader(cr, id[0]); err != nil {
+			return nil, header{}, err
+		}
+	}
+	cr, err = e.verified(cr, hdr)
+	return cr, hdr, err
+}
+
+// headerKey unwraps the file key with the identities or derives the key from
+// the passphrase using the parameters stored in the header. The caller has
+// to destroy the returned key. If the header holds a key check value a wrong
+// passphrase fails with ErrWrongPassword.
+func (e *Encryptor) headerKey(hdr header) (*SecureBuffer, error) {
+	stanzas, err := hdr.stanzas()
+	if err != nil {
+		return nil, err
+	}
+	if len(stanzas) > 0 {
+		return unwrapFileKey(e.identities, stanzas)
+	}
+	key, err := e.deriveKey(hdr.KDF)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files written before the key check was introduced have none
+	if want, ok := hdr.extension(extKeyCheck); ok {
+		kcv, err := keyCheck(key.Bytes(), hdr.IV)
+		if err != nil {
+			key.Destroy()
+			return nil, err
+		}
+		if subtle.ConstantTimeCompare(kcv, want) != 1 {
+			key.Destroy()
+			return nil, ErrWrongPassword
+		}
+	}
+	return key, nil
+}
+
+// Encrypt reads plaintext from src and writes the header and ciphertext to dst
+func (e *Encryptor) Encrypt(dst io.Writer, src io.Reader) error {
+	var sum []byte
+	if e.convergent {
+		rs, ok := src.(io.ReadSeeker)
+		if !ok {
+			return errConvergentStream
+		}
+		var err error
+		if sum, err = e.convergentSum(rs); err != nil {
+			return err
+		}
+	}
+	return e.encrypt(dst, src, e.metadata, sum)
+}
+
+// encrypt implements Encrypt, storing md in the header if it is not nil.
+// sum is the content digest for convergent encryption.
+func (e *Encryptor) encrypt(dst io.Writer, src io.Reader, md *Metadata, sum []byte) error {
+	dst, src = e.limitIO(dst, src)
+	// Refuse to encrypt twice by accident
+	if !e.force {
+		br := bufio.NewReaderSize(src, e.bufferSize)
+		if format := encryptedFormat(br); format != "" {
+			return fmt.Errorf("%w as %s file", ErrAlreadyEncrypted, format)
+		}
+		src = br
+	}
+
+	w, err := e.newWriter(dst, md, sum)
+	if err != nil {
+		return err
+	}
+	if _, err := e.copy(w, src); err != nil {
+		return fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return w.Close()
+}
+
+// encryptedFormat returns the format of the data in br if it starts like an
+// encrypted file, an empty string otherwise. Binary OpenPGP data is not
+// recognized as its first byte is too likely in plaintext.
+func encryptedFormat(br *bufio.Reader) string {
+	if b, err := br.Peek(len(headerMagic)); err == nil && string(b) == headerMagic || isArmored(br) {
+		return FormatFileenc
+	}
+	if isAge, _ := detectAge(br); isAge {
+		return FormatAge
+	}
+	if b, err := br.Peek(len(pgpArmorHeader)); err == nil && string(b) == pgpArmorHeader {
+		return FormatOpenPGP
+	}
+	return ""
+}
+
+// Decrypt reads the header and ciphertext from src and writes the plaintext to dst.
+// With an authenticated cipher plaintext is only written after it has been authenticated, but
+// on error dst may hold the plaintext of the chunks preceding the failure.
+func (e *Encryptor) Decrypt(dst io.Writer, src io.Reader) error {
+	_, err := e.decrypt(dst, src)
+	return err
+}
+
+// decrypt implements Decrypt and also returns the header
+func (e *Encryptor) decrypt(dst io.Writer, src io.Reader) (header, error) {
+	dst, src = e.limitIO(dst, src)
+	r, hdr, err := e.newReader(src)
+	if err != nil {
+		return header{}, err
+	}
+	if r, err = plaintext(r, hdr); err != nil {
+		return header{}, err
+	}
+	if _, err := e.copy(dst, r); err != nil {
+		if errors.Is(err, ErrAuthFailed) {
+			return header{}, err
+		}
+		return header{}, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return hdr, nil
+}
@@ -0,0 +1,76 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// fingerprintSalt is the fixed salt of the key derived for fingerprints
+const fingerprintSalt = "fileenc fingerprint v1"
+
+// ErrFingerprintMismatch is returned when the passphrase or key does not have the expected fingerprint
+var ErrFingerprintMismatch = fmt.Errorf("%w: the fingerprint does not match", ErrWrongPassword)
+
+// Fingerprint returns a short fingerprint of the passphrase or key, which two
+// parties can compare over another channel to make sure they use the same key
+// before transferring large files. It is derived with Argon2id at the default
+// cost and a fixed salt, so it does not depend on the settings of any file and
+// guessing the passphrase from it is as hard as from an encrypted file. Keys
+// are fingerprinted as given, in hex, Base64 or raw form.
+func Fingerprint(pass []byte) (string, error) {
+	if len(pass) == 0 {
+		return "", errors.New("no passphrase or key to fingerprint")
+	}
+	params, _ := DefaultKDFParams(KDFArgon2id)
+	params.Salt = []byte(fingerprintSalt)
+	key, err := params.deriveKey(pass)
+	if err != nil {
+		return "", err
+	}
+	defer key.Destroy()
+	h := sha256.New()
+	h.Write([]byte(fingerprintSalt))
+	h.Write(key.Bytes())
+	sum := hex.EncodeToString(h.Sum(nil)[:8])
+	return sum[:4] + "-" + sum[4:8] + "-" + sum[8:12] + "-" + sum[12:], nil
+}
+
+// VerifyFingerprint checks that pass has the fingerprint and returns
+// ErrFingerprintMismatch if not
+func VerifyFingerprint(pass []byte, fingerprint string) error {
+	got, err := Fingerprint(pass)
+	if err != nil {
+		return err
+	}
+	if !FingerprintsEqual(got, fingerprint) {
+		return ErrFingerprintMismatch
+	}
+	return nil
+}
+
+// FingerprintsEqual compares two fingerprints ignoring case, spaces, dashes and colons
+func FingerprintsEqual(a, b string) bool {
+	normalize := strings.NewReplacer("-", "", " ", "", ":", "")
+	a, b = strings.ToLower(normalize.Replace(a)), strings.ToLower(normalize.Replace(b))
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,66 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// FormatFileenc writes the native fileenc format
+	FormatFileenc = "fileenc"
+	// FormatAge writes the age format (age-encryption.org/v1), files can be decrypted with age and rage
+	FormatAge = "age"
+	// FormatOpenPGP writes a passphrase encrypted OpenPGP message (RFC 4880), files can be decrypted with gpg
+	FormatOpenPGP = "openpgp"
+)
+
+// WithFormat selects the container format written on encryption, FormatFileenc
+// by default. Decryption recognizes age files, binary or armored, automatically.
+func WithFormat(name string) Option {
+	return func(e *Encryptor) {
+		e.format = name
+	}
+}
+
+// validateFormat checks that the options can be used with the format
+func (e *Encryptor) validateFormat() error {
+	switch e.format {
+	case FormatFileenc:
+		if len(e.ageRecipients) > 0 {
+			return errors.New("age recipients require the age format")
+		}
+	case FormatAge:
+		if e.compression != CompressionNone {
+			return errors.New("the age format does not support compression")
+		}
+		if len(e.recipients) > 0 {
+			return errors.New("fileenc public keys cannot be used with the age format, use age recipients")
+		}
+	case FormatOpenPGP:
+		if e.compression != CompressionNone {
+			return errors.New("the openpgp format does not support compression")
+		}
+		if len(e.recipients) > 0 || len(e.ageRecipients) > 0 {
+			return errors.New("the openpgp format only supports passphrase encryption")
+		}
+	default:
+		return fmt.Errorf("unknown format %q", e.format)
+	}
+	return nil
+}
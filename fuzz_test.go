@@ -0,0 +1,203 @@
+package fileenc_test
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/bits"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// fuzzPass is the passphrase of the seed files, the same as of the test vectors
+const fuzzPass = "correct horse battery staple"
+
+// addSeeds adds the short ciphertexts of the test vectors and files written
+// with the options that change the parsed structure to the corpus of f
+func addSeeds(f *testing.F) {
+	data, err := os.ReadFile(vectorsFile)
+	if err != nil {
+		f.Fatal(err)
+	}
+	var vs vectors
+	if err := json.Unmarshal(data, &vs); err != nil {
+		f.Fatalf("invalid %s: %v", vectorsFile, err)
+	}
+	for _, v := range vs.Vectors {
+		if v.Passphrase != fuzzPass || v.Ciphertext == "" {
+			continue
+		}
+		ct, err := hex.DecodeString(v.Ciphertext)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(ct)
+	}
+
+	kdf := fileenc.KDFParams{Name: fileenc.KDFPBKDF2, Time: 1}
+	plaintext := bytes.Repeat([]byte("fileenc fuzzing seed "), 16)
+	for _, opts := range [][]fileenc.Option{
+		{fileenc.WithCipher(fileenc.CipherChaCha20Poly1305)},
+		{fileenc.WithCompression(fileenc.CompressionGzip)},
+		{fileenc.WithCompression(fileenc.CompressionZstd)},
+		{fileenc.WithArmor()},
+		{fileenc.WithMetadata(fileenc.Metadata{Name: "seed.txt", Mode: 0600, UID: 1000, GID: 1000})},
+	} {
+		enc, err := fileenc.New([]byte(fuzzPass), append(opts, fileenc.WithKDF(kdf))...)
+		if err != nil {
+			f.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := enc.Encrypt(&out, bytes.NewReader(plaintext)); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(out.Bytes())
+	}
+	f.Add([]byte("FENC"))
+	f.Add([]byte("-----BEGIN FILEENC ENCRYPTED FILE-----\n"))
+}
+
+// cheap reports whether the KDF stored in data derives a key within
+// milliseconds, so the fuzzer spends its time in the parsers. Files in other
+// formats are left out as their parsers are not part of fileenc.
+func cheap(data []byte) bool {
+	if text, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "FILEENC:"); ok {
+		raw, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(text, "="))
+		if err != nil {
+			return true
+		}
+		data = raw
+	}
+	info, err := fileenc.Inspect(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return true
+	}
+	if info.Format != fileenc.FormatFileenc {
+		return false
+	}
+	k := info.KDF
+	switch k.Name {
+	case fileenc.KDFArgon2id:
+		return uint64(k.Time)*uint64(k.Memory) <= 1<<12
+	case fileenc.KDFScrypt:
+		return k.Time <= 10 && uint64(k.Memory)*uint64(k.Threads) <= 8
+	case fileenc.KDFPBKDF2:
+		return k.Time <= 10000
+	}
+	return true
+}
+
+// FuzzDecrypt decrypts arbitrary input, which must fail with an error rather
+// than panic, hang or allocate without bound
+func FuzzDecrypt(f *testing.F) {
+	addSeeds(f)
+	enc, err := fileenc.New([]byte(fuzzPass))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if !cheap(data) {
+			t.Skip("expensive key derivation")
+		}
+		var out bytes.Buffer
+		if err := enc.Decrypt(&out, bytes.NewReader(data)); err != nil {
+			return
+		}
+		ra, err := enc.NewReaderAt(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		got, err := io.ReadAll(io.NewSectionReader(ra, 0, ra.Size()))
+		if err != nil {
+			t.Fatalf("ReaderAt failed after Decrypt succeeded: %v", err)
+		}
+		if !bytes.Equal(got, out.Bytes()) {
+			t.Fatal("ReaderAt and Decrypt return different plaintexts")
+		}
+	})
+}
+
+// FuzzInspect parses arbitrary headers without a key
+func FuzzInspect(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, err := fileenc.Inspect(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		if info.HeaderSize > len(data) {
+			t.Fatalf("header size %d exceeds the input of %d bytes", info.HeaderSize, len(data))
+		}
+	})
+}
+
+// FuzzDecryptText decrypts arbitrary text as given in environment variables
+// and configuration files
+func FuzzDecryptText(f *testing.F) {
+	enc, err := fileenc.New([]byte(fuzzPass), fileenc.WithKDF(fileenc.KDFParams{Name: fileenc.KDFPBKDF2, Time: 1}))
+	if err != nil {
+		f.Fatal(err)
+	}
+	text, err := enc.EncryptText([]byte("api token"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(text)
+	f.Add("FILEENC:")
+	f.Fuzz(func(t *testing.T, text string) {
+		if !cheap([]byte(text)) {
+			t.Skip("expensive key derivation")
+		}
+		enc.DecryptText(text)
+	})
+}
+
+// FuzzValidateKDF checks that the KDF parameters accepted from headers stay
+// within the memory limit of 4 GiB, without overflows in the bound
+func FuzzValidateKDF(f *testing.F) {
+	f.Add(true, uint32(20), uint32(8), uint8(1))
+	f.Add(true, uint32(25), uint32(4294967169), uint8(1))
+	f.Add(true, uint32(30), uint32(1), uint8(255))
+	f.Add(false, uint32(3), uint32(4<<20), uint8(4))
+	f.Add(false, uint32(1), uint32(4294967295), uint8(1))
+	f.Fuzz(func(t *testing.T, scrypt bool, time, memory uint32, threads uint8) {
+		const maxMemory = 4 << 30
+		p := fileenc.KDFParams{Name: fileenc.KDFArgon2id, Time: time, Memory: memory, Threads: threads}
+		// Argon2id needs memory KiB
+		hi, lo := uint64(0), uint64(memory)*1024
+		if scrypt {
+			p.Name = fileenc.KDFScrypt
+			if time < 1 || time > 30 {
+				return
+			}
+			// scrypt needs 128·r·(N+p) bytes
+			hi, lo = bits.Mul64(128*uint64(memory), 1<<time+uint64(threads))
+		}
+		if err := p.Validate(); err == nil && (hi != 0 || lo > maxMemory) {
+			t.Fatalf("%+v accepted, needs more than 4 GiB", p)
+		} else if err != nil && scrypt && memory >= 1 && threads >= 1 && hi == 0 && lo <= maxMemory {
+			t.Fatalf("%+v rejected: %v", p, err)
+		}
+	})
+}
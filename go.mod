@@ -1,3 +1,17 @@
 module github.com/itkonzepte-net/fileenc
 
-go 1.22.6
+go 1.26.0
+
+require (
+	filippo.io/age v1.3.2
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/hanwen/go-fuse/v2 v2.11.0
+	github.com/klauspost/compress v1.20.1
+	golang.org/x/crypto v0.57.0
+	golang.org/x/sys v0.48.0
+	golang.org/x/term v0.46.0
+)
+
+require (
+	filippo.io/hpke v0.4.0 // indirect
+)
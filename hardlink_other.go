@@ -0,0 +1,38 @@
+//go:build !unix
+
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import "io/fs"
+
+// fileID identifies a file independent of its names
+type fileID struct {
+	dev, ino uint64
+}
+
+// hardLinkID reports no hard links, they are not detected on this platform
+// and archived as separate files
+func hardLinkID(info fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// fileIDOf reports no identity, files are only told apart by their names on
+// this platform
+func fileIDOf(info fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
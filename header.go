@@ -0,0 +1,237 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// headerMagic identifies files written by fileenc
+	headerMagic = "FENC"
+	// formatVersion is the version of the file format written by this fileenc
+	formatVersion = 1
+)
+
+// Header extension types
+const (
+	// extCompression holds the id of the compression applied before encryption
+	extCompression byte = 1
+	// extRecipient holds the file key wrapped for one recipient, it can appear multiple times
+	extRecipient byte = 2
+	// extName holds the base name of the original file
+	extName byte = 3
+	// extModTime holds the modification time of the original file in nanoseconds since 1970, int64
+	extModTime byte = 4
+	// extMode holds the permission bits of the original file, uint32
+	extMode byte = 5
+	// extOwner holds the user and group id of the original file, two uint32
+	extOwner byte = 6
+	// extContent holds the type of the plaintext, contentTar for archives, contentSparse or contentSymlink
+	extContent byte = 7
+	// extKeyCheck holds a value derived from the key, so a wrong passphrase is
+	// recognized before any data is decrypted
+	extKeyCheck byte = 8
+	// extSignature holds the Ed25519 public key of the signer, the signature
+	// follows the plaintext, see signature.go
+	extSignature byte = 9
+	// extExpiry holds the expiry date in nanoseconds since 1970, int64, see expiry.go
+	extExpiry byte = 10
+	// extFields holds the key-value fields encrypted with a key derived from the file key, see fields.go
+	extFields byte = 11
+)
+
+// Content types of extContent
+const (
+	// contentTar marks the plaintext as tar archive of a directory
+	contentTar byte = 1
+	// contentSparse marks the plaintext as the records of a sparse file, see sparse.go
+	contentSparse byte = 2
+	// contentSymlink marks the plaintext as the target of a symbolic link
+	contentSymlink byte = 3
+)
+
+// cipherIDs maps the cipher names to the identifiers stored in the file header,
+// RegisterCipher adds to it
+var cipherIDs = map[string]byte{
+	CipherAESCFB:            1,
+	CipherAESGCM:            2,
+	CipherChaCha20Poly1305:  3,
+	CipherXChaCha20Poly1305: 4,
+}
+
+// ErrNotFileenc is returned when a file does not start with the fileenc header
+var ErrNotFileenc = errors.New("not a fileenc file")
+
+// ErrMalformedHeader is returned when the header of a fileenc file is damaged
+// or holds values this version does not know
+var ErrMalformedHeader = errors.New("malformed header")
+
+// header is the unencrypted header in front of every encrypted file.
+//
+// Layout, all integers big endian:
+//
+//	magic    [4]byte  "FENC"
+//	version  uint8    format version
+//	cipher   uint8    cipher id
+//	kdf      uint8    kdf id
+//	time     uint32   kdf time cost
+//	memory   uint32   kdf memory cost
+//	threads  uint8    kdf parallelism
+//	saltLen  uint8    followed by the kdf salt
+//	ivLen    uint8    followed by the cipher IV (aes-cfb) or subkey salt (authenticated ciphers)
+//	extLen   uint16   followed by extension fields
+//
+// Extension fields are encoded as type uint8, length uint16 and data and allow
+// adding optional information without changing the format version.
+type header struct {
+	Version    byte
+	Cipher     string
+	KDF        KDFParams
+	IV         []byte
+	Extensions []extension
+	// Fields holds the decrypted extFields, they are only set by newReader
+	Fields map[string]string
+}
+
+// extension is an optional type-length-value field of the header
+type extension struct {
+	Type byte
+	Data []byte
+}
+
+// marshal encodes the header into its binary representation
+func (h header) marshal() ([]byte, error) {
+	if len(h.KDF.Salt) > 255 || len(h.IV) > 255 {
+		return nil, errors.New("salt or IV too long for header")
+	}
+	var ext []byte
+	for _, e := range h.Extensions {
+		if len(e.Data) > 0xffff {
+			return nil, fmt.Errorf("header extension %d too long", e.Type)
+		}
+		ext = append(ext, e.Type)
+		ext = binary.BigEndian.AppendUint16(ext, uint16(len(e.Data)))
+		ext = append(ext, e.Data...)
+	}
+	if len(ext) > 0xffff {
+		return nil, errors.New("header extensions too long")
+	}
+
+	cid, _ := cipherID(h.Cipher)
+	kid, _ := kdfID(h.KDF.Name)
+	buf := []byte(headerMagic)
+	buf = append(buf, h.Version, cid, kid)
+	buf = binary.BigEndian.AppendUint32(buf, h.KDF.Time)
+	buf = binary.BigEndian.AppendUint32(buf, h.KDF.Memory)
+	buf = append(buf, h.KDF.Threads, byte(len(h.KDF.Salt)))
+	buf = append(buf, h.KDF.Salt...)
+	buf = append(buf, byte(len(h.IV)))
+	buf = append(buf, h.IV...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(ext)))
+	buf = append(buf, ext...)
+	return buf, nil
+}
+
+// extension returns the data of the first extension of type t
+func (h header) extension(t byte) ([]byte, bool) {
+	for _, e := range h.Extensions {
+		if e.Type == t {
+			return e.Data, true
+		}
+	}
+	return nil, false
+}
+
+// readHeader reads and validates the header from r and returns it together with its raw bytes
+func readHeader(r io.Reader) (header, []byte, error) {
+	var raw bytes.Buffer
+	tr := io.TeeReader(r, &raw)
+
+	fixed := make([]byte, 17)
+	if _, err := io.ReadFull(tr, fixed); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return header{}, nil, ErrNotFileenc
+		}
+		return header{}, nil, fmt.Errorf("failed to read header from file: %w", err)
+	}
+	if string(fixed[:4]) != headerMagic {
+		return header{}, nil, ErrNotFileenc
+	}
+
+	h := header{Version: fixed[4]}
+	if h.Version != formatVersion {
+		return header{}, nil, fmt.Errorf("%w: unsupported file format version %d", ErrMalformedHeader, h.Version)
+	}
+	var ok bool
+	if h.Cipher, ok = cipherName(fixed[5]); !ok {
+		return header{}, nil, fmt.Errorf("%w: unknown cipher id %d", ErrMalformedHeader, fixed[5])
+	}
+	if h.KDF.Name, ok = kdfName(fixed[6]); !ok {
+		return header{}, nil, fmt.Errorf("%w: unknown kdf id %d", ErrMalformedHeader, fixed[6])
+	}
+	h.KDF.Time = binary.BigEndian.Uint32(fixed[7:11])
+	h.KDF.Memory = binary.BigEndian.Uint32(fixed[11:15])
+	h.KDF.Threads = fixed[15]
+	if err := h.KDF.Validate(); err != nil {
+		return header{}, nil, fmt.Errorf("%w: %w", ErrMalformedHeader, err)
+	}
+
+	var err error
+	if h.KDF.Salt, err = readShortField(tr, int(fixed[16])); err != nil {
+		return header{}, nil, err
+	}
+	ivLen := make([]byte, 1)
+	if _, err := io.ReadFull(tr, ivLen); err != nil {
+		return header{}, nil, fmt.Errorf("failed to read header from file: %w", err)
+	}
+	if h.IV, err = readShortField(tr, int(ivLen[0])); err != nil {
+		return header{}, nil, err
+	}
+
+	extLen := make([]byte, 2)
+	if _, err := io.ReadFull(tr, extLen); err != nil {
+		return header{}, nil, fmt.Errorf("failed to read header from file: %w", err)
+	}
+	ext, err := readShortField(tr, int(binary.BigEndian.Uint16(extLen)))
+	if err != nil {
+		return header{}, nil, err
+	}
+	for len(ext) > 0 {
+		if len(ext) < 3 || len(ext) < 3+int(binary.BigEndian.Uint16(ext[1:3])) {
+			return header{}, nil, fmt.Errorf("%w: invalid extension", ErrMalformedHeader)
+		}
+		n := 3 + int(binary.BigEndian.Uint16(ext[1:3]))
+		h.Extensions = append(h.Extensions, extension{Type: ext[0], Data: ext[3:n]})
+		ext = ext[n:]
+	}
+
+	return h, raw.Bytes(), nil
+}
+
+// readShortField reads a length-prefixed header field of n bytes from r
+func readShortField(r io.Reader, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("failed to read header from file: %w", err)
+	}
+	return buf, nil
+}
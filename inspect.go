@@ -0,0 +1,214 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Info describes an encrypted file as far as it can be known without the key
+type Info struct {
+	// Format is FormatFileenc, FormatAge or FormatOpenPGP
+	Format string
+	// Version is the fileenc format version, 0 for other formats
+	Version int
+	// Cipher, KDF and Compression are only set for the fileenc format
+	Cipher      string
+	KDF         KDFParams
+	Compression string
+	// Recipients holds the type of every recipient the file key is wrapped for,
+	// it is empty for passphrase encrypted files
+	Recipients []string
+	// Metadata describes the original file as far as it has been stored
+	Metadata Metadata
+	// Signer is the public key of the signer of a signed file. The signature is
+	// only checked on decryption, until then the file merely claims it.
+	Signer *Ed25519PublicKey
+	// Expires is the expiry date stored in the header, the zero time if there is none
+	Expires time.Time
+	// Fields holds the fields stored with WithFields, they are encrypted and
+	// only set by Encryptor.Inspect
+	Fields map[string]string
+	// Armored is set for fileenc files in ASCII armor
+	Armored bool
+	// HeaderSize is the length of the unencrypted header in bytes
+	HeaderSize int
+	// Size is the plaintext size in bytes, -1 if it cannot be computed
+	Size int64
+	// EncryptedSize and ModTime describe the encrypted file, they are only set by InspectFile
+	EncryptedSize int64
+	ModTime       time.Time
+}
+
+// Inspect reads the header from r and describes the file without decrypting it.
+// size is the length of the encrypted data, it is used to compute the plaintext
+// size of uncompressed fileenc files; pass -1 if it is unknown.
+func Inspect(r io.Reader, size int64) (Info, error) {
+	info, _, err := inspect(r, size)
+	return info, err
+}
+
+// inspect implements Inspect and returns the header of fileenc files as well
+func inspect(r io.Reader, size int64) (Info, header, error) {
+	info := Info{Size: -1, EncryptedSize: size, Metadata: Metadata{UID: -1, GID: -1}}
+	br := bufio.NewReader(r)
+	if isAge, armored := detectAge(br); isAge {
+		info.Format = FormatAge
+		if !armored {
+			info.Recipients = ageStanzaTypes(br)
+		}
+		return info, header{}, nil
+	}
+	if isOpenPGP(br) {
+		info.Format = FormatOpenPGP
+		return info, header{}, nil
+	}
+
+	// The plaintext size of armored files is not computed
+	if isArmored(br) {
+		ar, err := newArmorReader(br)
+		if err != nil {
+			return Info{}, header{}, err
+		}
+		br = bufio.NewReader(ar)
+		info.Armored = true
+		size = -1
+	}
+
+	hdr, raw, err := readHeader(br)
+	if err != nil {
+		return Info{}, header{}, err
+	}
+	info.Format = FormatFileenc
+	info.Version = int(hdr.Version)
+	info.Cipher = hdr.Cipher
+	info.KDF = hdr.KDF
+	info.HeaderSize = len(raw)
+	info.Compression = CompressionNone
+	if id, ok := hdr.extension(extCompression); ok {
+		info.Compression = "unknown"
+		for name, cid := range compressionIDs {
+			if len(id) == 1 && id[0] == cid {
+				info.Compression = name
+			}
+		}
+	}
+	if info.Metadata, err = hdr.metadata(); err != nil {
+		return Info{}, header{}, err
+	}
+	stanzas, err := hdr.stanzas()
+	if err != nil {
+		return Info{}, header{}, err
+	}
+	for _, st := range stanzas {
+		info.Recipients = append(info.Recipients, st.Type)
+	}
+	if info.Signer, err = hdr.signer(); err != nil {
+		return Info{}, header{}, err
+	}
+	if info.Expires, err = hdr.expiry(); err != nil {
+		return Info{}, header{}, err
+	}
+
+	// Without compression the plaintext size follows from the chunk layout,
+	// sparse files are larger by their holes
+	if size >= 0 && info.Compression == CompressionNone && !info.Metadata.Sparse {
+		payload := size - int64(len(raw))
+		switch hdr.Cipher {
+		case CipherAESCFB:
+			info.Size = payload
+		default:
+			chunks := (payload + chunkSize + tagSize - 1) / (chunkSize + tagSize)
+			if plain := payload - chunks*tagSize; chunks > 0 && plain >= 0 {
+				info.Size = plain
+			}
+		}
+		// The signature follows the plaintext
+		if info.Signer != nil && info.Size >= 0 {
+			info.Size = max(info.Size-ed25519.SignatureSize, -1)
+		}
+	}
+	return info, hdr, nil
+}
+
+// Inspect describes the file like the function Inspect and decrypts the fields
+// stored in the header of fileenc files, which requires the key or an identity.
+// A wrong key fails with ErrWrongPassword or ErrNoIdentity.
+func (e *Encryptor) Inspect(r io.Reader, size int64) (Info, error) {
+	info, hdr, err := inspect(r, size)
+	if err != nil || info.Format != FormatFileenc {
+		return info, err
+	}
+	key, err := e.headerKey(hdr)
+	if err != nil {
+		return Info{}, err
+	}
+	defer key.Destroy()
+	if info.Fields, err = hdr.fields(key.Bytes()); err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
+
+// InspectFile describes the encrypted file at path, see Inspect
+func InspectFile(path string) (Info, error) {
+	return inspectFile(path, Inspect)
+}
+
+// InspectFile describes the encrypted file at path including its fields, see Encryptor.Inspect
+func (e *Encryptor) InspectFile(path string) (Info, error) {
+	return inspectFile(path, e.Inspect)
+}
+
+// inspectFile implements InspectFile with the function inspect
+func inspectFile(path string, inspect func(io.Reader, int64) (Info, error)) (Info, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to open encrypted file: %w", err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+	info, err := inspect(file, stat.Size())
+	if err != nil {
+		return Info{}, err
+	}
+	info.ModTime = stat.ModTime()
+	return info, nil
+}
+
+// ageStanzaTypes returns the recipient types listed in a binary age header
+func ageStanzaTypes(br *bufio.Reader) []string {
+	var types []string
+	for {
+		line, err := readLine(br, maxArmorLine)
+		if err != nil || strings.HasPrefix(line, "---") {
+			return types
+		}
+		if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "->" {
+			types = append(types, fields[1])
+		}
+	}
+}
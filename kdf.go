@@ -0,0 +1,276 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// KDFNone uses the passphrase as raw AES key, it must be 16, 24 or 32 bytes
+	// long, or a 24 or 32 byte key in hex or a 32 byte key in Base64
+	KDFNone = "none"
+	// KDFArgon2id derives the key with Argon2id (recommended)
+	KDFArgon2id = "argon2id"
+	// KDFScrypt derives the key with scrypt
+	KDFScrypt = "scrypt"
+	// KDFPBKDF2 derives the key with PBKDF2-HMAC-SHA256
+	KDFPBKDF2 = "pbkdf2"
+
+	// derivedKeySize is the length of derived keys, selecting AES-256
+	derivedKeySize = 32
+	// kdfSaltSize is the length of the random salt stored in the file header
+	kdfSaltSize = 16
+
+	// maxKDFMemory limits the memory in bytes a KDF may use, so a malicious
+	// header cannot make decryption allocate more than a large machine has
+	maxKDFMemory = 4 << 30
+	// maxArgon2Time and maxPBKDF2Iterations reject time costs far above any
+	// calibrated setting, so a malicious header cannot make derivation run
+	// indefinitely. A header at the limits can still take hours.
+	maxArgon2Time       = 1 << 10
+	maxPBKDF2Iterations = 100_000_000
+)
+
+// ErrInvalidKey is returned for keys of the wrong length or in a malformed text form
+var ErrInvalidKey = errors.New("invalid key")
+
+// kdfIDs maps the KDF names to the identifiers stored in the file header,
+// RegisterKDF adds to it
+var kdfIDs = map[string]byte{
+	KDFNone:     0,
+	KDFArgon2id: 1,
+	KDFScrypt:   2,
+	KDFPBKDF2:   3,
+}
+
+// KDFParams holds a key derivation function and its cost parameters.
+// Salt is generated on encryption and read from the file header on decryption.
+// The meaning of Time, Memory and Threads depends on the function:
+//
+//	argon2id: passes, memory in KiB, parallelism
+//	scrypt:   log2 of N, block size r, parallelism p
+//	pbkdf2:   iterations, unused, unused
+type KDFParams struct {
+	Name    string
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	Salt    []byte
+}
+
+// DefaultKDFParams returns the recommended cost parameters for the named KDF
+func DefaultKDFParams(name string) (KDFParams, error) {
+	switch name {
+	case KDFNone:
+		return KDFParams{Name: name}, nil
+	case KDFArgon2id:
+		return KDFParams{Name: name, Time: 3, Memory: 64 * 1024, Threads: 4}, nil
+	case KDFScrypt:
+		return KDFParams{Name: name, Time: 15, Memory: 8, Threads: 1}, nil
+	case KDFPBKDF2:
+		return KDFParams{Name: name, Time: 600000}, nil
+	}
+	if k, ok := registeredKDF(name); ok {
+		return k.Defaults, nil
+	}
+	return KDFParams{}, fmt.Errorf("unknown kdf %q", name)
+}
+
+// CalibrateKDF returns the parameters of the named KDF taking about target to
+// derive a key on this machine and the measured time. The time cost is scaled
+// from a cheap run, the memory and parallelism of the defaults are kept.
+// Registered KDFs keep their defaults, only their time is measured.
+func CalibrateKDF(name string, target time.Duration) (KDFParams, time.Duration, error) {
+	p, err := DefaultKDFParams(name)
+	if err != nil || name == KDFNone {
+		return p, 0, err
+	}
+	if _, ok := registeredKDF(name); ok {
+		took, err := p.measure()
+		if err != nil {
+			return KDFParams{}, 0, err
+		}
+		return p, took, nil
+	}
+	switch name {
+	case KDFArgon2id:
+		p.Time = 1
+	case KDFScrypt:
+		p.Time = 12
+	case KDFPBKDF2:
+		p.Time = 10000
+	}
+	took, err := p.measure()
+	if err != nil {
+		return KDFParams{}, 0, err
+	}
+	// The first estimate is refined once, cheap runs are dominated by setup costs
+	for range 2 {
+		scale := float64(target) / float64(took)
+		if name == KDFScrypt {
+			// Every step of log2(N) doubles the time
+			maxLog := math.Log2(maxKDFMemory/(128*float64(p.Memory))) - 1
+			p.Time = uint32(min(max(float64(p.Time)+math.Round(math.Log2(scale)), 1), maxLog))
+		} else {
+			limit := float64(maxArgon2Time)
+			if name == KDFPBKDF2 {
+				limit = maxPBKDF2Iterations
+			}
+			p.Time = uint32(min(max(math.Round(float64(p.Time)*scale), 1), limit))
+		}
+		if took, err = p.measure(); err != nil {
+			return KDFParams{}, 0, err
+		}
+	}
+	return p, took, nil
+}
+
+// measure returns the time taken to derive a key with the parameters
+func (p KDFParams) measure() (time.Duration, error) {
+	if err := p.newKDFSalt(rand.Reader); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	key, err := p.deriveKey([]byte("fileenc calibration"))
+	took := time.Since(start)
+	key.Destroy()
+	return took, err
+}
+
+// Validate checks that the cost parameters are usable for the KDF
+func (p KDFParams) Validate() error {
+	switch p.Name {
+	case KDFNone:
+		return nil
+	case KDFArgon2id:
+		if p.Time < 1 || p.Time > maxArgon2Time || p.Memory < 8*uint32(p.Threads) || p.Threads < 1 ||
+			uint64(p.Memory)*1024 > maxKDFMemory {
+			return fmt.Errorf("invalid argon2id parameters time=%d memory=%d threads=%d", p.Time, p.Memory, p.Threads)
+		}
+	case KDFScrypt:
+		// scrypt needs 128·r·N bytes for its table and 128·r·p for the blocks,
+		// divided rather than multiplied so large r cannot overflow
+		if p.Time < 1 || p.Time > 30 || p.Memory < 1 || p.Threads < 1 ||
+			uint64(p.Memory) > maxKDFMemory/128/(1<<p.Time+uint64(p.Threads)) {
+			return fmt.Errorf("invalid scrypt parameters log2(N)=%d r=%d p=%d", p.Time, p.Memory, p.Threads)
+		}
+	case KDFPBKDF2:
+		if p.Time < 1 || p.Time > maxPBKDF2Iterations {
+			return fmt.Errorf("invalid pbkdf2 iterations %d", p.Time)
+		}
+	default:
+		k, ok := registeredKDF(p.Name)
+		if !ok {
+			return fmt.Errorf("unknown kdf %q", p.Name)
+		}
+		return k.Validate(p)
+	}
+	return nil
+}
+
+// deriveKey derives an AES key from the passphrase using the KDF, its
+// parameters and salt. The key is returned in a SecureBuffer owned by the caller.
+func (p KDFParams) deriveKey(pass []byte) (*SecureBuffer, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	switch p.Name {
+	case KDFNone:
+		return rawKey(pass)
+	case KDFArgon2id:
+		return SecureCopy(argon2.IDKey(pass, p.Salt, p.Time, p.Memory, p.Threads, derivedKeySize)), nil
+	case KDFScrypt:
+		key, err := scrypt.Key(pass, p.Salt, 1<<p.Time, int(p.Memory), int(p.Threads), derivedKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+		return SecureCopy(key), nil
+	case KDFPBKDF2:
+		// crypto/pbkdf2 takes the passphrase as a string, a copy that cannot be cleared
+		key, err := pbkdf2.Key(sha256.New, string(pass), p.Salt, int(p.Time), derivedKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+		return SecureCopy(key), nil
+	}
+	k, _ := registeredKDF(p.Name)
+	key, err := k.Derive(pass, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	if len(key) != derivedKeySize {
+		clear(key)
+		return nil, fmt.Errorf("kdf %s returned a %d byte key instead of %d bytes", p.Name, len(key), derivedKeySize)
+	}
+	return SecureCopy(key), nil
+}
+
+// DeriveKey derives a 256 bit key from the passphrase with the KDF, its
+// parameters and Salt, which must be set unless the KDF is KDFNone. The caller
+// has to destroy the key.
+func (p KDFParams) DeriveKey(pass []byte) (*SecureBuffer, error) {
+	if p.Name != KDFNone && len(p.Salt) == 0 {
+		return nil, errors.New("no salt given")
+	}
+	return p.deriveKey(pass)
+}
+
+// rawKey returns the AES key given as passphrase for KDFNone: 16, 24 or 32
+// bytes are used as is, a 24 or 32 byte key may be given in hex and a 32 byte
+// key in Base64, as written by fileenc keygen -symmetric
+func rawKey(pass []byte) (*SecureBuffer, error) {
+	key := NewSecureBuffer(len(pass))
+	switch len(pass) {
+	case 16, 24, 32:
+		key.data = key.data[:copy(key.data, pass)]
+		return key, nil
+	case 48, 64:
+		if n, err := hex.Decode(key.data, pass); err == nil {
+			key.data = key.data[:n]
+			return key, nil
+		}
+	case 44:
+		if n, err := base64.StdEncoding.Decode(key.data, pass); err == nil && n == 32 {
+			key.data = key.data[:n]
+			return key, nil
+		}
+	}
+	key.Destroy()
+	return nil, fmt.Errorf("%w: key must be 16, 24, or 32 bytes long or given in hex or Base64, got %d bytes", ErrInvalidKey, len(pass))
+}
+
+// newKDFSalt fills the salt of the parameters with bytes read from random
+func (p *KDFParams) newKDFSalt(random io.Reader) error {
+	p.Salt = make([]byte, kdfSaltSize)
+	if _, err := io.ReadFull(random, p.Salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return nil
+}
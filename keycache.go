@@ -0,0 +1,115 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// KeyCache keeps the keys derived from passphrases in memory, so the
+// passphrase is only stretched once per salt instead of once per file.
+// Encryptors sharing a cache also share one salt per set of KDF parameters
+// for encryption; every file still gets its own subkey from its random IV.
+// A KeyCache is safe for concurrent use.
+type KeyCache struct {
+	mu    sync.Mutex
+	keys  map[[sha256.Size]byte]*SecureBuffer
+	salts map[string]KDFParams
+}
+
+// NewKeyCache returns an empty KeyCache
+func NewKeyCache() *KeyCache {
+	return &KeyCache{keys: map[[sha256.Size]byte]*SecureBuffer{}, salts: map[string]KDFParams{}}
+}
+
+// WithKeyCache makes the Encryptor look up and store derived keys in cache
+func WithKeyCache(cache *KeyCache) Option {
+	return func(e *Encryptor) {
+		e.keyCache = cache
+	}
+}
+
+// Clear zeroes and forgets the cached keys and salts
+func (c *KeyCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, key := range c.keys {
+		key.Destroy()
+		delete(c.keys, id)
+	}
+	clear(c.salts)
+}
+
+// salted returns params with the salt used for encryption with them
+func (c *KeyCache) salted(params KDFParams) (KDFParams, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := fmt.Sprintf("%s/%d/%d/%d", params.Name, params.Time, params.Memory, params.Threads)
+	if p, ok := c.salts[id]; ok {
+		return p, nil
+	}
+	if err := params.newKDFSalt(rand.Reader); err != nil {
+		return KDFParams{}, err
+	}
+	c.salts[id] = params
+	return params, nil
+}
+
+// deriveKey returns a copy of the key derived from pass with params, deriving
+// it only if it is not cached. The passphrase is part of the lookup, so a cache
+// can be shared by Encryptors with different passphrases.
+func (c *KeyCache) deriveKey(params KDFParams, pass []byte) (*SecureBuffer, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s/%d/%d/%d/%d:", params.Name, params.Time, params.Memory, params.Threads, len(params.Salt))
+	h.Write(params.Salt)
+	h.Write(pass)
+	var id [sha256.Size]byte
+	h.Sum(id[:0])
+
+	c.mu.Lock()
+	if key, ok := c.keys[id]; ok {
+		defer c.mu.Unlock()
+		return key.clone(), nil
+	}
+	c.mu.Unlock()
+
+	// Derive without holding the lock, a key derived concurrently is discarded
+	derived, err := params.deriveKey(pass)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[id]; ok {
+		derived.Destroy()
+		return key.clone(), nil
+	}
+	c.keys[id] = derived
+	return derived.clone(), nil
+}
+
+// deriveKey derives the key for params from the passphrase, through the key
+// cache if there is one. The caller has to destroy the key.
+func (e *Encryptor) deriveKey(params KDFParams) (*SecureBuffer, error) {
+	if e.keyCache == nil || params.Name == KDFNone {
+		return params.deriveKey(e.pass)
+	}
+	return e.keyCache.deriveKey(params, e.pass)
+}
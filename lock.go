@@ -0,0 +1,126 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrFileInUse is returned if the source or destination is locked by another
+// process, e.g. a second fileenc writing the same file
+var ErrFileInUse = errors.New("file is in use")
+
+// maxLockAttempts limits how often a lock file is opened again after it was
+// removed by the process holding it
+const maxLockAttempts = 3
+
+// fileLocks holds the files opened to keep their advisory locks, closing them
+// releases the locks
+type fileLocks struct {
+	files []*os.File
+	// dst is the lock file next to the destination, it is removed on release
+	dst *os.File
+}
+
+// lockFiles takes a shared lock on srcPath and an exclusive one on dstPath, a
+// file processed in place is locked exclusively. It fails with ErrFileInUse
+// instead of waiting for another process. Files that do not exist are not
+// locked, the destination is additionally locked through a lock file as it is
+// replaced by a new file or does not exist yet.
+func lockFiles(srcPath, dstPath string) (*fileLocks, error) {
+	locks := &fileLocks{}
+	if err := locks.add(srcPath, srcPath == dstPath); err != nil {
+		return nil, err
+	}
+	if srcPath != dstPath {
+		if err := locks.add(dstPath, true); err != nil {
+			locks.release()
+			return nil, err
+		}
+	}
+	if err := locks.addDst(dstPath); err != nil {
+		locks.release()
+		return nil, err
+	}
+	return locks, nil
+}
+
+// add opens and locks the file at path
+func (l *fileLocks) add(path string, exclusive bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		// Missing sources are reported when they are opened for reading
+		return nil
+	}
+	if err := lockFile(file, exclusive); err != nil {
+		file.Close()
+		return fmt.Errorf("%w: %s", err, path)
+	}
+	l.files = append(l.files, file)
+	return nil
+}
+
+// addDst creates and locks the hidden lock file of the destination path. A
+// lock file removed by its previous holder after it was opened is opened again.
+func (l *fileLocks) addDst(path string) error {
+	if !fileLocking {
+		return nil
+	}
+	name := lockName(path)
+	for range maxLockAttempts {
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create lock file: %w", err)
+		}
+		if err := lockFile(file, true); err != nil {
+			file.Close()
+			return fmt.Errorf("%w: %s", err, path)
+		}
+		locked, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to stat lock file: %w", err)
+		}
+		if current, err := os.Stat(name); err == nil && os.SameFile(locked, current) {
+			l.dst = file
+			return nil
+		}
+		file.Close()
+	}
+	return fmt.Errorf("%w: %s", ErrFileInUse, path)
+}
+
+// lockName returns the name of the lock file of path
+func lockName(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock")
+}
+
+// release closes the files, releasing their locks. The lock file is removed
+// before it is unlocked, a process that opened it meanwhile notices that and
+// creates a new one.
+func (l *fileLocks) release() {
+	for _, file := range l.files {
+		file.Close()
+	}
+	if l.dst != nil {
+		os.Remove(l.dst.Name())
+		l.dst.Close()
+	}
+}
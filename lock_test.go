@@ -0,0 +1,100 @@
+package fileenc_test
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// TestConcurrentEncryptFile encrypts two files to the same new destination at
+// once, one of them must fail instead of silently replacing the other's output
+func TestConcurrentEncryptFile(t *testing.T) {
+	enc, err := fileenc.New([]byte(fuzzPass), fileenc.WithKDF(fileenc.KDFParams{Name: fileenc.KDFPBKDF2, Time: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 20 {
+		dir := t.TempDir()
+		dst := filepath.Join(dir, "out.enc")
+		var srcs [2]string
+		for n := range srcs {
+			srcs[n] = filepath.Join(dir, string(rune('a'+n)))
+			if err := os.WriteFile(srcs[n], bytes.Repeat([]byte{byte(n)}, 4<<20), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var wg sync.WaitGroup
+		var errs [2]error
+		start := make(chan struct{})
+		for n := range srcs {
+			wg.Go(func() {
+				<-start
+				errs[n] = enc.EncryptFile(srcs[n], dst)
+			})
+		}
+		close(start)
+		wg.Wait()
+
+		failed := 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+			case errors.Is(err, fileenc.ErrFileInUse), errors.Is(err, fileenc.ErrFileExists):
+				failed++
+			default:
+				t.Fatalf("run %d: unexpected error: %v", i, err)
+			}
+		}
+		if failed != 1 {
+			t.Fatalf("run %d: %d of 2 encryptions failed, want 1: %v", i, failed, errs)
+		}
+
+		// The output belongs to the encryption that succeeded
+		won := 0
+		if errs[0] != nil {
+			won = 1
+		}
+		var out bytes.Buffer
+		in, err := os.Open(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = enc.Decrypt(&out, in)
+		in.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := bytes.Repeat([]byte{byte(won)}, 4<<20); !bytes.Equal(out.Bytes(), want) {
+			t.Fatalf("run %d: output does not match the source of the successful encryption", i)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 3 {
+			t.Fatalf("run %d: %d files left in the directory, want the sources and the output", i, len(entries))
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Metadata describes the original file, it is stored unencrypted but
+// authenticated in the header of fileenc files
+type Metadata struct {
+	// Name is the base name of the original file, empty if not stored
+	Name string
+	// ModTime is the modification time, zero if not stored
+	ModTime time.Time
+	// Mode holds the permission bits, 0 if not stored
+	Mode fs.FileMode
+	// UID and GID are the owner, -1 if not stored
+	UID, GID int
+	// Archive is set if the plaintext is a tar archive of the directory Name
+	Archive bool
+	// Sparse is set if the original file had holes, EncryptFile stores only
+	// its data and DecryptFile recreates the holes. It cannot be set with WithMetadata.
+	Sparse bool
+	// Symlink is set if the plaintext is the target of a symbolic link, see
+	// WithPreserveSymlinks. It cannot be set with WithMetadata.
+	Symlink bool
+}
+
+// WithMetadata stores md in the header of files written by NewWriter and Encrypt.
+// EncryptFile takes the metadata from the source file instead, see WithFileMetadata.
+func WithMetadata(md Metadata) Option {
+	return func(e *Encryptor) {
+		md.Sparse, md.Symlink = false, false
+		e.metadata = &md
+	}
+}
+
+// WithFileMetadata makes EncryptFile store the modification time and permissions
+// of the source file and DecryptFile restore them. With name the base name of
+// the source file is stored as well, with owner its user and group, which are
+// only restored if the process is allowed to change the ownership.
+func WithFileMetadata(name, owner bool) Option {
+	return func(e *Encryptor) {
+		e.fileMetadata = true
+		e.storeName = name
+		e.storeOwner = owner
+	}
+}
+
+// fileMetadataOf collects the metadata of the file described by stat
+func (e *Encryptor) fileMetadataOf(path string, stat fs.FileInfo) Metadata {
+	md := Metadata{ModTime: stat.ModTime(), Mode: stat.Mode().Perm(), UID: -1, GID: -1}
+	if e.storeName {
+		md.Name = filepath.Base(path)
+	}
+	if e.storeOwner {
+		md.UID, md.GID = fileOwner(stat)
+	}
+	return md
+}
+
+// extensions encodes the stored fields of the metadata as header extensions
+func (md Metadata) extensions() []extension {
+	var ext []extension
+	if md.Name != "" {
+		ext = append(ext, extension{Type: extName, Data: []byte(md.Name)})
+	}
+	if !md.ModTime.IsZero() {
+		ext = append(ext, extension{Type: extModTime, Data: binary.BigEndian.AppendUint64(nil, uint64(md.ModTime.UnixNano()))})
+	}
+	if md.Mode != 0 {
+		ext = append(ext, extension{Type: extMode, Data: binary.BigEndian.AppendUint32(nil, uint32(md.Mode.Perm()))})
+	}
+	switch {
+	case md.Archive:
+		ext = append(ext, extension{Type: extContent, Data: []byte{contentTar}})
+	case md.Sparse:
+		ext = append(ext, extension{Type: extContent, Data: []byte{contentSparse}})
+	case md.Symlink:
+		ext = append(ext, extension{Type: extContent, Data: []byte{contentSymlink}})
+	}
+	if md.UID >= 0 && md.GID >= 0 {
+		data := binary.BigEndian.AppendUint32(nil, uint32(md.UID))
+		ext = append(ext, extension{Type: extOwner, Data: binary.BigEndian.AppendUint32(data, uint32(md.GID))})
+	}
+	return ext
+}
+
+// metadata decodes the metadata stored in the header
+func (h header) metadata() (Metadata, error) {
+	md := Metadata{UID: -1, GID: -1}
+	if name, ok := h.extension(extName); ok {
+		md.Name = string(name)
+	}
+	if t, ok := h.extension(extModTime); ok {
+		if len(t) != 8 {
+			return Metadata{}, fmt.Errorf("%w: invalid modification time", ErrMalformedHeader)
+		}
+		md.ModTime = time.Unix(0, int64(binary.BigEndian.Uint64(t)))
+	}
+	if mode, ok := h.extension(extMode); ok {
+		if len(mode) != 4 {
+			return Metadata{}, fmt.Errorf("%w: invalid permissions", ErrMalformedHeader)
+		}
+		md.Mode = fs.FileMode(binary.BigEndian.Uint32(mode)).Perm()
+	}
+	if content, ok := h.extension(extContent); ok {
+		if len(content) != 1 {
+			return Metadata{}, fmt.Errorf("%w: invalid content type", ErrMalformedHeader)
+		}
+		md.Archive = content[0] == contentTar
+		md.Sparse = content[0] == contentSparse
+		md.Symlink = content[0] == contentSymlink
+	}
+	if owner, ok := h.extension(extOwner); ok {
+		if len(owner) != 8 {
+			return Metadata{}, fmt.Errorf("%w: invalid owner", ErrMalformedHeader)
+		}
+		md.UID = int(binary.BigEndian.Uint32(owner[:4]))
+		md.GID = int(binary.BigEndian.Uint32(owner[4:]))
+	}
+	return md, nil
+}
+
+// restore applies the stored permissions, modification time and, if requested, owner to path
+func (md Metadata) restore(path string, owner bool) error {
+	if md.Mode != 0 {
+		if err := os.Chmod(path, md.Mode); err != nil {
+			return fmt.Errorf("failed to restore permissions: %w", err)
+		}
+	}
+	if !md.ModTime.IsZero() {
+		if err := os.Chtimes(path, md.ModTime, md.ModTime); err != nil {
+			return fmt.Errorf("failed to restore modification time: %w", err)
+		}
+	}
+	if owner && md.UID >= 0 && md.GID >= 0 {
+		if err := os.Lchown(path, md.UID, md.GID); err != nil {
+			return fmt.Errorf("failed to restore owner: %w", err)
+		}
+	}
+	return nil
+}
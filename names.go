@@ -0,0 +1,143 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// nameTagSize is the length of the tag detecting wrong keys and damaged names
+	nameTagSize = 4
+	// maxNameSize is the longest name whose encrypted form fits the 255 bytes
+	// most file systems allow
+	maxNameSize = 255*5/8 - aes.BlockSize - nameTagSize
+)
+
+// nameEncoding is lower case base32, encrypted names survive case-insensitive file systems
+var nameEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// ErrInvalidName is returned for names that cannot be encrypted or decrypted
+var ErrInvalidName = errors.New("invalid file name")
+
+// EncryptName returns name encrypted with the passphrase for a file in the
+// directory dir. The result is deterministic, the same name in the same
+// directory always gives the same encrypted name, and it only consists of
+// lower case letters and digits. The key is derived with the KDF parameters
+// of the Encryptor and a fixed salt, use WithKeyCache to derive it only once.
+// Names are limited to 139 bytes.
+func (e *Encryptor) EncryptName(dir, name string) (string, error) {
+	if len(name) == 0 || len(name) > maxNameSize {
+		return "", fmt.Errorf("%w: %q must be 1 to %d bytes long to be encrypted", ErrInvalidName, name, maxNameSize)
+	}
+	keys, err := e.nameKeys(e.kdf)
+	if err != nil {
+		return "", err
+	}
+	defer clear(keys)
+	mac := hmac.New(sha256.New, keys[:32])
+	mac.Write([]byte(dir))
+	mac.Write([]byte{0})
+	mac.Write([]byte(name))
+	iv := mac.Sum(nil)[:aes.BlockSize]
+
+	out, err := nameCTR(keys[32:64], iv, []byte(name))
+	if err != nil {
+		return "", err
+	}
+	out = append(iv, out...)
+	return nameEncoding.EncodeToString(append(out, nameTag(keys[64:], out)...)), nil
+}
+
+// DecryptName returns the name encrypted by EncryptName with the KDF
+// parameters params, which are stored in the header of every encrypted file.
+// ErrWrongPassword is returned if the name was encrypted with another key.
+func (e *Encryptor) DecryptName(encrypted string, params KDFParams) (string, error) {
+	data, err := nameEncoding.DecodeString(encrypted)
+	if err != nil || len(data) < aes.BlockSize+nameTagSize+1 {
+		return "", fmt.Errorf("%w: %q is not an encrypted name", ErrInvalidName, encrypted)
+	}
+	keys, err := e.nameKeys(params)
+	if err != nil {
+		return "", err
+	}
+	defer clear(keys)
+	data, tag := data[:len(data)-nameTagSize], data[len(data)-nameTagSize:]
+	if !hmac.Equal(tag, nameTag(keys[64:], data)) {
+		return "", ErrWrongPassword
+	}
+	plain, err := nameCTR(keys[32:64], data[:aes.BlockSize], data[aes.BlockSize:])
+	if err != nil {
+		return "", err
+	}
+	name := string(plain)
+	// The name becomes a path component, it must not leave the directory
+	if !utf8.ValidString(name) || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return name, nil
+}
+
+// nameKeys returns the keys for the IV, the encryption and the tag of names,
+// derived from the passphrase with a fixed salt for the parameters
+func (e *Encryptor) nameKeys(params KDFParams) ([]byte, error) {
+	if len(e.recipients) > 0 || len(e.ageRecipients) > 0 || e.shareCount > 0 {
+		return nil, errors.New("encrypting names requires a passphrase")
+	}
+	params.Salt = nil
+	if params.Name != KDFNone {
+		sum := sha256.Sum256(fmt.Appendf(nil, "fileenc name salt %s/%d/%d/%d", params.Name, params.Time, params.Memory, params.Threads))
+		params.Salt = sum[:kdfSaltSize]
+	}
+	key, err := e.deriveKey(params)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+	keys, err := hkdf.Key(sha256.New, key.Bytes(), nil, "fileenc names", 96)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive name keys: %w", err)
+	}
+	return keys, nil
+}
+
+// nameCTR encrypts or decrypts data with AES-CTR under key and iv
+func nameCTR(key, iv, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(out, data)
+	return out, nil
+}
+
+// nameTag returns the tag authenticating the IV and the encrypted name
+func nameTag(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)[:nameTagSize]
+}
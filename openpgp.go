@@ -0,0 +1,230 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+const (
+	// OpenPGP packet tags in the new format, RFC 4880 section 4.2
+	pgpTagSKESK   = 0xc0 | 3
+	pgpTagLiteral = 0xc0 | 11
+	pgpTagSEIPD   = 0xc0 | 18
+	pgpTagMDC     = 0xc0 | 19
+
+	// pgpCipherAES256 and pgpHashSHA256 are the algorithm ids of RFC 4880 section 9
+	pgpCipherAES256 = 9
+	pgpHashSHA256   = 8
+	// pgpS2KCount encodes the maximum iteration count of 65011712 bytes for the iterated and salted S2K
+	pgpS2KCount = 0xff
+	// pgpPartialPower sends streamed packet bodies in partial chunks of 1<<pgpPartialPower bytes
+	pgpPartialPower = 16
+
+	// pgpArmorHeader starts and pgpArmorFooter ends an ASCII armored OpenPGP message
+	pgpArmorHeader = "-----BEGIN PGP MESSAGE-----"
+	pgpArmorFooter = "-----END PGP MESSAGE-----"
+)
+
+// openPGPWriter writes a symmetrically encrypted OpenPGP message: a session key
+// packet holding the S2K parameters followed by an integrity protected data
+// packet, which contains a literal data packet and the SHA-1 modification
+// detection code over the plaintext.
+type openPGPWriter struct {
+	seipd *partialWriter
+	plain io.Writer
+	enc   io.Writer
+	mdc   hash.Hash
+	lit   *partialWriter
+}
+
+// newOpenPGPWriter returns a writer encrypting to w as an OpenPGP message with the passphrase
+func (e *Encryptor) newOpenPGPWriter(w io.Writer) (io.WriteCloser, error) {
+	if len(e.pass) == 0 {
+		return nil, errors.New("openpgp format needs a passphrase")
+	}
+
+	// Derive the session key with the iterated and salted S2K, RFC 4880 section 3.7.1.3
+	salt := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key := pgpS2K(e.pass, salt, pgpS2KCount)
+	defer clear(key)
+
+	skesk := []byte{pgpTagSKESK, 13, 4, pgpCipherAES256, 3, pgpHashSHA256}
+	skesk = append(skesk, salt...)
+	skesk = append(skesk, pgpS2KCount)
+	if _, err := w.Write(skesk); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	// The integrity protected packet starts with its version, then the encrypted
+	// random prefix whose last two bytes are repeated
+	if _, err := w.Write([]byte{pgpTagSEIPD}); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+	p := &openPGPWriter{seipd: newPartialWriter(w), mdc: sha1.New()}
+	if _, err := p.seipd.Write([]byte{1}); err != nil {
+		return nil, err
+	}
+	p.enc = &cipher.StreamWriter{S: cipher.NewCFBEncrypter(block, make([]byte, aes.BlockSize)), W: p.seipd}
+	p.plain = io.MultiWriter(p.mdc, p.enc)
+	prefix := make([]byte, aes.BlockSize+2)
+	if _, err := io.ReadFull(rand.Reader, prefix[:aes.BlockSize]); err != nil {
+		return nil, fmt.Errorf("failed to generate IV: %w", err)
+	}
+	copy(prefix[aes.BlockSize:], prefix[aes.BlockSize-2:aes.BlockSize])
+
+	// Binary literal data without a file name
+	lit := []byte{pgpTagLiteral}
+	if _, err := p.plain.Write(append(prefix, lit...)); err != nil {
+		return nil, err
+	}
+	p.lit = newPartialWriter(p.plain)
+	lit = append([]byte{'b', 0}, binary.BigEndian.AppendUint32(nil, uint32(time.Now().Unix()))...)
+	if _, err := p.lit.Write(lit); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Write encrypts p as literal data
+func (p *openPGPWriter) Write(b []byte) (int, error) {
+	return p.lit.Write(b)
+}
+
+// Close finishes the literal data, appends the modification detection code and
+// finishes the encrypted packet, it does not close the underlying writer
+func (p *openPGPWriter) Close() error {
+	if err := p.lit.Close(); err != nil {
+		return err
+	}
+	if _, err := p.plain.Write([]byte{pgpTagMDC, sha1.Size}); err != nil {
+		return err
+	}
+	if _, err := p.enc.Write(p.mdc.Sum(nil)); err != nil {
+		return err
+	}
+	return p.seipd.Close()
+}
+
+// pgpS2K derives a 32 byte key by hashing salt and passphrase repeatedly with SHA-256
+// until the number of bytes encoded by count has been processed
+func pgpS2K(pass, salt []byte, count byte) []byte {
+	total := (16 + int(count&15)) << ((count >> 4) + 6)
+	data := append(append([]byte{}, salt...), pass...)
+	defer clear(data)
+	if total < len(data) {
+		total = len(data)
+	}
+
+	// Hash a large repeated buffer instead of many tiny writes
+	rep := make([]byte, 0, 64*1024+len(data))
+	for len(rep) < 64*1024 {
+		rep = append(rep, data...)
+	}
+	defer clear(rep)
+	h := sha256.New()
+	for total > 0 {
+		n := min(total, len(rep))
+		h.Write(rep[:n])
+		total -= n
+	}
+	return h.Sum(nil)
+}
+
+// partialWriter writes a streamed OpenPGP packet body using partial body lengths.
+// The packet tag must have been written before.
+type partialWriter struct {
+	w      io.Writer
+	buf    []byte
+	closed bool
+}
+
+// newPartialWriter returns a partialWriter writing to w
+func newPartialWriter(w io.Writer) *partialWriter {
+	return &partialWriter{w: w, buf: make([]byte, 0, 1<<pgpPartialPower)}
+}
+
+// Write buffers b and writes every full chunk as partial body once more data follows it
+func (p *partialWriter) Write(b []byte) (int, error) {
+	if p.closed {
+		return 0, errors.New("write to closed encrypting writer")
+	}
+	written := 0
+	for len(b) > 0 {
+		if len(p.buf) == cap(p.buf) {
+			if _, err := p.w.Write(append([]byte{224 + pgpPartialPower}, p.buf...)); err != nil {
+				return written, fmt.Errorf("failed to write encrypted data: %w", err)
+			}
+			p.buf = p.buf[:0]
+		}
+		n := copy(p.buf[len(p.buf):cap(p.buf)], b)
+		p.buf = p.buf[:len(p.buf)+n]
+		b = b[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close writes the remaining data with a definite length, RFC 4880 section 4.2.2
+func (p *partialWriter) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	n := len(p.buf)
+	var hdr []byte
+	switch {
+	case n < 192:
+		hdr = []byte{byte(n)}
+	case n < 8384:
+		hdr = []byte{byte((n-192)>>8) + 192, byte(n - 192)}
+	default:
+		hdr = binary.BigEndian.AppendUint32([]byte{255}, uint32(n))
+	}
+	if _, err := p.w.Write(append(hdr, p.buf...)); err != nil {
+		return fmt.Errorf("failed to write encrypted data: %w", err)
+	}
+	return nil
+}
+
+// isOpenPGP reports whether the data buffered in br looks like an OpenPGP message
+func isOpenPGP(br *bufio.Reader) bool {
+	if b, err := br.Peek(len(pgpArmorHeader)); err == nil && string(b) == pgpArmorHeader {
+		return true
+	}
+	b, err := br.Peek(1)
+	return err == nil && (b[0] == pgpTagSKESK || b[0] == 0x8c)
+}
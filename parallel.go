@@ -0,0 +1,330 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// WithThreads seals and opens up to n chunks of a file in parallel, so a
+// single large file uses several cores. The output is the same as with one
+// thread, n <= 0 uses all CPU cores. AES-CFB is always processed sequentially.
+func WithThreads(n int) Option {
+	return func(e *Encryptor) {
+		if n <= 0 {
+			n = runtime.NumCPU()
+		}
+		e.threads = n
+	}
+}
+
+// sealJob is a chunk sealed by its own goroutine, done is closed once out holds it
+type sealJob struct {
+	plain []byte
+	out   []byte
+	done  chan struct{}
+}
+
+// parallelChunkWriter writes the same chunks as chunkWriter, sealing up to
+// threads of them at once. They are written in order by the calling goroutine,
+// so no goroutine outlives a failed or abandoned writer for long.
+type parallelChunkWriter struct {
+	w        io.Writer
+	aead     cipher.AEAD
+	hdr      []byte
+	threads  int
+	cur      *sealJob
+	inflight []*sealJob
+	spare    []*sealJob
+	counter  uint64
+	closed   bool
+	err      error
+}
+
+// newParallelChunkWriter returns a writer sealing to w with the authenticated
+// cipher name using threads goroutines
+func newParallelChunkWriter(w io.Writer, name string, key, salt, hdr []byte, threads int) (*parallelChunkWriter, error) {
+	aead, err := newChunkAEAD(name, key, salt)
+	if err != nil {
+		return nil, err
+	}
+	g := &parallelChunkWriter{w: w, aead: aead, hdr: hdr, threads: threads}
+	g.cur = g.job()
+	return g, nil
+}
+
+// job returns a spare job or a new one
+func (g *parallelChunkWriter) job() *sealJob {
+	if n := len(g.spare); n > 0 {
+		job := g.spare[n-1]
+		g.spare = g.spare[:n-1]
+		job.plain = job.plain[:0]
+		return job
+	}
+	return &sealJob{plain: make([]byte, 0, chunkSize+1), out: make([]byte, 0, chunkSize+g.aead.Overhead())}
+}
+
+// Write buffers p and seals every full chunk once more data follows it
+func (g *parallelChunkWriter) Write(p []byte) (int, error) {
+	if g.closed {
+		return 0, errors.New("write to closed encrypting writer")
+	}
+	if g.err != nil {
+		return 0, g.err
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed when more data arrives, it could be the final chunk
+		if len(g.cur.plain) == chunkSize {
+			if g.err = g.seal(false); g.err != nil {
+				return written, g.err
+			}
+		}
+		n := copy(g.cur.plain[len(g.cur.plain):chunkSize], p)
+		g.cur.plain = g.cur.plain[:len(g.cur.plain)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// ReadFrom reads r straight into the chunk buffers like chunkWriter.ReadFrom
+func (g *parallelChunkWriter) ReadFrom(r io.Reader) (int64, error) {
+	if g.closed {
+		return 0, errors.New("write to closed encrypting writer")
+	}
+	if g.err != nil {
+		return 0, g.err
+	}
+	var total int64
+	for {
+		n, err := r.Read(g.cur.plain[len(g.cur.plain) : chunkSize+1])
+		g.cur.plain = g.cur.plain[:len(g.cur.plain)+n]
+		total += int64(n)
+		if len(g.cur.plain) > chunkSize {
+			next := g.cur.plain[chunkSize]
+			g.cur.plain = g.cur.plain[:chunkSize]
+			if g.err = g.seal(false); g.err != nil {
+				return total, g.err
+			}
+			g.cur.plain = append(g.cur.plain, next)
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Close seals the final chunk and writes the chunks still in flight, it does
+// not close the underlying writer
+func (g *parallelChunkWriter) Close() error {
+	if g.closed || g.err != nil {
+		return g.err
+	}
+	g.closed = true
+	if g.err = g.seal(true); g.err == nil {
+		g.err = g.flush(0)
+	}
+	return g.err
+}
+
+// seal starts sealing the buffered chunk and writes the oldest chunks once
+// threads of them are in flight
+func (g *parallelChunkWriter) seal(last bool) error {
+	job, nonce := g.cur, chunkNonce(g.aead, g.counter, last)
+	g.counter++
+	job.done = make(chan struct{})
+	go func() {
+		job.out = g.aead.Seal(job.out[:0], nonce, job.plain, g.hdr)
+		close(job.done)
+	}()
+	g.inflight = append(g.inflight, job)
+	g.cur = g.job()
+	return g.flush(g.threads - 1)
+}
+
+// flush writes the oldest chunks in flight until at most keep are left
+func (g *parallelChunkWriter) flush(keep int) error {
+	for len(g.inflight) > keep {
+		job := g.inflight[0]
+		g.inflight = g.inflight[1:]
+		<-job.done
+		g.spare = append(g.spare, job)
+		if _, err := g.w.Write(job.out); err != nil {
+			return fmt.Errorf("failed to write encrypted data: %w", err)
+		}
+	}
+	return nil
+}
+
+// openJob is a chunk opened by its own goroutine, done is closed once plain
+// or err holds the result
+type openJob struct {
+	sealed []byte
+	plain  []byte
+	err    error
+	done   chan struct{}
+}
+
+// parallelChunkReader returns the same plaintext as chunkReader, reading up to
+// threads chunks ahead and opening them in parallel
+type parallelChunkReader struct {
+	r        io.Reader
+	aead     cipher.AEAD
+	hdr      []byte
+	threads  int
+	inflight []*openJob
+	spare    []*openJob
+	cur      *openJob
+	out      []byte
+	// carry is the byte read ahead of the next chunk if pending is set
+	carry   byte
+	pending bool
+	counter uint64
+	eof     bool
+	readErr error
+	err     error
+}
+
+// newParallelChunkReader returns a reader opening the chunks sealed with the
+// authenticated cipher name read from r using threads goroutines
+func newParallelChunkReader(r io.Reader, name string, key, salt, hdr []byte, threads int) (*parallelChunkReader, error) {
+	aead, err := newChunkAEAD(name, key, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &parallelChunkReader{r: r, aead: aead, hdr: hdr, threads: threads}, nil
+}
+
+// Read returns decrypted and authenticated plaintext in order
+func (g *parallelChunkReader) Read(p []byte) (int, error) {
+	for len(g.out) == 0 {
+		if err := g.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, g.out)
+	g.out = g.out[n:]
+	return n, nil
+}
+
+// WriteTo writes the plaintext of every chunk straight to w in order, saving
+// io.Copy the copy through its own buffer
+func (g *parallelChunkReader) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		if len(g.out) > 0 {
+			n, err := w.Write(g.out)
+			total += int64(n)
+			g.out = g.out[n:]
+			if err != nil {
+				return total, err
+			}
+		}
+		if err := g.next(); err == io.EOF {
+			return total, nil
+		} else if err != nil {
+			return total, err
+		}
+	}
+}
+
+// next waits for the oldest chunk in flight and makes its plaintext the
+// output, it returns io.EOF after the final chunk
+func (g *parallelChunkReader) next() error {
+	if g.cur != nil {
+		g.spare = append(g.spare, g.cur)
+		g.cur = nil
+	}
+	if g.err != nil {
+		return g.err
+	}
+	g.fill()
+	if len(g.inflight) == 0 {
+		if g.readErr != nil {
+			g.err = g.readErr
+			return g.err
+		}
+		return io.EOF
+	}
+	job := g.inflight[0]
+	g.inflight = g.inflight[1:]
+	<-job.done
+	if job.err != nil {
+		g.err = ErrAuthFailed
+		return g.err
+	}
+	g.cur, g.out = job, job.plain
+	return nil
+}
+
+// fill reads chunks until threads of them are in flight or the data ends. One
+// byte is read ahead so the final chunk can be recognized.
+func (g *parallelChunkReader) fill() {
+	encSize := chunkSize + g.aead.Overhead()
+	for !g.eof && g.readErr == nil && len(g.inflight) < g.threads {
+		job := g.job(encSize)
+		start := 0
+		if g.pending {
+			job.sealed[0] = g.carry
+			start = 1
+		}
+		n, err := io.ReadFull(g.r, job.sealed[start:])
+		n += start
+		last := false
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			last = true
+		default:
+			g.readErr = fmt.Errorf("failed to read encrypted data: %w", err)
+			g.spare = append(g.spare, job)
+			return
+		}
+		end := n
+		if !last {
+			end = encSize
+		}
+		g.carry, g.pending = job.sealed[encSize], true
+		nonce := chunkNonce(g.aead, g.counter, last)
+		g.counter++
+		g.eof = last
+		job.done = make(chan struct{})
+		go func() {
+			job.plain, job.err = g.aead.Open(job.plain[:0], nonce, job.sealed[:end], g.hdr)
+			close(job.done)
+		}()
+		g.inflight = append(g.inflight, job)
+	}
+}
+
+// job returns a spare job or a new one for sealed chunks of encSize bytes
+func (g *parallelChunkReader) job(encSize int) *openJob {
+	if n := len(g.spare); n > 0 {
+		job := g.spare[n-1]
+		g.spare = g.spare[:n-1]
+		return job
+	}
+	return &openJob{sealed: make([]byte, encSize+1), plain: make([]byte, 0, chunkSize)}
+}
@@ -0,0 +1,61 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Archives and snapshots name their entries with slashes. Paths longer than
+// MAX_PATH and UNC paths need no special care on Windows, the os package
+// passes them on in the extended-length form \\?\.
+
+// localName converts the name of an archive or snapshot entry to a relative
+// path of this system. Backslashes, as written by some Windows tools, count
+// as separators. Names that would leave the destination fail, ok is false for
+// names this system cannot store, e.g. CON, a:b or C:/x on Windows.
+func localName(name string) (path string, ok bool, err error) {
+	slashed := strings.TrimSuffix(strings.ReplaceAll(name, `\`, "/"), "/")
+	parts := strings.Split(slashed, "/")
+	if slashed == "" || strings.HasPrefix(slashed, "/") || slices.Contains(parts, "..") {
+		return "", false, fmt.Errorf("unsafe path %q", name)
+	}
+	path = filepath.FromSlash(slashed)
+	return path, filepath.IsLocal(path), nil
+}
+
+// nameFolder finds entries whose names differ only in case, which would
+// replace each other on case-insensitive file systems like those of Windows
+// and macOS. It maps the folded names to the names seen.
+type nameFolder map[string]string
+
+// collision returns the name seen before that differs from name only in
+// case, on case-sensitive systems there is none
+func (f nameFolder) collision(name string) (string, bool) {
+	if !caseInsensitive {
+		return "", false
+	}
+	key := strings.ToLower(name)
+	if other, ok := f[key]; ok && other != name {
+		return other, true
+	}
+	f[key] = name
+	return "", false
+}
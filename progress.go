@@ -0,0 +1,96 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"io"
+	"time"
+)
+
+// progressInterval is the minimum time between two progress reports
+const progressInterval = 200 * time.Millisecond
+
+// Progress describes how far the processing of a file has come
+type Progress struct {
+	// Name is the file being processed
+	Name string
+	// Done is the number of bytes read so far
+	Done int64
+	// Total is the size of the input, -1 if unknown
+	Total int64
+	// Elapsed is the time since processing started
+	Elapsed time.Duration
+}
+
+// Rate returns the throughput in bytes per second
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Done) / p.Elapsed.Seconds()
+}
+
+// ETA returns the estimated time until the input is processed, -1 if unknown
+func (p Progress) ETA() time.Duration {
+	rate := p.Rate()
+	if p.Total < 0 || rate == 0 {
+		return -1
+	}
+	return time.Duration(float64(p.Total-p.Done) / rate * float64(time.Second))
+}
+
+// ProgressFunc receives progress reports. It may be called from several
+// goroutines at once when an Encryptor is used concurrently.
+type ProgressFunc func(Progress)
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	r        io.Reader
+	fn       ProgressFunc
+	interval time.Duration
+	p        Progress
+	start    time.Time
+	last     time.Time
+}
+
+// NewProgressReader returns a reader passing reads through to r and calling fn
+// at most once per interval as well as when r is exhausted. name and total are
+// passed on in the reports, total is -1 if the size is unknown.
+func NewProgressReader(r io.Reader, name string, total int64, interval time.Duration, fn ProgressFunc) io.Reader {
+	now := time.Now()
+	return &progressReader{
+		r:        r,
+		fn:       fn,
+		interval: interval,
+		p:        Progress{Name: name, Total: total},
+		start:    now,
+		last:     now,
+	}
+}
+
+// Read reads from the underlying reader and reports the progress
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.p.Done += int64(n)
+	now := time.Now()
+	if err == io.EOF || now.Sub(pr.last) >= pr.interval {
+		pr.last = now
+		pr.p.Elapsed = now.Sub(pr.start)
+		pr.fn(pr.p)
+	}
+	return n, err
+}
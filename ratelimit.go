@@ -0,0 +1,122 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// WithBandwidthLimit caps the reads of the input and the writes of the output of
+// Encrypt, Decrypt and the file functions at bytesPerSecond each. The limit is
+// shared by all files processed concurrently with the Encryptor. 0 removes the limit.
+func WithBandwidthLimit(bytesPerSecond int64) Option {
+	return func(e *Encryptor) {
+		e.readLimit, e.writeLimit = nil, nil
+		if bytesPerSecond > 0 {
+			e.readLimit, e.writeLimit = newRateLimiter(bytesPerSecond), newRateLimiter(bytesPerSecond)
+		}
+	}
+}
+
+// NewRateLimitedReader returns a reader passing reads through to r at no more
+// than bytesPerSecond on average
+func NewRateLimitedReader(r io.Reader, bytesPerSecond int64) io.Reader {
+	return &limitedReader{r: r, l: newRateLimiter(bytesPerSecond)}
+}
+
+// NewRateLimitedWriter returns a writer passing writes through to w at no more
+// than bytesPerSecond on average
+func NewRateLimitedWriter(w io.Writer, bytesPerSecond int64) io.Writer {
+	return &limitedWriter{w: w, l: newRateLimiter(bytesPerSecond)}
+}
+
+// rateLimiter delays the callers of wait so the bytes they pass stay below the rate
+type rateLimiter struct {
+	mu    sync.Mutex
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate, start: time.Now()}
+}
+
+// chunk returns how many bytes may be passed at once, a tenth of a second
+// worth, so a large buffer does not stall for long
+func (l *rateLimiter) chunk(n int) int {
+	return min(n, max(int(l.rate/10), 1))
+}
+
+// wait accounts n bytes and sleeps until the rate allows them
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	l.n += int64(n)
+	due := l.start.Add(time.Duration(float64(l.n) / float64(l.rate) * float64(time.Second)))
+	now := time.Now()
+	// After a pause the unused time is not saved up for a burst
+	if now.Sub(due) > time.Second {
+		l.start, l.n, due = now, 0, now
+	}
+	l.mu.Unlock()
+	if delay := due.Sub(now); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// limitedReader reads through a rateLimiter
+type limitedReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+// Read reads at most a chunk and waits for the limiter
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p[:lr.l.chunk(len(p))])
+	lr.l.wait(n)
+	return n, err
+}
+
+// limitedWriter writes through a rateLimiter
+type limitedWriter struct {
+	w io.Writer
+	l *rateLimiter
+}
+
+// Write writes p in chunks, waiting for the limiter after each
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := lw.w.Write(p[written : written+lw.l.chunk(len(p)-written)])
+		written += n
+		lw.l.wait(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// limitIO wraps dst and src in the bandwidth limits of the Encryptor
+func (e *Encryptor) limitIO(dst io.Writer, src io.Reader) (io.Writer, io.Reader) {
+	if e.readLimit == nil {
+		return dst, src
+	}
+	return &limitedWriter{w: dst, l: e.writeLimit}, &limitedReader{r: src, l: e.readLimit}
+}
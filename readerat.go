@@ -0,0 +1,151 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrNotSeekable is returned by NewReaderAt for files that cannot be decrypted
+// at random positions, only uncompressed files in the fileenc format
+// using an authenticated cipher can
+var ErrNotSeekable = errors.New("file does not support random access, it must use an authenticated cipher without compression and not be sparse or signed")
+
+// ReaderAt decrypts arbitrary ranges of an encrypted file. Every chunk of
+// chunkSize plaintext bytes is stored at a fixed offset after the header and
+// sealed with its own nonce, so only the chunks covering a range are read and
+// authenticated. It is safe for concurrent use. Wrap it with io.NewSectionReader
+// to get an io.ReadSeeker.
+type ReaderAt struct {
+	r      io.ReaderAt
+	aead   cipher.AEAD
+	hdr    []byte
+	size   int64
+	chunks int64
+
+	mu      sync.Mutex
+	cached  int64
+	plain   []byte
+	scratch []byte
+}
+
+// NewReaderAt reads the header of the encrypted file of length size from r and
+// returns a ReaderAt for its plaintext
+func (e *Encryptor) NewReaderAt(r io.ReaderAt, size int64) (*ReaderAt, error) {
+	hdr, raw, err := readHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := hdr.extension(extCompression); ok || hdr.Cipher == CipherAESCFB {
+		return nil, ErrNotSeekable
+	}
+	if md, err := hdr.metadata(); err != nil || md.Sparse {
+		return nil, ErrNotSeekable
+	}
+	if _, signed := hdr.extension(extSignature); signed {
+		return nil, ErrNotSeekable
+	}
+	if err := e.checkExpiry(hdr); err != nil {
+		return nil, err
+	}
+
+	key, err := e.headerKey(hdr)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+	aead, err := newChunkAEAD(hdr.Cipher, key.Bytes(), hdr.IV)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every file holds at least one chunk, the last one may be shorter
+	payload := size - int64(len(raw))
+	encSize := int64(chunkSize + tagSize)
+	chunks := (payload + encSize - 1) / encSize
+	if chunks == 0 || payload-chunks*tagSize < 0 {
+		return nil, ErrAuthFailed
+	}
+	return &ReaderAt{
+		r:       r,
+		aead:    aead,
+		hdr:     raw,
+		size:    payload - chunks*tagSize,
+		chunks:  chunks,
+		cached:  -1,
+		scratch: make([]byte, encSize),
+	}, nil
+}
+
+// Size returns the plaintext size
+func (ra *ReaderAt) Size() int64 {
+	return ra.size
+}
+
+// ReadAt decrypts len(p) bytes of plaintext starting at off. It fails with
+// ErrAuthFailed if a chunk in the range has been modified.
+func (ra *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	n := 0
+	for len(p) > 0 {
+		if off >= ra.size {
+			return n, io.EOF
+		}
+		plain, err := ra.chunk(off / chunkSize)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p, plain[off%chunkSize:])
+		n += c
+		off += int64(c)
+		p = p[c:]
+	}
+	return n, nil
+}
+
+// chunk returns the authenticated plaintext of chunk i. The returned slice is
+// never modified afterwards, so it can be used after the lock is released.
+func (ra *ReaderAt) chunk(i int64) ([]byte, error) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	if ra.cached == i {
+		return ra.plain, nil
+	}
+
+	encSize := int64(chunkSize + tagSize)
+	start := int64(len(ra.hdr)) + i*encSize
+	length := min(encSize, int64(len(ra.hdr))+ra.size+ra.chunks*tagSize-start)
+	buf := ra.scratch[:length]
+	if n, err := ra.r.ReadAt(buf, start); n < len(buf) {
+		if err == io.EOF {
+			return nil, ErrAuthFailed
+		}
+		return nil, fmt.Errorf("failed to read encrypted data: %w", err)
+	}
+	plain, err := ra.aead.Open(nil, chunkNonce(ra.aead, uint64(i), i == ra.chunks-1), buf, ra.hdr)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+	ra.cached, ra.plain = i, plain
+	return plain, nil
+}
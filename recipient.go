@@ -0,0 +1,129 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// fileKeySize is the length of the random file key used with recipients, selecting AES-256
+const fileKeySize = 32
+
+// ErrNoIdentity is returned when none of the identities can unwrap the file key
+var ErrNoIdentity = errors.New("no identity matches any recipient of the file")
+
+// Stanza is a file key wrapped for one recipient and stored in the header.
+// Type names the recipient implementation, Body is opaque to everything else.
+type Stanza struct {
+	Type string
+	Body []byte
+}
+
+// Recipient wraps the random file key of an encrypted file so only the
+// matching Identity can unwrap it again
+type Recipient interface {
+	Wrap(fileKey []byte) (Stanza, error)
+}
+
+// Identity unwraps a file key wrapped for its Recipient. Unwrap returns
+// ErrNoIdentity if none of the stanzas belongs to the identity.
+type Identity interface {
+	Unwrap(stanzas []Stanza) ([]byte, error)
+}
+
+// marshal encodes the stanza as type length, type and body
+func (s Stanza) marshal() ([]byte, error) {
+	if len(s.Type) == 0 || len(s.Type) > 255 {
+		return nil, fmt.Errorf("invalid stanza type %q", s.Type)
+	}
+	buf := append([]byte{byte(len(s.Type))}, s.Type...)
+	return append(buf, s.Body...), nil
+}
+
+// parseStanza decodes a stanza encoded by marshal
+func parseStanza(data []byte) (Stanza, error) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) || data[0] == 0 {
+		return Stanza{}, fmt.Errorf("%w: invalid recipient stanza", ErrMalformedHeader)
+	}
+	n := 1 + int(data[0])
+	return Stanza{Type: string(data[1:n]), Body: data[n:]}, nil
+}
+
+// wrapFileKey generates a file key from random and wraps it for every recipient
+func wrapFileKey(random io.Reader, recipients []Recipient) (*SecureBuffer, []extension, error) {
+	fileKey := NewSecureBuffer(fileKeySize)
+	if _, err := io.ReadFull(random, fileKey.Bytes()); err != nil {
+		fileKey.Destroy()
+		return nil, nil, fmt.Errorf("failed to generate file key: %w", err)
+	}
+	exts := make([]extension, 0, len(recipients))
+	for _, r := range recipients {
+		st, err := r.Wrap(fileKey.Bytes())
+		if err != nil {
+			fileKey.Destroy()
+			return nil, nil, fmt.Errorf("failed to wrap file key: %w", err)
+		}
+		data, err := st.marshal()
+		if err != nil {
+			fileKey.Destroy()
+			return nil, nil, err
+		}
+		exts = append(exts, extension{Type: extRecipient, Data: data})
+	}
+	return fileKey, exts, nil
+}
+
+// unwrapFileKey returns the file key from the first identity able to unwrap one
+// of the stanzas. If none can, the most specific ErrNoIdentity is returned.
+func unwrapFileKey(identities []Identity, stanzas []Stanza) (*SecureBuffer, error) {
+	last := ErrNoIdentity
+	for _, id := range identities {
+		key, err := id.Unwrap(stanzas)
+		if err == nil {
+			if len(key) != fileKeySize {
+				clear(key)
+				return nil, errors.New("unwrapped file key has invalid length")
+			}
+			return SecureCopy(key), nil
+		}
+		if !errors.Is(err, ErrNoIdentity) {
+			return nil, err
+		}
+		if err != ErrNoIdentity {
+			last = err
+		}
+	}
+	return nil, last
+}
+
+// stanzas returns the recipient stanzas stored in the header
+func (h header) stanzas() ([]Stanza, error) {
+	var stanzas []Stanza
+	for _, e := range h.Extensions {
+		if e.Type != extRecipient {
+			continue
+		}
+		st, err := parseStanza(e.Data)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, st)
+	}
+	return stanzas, nil
+}
@@ -0,0 +1,163 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"sync"
+)
+
+// firstPrivateID is the first cipher and KDF identifier left to registered
+// algorithms, fileenc itself only uses smaller ones
+const firstPrivateID = 128
+
+// CipherSuite is an authenticated cipher registered with RegisterCipher. It
+// seals the 64 KiB chunks of a file like the built-in ciphers, the header,
+// nonces and chunk layout stay the same.
+type CipherSuite struct {
+	// ID identifies the cipher in the file header, it must be 128 or larger
+	ID byte
+	// KeySize is the length of the subkey derived for every file, 0 keeps
+	// the length of the key
+	KeySize int
+	// New returns the AEAD for a subkey. Its nonces must be at least 9 bytes
+	// and its tags 16 bytes long.
+	New func(key []byte) (cipher.AEAD, error)
+}
+
+// KDF is a key derivation function registered with RegisterKDF. The cost
+// parameters Time, Memory and Threads of KDFParams are stored in the file
+// header and passed on as they are.
+type KDF struct {
+	// ID identifies the KDF in the file header, it must be 128 or larger
+	ID byte
+	// Defaults holds the recommended cost parameters
+	Defaults KDFParams
+	// Validate checks cost parameters given as option or read from a header
+	Validate func(p KDFParams) error
+	// Derive returns the 32 byte key for the passphrase and the parameters,
+	// the caller clears it after use
+	Derive func(pass []byte, p KDFParams) ([]byte, error)
+}
+
+var (
+	registryMu   sync.RWMutex
+	cipherSuites = map[string]CipherSuite{}
+	kdfs         = map[string]KDF{}
+)
+
+// RegisterCipher makes the cipher suite available under name for WithCipher
+// and for decrypting files carrying its ID, so approved algorithms can be
+// used without changing the file format. It is meant to be called from init
+// functions and panics if the name or the ID is taken or the suite is invalid.
+func RegisterCipher(name string, s CipherSuite) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if s.ID < firstPrivateID || s.New == nil || s.KeySize < 0 {
+		panic("fileenc: RegisterCipher needs an ID from 128 on and a New function")
+	}
+	if _, dup := cipherIDs[name]; dup {
+		panic("fileenc: RegisterCipher called twice for cipher " + name)
+	}
+	for _, id := range cipherIDs {
+		if id == s.ID {
+			panic(fmt.Sprintf("fileenc: RegisterCipher called twice for ID %d", s.ID))
+		}
+	}
+	cipherIDs[name] = s.ID
+	cipherSuites[name] = s
+}
+
+// RegisterKDF makes the key derivation function available under name for
+// WithKDF and for decrypting files carrying its ID. It is meant to be called
+// from init functions and panics if the name or the ID is taken or the KDF is
+// incomplete.
+func RegisterKDF(name string, k KDF) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if k.ID < firstPrivateID || k.Validate == nil || k.Derive == nil {
+		panic("fileenc: RegisterKDF needs an ID from 128 on and Validate and Derive functions")
+	}
+	if _, dup := kdfIDs[name]; dup {
+		panic("fileenc: RegisterKDF called twice for kdf " + name)
+	}
+	for _, id := range kdfIDs {
+		if id == k.ID {
+			panic(fmt.Sprintf("fileenc: RegisterKDF called twice for ID %d", k.ID))
+		}
+	}
+	k.Defaults.Name = name
+	kdfIDs[name] = k.ID
+	kdfs[name] = k
+}
+
+// cipherID returns the header identifier of the cipher name
+func cipherID(name string) (byte, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	id, ok := cipherIDs[name]
+	return id, ok
+}
+
+// cipherName returns the name of the cipher with the header identifier id
+func cipherName(id byte) (string, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for name, cid := range cipherIDs {
+		if cid == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// registeredCipher returns the registered cipher suite of name
+func registeredCipher(name string) (CipherSuite, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	s, ok := cipherSuites[name]
+	return s, ok
+}
+
+// kdfID returns the header identifier of the KDF name
+func kdfID(name string) (byte, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	id, ok := kdfIDs[name]
+	return id, ok
+}
+
+// kdfName returns the name of the KDF with the header identifier id
+func kdfName(id byte) (string, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for name, kid := range kdfIDs {
+		if kid == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// registeredKDF returns the registered key derivation function of name
+func registeredKDF(name string) (KDF, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	k, ok := kdfs[name]
+	return k, ok
+}
@@ -0,0 +1,74 @@
+package fileenc_test
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+func TestRegisteredAlgorithms(t *testing.T) {
+	fileenc.RegisterCipher("test-aes-gcm", fileenc.CipherSuite{ID: 200, New: func(key []byte) (cipher.AEAD, error) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}})
+	fileenc.RegisterKDF("test-hkdf", fileenc.KDF{
+		ID:       201,
+		Validate: func(fileenc.KDFParams) error { return nil },
+		Derive: func(pass []byte, p fileenc.KDFParams) ([]byte, error) {
+			return hkdf.Key(sha256.New, pass, p.Salt, "test", 32)
+		},
+	})
+
+	params, err := fileenc.DefaultKDFParams("test-hkdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := fileenc.New([]byte("passphrase"), fileenc.WithCipher("test-aes-gcm"), fileenc.WithKDF(params))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := bytes.Repeat([]byte("registered "), 10000)
+	var out bytes.Buffer
+	if err := enc.Encrypt(&out, bytes.NewReader(plaintext)); err != nil {
+		t.Fatal(err)
+	}
+	if cid, kid := out.Bytes()[5], out.Bytes()[6]; cid != 200 || kid != 201 {
+		t.Errorf("header ids = %d, %d, want 200, 201", cid, kid)
+	}
+
+	dec, err := fileenc.New([]byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back bytes.Buffer
+	if err := dec.Decrypt(&back, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back.Bytes(), plaintext) {
+		t.Error("decrypted plaintext differs")
+	}
+}
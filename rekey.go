@@ -0,0 +1,93 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Rekey decrypts src with e and encrypts the plaintext with to into dst in a
+// single pass, the plaintext never leaves memory. The metadata stored in the
+// header is carried over, as are the expiry date and the fields unless to sets
+// them or writes another format. Cipher, KDF and compression are those of to.
+func (e *Encryptor) Rekey(dst io.Writer, src io.Reader, to *Encryptor) error {
+	r, hdr, err := e.newReader(src)
+	if err != nil {
+		return err
+	}
+	md := to.metadata
+	if hdr.Version != 0 {
+		m, err := hdr.metadata()
+		if err != nil {
+			return err
+		}
+		md = &m
+		expires, err := hdr.expiry()
+		if err != nil {
+			return err
+		}
+		if to.format == FormatFileenc && ((!expires.IsZero() && to.expires.IsZero()) || (hdr.Fields != nil && to.fields == nil)) {
+			keep := *to
+			if keep.expires.IsZero() {
+				keep.expires = expires
+			}
+			if keep.fields == nil {
+				keep.fields = hdr.Fields
+			}
+			to = &keep
+		}
+	}
+	// Only the fileenc format keeps the holes of sparse files
+	if md != nil && md.Sparse && to.format != FormatFileenc {
+		if r, err = plaintext(r, hdr); err != nil {
+			return err
+		}
+	}
+
+	w, err := to.newWriter(dst, md, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := e.copy(w, r); err != nil {
+		if errors.Is(err, ErrAuthFailed) {
+			return err
+		}
+		return fmt.Errorf("failed to re-encrypt: %w", err)
+	}
+	return w.Close()
+}
+
+// RekeyFile replaces the encrypted file at path with a version encrypted by to.
+// The file is only replaced once it has been decrypted and authenticated
+// completely, so a wrong key or a corrupt file leave it untouched.
+func (e *Encryptor) RekeyFile(path string, to *Encryptor) error {
+	return e.writeAtomic(path, true, func(w io.Writer) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open encrypted file: %w", err)
+		}
+		defer file.Close()
+		src, err := e.progressReader(file, path)
+		if err != nil {
+			return err
+		}
+		return e.Rekey(w, src, to)
+	})
+}
@@ -0,0 +1,580 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// A repository stores files deduplicated: they are split into content defined
+// chunks, which are encrypted and stored under a keyed hash of their content,
+// so equal chunks of different files and versions are stored once.
+//
+// Layout of the repository directory:
+//
+//	config             the repository key, encrypted with the Encryptor like a file
+//	data/ab/abcd...    the chunks, named by the HMAC-SHA256 of their plaintext
+//	snapshots/<id>     the snapshots, listing the files and their chunks
+//	index              the snapshots and the versions of every file, see History
+//	locks/<id>         the processes writing to the repository, see Prune
+//
+// Chunks and snapshots are compressed with zstd if that makes them smaller and
+// sealed with XChaCha20-Poly1305 under a key derived from the repository key,
+// with their name as additional data so they cannot be swapped.
+
+// repoVersion is the version of the repository layout written by this fileenc
+const repoVersion = 1
+
+// ErrNotRepository is returned when opening a directory that holds no repository
+var ErrNotRepository = errors.New("not a fileenc repository")
+
+// repoConfig is stored encrypted in the config file of a repository
+type repoConfig struct {
+	Version int    `json:"version"`
+	ID      string `json:"id"`
+	Key     []byte `json:"key"`
+}
+
+// Repository is an open repository, Close destroys its keys
+type Repository struct {
+	path string
+	id   string
+	e    *Encryptor
+	aead cipher.AEAD
+	// idKey keys the HMAC naming the chunks
+	idKey *SecureBuffer
+	gear  *gearTable
+	zenc  *zstd.Encoder
+	zdec  *zstd.Decoder
+}
+
+// Snapshot lists the files stored by one Put
+type Snapshot struct {
+	// ID names the snapshot, it starts with the time in UTC
+	ID    string    `json:"-"`
+	Time  time.Time `json:"time"`
+	Host  string    `json:"host"`
+	Paths []string  `json:"paths"`
+	// Size is the plaintext size of all files, Added the size of the chunks
+	// that were not in the repository before
+	Size  int64          `json:"size"`
+	Added int64          `json:"added"`
+	Files []SnapshotFile `json:"files,omitempty"`
+}
+
+// SnapshotFile is a file, directory or symbolic link of a snapshot
+type SnapshotFile struct {
+	// Name is the slash separated path, starting with the base name of the path given to Put
+	Name    string      `json:"name"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size,omitempty"`
+	Link    string      `json:"link,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+}
+
+// InitRepository creates a repository in the directory path, which must not
+// exist or be empty. Its random key is stored encrypted by e, so e and every
+// Encryptor able to decrypt what e encrypts can open it.
+func InitRepository(path string, e *Encryptor) (*Repository, error) {
+	if entries, err := os.ReadDir(path); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("%w: %s is not empty", ErrFileExists, path)
+	}
+	for _, dir := range []string{path, filepath.Join(path, "data"), filepath.Join(path, "snapshots"), filepath.Join(path, "locks")} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create repository: %w", err)
+		}
+	}
+
+	id := make([]byte, 16)
+	key := NewSecureBuffer(32)
+	defer key.Destroy()
+	if _, err := io.ReadFull(e.random, id); err != nil {
+		return nil, fmt.Errorf("failed to generate repository key: %w", err)
+	}
+	if _, err := io.ReadFull(e.random, key.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to generate repository key: %w", err)
+	}
+	cfg, err := json.Marshal(repoConfig{Version: repoVersion, ID: hex.EncodeToString(id), Key: key.Bytes()})
+	if err != nil {
+		return nil, err
+	}
+	defer clear(cfg)
+	err = e.writeAtomic(filepath.Join(path, "config"), false, func(w io.Writer) error {
+		return e.Encrypt(w, bytes.NewReader(cfg))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newRepository(path, hex.EncodeToString(id), key.Bytes(), e)
+}
+
+// OpenRepository opens the repository in the directory path, decrypting its
+// key with e. A wrong key fails with ErrWrongPassword or ErrNoIdentity.
+func OpenRepository(path string, e *Encryptor) (*Repository, error) {
+	file, err := os.Open(filepath.Join(path, "config"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotRepository, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	defer file.Close()
+	var plain bytes.Buffer
+	if err := e.Decrypt(&plain, file); err != nil {
+		return nil, err
+	}
+	defer clear(plain.Bytes())
+	var cfg repoConfig
+	if err := json.Unmarshal(plain.Bytes(), &cfg); err != nil {
+		return nil, fmt.Errorf("%w: invalid config: %w", ErrNotRepository, err)
+	}
+	key := SecureCopy(cfg.Key)
+	defer key.Destroy()
+	if cfg.Version != repoVersion || key.Len() != 32 {
+		return nil, fmt.Errorf("%w: unsupported repository version %d", ErrNotRepository, cfg.Version)
+	}
+	return newRepository(path, cfg.ID, key.Bytes(), e)
+}
+
+// newRepository derives the keys of the repository from its key
+func newRepository(path, id string, key []byte, e *Encryptor) (*Repository, error) {
+	keys, err := hkdf.Key(sha256.New, key, nil, "fileenc repository", 2*chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive repository keys: %w", err)
+	}
+	r := &Repository{path: path, id: id, e: e, idKey: SecureCopy(keys[chacha20poly1305.KeySize:])}
+	if r.aead, err = chacha20poly1305.NewX(keys[:chacha20poly1305.KeySize]); err != nil {
+		r.idKey.Destroy()
+		return nil, err
+	}
+	clear(keys)
+	if r.gear, err = newGearTable(key); err != nil {
+		r.idKey.Destroy()
+		return nil, err
+	}
+	r.zenc, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	r.zdec, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	return r, nil
+}
+
+// Close destroys the keys of the repository
+func (r *Repository) Close() error {
+	r.idKey.Destroy()
+	r.zenc.Close()
+	r.zdec.Close()
+	return nil
+}
+
+// ID returns the random identifier of the repository
+func (r *Repository) ID() string {
+	return r.id
+}
+
+// chunkID returns the name of the chunk holding data
+func (r *Repository) chunkID(data []byte) string {
+	mac := hmac.New(sha256.New, r.idKey.Bytes())
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// chunkPath returns the path of the chunk id
+func (r *Repository) chunkPath(id string) string {
+	return filepath.Join(r.path, "data", id[:2], id)
+}
+
+// seal compresses plain if that helps and encrypts it for the object name
+func (r *Repository) seal(name string, plain []byte) ([]byte, error) {
+	payload := append([]byte{0}, plain...)
+	if z := r.zenc.EncodeAll(plain, []byte{1}); len(z) < len(payload) {
+		payload = z
+	}
+	nonce := make([]byte, r.aead.NonceSize(), r.aead.NonceSize()+len(payload)+r.aead.Overhead())
+	if _, err := io.ReadFull(r.e.random, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return r.aead.Seal(nonce, nonce, payload, []byte(name)), nil
+}
+
+// unseal decrypts and decompresses the object name
+func (r *Repository) unseal(name string, data []byte) ([]byte, error) {
+	if len(data) < r.aead.NonceSize()+r.aead.Overhead()+1 {
+		return nil, ErrAuthFailed
+	}
+	payload, err := r.aead.Open(nil, data[:r.aead.NonceSize()], data[r.aead.NonceSize():], []byte(name))
+	if err != nil || len(payload) == 0 {
+		return nil, ErrAuthFailed
+	}
+	switch payload[0] {
+	case 0:
+		return payload[1:], nil
+	case 1:
+		plain, err := r.zdec.DecodeAll(payload[1:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", name, err)
+		}
+		return plain, nil
+	}
+	return nil, fmt.Errorf("%w: unknown compression of %s", ErrMalformedHeader, name)
+}
+
+// storeChunk stores data unless a chunk with the same content exists and
+// returns its id and the number of bytes written
+func (r *Repository) storeChunk(data []byte) (string, int64, error) {
+	id := r.chunkID(data)
+	path := r.chunkPath(id)
+	if _, err := os.Stat(path); err == nil {
+		return id, 0, nil
+	}
+	sealed, err := r.seal("chunk "+id, data)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+	err = r.e.writeAtomic(path, true, func(w io.Writer) error {
+		_, err := w.Write(sealed)
+		return err
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return id, int64(len(sealed)), nil
+}
+
+// loadChunk reads and authenticates the chunk id
+func (r *Repository) loadChunk(id string) ([]byte, error) {
+	if len(id) != 2*sha256.Size {
+		return nil, fmt.Errorf("%w: invalid chunk id %q", ErrMalformedHeader, id)
+	}
+	data, err := os.ReadFile(r.chunkPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk: %w", err)
+	}
+	plain, err := r.unseal("chunk "+id, data)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", id, err)
+	}
+	if r.chunkID(plain) != id {
+		return nil, fmt.Errorf("chunk %s: %w", id, ErrAuthFailed)
+	}
+	return plain, nil
+}
+
+// Put stores the files and directories at paths as new snapshot. Every path
+// is stored under its base name, directories with everything below them.
+// Other file types than regular files, directories and symbolic links are
+// skipped and reported to the SkipFunc of the Encryptor. Put fails with
+// ErrLocked while Prune runs.
+func (r *Repository) Put(paths ...string) (*Snapshot, error) {
+	unlock, err := r.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	host, _ := os.Hostname()
+	snap := &Snapshot{Time: time.Now().UTC(), Host: host}
+	bases := map[string]bool{}
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		base := filepath.Base(abs)
+		if bases[base] {
+			return nil, fmt.Errorf("two paths are named %s", base)
+		}
+		bases[base] = true
+		snap.Paths = append(snap.Paths, abs)
+
+		err = filepath.WalkDir(abs, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(abs, path)
+			if err != nil {
+				return err
+			}
+			return r.putFile(snap, path, filepath.ToSlash(filepath.Join(base, rel)), d)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	idx, err := r.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.saveSnapshot(snap); err != nil {
+		return nil, err
+	}
+	idx.add(snap)
+	if err := r.saveIndex(idx); err != nil {
+		return nil, fmt.Errorf("snapshot %s was stored, but %w", snap.ID, err)
+	}
+	return snap, nil
+}
+
+// putFile stores the file at path as entry name of snap
+func (r *Repository) putFile(snap *Snapshot, path, name string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	f := SnapshotFile{Name: name, Mode: info.Mode(), ModTime: info.ModTime().UTC()}
+	switch {
+	case info.IsDir():
+	case info.Mode()&fs.ModeSymlink != 0:
+		if f.Link, err = os.Readlink(path); err != nil {
+			return fmt.Errorf("failed to read link: %w", err)
+		}
+	case info.Mode().IsRegular():
+		if err := r.putChunks(snap, &f, path); err != nil {
+			return err
+		}
+	default:
+		r.e.skip(path, SpecialFileType(info.Mode()))
+		return nil
+	}
+	snap.Files = append(snap.Files, f)
+	return nil
+}
+
+// putChunks splits the file at path into chunks, stores them and records them in f
+func (r *Repository) putChunks(snap *Snapshot, f *SnapshotFile, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	src, err := r.e.progressReader(file, path)
+	if err != nil {
+		return err
+	}
+	c := newChunker(src, r.gear)
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		id, added, err := r.storeChunk(chunk)
+		if err != nil {
+			return err
+		}
+		f.Chunks = append(f.Chunks, id)
+		f.Size += int64(len(chunk))
+		snap.Size += int64(len(chunk))
+		snap.Added += added
+	}
+}
+
+// saveSnapshot names snap and stores it
+func (r *Repository) saveSnapshot(snap *Snapshot) error {
+	suffix := make([]byte, 4)
+	if _, err := io.ReadFull(r.e.random, suffix); err != nil {
+		return fmt.Errorf("failed to generate snapshot id: %w", err)
+	}
+	snap.ID = snap.Time.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	sealed, err := r.seal("snapshot "+snap.ID, data)
+	if err != nil {
+		return err
+	}
+	return r.e.writeAtomic(filepath.Join(r.path, "snapshots", snap.ID), false, func(w io.Writer) error {
+		_, err := w.Write(sealed)
+		return err
+	})
+}
+
+// Snapshots returns the IDs of the snapshots, the oldest first
+func (r *Repository) Snapshots() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.path, "snapshots"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			ids = append(ids, entry.Name())
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Snapshot reads the snapshot id, which may be abbreviated to a unique prefix
+func (r *Repository) Snapshot(id string) (*Snapshot, error) {
+	ids, err := r.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	var found []string
+	for _, s := range ids {
+		if s == id {
+			found = []string{s}
+			break
+		}
+		if id != "" && strings.HasPrefix(s, id) {
+			found = append(found, s)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return nil, fmt.Errorf("no snapshot %s: %w", id, fs.ErrNotExist)
+	case len(found) > 1:
+		return nil, fmt.Errorf("snapshot %s is ambiguous", id)
+	}
+
+	data, err := os.ReadFile(filepath.Join(r.path, "snapshots", found[0]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	plain, err := r.unseal("snapshot "+found[0], data)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", found[0], err)
+	}
+	snap := &Snapshot{ID: found[0]}
+	if err := json.Unmarshal(plain, snap); err != nil {
+		return nil, fmt.Errorf("%w: invalid snapshot %s: %w", ErrMalformedHeader, found[0], err)
+	}
+	return snap, nil
+}
+
+// Restore writes the files of snap for which match returns true, or all if
+// match is nil, into dstDir. Existing files are only replaced with
+// WithOverwrite. Every chunk is authenticated before it is written.
+func (r *Repository) Restore(snap *Snapshot, dstDir string, match func(name string) bool) error {
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	root, err := os.OpenRoot(dstDir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer root.Close()
+
+	// Directory permissions and times are applied last, restoring changes them
+	var dirs []SnapshotFile
+	folder := nameFolder{}
+	for _, f := range snap.Files {
+		if match != nil && !match(f.Name) {
+			continue
+		}
+		name, ok, err := localName(f.Name)
+		if err != nil {
+			return fmt.Errorf("%w in snapshot", err)
+		}
+		if !ok {
+			r.e.skip(f.Name, "its name is not valid on this system")
+			continue
+		}
+		if other, found := folder.collision(name); found && !f.Mode.IsDir() {
+			r.e.skip(f.Name, fmt.Sprintf("its name differs only in case from %s", other))
+			continue
+		}
+		switch {
+		case f.Mode.IsDir():
+			if err := root.MkdirAll(name, 0700); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			dirs = append(dirs, f)
+			continue
+		case f.Mode&fs.ModeSymlink != 0:
+			if dir := filepath.Dir(name); dir != "." {
+				if err := root.MkdirAll(dir, 0700); err != nil {
+					return fmt.Errorf("failed to create directory: %w", err)
+				}
+			}
+			if r.e.overwrite {
+				root.Remove(name)
+			}
+			if err := root.Symlink(f.Link, name); err != nil {
+				return fmt.Errorf("failed to create link: %w", err)
+			}
+			continue
+		}
+		if err := r.e.extractFile(root, name, &repoFileReader{r: r, ids: f.Chunks}); err != nil {
+			return err
+		}
+		if err := restoreEntry(root, name, f.tarHeader(), false); err != nil {
+			return err
+		}
+	}
+	for _, f := range slices.Backward(dirs) {
+		name, _, _ := localName(f.Name)
+		if err := restoreEntry(root, name, f.tarHeader(), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tarHeader returns the attributes of f for restoreEntry
+func (f SnapshotFile) tarHeader() *tar.Header {
+	return &tar.Header{Name: f.Name, Mode: int64(f.Mode.Perm()), ModTime: f.ModTime}
+}
+
+// repoFileReader reads the content of a file from its chunks
+type repoFileReader struct {
+	r   *Repository
+	ids []string
+	buf []byte
+}
+
+// Read returns the data of the chunks one after the other
+func (c *repoFileReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if len(c.ids) == 0 {
+			return 0, io.EOF
+		}
+		data, err := c.r.loadChunk(c.ids[0])
+		if err != nil {
+			return 0, err
+		}
+		c.buf, c.ids = data, c.ids[1:]
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
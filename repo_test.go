@@ -0,0 +1,446 @@
+package fileenc_test
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// repoEncryptor returns an Encryptor with a cheap KDF for test repositories
+func repoEncryptor(t *testing.T, opts ...fileenc.Option) *fileenc.Encryptor {
+	t.Helper()
+	opts = append(opts, fileenc.WithKDF(fileenc.KDFParams{Name: fileenc.KDFPBKDF2, Time: 1}))
+	e, err := fileenc.New([]byte(fuzzPass), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// newTestRepo creates a repository in a temporary directory
+func newTestRepo(t *testing.T) (*fileenc.Repository, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "repo")
+	repo, err := fileenc.InitRepository(path, repoEncryptor(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo, path
+}
+
+// randomData returns size reproducible random bytes
+func randomData(seed byte, size int) []byte {
+	data := make([]byte, size)
+	rand.NewChaCha8([32]byte{seed}).Read(data)
+	return data
+}
+
+// writeFiles writes the files, named by slash separated paths, below dir
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// storedChunks returns the names of the chunk files in the repository at path
+func storedChunks(t *testing.T, path string) []string {
+	t.Helper()
+	var chunks []string
+	err := filepath.WalkDir(filepath.Join(path, "data"), func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			chunks = append(chunks, d.Name())
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+// snapshotFile returns the file name of snap
+func snapshotFile(t *testing.T, snap *fileenc.Snapshot, name string) fileenc.SnapshotFile {
+	t.Helper()
+	for _, f := range snap.Files {
+		if f.Name == name {
+			return f
+		}
+	}
+	t.Fatalf("snapshot %s has no file %s", snap.ID, name)
+	return fileenc.SnapshotFile{}
+}
+
+// TestRepositoryRoundTrip stores a directory and restores it from the
+// repository opened again
+func TestRepositoryRoundTrip(t *testing.T) {
+	_, path := newTestRepo(t)
+	src := filepath.Join(t.TempDir(), "docs")
+	files := map[string][]byte{
+		"a.txt":       []byte("hello repository"),
+		"empty":       nil,
+		"sub/big.bin": randomData(1, 3<<20),
+	}
+	writeFiles(t, src, files)
+	if err := os.Symlink("a.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "a.txt"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := fileenc.OpenRepository(path, repoEncryptor(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	put, err := repo.Put(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if put.Size != int64(len(files["a.txt"])+len(files["sub/big.bin"])) {
+		t.Errorf("snapshot size %d", put.Size)
+	}
+
+	reopened, err := fileenc.OpenRepository(path, repoEncryptor(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	snap, err := reopened.Snapshot(put.ID[:12])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	if err := reopened.Restore(snap, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dst, "docs", filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s restored with different content", name)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dst, "docs", "a.txt")); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("a.txt restored with mode %v, %v", info.Mode(), err)
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "docs", "link")); err != nil || target != "a.txt" {
+		t.Errorf("link restored as %q, %v", target, err)
+	}
+
+	// Another passphrase cannot open the repository
+	other, err := fileenc.New([]byte("wrong"), fileenc.WithKDF(fileenc.KDFParams{Name: fileenc.KDFPBKDF2, Time: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileenc.OpenRepository(path, other); !errors.Is(err, fileenc.ErrWrongPassword) {
+		t.Errorf("wrong passphrase: got %v, want ErrWrongPassword", err)
+	}
+}
+
+// TestRepositoryDeduplication stores identical content once, within a
+// snapshot and across snapshots
+func TestRepositoryDeduplication(t *testing.T) {
+	repo, path := newTestRepo(t)
+	data := randomData(2, 4<<20)
+	first := filepath.Join(t.TempDir(), "first")
+	writeFiles(t, first, map[string][]byte{"a": data, "copy/a": data})
+	snap, err := repo.Put(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := snapshotFile(t, snap, "first/a"), snapshotFile(t, snap, "first/copy/a")
+	if len(a.Chunks) < 2 || !slices.Equal(a.Chunks, b.Chunks) {
+		t.Fatalf("identical files got chunks %v and %v", a.Chunks, b.Chunks)
+	}
+	chunks := storedChunks(t, path)
+	if len(chunks) != len(a.Chunks) {
+		t.Errorf("%d chunks stored for %d distinct ones", len(chunks), len(a.Chunks))
+	}
+
+	// The same content under another name adds nothing
+	second := filepath.Join(t.TempDir(), "second")
+	writeFiles(t, second, map[string][]byte{"renamed": data})
+	snap, err = repo.Put(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Added != 0 || snap.Size != int64(len(data)) {
+		t.Errorf("second snapshot added %d bytes of %d", snap.Added, snap.Size)
+	}
+	if n := len(storedChunks(t, path)); n != len(chunks) {
+		t.Errorf("%d chunks stored after the second snapshot, want %d", n, len(chunks))
+	}
+}
+
+// TestRepositoryChunkBoundaries checks that inserting data only changes the
+// chunks around the insertion
+func TestRepositoryChunkBoundaries(t *testing.T) {
+	repo, _ := newTestRepo(t)
+	data := randomData(3, 16<<20)
+	at := 8 << 20
+	inserted := slices.Concat(data[:at], []byte("a few inserted bytes"), data[at:])
+	dir := filepath.Join(t.TempDir(), "files")
+	writeFiles(t, dir, map[string][]byte{"original": data, "inserted": inserted})
+	snap, err := repo.Put(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, ins := snapshotFile(t, snap, "files/original"), snapshotFile(t, snap, "files/inserted")
+	if len(orig.Chunks) < 8 {
+		t.Fatalf("16 MiB split into %d chunks only", len(orig.Chunks))
+	}
+	changed := 0
+	for _, id := range ins.Chunks {
+		if !slices.Contains(orig.Chunks, id) {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 2 {
+		t.Errorf("%d of %d chunks changed by the insertion, want 1 or 2", changed, len(ins.Chunks))
+	}
+	// The chunks before and after the change stay in place
+	if orig.Chunks[0] != ins.Chunks[0] || orig.Chunks[len(orig.Chunks)-1] != ins.Chunks[len(ins.Chunks)-1] {
+		t.Error("first or last chunk changed by an insertion in the middle")
+	}
+}
+
+// TestRepositoryPrune removes a forgotten snapshot and only the chunks no
+// other snapshot uses
+func TestRepositoryPrune(t *testing.T) {
+	repo, path := newTestRepo(t)
+	shared, gone, kept := randomData(4, 1<<20), randomData(5, 1<<20), randomData(6, 1<<20)
+	old := filepath.Join(t.TempDir(), "data")
+	writeFiles(t, old, map[string][]byte{"shared": shared, "gone": gone})
+	oldSnap, err := repo.Put(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur := filepath.Join(t.TempDir(), "data")
+	writeFiles(t, cur, map[string][]byte{"shared": shared, "kept": kept})
+	curSnap, err := repo.Put(cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := repo.Prune(oldSnap.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goneChunks := snapshotFile(t, oldSnap, "data/gone").Chunks
+	if stats.Snapshots != 1 || stats.Chunks != len(goneChunks) {
+		t.Errorf("pruned %d snapshots and %d chunks, want 1 and %d", stats.Snapshots, stats.Chunks, len(goneChunks))
+	}
+	ids, err := repo.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []string{curSnap.ID}) {
+		t.Errorf("snapshots %v left, want %s", ids, curSnap.ID)
+	}
+	stored := storedChunks(t, path)
+	for _, f := range curSnap.Files {
+		for _, id := range f.Chunks {
+			if !slices.Contains(stored, id) {
+				t.Errorf("chunk %s of %s was swept", id, f.Name)
+			}
+		}
+	}
+	for _, id := range goneChunks {
+		if slices.Contains(stored, id) {
+			t.Errorf("unused chunk %s was kept", id)
+		}
+	}
+	dst := t.TempDir()
+	if err := repo.Restore(curSnap, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{"shared": shared, "kept": kept} {
+		if got, err := os.ReadFile(filepath.Join(dst, "data", name)); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s not restored after prune: %v", name, err)
+		}
+	}
+
+	// Without snapshots to forget nothing in use is removed
+	if stats, err := repo.Prune(); err != nil || stats.Snapshots != 0 || stats.Chunks != 0 {
+		t.Errorf("second prune removed %+v, %v", stats, err)
+	}
+}
+
+// TestRepositoryLocks checks that another process writing to the repository
+// blocks Prune and a running Prune blocks writers
+func TestRepositoryLocks(t *testing.T) {
+	repo, path := newTestRepo(t)
+	src := filepath.Join(t.TempDir(), "data")
+	writeFiles(t, src, map[string][]byte{"file": []byte("locked")})
+	snap, err := repo.Put(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := filepath.Join(path, "locks", "0123456789abcdef")
+
+	// A writer holds a shared lock
+	if err := os.WriteFile(lock, []byte(`{"host":"other","pid":1,"exclusive":false}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Prune(snap.ID); !errors.Is(err, fileenc.ErrLocked) {
+		t.Fatalf("prune while locked: got %v, want ErrLocked", err)
+	}
+	if _, err := repo.Snapshot(snap.ID); err != nil {
+		t.Fatalf("snapshot removed by a blocked prune: %v", err)
+	}
+	if _, err := repo.Put(src); err != nil {
+		t.Errorf("put next to another writer: %v", err)
+	}
+
+	// A prune holds an exclusive lock
+	if err := os.WriteFile(lock, []byte(`{"host":"other","pid":1,"exclusive":true}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Put(src); !errors.Is(err, fileenc.ErrLocked) {
+		t.Errorf("put while pruned: got %v, want ErrLocked", err)
+	}
+
+	if n, err := repo.RemoveLocks(); err != nil || n != 1 {
+		t.Fatalf("removed %d locks, %v", n, err)
+	}
+	if _, err := repo.Prune(snap.ID); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(filepath.Join(path, "locks")); err != nil || len(entries) != 0 {
+		t.Errorf("locks left behind: %v", entries)
+	}
+}
+
+// checkRepo runs Check reading the share readData of the chunks and returns
+// the problems found
+func checkRepo(t *testing.T, repo *fileenc.Repository, readData float64) []fileenc.CheckProblem {
+	t.Helper()
+	var problems []fileenc.CheckProblem
+	stats, err := repo.Check(readData, func(p fileenc.CheckProblem) {
+		problems = append(problems, p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Problems != len(problems) {
+		t.Errorf("%d problems counted, %d reported", stats.Problems, len(problems))
+	}
+	return problems
+}
+
+// checkFixture stores a file in a new repository and returns the repository,
+// its path, the snapshot and the first chunk of the file
+func checkFixture(t *testing.T) (*fileenc.Repository, string, *fileenc.Snapshot, string) {
+	t.Helper()
+	repo, path := newTestRepo(t)
+	src := filepath.Join(t.TempDir(), "data")
+	writeFiles(t, src, map[string][]byte{"file": randomData(7, 1<<20)})
+	snap, err := repo.Put(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := checkRepo(t, repo, 1); len(problems) != 0 {
+		t.Fatalf("intact repository has problems: %v", problems)
+	}
+	return repo, path, snap, snapshotFile(t, snap, "data/file").Chunks[0]
+}
+
+// TestRepositoryCheckMissingChunk reports a removed chunk and the file needing it
+func TestRepositoryCheckMissingChunk(t *testing.T) {
+	repo, path, snap, chunk := checkFixture(t)
+	object := "data/" + chunk[:2] + "/" + chunk
+	if err := os.Remove(filepath.Join(path, filepath.FromSlash(object))); err != nil {
+		t.Fatal(err)
+	}
+	// Missing chunks are found without reading any data
+	problems := checkRepo(t, repo, 0)
+	if len(problems) != 1 || problems[0].Object != object || !errors.Is(problems[0].Err, fs.ErrNotExist) {
+		t.Fatalf("got %v, want chunk %s missing", problems, chunk)
+	}
+	if want := []string{snap.ID + ":data/file"}; !slices.Equal(problems[0].Files, want) {
+		t.Errorf("affected files %v, want %v", problems[0].Files, want)
+	}
+}
+
+// TestRepositoryCheckCorruptChunk reports a chunk failing authentication
+func TestRepositoryCheckCorruptChunk(t *testing.T) {
+	repo, path, _, chunk := checkFixture(t)
+	object := "data/" + chunk[:2] + "/" + chunk
+	name := filepath.Join(path, filepath.FromSlash(object))
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 1
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Damaged chunks are only found when they are read
+	if problems := checkRepo(t, repo, 0); len(problems) != 0 {
+		t.Errorf("problems found without reading: %v", problems)
+	}
+	problems := checkRepo(t, repo, 1)
+	if len(problems) != 1 || problems[0].Object != object || !errors.Is(problems[0].Err, fileenc.ErrAuthFailed) {
+		t.Fatalf("got %v, want chunk %s failing authentication", problems, chunk)
+	}
+}
+
+// TestRepositoryCheckStaleIndex reports an index not matching the snapshots
+func TestRepositoryCheckStaleIndex(t *testing.T) {
+	repo, path, _, _ := checkFixture(t)
+	index := filepath.Join(path, "index")
+	old, err := os.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "more")
+	writeFiles(t, src, map[string][]byte{"file": []byte("second snapshot")})
+	if _, err := repo.Put(src); err != nil {
+		t.Fatal(err)
+	}
+	if problems := checkRepo(t, repo, 1); len(problems) != 0 {
+		t.Fatalf("index updated by Put has problems: %v", problems)
+	}
+	// The index of the first snapshot only
+	if err := os.WriteFile(index, old, 0600); err != nil {
+		t.Fatal(err)
+	}
+	problems := checkRepo(t, repo, 1)
+	if len(problems) != 1 || problems[0].Object != "index" {
+		t.Fatalf("got %v, want a stale index", problems)
+	}
+}
@@ -0,0 +1,160 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+)
+
+// CheckProblem is a missing or damaged object found by Check
+type CheckProblem struct {
+	// Object is the slash separated path of the object in the repository
+	Object string
+	Err    error
+	// Files lists the files that cannot be restored because of the problem,
+	// as snapshot id, a colon and the name
+	Files []string
+}
+
+// CheckStats summarizes a Check
+type CheckStats struct {
+	Snapshots int
+	// Chunks is the number of chunks the snapshots refer to, Read of those
+	// read and authenticated
+	Chunks int
+	Read   int
+	// Unused is the number of chunks no snapshot refers to, Prune removes them
+	Unused   int
+	Problems int
+}
+
+// errIndexStale is reported if the index does not match the snapshots
+var errIndexStale = errors.New("the index does not match the snapshots")
+
+// Check verifies that every snapshot can be decrypted, every chunk it refers
+// to exists and the index matches the snapshots. Chunks are read and
+// authenticated with the probability readData, 1 reads all of them and 0
+// only checks that they exist; on slow or remote storage a sample limits
+// the data read. Problems are reported to problem, the error is only set if
+// the check could not run. Prune cannot run at the same time.
+func (r *Repository) Check(readData float64, problem func(CheckProblem)) (CheckStats, error) {
+	var stats CheckStats
+	unlock, err := r.lock(false)
+	if err != nil {
+		return stats, err
+	}
+	defer unlock()
+	report := func(p CheckProblem) {
+		stats.Problems++
+		problem(p)
+	}
+
+	ids, err := r.Snapshots()
+	if err != nil {
+		return stats, err
+	}
+	var snaps []*Snapshot
+	refs := map[string][]string{}
+	for _, id := range ids {
+		snap, err := r.Snapshot(id)
+		if err != nil {
+			report(CheckProblem{Object: "snapshots/" + id, Err: err})
+			continue
+		}
+		snaps = append(snaps, snap)
+		for _, f := range snap.Files {
+			for _, chunk := range f.Chunks {
+				refs[chunk] = append(refs[chunk], id+":"+f.Name)
+			}
+		}
+	}
+	stats.Snapshots = len(snaps)
+	stats.Chunks = len(refs)
+
+	stored := map[string]bool{}
+	err = filepath.WalkDir(filepath.Join(r.path, "data"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || len(d.Name()) != 64 {
+			return err
+		}
+		stored[d.Name()] = true
+		if refs[d.Name()] == nil {
+			stats.Unused++
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("failed to list chunks: %w", err)
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(refs)) {
+		object := "data/" + id[:2] + "/" + id
+		if !stored[id] {
+			report(CheckProblem{Object: object, Err: fs.ErrNotExist, Files: refs[id]})
+			continue
+		}
+		if readData < 1 && rand.Float64() >= readData {
+			continue
+		}
+		stats.Read++
+		if _, err := r.loadChunk(id); err != nil {
+			report(CheckProblem{Object: object, Err: err, Files: refs[id]})
+		}
+	}
+
+	// The index can only be compared if all snapshots could be read
+	idx, err := r.readIndex()
+	if err != nil {
+		report(CheckProblem{Object: "index", Err: err})
+	} else if len(snaps) == len(ids) && !idx.matches(snaps) {
+		report(CheckProblem{Object: "index", Err: errIndexStale})
+	}
+	return stats, nil
+}
+
+// matches reports whether idx indexes exactly the files of snaps
+func (idx *repoIndex) matches(snaps []*Snapshot) bool {
+	if len(idx.Snapshots) != len(snaps) {
+		return false
+	}
+	files, indexed := 0, 0
+	for _, snap := range snaps {
+		if idx.Snapshots[snap.ID] == nil {
+			return false
+		}
+		for _, f := range snap.Files {
+			files++
+			found := slices.ContainsFunc(idx.Paths[f.Name], func(v FileVersion) bool {
+				return v.SnapshotFile.equal(f) && slices.Contains(v.Snapshots, snap.ID)
+			})
+			if !found {
+				return false
+			}
+		}
+	}
+	for _, versions := range idx.Paths {
+		for _, v := range versions {
+			indexed += len(v.Snapshots)
+		}
+	}
+	return files == indexed
+}
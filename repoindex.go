@@ -0,0 +1,214 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The index of a repository lists the snapshots and, for every path, its
+// versions with their chunks and the snapshots holding them, so listing
+// snapshots and looking up a file read one object instead of all snapshots.
+// It is sealed like the other objects and only a cache: it is brought up to
+// date with the snapshots directory whenever it is read.
+
+// repoIndex is stored sealed in the index file of a repository
+type repoIndex struct {
+	Snapshots map[string]*Snapshot     `json:"snapshots"`
+	Paths     map[string][]FileVersion `json:"paths"`
+}
+
+// FileVersion is a version of a file and the snapshots it appears in
+type FileVersion struct {
+	SnapshotFile
+	Snapshots []string `json:"snapshots"`
+}
+
+// indexPath returns the path of the index file
+func (r *Repository) indexPath() string {
+	return filepath.Join(r.path, "index")
+}
+
+// readIndex reads the index as stored, a missing one is empty
+func (r *Repository) readIndex() (*repoIndex, error) {
+	idx := &repoIndex{Snapshots: map[string]*Snapshot{}, Paths: map[string][]FileVersion{}}
+	data, err := os.ReadFile(r.indexPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	plain, err := r.unseal("index", data)
+	if err != nil {
+		return nil, fmt.Errorf("index: %w", err)
+	}
+	if err := json.Unmarshal(plain, idx); err != nil || idx.Snapshots == nil || idx.Paths == nil {
+		return nil, fmt.Errorf("%w: invalid index", ErrMalformedHeader)
+	}
+	for id, snap := range idx.Snapshots {
+		snap.ID = id
+	}
+	return idx, nil
+}
+
+// loadIndex reads the index and adds and removes snapshots until it matches
+// the snapshots directory, a damaged index is rebuilt
+func (r *Repository) loadIndex() (*repoIndex, error) {
+	idx, err := r.readIndex()
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return nil, err
+	}
+	if err != nil {
+		idx = &repoIndex{Snapshots: map[string]*Snapshot{}, Paths: map[string][]FileVersion{}}
+	}
+
+	ids, err := r.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	changed := false
+	for id := range idx.Snapshots {
+		if _, found := slices.BinarySearch(ids, id); !found {
+			idx.remove(id)
+			changed = true
+		}
+	}
+	for _, id := range ids {
+		if idx.Snapshots[id] != nil {
+			continue
+		}
+		snap, err := r.Snapshot(id)
+		if err != nil {
+			return nil, err
+		}
+		idx.add(snap)
+		changed = true
+	}
+	if changed {
+		if err := r.saveIndex(idx); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// saveIndex seals and stores idx
+func (r *Repository) saveIndex(idx *repoIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	sealed, err := r.seal("index", data)
+	if err != nil {
+		return err
+	}
+	return r.e.writeAtomic(r.indexPath(), true, func(w io.Writer) error {
+		_, err := w.Write(sealed)
+		return err
+	})
+}
+
+// add indexes snap, files equal to an indexed version are added to it
+func (idx *repoIndex) add(snap *Snapshot) {
+	summary := *snap
+	summary.Files = nil
+	idx.Snapshots[snap.ID] = &summary
+	for _, f := range snap.Files {
+		versions := idx.Paths[f.Name]
+		i := slices.IndexFunc(versions, func(v FileVersion) bool { return v.SnapshotFile.equal(f) })
+		if i < 0 {
+			versions = append(versions, FileVersion{SnapshotFile: f})
+			i = len(versions) - 1
+		}
+		versions[i].Snapshots = append(versions[i].Snapshots, snap.ID)
+		idx.Paths[f.Name] = versions
+	}
+}
+
+// remove drops the snapshot id and the versions only it held
+func (idx *repoIndex) remove(id string) {
+	delete(idx.Snapshots, id)
+	for name, versions := range idx.Paths {
+		for i := range versions {
+			versions[i].Snapshots = slices.DeleteFunc(versions[i].Snapshots, func(s string) bool { return s == id })
+		}
+		versions = slices.DeleteFunc(versions, func(v FileVersion) bool { return len(v.Snapshots) == 0 })
+		if len(versions) == 0 {
+			delete(idx.Paths, name)
+		} else {
+			idx.Paths[name] = versions
+		}
+	}
+}
+
+// equal reports whether f and g are the same version of a file
+func (f SnapshotFile) equal(g SnapshotFile) bool {
+	return f.Name == g.Name && f.Mode == g.Mode && f.ModTime.Equal(g.ModTime) && f.Size == g.Size &&
+		f.Link == g.Link && slices.Equal(f.Chunks, g.Chunks)
+}
+
+// History returns the snapshots without their files, the oldest first, from
+// the index
+func (r *Repository) History() ([]*Snapshot, error) {
+	idx, err := r.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	snaps := slices.Collect(maps.Values(idx.Snapshots))
+	slices.SortFunc(snaps, func(a, b *Snapshot) int {
+		if c := a.Time.Compare(b.Time); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return snaps, nil
+}
+
+// Versions returns the versions of the file name and of everything below it
+// from the index, ordered by name and the first snapshot holding them. Name is
+// a slash separated path as in SnapshotFile.
+func (r *Repository) Versions(name string) ([]FileVersion, error) {
+	idx, err := r.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	name = strings.Trim(name, "/")
+	var found []FileVersion
+	for path, versions := range idx.Paths {
+		if path == name || strings.HasPrefix(path, name+"/") {
+			found = append(found, versions...)
+		}
+	}
+	slices.SortFunc(found, func(a, b FileVersion) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Snapshots[0], b.Snapshots[0])
+	})
+	return found, nil
+}
@@ -0,0 +1,201 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Writers and Prune lock the repository with a file in its locks directory.
+// Put takes a shared lock, Prune an exclusive one, so chunks Put relies on
+// are never swept. A lock is created first and then checked against the
+// others, when two conflict both give up. Locks left by crashed processes
+// stay until RemoveLocks removes them.
+
+// ErrLocked is returned if the repository is locked by another process
+var ErrLocked = errors.New("repository is locked")
+
+// repoLock is the content of a lock file
+type repoLock struct {
+	Host      string    `json:"host"`
+	PID       int       `json:"pid"`
+	Time      time.Time `json:"time"`
+	Exclusive bool      `json:"exclusive"`
+}
+
+// lock locks the repository and returns the function removing the lock
+func (r *Repository) lock(exclusive bool) (func(), error) {
+	dir := filepath.Join(r.path, "locks")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	host, _ := os.Hostname()
+	data, err := json.Marshal(repoLock{Host: host, PID: os.Getpid(), Time: time.Now().UTC(), Exclusive: exclusive})
+	if err != nil {
+		return nil, err
+	}
+	suffix := make([]byte, 8)
+	if _, err := io.ReadFull(r.e.random, suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate lock name: %w", err)
+	}
+	name := hex.EncodeToString(suffix)
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to lock repository: %w", err)
+	}
+	unlock := func() { os.Remove(path) }
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("failed to lock repository: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == name || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		var other repoLock
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &other)
+		}
+		if err != nil {
+			other.Exclusive = true
+		}
+		if exclusive || other.Exclusive {
+			unlock()
+			return nil, fmt.Errorf("%w by %s (pid %d) since %s", ErrLocked, other.Host, other.PID,
+				other.Time.Local().Format(time.DateTime))
+		}
+	}
+	return unlock, nil
+}
+
+// RemoveLocks removes all locks of the repository, only use it when no
+// other process uses the repository. It returns the number of locks removed.
+func (r *Repository) RemoveLocks() (int, error) {
+	dir := filepath.Join(r.path, "locks")
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to list locks: %w", err)
+	}
+	n := 0
+	for _, entry := range entries {
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return n, fmt.Errorf("failed to remove lock: %w", err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// PruneStats reports what Prune removed
+type PruneStats struct {
+	Snapshots int
+	Chunks    int
+	// Bytes is the size of the chunks removed
+	Bytes int64
+}
+
+// Prune removes the snapshots forget and then the chunks no remaining
+// snapshot refers to. It locks the repository exclusively, marks the chunks
+// of all snapshots and only then sweeps the data directory, keeping chunks
+// written since the mark began. If a snapshot cannot be read nothing is swept.
+func (r *Repository) Prune(forget ...string) (PruneStats, error) {
+	var stats PruneStats
+	unlock, err := r.lock(true)
+	if err != nil {
+		return stats, err
+	}
+	defer unlock()
+
+	for _, id := range forget {
+		if filepath.Base(id) != id || strings.HasPrefix(id, ".") {
+			return stats, fmt.Errorf("invalid snapshot id %q", id)
+		}
+		if err := os.Remove(filepath.Join(r.path, "snapshots", id)); err != nil {
+			return stats, fmt.Errorf("failed to remove snapshot: %w", err)
+		}
+		stats.Snapshots++
+	}
+
+	// Mark
+	start := time.Now()
+	ids, err := r.Snapshots()
+	if err != nil {
+		return stats, err
+	}
+	used := map[string]bool{}
+	for _, id := range ids {
+		snap, err := r.Snapshot(id)
+		if err != nil {
+			return stats, fmt.Errorf("failed to mark chunks, nothing was swept: %w", err)
+		}
+		for _, f := range snap.Files {
+			for _, chunk := range f.Chunks {
+				used[chunk] = true
+			}
+		}
+	}
+
+	// Sweep
+	err = filepath.WalkDir(filepath.Join(r.path, "data"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if used[d.Name()] || len(d.Name()) != 64 && !strings.HasSuffix(d.Name(), ".tmp") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat chunk: %w", err)
+		}
+		if !info.ModTime().Before(start) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove chunk: %w", err)
+		}
+		if len(d.Name()) == 64 {
+			stats.Chunks++
+			stats.Bytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	if _, err := r.loadIndex(); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
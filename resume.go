@@ -0,0 +1,272 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// partialExt is appended to the destination while a resumable encryption is in progress
+const partialExt = ".partial"
+
+// resumeState records the source a partial file is written from, a partial
+// file is only continued from the same unchanged source
+type resumeState struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Dev     uint64 `json:"dev,omitempty"`
+	Ino     uint64 `json:"ino,omitempty"`
+}
+
+// resumeStateOf returns the state of the source described by info
+func resumeStateOf(info fs.FileInfo) resumeState {
+	st := resumeState{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	if id, ok := fileIDOf(info); ok {
+		st.Dev, st.Ino = id.dev, id.ino
+	}
+	return st
+}
+
+// resumeStatePath returns the path of the hidden file holding the state of the partial file
+func resumeStatePath(partial string) string {
+	return filepath.Join(filepath.Dir(partial), "."+filepath.Base(partial)+".state")
+}
+
+// readResumeState reads the state recorded for the partial file
+func readResumeState(partial string) (resumeState, error) {
+	var st resumeState
+	data, err := os.ReadFile(resumeStatePath(partial))
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(data, &st)
+	return st, err
+}
+
+// writeResumeState records the state of the source of the partial file
+func (e *Encryptor) writeResumeState(partial string, st resumeState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(resumeStatePath(partial), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write resume state: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write resume state: %w", err)
+	}
+	if err := e.syncFile(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// WithResume makes EncryptFile write to the destination with .partial appended
+// and keep that file on failure. A later EncryptFile with the same passphrase
+// validates the chunks completed so far and continues after the last good one.
+// It requires a passphrase, an authenticated cipher and no compression.
+func WithResume() Option {
+	return func(e *Encryptor) {
+		e.resume = true
+	}
+}
+
+// validateResume checks that the settings allow resuming
+func (e *Encryptor) validateResume() error {
+	switch {
+	case !e.resume:
+		return nil
+	case e.format != FormatFileenc || e.cipher == CipherAESCFB:
+		return errors.New("resuming requires the fileenc format and an authenticated cipher")
+	case e.compression != CompressionNone:
+		return errors.New("resuming does not support compression")
+	case e.armor:
+		return errors.New("resuming does not support armor")
+	case len(e.recipients) > 0:
+		return errors.New("resuming requires a passphrase, the file key of recipients cannot be recovered")
+	}
+	return nil
+}
+
+// encryptResumable implements EncryptFile with WithResume
+func (e *Encryptor) encryptResumable(srcPath, dstPath string, overwrite bool) error {
+	if !overwrite {
+		if _, err := os.Stat(dstPath); err == nil {
+			return fmt.Errorf("%w: %s, overwrite is disabled", ErrFileExists, dstPath)
+		}
+	}
+
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	md, err := e.sourceMetadata(file, srcPath)
+	if err != nil {
+		return err
+	}
+
+	partial := dstPath + partialExt
+	out, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open partial file: %w", err)
+	}
+	defer out.Close()
+	// A second run resuming the same file would interleave the chunks
+	if err := lockFile(out, true); err != nil {
+		return fmt.Errorf("%w: %s", err, partial)
+	}
+
+	// Chunks written from another source, or from one changed since, must not
+	// be continued. A partial file without state is started over.
+	outStat, err := out.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat partial file: %w", err)
+	}
+	state := resumeStateOf(stat)
+	saved, err := readResumeState(partial)
+	switch {
+	case outStat.Size() > 0 && err == nil && saved != state:
+		return fmt.Errorf("cannot resume %s: the source has been changed or replaced since, remove it to start over", partial)
+	case outStat.Size() == 0 || err != nil:
+		if err := out.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate partial file: %w", err)
+		}
+		if err := e.writeResumeState(partial, state); err != nil {
+			return err
+		}
+	}
+
+	// Continue after the chunks completed by an earlier run or start over
+	w, offset, err := e.resumeWriter(out, md)
+	if err != nil {
+		return fmt.Errorf("cannot resume %s: %w", partial, err)
+	}
+	if offset > stat.Size() {
+		return fmt.Errorf("cannot resume %s: the source is shorter than the encrypted part, remove it to start over", partial)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in file: %w", err)
+	}
+	src, err := e.progressReader(file, srcPath)
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		err = e.encrypt(out, src, md, nil)
+	} else if _, err = e.copy(w, src); err != nil {
+		err = fmt.Errorf("failed to encrypt: %w", err)
+	} else {
+		err = w.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only a complete file gets its final name
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", partial, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", partial, err)
+	}
+	if err := os.Rename(partial, dstPath); err != nil {
+		return fmt.Errorf("failed to rename partial file: %w", err)
+	}
+	os.Remove(resumeStatePath(partial))
+	return e.syncDir(filepath.Dir(dstPath))
+}
+
+// resumeWriter validates the chunks in the partial file out and returns a
+// writer continuing after the last good one together with the plaintext offset
+// to continue from. It returns a nil writer and truncates out if there is
+// nothing to resume.
+func (e *Encryptor) resumeWriter(out *os.File, md *Metadata) (io.WriteCloser, int64, error) {
+	stat, err := out.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	hdr, raw, err := readHeader(out)
+	if stat.Size() == 0 || err != nil {
+		// Nothing or not even the header was written
+		if err := out.Truncate(0); err != nil {
+			return nil, 0, err
+		}
+		_, err := out.Seek(0, io.SeekStart)
+		return nil, 0, err
+	}
+
+	// The partial file must have been written with resumable settings
+	if _, ok := hdr.extension(extCompression); ok || hdr.Cipher == CipherAESCFB {
+		return nil, 0, errors.New("not written with resumable settings, remove it to start over")
+	}
+	if md != nil && !md.ModTime.IsZero() {
+		if pmd, err := hdr.metadata(); err == nil && !pmd.ModTime.Equal(md.ModTime) {
+			return nil, 0, errors.New("the source has been modified since, remove it to start over")
+		}
+	}
+	key, err := e.headerKey(hdr)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer key.Destroy()
+	aead, err := newChunkAEAD(hdr.Cipher, key.Bytes(), hdr.IV)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Keep the complete chunks up to the first one failing authentication
+	encSize := int64(chunkSize + tagSize)
+	complete := (stat.Size() - int64(len(raw))) / encSize
+	buf := make([]byte, encSize)
+	var good int64
+	for ; good < complete; good++ {
+		if _, err := out.ReadAt(buf, int64(len(raw))+good*encSize); err != nil {
+			return nil, 0, fmt.Errorf("failed to read partial file: %w", err)
+		}
+		if _, err := aead.Open(buf[:0], chunkNonce(aead, uint64(good), false), buf, raw); err != nil {
+			break
+		}
+	}
+	end := int64(len(raw)) + good*encSize
+	if err := out.Truncate(end); err != nil {
+		return nil, 0, fmt.Errorf("failed to truncate partial file: %w", err)
+	}
+	if _, err := out.Seek(end, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+
+	cw, err := newChunkWriter(out, hdr.Cipher, key.Bytes(), hdr.IV, raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	cw.counter = uint64(good)
+	return cw, good * chunkSize, nil
+}
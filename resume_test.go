@@ -0,0 +1,221 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// resumeSize is the size of the test source, five full chunks and a short one
+const resumeSize = 5*chunkSize + 1000
+
+// resumeFixture writes a source and encrypts it without interruption. It
+// returns the paths of the source and the destination and the reference output.
+func resumeFixture(t *testing.T) (src, dst string, ref []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	src, dst = filepath.Join(dir, "src"), filepath.Join(dir, "src.enc")
+	plaintext := make([]byte, resumeSize)
+	for i := range plaintext {
+		plaintext[i] = byte(i * 7)
+	}
+	if err := os.WriteFile(src, plaintext, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := resumeEncryptor(t, true).EncryptFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(dst); err != nil {
+		t.Fatal(err)
+	}
+	return src, dst, ref
+}
+
+// resumeEncryptor returns a resuming Encryptor. With random it uses the same
+// random bytes every time, else it fails if it needs any, i.e. if it does not
+// continue an existing partial file.
+func resumeEncryptor(t *testing.T, random bool) *Encryptor {
+	t.Helper()
+	var rnd []byte
+	if random {
+		rnd = bytes.Repeat([]byte{0x5a}, 4096)
+	}
+	e, err := New([]byte("resume test"), WithResume(), WithKDF(KDFParams{Name: KDFPBKDF2, Time: 1}), WithRand(bytes.NewReader(rnd)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// interrupt writes the first size bytes of ref as partial file of dst together
+// with the state of src, as left behind by an interrupted run
+func interrupt(t *testing.T, src, dst string, ref []byte, size int) {
+	t.Helper()
+	partial := dst + partialExt
+	if err := os.WriteFile(partial, ref[:size], 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resumeEncryptor(t, false).writeResumeState(partial, resumeStateOf(info)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chunkOffset returns the offset of chunk n in ref
+func chunkOffset(t *testing.T, ref []byte, n int) int {
+	t.Helper()
+	_, raw, err := readHeader(bytes.NewReader(ref))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(raw) + n*(chunkSize+tagSize)
+}
+
+// checkResumed checks that dst holds ref and the partial file and its state are gone
+func checkResumed(t *testing.T, dst string, ref []byte) {
+	t.Helper()
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, ref) {
+		t.Fatal("resumed output differs from the uninterrupted one")
+	}
+	for _, name := range []string{dst + partialExt, resumeStatePath(dst + partialExt)} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s left behind", name)
+		}
+	}
+}
+
+// TestResumeTruncated continues a partial file cut off in the middle of a chunk
+func TestResumeTruncated(t *testing.T) {
+	src, dst, ref := resumeFixture(t)
+	interrupt(t, src, dst, ref, chunkOffset(t, ref, 2)+chunkSize/2)
+	if err := resumeEncryptor(t, false).EncryptFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	checkResumed(t, dst, ref)
+}
+
+// TestResumeCorruptChunk drops a damaged last chunk and continues before it
+func TestResumeCorruptChunk(t *testing.T) {
+	src, dst, ref := resumeFixture(t)
+	size := chunkOffset(t, ref, 3)
+	interrupt(t, src, dst, ref, size)
+	partial, err := os.OpenFile(dst+partialExt, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := partial.WriteAt([]byte{^ref[size-100]}, int64(size-100)); err != nil {
+		t.Fatal(err)
+	}
+	partial.Close()
+	if err := resumeEncryptor(t, false).EncryptFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	checkResumed(t, dst, ref)
+}
+
+// TestResumeWithoutState starts a partial file over if its state is missing
+func TestResumeWithoutState(t *testing.T) {
+	src, dst, ref := resumeFixture(t)
+	interrupt(t, src, dst, ref, chunkOffset(t, ref, 2))
+	if err := os.Remove(resumeStatePath(dst + partialExt)); err != nil {
+		t.Fatal(err)
+	}
+	if err := resumeEncryptor(t, false).EncryptFile(src, dst); err == nil {
+		t.Fatal("partial file without state was continued")
+	}
+	if err := resumeEncryptor(t, true).EncryptFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	checkResumed(t, dst, ref)
+}
+
+// TestResumeSourceChanged refuses to continue from a source changed or
+// replaced since the partial file was written
+func TestResumeSourceChanged(t *testing.T) {
+	for name, change := range map[string]func(t *testing.T, src string){
+		"appended": func(t *testing.T, src string) {
+			f, err := os.OpenFile(src, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.Write([]byte("more")); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"rewritten": func(t *testing.T, src string) {
+			data := bytes.Repeat([]byte{1}, resumeSize)
+			if err := os.WriteFile(src, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(src, time.Time{}, time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"replaced": func(t *testing.T, src string) {
+			// Same size and modification time, but another file
+			info, err := os.Stat(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := fileIDOf(info); !ok {
+				t.Skip("files are not identified on this platform")
+			}
+			tmp := src + ".new"
+			if err := os.WriteFile(tmp, bytes.Repeat([]byte{2}, resumeSize), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(tmp, time.Time{}, info.ModTime()); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(tmp, src); err != nil {
+				t.Fatal(err)
+			}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			src, dst, ref := resumeFixture(t)
+			size := chunkOffset(t, ref, 2)
+			interrupt(t, src, dst, ref, size)
+			change(t, src)
+			if err := resumeEncryptor(t, true).EncryptFile(src, dst); err == nil {
+				t.Fatal("resumed from a changed source")
+			}
+			if _, err := os.Stat(dst); !os.IsNotExist(err) {
+				t.Error("output written from a changed source")
+			}
+			if info, err := os.Stat(dst + partialExt); err != nil || info.Size() != int64(size) {
+				t.Error("partial file was changed")
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/subtle"
+	"runtime"
+)
+
+// SecureBuffer holds key material. Where the platform supports it the memory
+// is allocated outside the Go heap and locked into RAM, so it is neither
+// moved nor copied by the garbage collector and never written to swap.
+// Destroy zeroes and releases it; keys must not be converted to strings,
+// which cannot be zeroed. A nil SecureBuffer is empty.
+type SecureBuffer struct {
+	data   []byte
+	mem    []byte
+	mapped bool
+	locked bool
+}
+
+// NewSecureBuffer returns a zeroed SecureBuffer of size bytes
+func NewSecureBuffer(size int) *SecureBuffer {
+	b := &SecureBuffer{}
+	if size > 0 {
+		b.mem, b.mapped, b.locked = allocSecure(size)
+		b.data = b.mem[:size]
+	}
+	return b
+}
+
+// SecureCopy returns a SecureBuffer holding a copy of data and zeroes data
+func SecureCopy(data []byte) *SecureBuffer {
+	b := NewSecureBuffer(len(data))
+	copy(b.data, data)
+	clear(data)
+	return b
+}
+
+// Bytes returns the content of the buffer, it must not be used after Destroy
+func (b *SecureBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	return b.data
+}
+
+// Len returns the length of the content
+func (b *SecureBuffer) Len() int {
+	return len(b.Bytes())
+}
+
+// clone returns a new SecureBuffer holding a copy of the content
+func (b *SecureBuffer) clone() *SecureBuffer {
+	c := NewSecureBuffer(b.Len())
+	copy(c.data, b.Bytes())
+	return c
+}
+
+// Locked reports whether the buffer is locked into RAM. Locking fails when
+// the limit of locked memory, RLIMIT_MEMLOCK on Unix, is reached.
+func (b *SecureBuffer) Locked() bool {
+	return b != nil && b.locked
+}
+
+// Destroy zeroes the buffer and releases its memory. It may be called more than once.
+func (b *SecureBuffer) Destroy() {
+	if b == nil || b.mem == nil {
+		return
+	}
+	clear(b.mem)
+	// Keep the zeroing from being optimized away before the memory is released
+	runtime.KeepAlive(b.mem)
+	freeSecure(b.mem, b.mapped, b.locked)
+	b.data, b.mem, b.mapped, b.locked = nil, nil, false, false
+}
+
+// Equal reports in constant time whether the buffer holds data
+func (b *SecureBuffer) Equal(data []byte) bool {
+	return subtle.ConstantTimeCompare(b.Bytes(), data) == 1
+}
@@ -0,0 +1,46 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocSecure maps whole anonymous pages for size bytes and locks them, if
+// mapping fails the memory comes from the heap and is not locked
+func allocSecure(size int) (mem []byte, mapped, locked bool) {
+	page := os.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, (size+page-1)/page*page, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return make([]byte, size), false, false
+	}
+	return mem, true, unix.Mlock(mem) == nil
+}
+
+// freeSecure unlocks and unmaps memory returned by allocSecure
+func freeSecure(mem []byte, mapped, locked bool) {
+	if locked {
+		unix.Munlock(mem)
+	}
+	if mapped {
+		unix.Munmap(mem)
+	}
+}
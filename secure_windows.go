@@ -0,0 +1,45 @@
+//go:build windows
+
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// allocSecure allocates whole pages for size bytes and locks them. The Go heap
+// does not move objects, the pages are taken from the middle of a larger
+// allocation so no other object shares them.
+func allocSecure(size int) (mem []byte, mapped, locked bool) {
+	page := os.Getpagesize()
+	n := (size + page - 1) / page * page
+	buf := make([]byte, n+page)
+	off := page - int(uintptr(unsafe.Pointer(&buf[0]))%uintptr(page))
+	mem = buf[off%page:][:n:n]
+	return mem, false, windows.VirtualLock(uintptr(unsafe.Pointer(&mem[0])), uintptr(n)) == nil
+}
+
+// freeSecure unlocks memory returned by allocSecure, the garbage collector frees it
+func freeSecure(mem []byte, mapped, locked bool) {
+	if locked {
+		windows.VirtualUnlock(uintptr(unsafe.Pointer(&mem[0])), uintptr(len(mem)))
+	}
+}
@@ -0,0 +1,369 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// shamirStanzaType names the stanza recording how a file key was split into shares
+	shamirStanzaType = "shamir"
+	// sharePrefix starts the text form of a share
+	sharePrefix = "FILEENC-SHARE-"
+	// shareSetSize is the length of the random id tying the shares of a file together
+	shareSetSize = 8
+	// shareCheckSize is the length of the check value of the recovered file key
+	shareCheckSize = 16
+)
+
+// Share is one of the shares a file key was split into with WithShares. Any
+// threshold shares of the same file recover the key, fewer reveal nothing about it.
+type Share struct {
+	set       []byte
+	threshold int
+	x         byte
+	y         []byte
+}
+
+// WithShares makes EncryptFile split a random file key into count shares, any
+// threshold of which recover it (Shamir's secret sharing). The shares are
+// written next to the output as <dst>.share1 to <dst>.share<count> and should be
+// handed to different people. Recipients may be given as well, the passphrase is
+// not used. NewWriter and Encrypt cannot write shares and fail.
+func WithShares(count, threshold int) Option {
+	return func(e *Encryptor) {
+		e.shareCount, e.shareThreshold = count, threshold
+	}
+}
+
+// validateShares checks that the settings allow splitting the file key
+func (e *Encryptor) validateShares() error {
+	switch {
+	case e.shareCount == 0:
+		return nil
+	case e.shareThreshold < 2 || e.shareThreshold > e.shareCount || e.shareCount > 255:
+		return fmt.Errorf("invalid shares: need 2 <= threshold <= count <= 255, got %d of %d", e.shareThreshold, e.shareCount)
+	case e.format != FormatFileenc:
+		return errors.New("key shares require the fileenc format")
+	case e.resume || e.convergent:
+		return errors.New("key shares cannot be combined with resuming or convergent encryption")
+	}
+	return nil
+}
+
+// SharePath returns the path of share n of the encrypted file path, counting from 1
+func SharePath(path string, n int) string {
+	return fmt.Sprintf("%s.share%d", path, n)
+}
+
+// Index returns the number of the share, counting from 1
+func (s Share) Index() int {
+	return int(s.x)
+}
+
+// Threshold returns the number of shares needed to recover the key
+func (s Share) Threshold() int {
+	return s.threshold
+}
+
+// String returns the text form of the share
+func (s Share) String() string {
+	data := append(slices.Clone(s.set), byte(s.threshold), s.x)
+	return sharePrefix + base64.RawURLEncoding.EncodeToString(append(data, s.y...))
+}
+
+// ParseShare decodes a share in text form
+func ParseShare(s string) (Share, error) {
+	data, err := parseKeyString(s, sharePrefix)
+	if err != nil {
+		return Share{}, err
+	}
+	if len(data) != shareSetSize+2+fileKeySize || data[shareSetSize] < 2 || data[shareSetSize+1] == 0 {
+		return Share{}, fmt.Errorf("%w: malformed share", ErrInvalidKey)
+	}
+	return Share{
+		set:       data[:shareSetSize],
+		threshold: int(data[shareSetSize]),
+		x:         data[shareSetSize+1],
+		y:         data[shareSetSize+2:],
+	}, nil
+}
+
+// ParseShares reads shares, one per line. Empty lines and lines starting with # are ignored.
+func ParseShares(r io.Reader) ([]Share, error) {
+	var shares []Share
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		share, err := ParseShare(line)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read shares: %w", err)
+	}
+	if len(shares) == 0 {
+		return nil, errors.New("no shares found")
+	}
+	return shares, nil
+}
+
+// ShareIdentity recovers file keys from shares written by WithShares
+type ShareIdentity struct {
+	shares []Share
+}
+
+// NewShareIdentity returns an identity combining the shares, which may belong to several files
+func NewShareIdentity(shares ...Share) *ShareIdentity {
+	return &ShareIdentity{shares: shares}
+}
+
+// Unwrap combines the shares belonging to the file if there are enough of them
+func (i *ShareIdentity) Unwrap(stanzas []Stanza) ([]byte, error) {
+	for _, st := range stanzas {
+		if st.Type != shamirStanzaType || len(st.Body) != shareSetSize+2+shareCheckSize {
+			continue
+		}
+		set, threshold := st.Body[:shareSetSize], int(st.Body[shareSetSize])
+		var xs []byte
+		var ys [][]byte
+		for _, s := range i.shares {
+			if bytes.Equal(s.set, set) && !bytes.Contains(xs, []byte{s.x}) && len(xs) < threshold {
+				xs, ys = append(xs, s.x), append(ys, s.y)
+			}
+		}
+		if len(xs) == 0 {
+			continue
+		}
+		if len(xs) < threshold {
+			return nil, fmt.Errorf("%w: %d of the %d shares needed", ErrNoIdentity, len(xs), threshold)
+		}
+		key := shamirCombine(xs, ys)
+		check, err := shareCheck(key, set)
+		if err != nil {
+			return nil, err
+		}
+		if subtle.ConstantTimeCompare(check, st.Body[shareSetSize+2:]) != 1 {
+			clear(key)
+			return nil, errors.New("the shares do not recover the file key, one of them is damaged")
+		}
+		return key, nil
+	}
+	return nil, ErrNoIdentity
+}
+
+// shareCheck returns the value verifying a file key recovered from the shares of set
+func shareCheck(key, set []byte) ([]byte, error) {
+	check, err := hkdf.Key(sha256.New, key, set, "fileenc shamir check", shareCheckSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive share check: %w", err)
+	}
+	return check, nil
+}
+
+// shareRecipient splits the file key into shares and writes them to temporary
+// files next to path, which are renamed into place by commit
+type shareRecipient struct {
+	count     int
+	threshold int
+	path      string
+	temps     []string
+	// e syncs the shares with WithDurability
+	e *Encryptor
+}
+
+// Wrap splits the file key and records the split in the stanza
+func (r *shareRecipient) Wrap(fileKey []byte) (Stanza, error) {
+	set := make([]byte, shareSetSize)
+	if _, err := io.ReadFull(rand.Reader, set); err != nil {
+		return Stanza{}, fmt.Errorf("failed to generate share id: %w", err)
+	}
+	ys, err := shamirSplit(fileKey, r.count, r.threshold)
+	if err != nil {
+		return Stanza{}, err
+	}
+	for n, y := range ys {
+		share := Share{set: set, threshold: r.threshold, x: byte(n + 1), y: y}
+		text := fmt.Sprintf("# share %d of %d of %s, any %d of them decrypt it\n%s\n",
+			n+1, r.count, filepath.Base(r.path), r.threshold, share)
+		if err := r.writeTemp(SharePath(r.path, n+1), text); err != nil {
+			return Stanza{}, err
+		}
+	}
+	check, err := shareCheck(fileKey, set)
+	if err != nil {
+		return Stanza{}, err
+	}
+	body := append(append(set, byte(r.threshold), byte(r.count)), check...)
+	return Stanza{Type: shamirStanzaType, Body: body}, nil
+}
+
+// writeTemp writes text to a temporary file next to path
+func (r *shareRecipient) writeTemp(path, text string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	r.temps = append(r.temps, tmp.Name())
+	if _, err := tmp.WriteString(text); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write share: %w", err)
+	}
+	if err := r.e.syncFile(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	return tmp.Close()
+}
+
+// commit renames the shares into place and removes shares left over from an
+// earlier split into more shares
+func (r *shareRecipient) commit() error {
+	for n, tmp := range r.temps {
+		if err := os.Rename(tmp, SharePath(r.path, n+1)); err != nil {
+			return fmt.Errorf("failed to rename temporary file: %w", err)
+		}
+	}
+	for n := len(r.temps) + 1; os.Remove(SharePath(r.path, n)) == nil; n++ {
+	}
+	r.temps = nil
+	return r.e.syncDir(filepath.Dir(r.path))
+}
+
+// abort removes the shares not yet renamed
+func (r *shareRecipient) abort() {
+	for _, tmp := range r.temps {
+		os.Remove(tmp)
+	}
+}
+
+// encryptShared implements EncryptFile with WithShares, the shares are only
+// renamed into place once the encrypted file is complete
+func (e *Encryptor) encryptShared(srcPath, dstPath string, overwrite bool) error {
+	if !overwrite {
+		if _, err := os.Stat(SharePath(dstPath, 1)); err == nil {
+			return fmt.Errorf("%w: %s, overwrite is disabled", ErrFileExists, SharePath(dstPath, 1))
+		}
+	}
+	sr := &shareRecipient{count: e.shareCount, threshold: e.shareThreshold, path: dstPath, e: e}
+	defer sr.abort()
+	se := *e
+	se.recipients = append(slices.Clone(e.recipients), sr)
+	se.shareCount = 0
+	if err := se.encryptLocked(srcPath, dstPath, overwrite); err != nil {
+		return err
+	}
+	return sr.commit()
+}
+
+// shamirSplit splits every byte of secret with a random polynomial of degree
+// threshold-1 over GF(2^8) and returns its values at x = 1 to count
+func shamirSplit(secret []byte, count, threshold int) ([][]byte, error) {
+	coeffs := make([]byte, threshold-1)
+	defer clear(coeffs)
+	ys := make([][]byte, count)
+	for n := range ys {
+		ys[n] = make([]byte, len(secret))
+	}
+	for i, b := range secret {
+		if _, err := io.ReadFull(rand.Reader, coeffs); err != nil {
+			return nil, fmt.Errorf("failed to generate shares: %w", err)
+		}
+		for n := range ys {
+			// Horner's scheme from the highest coefficient down to the secret
+			x, y := byte(n+1), byte(0)
+			for j := len(coeffs) - 1; j >= 0; j-- {
+				y = gfMul(y, x) ^ coeffs[j]
+			}
+			ys[n][i] = gfMul(y, x) ^ b
+		}
+	}
+	return ys, nil
+}
+
+// shamirCombine interpolates the polynomials through the points (xs, ys) at x = 0
+func shamirCombine(xs []byte, ys [][]byte) []byte {
+	secret := make([]byte, len(ys[0]))
+	for i, xi := range xs {
+		// Lagrange basis polynomial of xi at 0
+		basis := byte(1)
+		for j, xj := range xs {
+			if i != j {
+				basis = gfMul(basis, gfDiv(xj, xj^xi))
+			}
+		}
+		for k := range secret {
+			secret[k] ^= gfMul(ys[i][k], basis)
+		}
+	}
+	return secret
+}
+
+// gfExp and gfLog are the exponent and logarithm tables of GF(2^8) with the
+// AES polynomial and generator 3
+var gfExp, gfLog = gfTables()
+
+// gfTables computes gfExp and gfLog
+func gfTables() (exp [510]byte, log [256]byte) {
+	x := byte(1)
+	for i := range 255 {
+		exp[i], exp[i+255] = x, x
+		log[x] = byte(i)
+		// Multiply by 3: x*2 reduced by the AES polynomial, plus x
+		x2 := x << 1
+		if x&0x80 != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	return exp, log
+}
+
+// gfMul multiplies in GF(2^8)
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfDiv divides in GF(2^8), b must not be 0
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
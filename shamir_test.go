@@ -0,0 +1,199 @@
+package fileenc_test
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// encryptShared encrypts plaintext to a new file in dir split into count
+// shares, threshold of which decrypt it, and returns its path and the shares
+func encryptShared(t *testing.T, dir string, plaintext []byte, count, threshold int) (string, []fileenc.Share) {
+	t.Helper()
+	src, err := os.CreateTemp(dir, "plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Close()
+	if err := os.WriteFile(src.Name(), plaintext, 0600); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := fileenc.New(nil, fileenc.WithShares(count, threshold))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := src.Name() + ".enc"
+	if err := enc.EncryptFile(src.Name(), dst); err != nil {
+		t.Fatal(err)
+	}
+	shares := make([]fileenc.Share, count)
+	for n := range shares {
+		f, err := os.Open(fileenc.SharePath(dst, n+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := fileenc.ParseShares(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parsed) != 1 || parsed[0].Index() != n+1 || parsed[0].Threshold() != threshold {
+			t.Fatalf("share file %d holds %d shares, want share %d of threshold %d", n+1, len(parsed), n+1, threshold)
+		}
+		shares[n] = parsed[0]
+	}
+	return dst, shares
+}
+
+// decryptShared decrypts path with the shares
+func decryptShared(path string, shares ...fileenc.Share) ([]byte, error) {
+	dec, err := fileenc.New(nil, fileenc.WithIdentities(fileenc.NewShareIdentity(shares...)))
+	if err != nil {
+		return nil, err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	var out bytes.Buffer
+	err = dec.Decrypt(&out, in)
+	return out.Bytes(), err
+}
+
+// subsets returns the index sets of size k of n elements
+func subsets(n, k int) [][]int {
+	if k == 0 {
+		return [][]int{nil}
+	}
+	var sets [][]int
+	for last := k - 1; last < n; last++ {
+		for _, set := range subsets(last, k-1) {
+			sets = append(sets, append(set, last))
+		}
+	}
+	return sets
+}
+
+// pick returns the shares at the indexes
+func pick(shares []fileenc.Share, indexes []int) []fileenc.Share {
+	picked := make([]fileenc.Share, len(indexes))
+	for i, n := range indexes {
+		picked[i] = shares[n]
+	}
+	return picked
+}
+
+// TestSharesThreshold checks that every threshold shares recover the file key
+// and no threshold-1 shares do
+func TestSharesThreshold(t *testing.T) {
+	plaintext := []byte("split between the board members")
+	for _, c := range []struct{ count, threshold int }{{2, 2}, {3, 2}, {5, 3}, {6, 6}} {
+		dst, shares := encryptShared(t, t.TempDir(), plaintext, c.count, c.threshold)
+		for _, set := range subsets(c.count, c.threshold) {
+			got, err := decryptShared(dst, pick(shares, set)...)
+			if err != nil {
+				t.Fatalf("%d of %d, shares %v: %v", c.threshold, c.count, set, err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Fatalf("%d of %d, shares %v: wrong plaintext", c.threshold, c.count, set)
+			}
+		}
+		// More shares than needed work as well
+		if got, err := decryptShared(dst, shares...); err != nil || !bytes.Equal(got, plaintext) {
+			t.Fatalf("%d of %d, all shares: %v", c.threshold, c.count, err)
+		}
+		for _, set := range subsets(c.count, c.threshold-1) {
+			if _, err := decryptShared(dst, pick(shares, set)...); !errors.Is(err, fileenc.ErrNoIdentity) {
+				t.Fatalf("%d of %d, shares %v: got %v, want ErrNoIdentity", c.threshold, c.count, set, err)
+			}
+		}
+	}
+}
+
+// TestSharesRejected checks that duplicate, damaged and malformed shares do
+// not recover a key
+func TestSharesRejected(t *testing.T) {
+	dst, shares := encryptShared(t, t.TempDir(), []byte("secret"), 4, 3)
+
+	// A share given twice counts once
+	if _, err := decryptShared(dst, shares[0], shares[0], shares[1]); !errors.Is(err, fileenc.ErrNoIdentity) {
+		t.Errorf("duplicate share: got %v, want ErrNoIdentity", err)
+	}
+
+	// A changed share value is detected by the check value in the header
+	text := shares[2].String()
+	i, c := len(text)-10, byte('A')
+	if text[i] == c {
+		c = 'B'
+	}
+	damaged := text[:i] + string(c) + text[i+1:]
+	bad, err := fileenc.ParseShare(damaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptShared(dst, shares[0], shares[1], bad); err == nil || errors.Is(err, fileenc.ErrNoIdentity) {
+		t.Errorf("damaged share: got %v, want a damaged share error", err)
+	}
+
+	// Truncated or foreign text is no share
+	for _, s := range []string{text[:len(text)-4], strings.Replace(text, "SHARE", "SHARD", 1), "", text + "AAAA"} {
+		if _, err := fileenc.ParseShare(s); !errors.Is(err, fileenc.ErrInvalidKey) {
+			t.Errorf("ParseShare(%q): got %v, want ErrInvalidKey", s, err)
+		}
+	}
+	if _, err := fileenc.ParseShares(strings.NewReader("# only a comment\n\n")); err == nil {
+		t.Error("ParseShares without shares succeeded")
+	}
+
+	// Thresholds that cannot be met or protect nothing are refused
+	for _, c := range []struct{ count, threshold int }{{3, 1}, {2, 3}, {256, 2}} {
+		if _, err := fileenc.New(nil, fileenc.WithShares(c.count, c.threshold)); err == nil {
+			t.Errorf("WithShares(%d, %d) accepted", c.count, c.threshold)
+		}
+	}
+}
+
+// TestSharesOfOtherSplits checks that shares of different files are not
+// combined, while one identity may hold the shares of several files
+func TestSharesOfOtherSplits(t *testing.T) {
+	dir := t.TempDir()
+	a, sharesA := encryptShared(t, dir, []byte("file a"), 3, 2)
+	b, sharesB := encryptShared(t, dir, []byte("file b"), 3, 2)
+	if filepath.Dir(a) != filepath.Dir(b) || a == b {
+		t.Fatal("files not written next to each other")
+	}
+
+	// Share 1 of a and share 2 of b have different x values but belong to other splits
+	if _, err := decryptShared(a, sharesA[0], sharesB[1]); !errors.Is(err, fileenc.ErrNoIdentity) {
+		t.Errorf("mixed shares: got %v, want ErrNoIdentity", err)
+	}
+	mixed := []fileenc.Share{sharesA[0], sharesB[1], sharesA[2], sharesB[0]}
+	for path, want := range map[string]string{a: "file a", b: "file b"} {
+		got, err := decryptShared(path, mixed...)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
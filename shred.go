@@ -0,0 +1,78 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultShredPasses is the number of random overwrites used by Shred if passes is not positive
+const DefaultShredPasses = 3
+
+// Shred overwrites the regular file at path with random data the given number of
+// times, syncing every pass to disk, and removes it afterwards.
+//
+// Journaling and copy-on-write file systems, SSD wear leveling and backups may
+// keep copies of the original data that Shred cannot reach.
+func Shred(path string, passes int) error {
+	if passes <= 0 {
+		passes = DefaultShredPasses
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New("only regular files can be shredded")
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// Overwrite the whole file with random data and sync every pass
+	for i := 0; i < passes; i++ {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek file: %w", err)
+		}
+		if _, err := io.CopyN(file, rand.Reader, info.Size()); err != nil {
+			return fmt.Errorf("failed to overwrite file: %w", err)
+		}
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync file: %w", err)
+		}
+	}
+
+	// Drop the size information as well before removing the file
+	if err := file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+const (
+	// ed25519PublicPrefix starts the text form of Ed25519 public keys
+	ed25519PublicPrefix = "FILEENC-ED25519-PUBLIC-"
+	// ed25519SecretPrefix starts the text form of Ed25519 signers
+	ed25519SecretPrefix = "FILEENC-ED25519-SECRET-"
+	// signatureContext separates fileenc signatures from other uses of the key
+	signatureContext = "fileenc signature v1"
+)
+
+// ErrSignature is returned when a signature is invalid, made by an untrusted
+// key or missing although trusted signers are given
+var ErrSignature = errors.New("signature verification failed")
+
+// Ed25519Signer signs the plaintext of the files it encrypts
+type Ed25519Signer struct {
+	priv ed25519.PrivateKey
+}
+
+// Ed25519PublicKey verifies the signatures of an Ed25519Signer
+type Ed25519PublicKey struct {
+	pub ed25519.PublicKey
+}
+
+// GenerateEd25519Signer returns a new random signer
+func GenerateEd25519Signer() (*Ed25519Signer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return &Ed25519Signer{priv: priv}, nil
+}
+
+// ParseEd25519Signer parses the text form returned by Ed25519Signer.String
+func ParseEd25519Signer(s string) (*Ed25519Signer, error) {
+	data, err := parseKeyString(s, ed25519SecretPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%w: Ed25519 secret key must be %d bytes long", ErrInvalidKey, ed25519.SeedSize)
+	}
+	return &Ed25519Signer{priv: ed25519.NewKeyFromSeed(data)}, nil
+}
+
+// ParseEd25519PublicKey parses the text form returned by Ed25519PublicKey.String
+func ParseEd25519PublicKey(s string) (*Ed25519PublicKey, error) {
+	data, err := parseKeyString(s, ed25519PublicPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return newEd25519PublicKey(data)
+}
+
+// newEd25519PublicKey returns the public key of the 32 bytes in data
+func newEd25519PublicKey(data []byte) (*Ed25519PublicKey, error) {
+	if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: Ed25519 public key must be %d bytes long", ErrInvalidKey, ed25519.PublicKeySize)
+	}
+	return &Ed25519PublicKey{pub: ed25519.PublicKey(data)}, nil
+}
+
+// ParseSigner reads a signer from a file written by fileenc keygen -signing.
+// Empty lines and lines starting with # are ignored.
+func ParseSigner(r io.Reader) (*Ed25519Signer, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return ParseEd25519Signer(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read signer: %w", err)
+	}
+	return nil, errors.New("no signer found")
+}
+
+// ParseEd25519PublicKeys reads public keys, one per line. Empty lines and
+// lines starting with # are ignored.
+func ParseEd25519PublicKeys(r io.Reader) ([]*Ed25519PublicKey, error) {
+	var keys []*Ed25519PublicKey
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, err := ParseEd25519PublicKey(line)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read public keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+	return keys, nil
+}
+
+// String returns the text form of the private key
+func (s *Ed25519Signer) String() string {
+	return ed25519SecretPrefix + base64.RawURLEncoding.EncodeToString(s.priv.Seed())
+}
+
+// PublicKey returns the public key verifying the signatures of s
+func (s *Ed25519Signer) PublicKey() *Ed25519PublicKey {
+	return &Ed25519PublicKey{pub: s.priv.Public().(ed25519.PublicKey)}
+}
+
+// String returns the text form of the public key
+func (k *Ed25519PublicKey) String() string {
+	return ed25519PublicPrefix + base64.RawURLEncoding.EncodeToString(k.pub)
+}
+
+// Equal reports whether k and o are the same key
+func (k *Ed25519PublicKey) Equal(o *Ed25519PublicKey) bool {
+	return k.pub.Equal(o.pub)
+}
+
+// WithSigner makes encryption sign the plaintext with signer. The public key
+// is stored in the header and the signature after the plaintext, inside the
+// encryption. Signing requires the fileenc format.
+func WithSigner(signer *Ed25519Signer) Option {
+	return func(e *Encryptor) {
+		e.signer = signer
+	}
+}
+
+// WithTrustedSigners makes decryption fail with ErrSignature unless the file
+// is signed by one of the keys. Without trusted signers the signature of a
+// signed file is still checked, but any key is accepted.
+func WithTrustedSigners(keys ...*Ed25519PublicKey) Option {
+	return func(e *Encryptor) {
+		e.trustedSigners = append(e.trustedSigners, keys...)
+	}
+}
+
+// validateSigner checks that the signing settings can be combined with the others
+func (e *Encryptor) validateSigner() error {
+	switch {
+	case e.signer == nil:
+		return nil
+	case e.format != FormatFileenc:
+		return errors.New("signing requires the fileenc format")
+	case e.resume:
+		return errors.New("resuming does not support signing")
+	}
+	return nil
+}
+
+// signatureOptions returns the Ed25519ph options of fileenc signatures
+func signatureOptions() *ed25519.Options {
+	return &ed25519.Options{Hash: crypto.SHA512, Context: signatureContext}
+}
+
+// signWriter hashes the plaintext written through it and appends the signature on Close
+type signWriter struct {
+	w    io.WriteCloser
+	h    hash.Hash
+	priv ed25519.PrivateKey
+}
+
+// Write hashes p and writes it on
+func (s *signWriter) Write(p []byte) (int, error) {
+	s.h.Write(p)
+	return s.w.Write(p)
+}
+
+// Close writes the signature of the plaintext and closes the underlying writer
+func (s *signWriter) Close() error {
+	sig, err := s.priv.Sign(nil, s.h.Sum(nil), signatureOptions())
+	if err != nil {
+		return fmt.Errorf("failed to sign: %w", err)
+	}
+	if _, err := s.w.Write(sig); err != nil {
+		return err
+	}
+	return s.w.Close()
+}
+
+// verifyReader returns the plaintext read from r without the trailing
+// signature, which it checks against the public key at the end
+type verifyReader struct {
+	r    io.Reader
+	h    hash.Hash
+	pub  ed25519.PublicKey
+	buf  []byte
+	n    int
+	err  error
+	done bool
+}
+
+// Read returns the plaintext, holding back the last bytes until they are known to be the signature
+func (v *verifyReader) Read(p []byte) (int, error) {
+	for v.n <= ed25519.SignatureSize && v.err == nil {
+		var n int
+		n, v.err = v.r.Read(v.buf[v.n:])
+		v.n += n
+	}
+	if v.n > ed25519.SignatureSize {
+		n := copy(p, v.buf[:v.n-ed25519.SignatureSize])
+		v.h.Write(p[:n])
+		v.n = copy(v.buf, v.buf[n:v.n])
+		return n, nil
+	}
+	if v.err != io.EOF {
+		return 0, v.err
+	}
+	if !v.done {
+		v.done = true
+		if v.n < ed25519.SignatureSize || ed25519.VerifyWithOptions(v.pub, v.h.Sum(nil), v.buf[:v.n], signatureOptions()) != nil {
+			v.err = fmt.Errorf("%w: the signature does not match the plaintext", ErrSignature)
+			return 0, v.err
+		}
+	}
+	return 0, io.EOF
+}
+
+// verified returns the plaintext read from r without the signature of a signed
+// file, which is checked once r is read to its end. Unsigned files fail if
+// trusted signers are given.
+func (e *Encryptor) verified(r io.Reader, hdr header) (io.Reader, error) {
+	signer, err := hdr.signer()
+	switch {
+	case err != nil:
+		return nil, err
+	case signer == nil && len(e.trustedSigners) > 0:
+		return nil, fmt.Errorf("%w: the file is not signed", ErrSignature)
+	case signer == nil:
+		return r, nil
+	}
+	if len(e.trustedSigners) > 0 && !signer.trustedBy(e.trustedSigners) {
+		return nil, fmt.Errorf("%w: signed by the untrusted key %s", ErrSignature, signer)
+	}
+	return &verifyReader{r: r, h: sha512.New(), pub: signer.pub, buf: make([]byte, 32*1024+ed25519.SignatureSize)}, nil
+}
+
+// trustedBy reports whether k is one of the trusted keys
+func (k *Ed25519PublicKey) trustedBy(trusted []*Ed25519PublicKey) bool {
+	for _, t := range trusted {
+		if k.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// signer returns the public key of the signer stored in the header, nil for unsigned files
+func (h header) signer() (*Ed25519PublicKey, error) {
+	data, ok := h.extension(extSignature)
+	if !ok {
+		return nil, nil
+	}
+	k, err := newEd25519PublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid signer", ErrMalformedHeader)
+	}
+	return k, nil
+}
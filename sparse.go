@@ -0,0 +1,250 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The plaintext of a sparse file is a sequence of records, each a kind byte
+// and a uint64 big endian length. Data records are followed by the data,
+// hole records stand for that many zero bytes that are not stored.
+const (
+	sparseData byte = 'D'
+	sparseHole byte = 'H'
+	// sparseRecordSize is the length of a record without its data
+	sparseRecordSize = 9
+)
+
+// errSparseRecord is returned for an invalid record in the plaintext of a sparse file
+var errSparseRecord = errors.New("invalid sparse file record")
+
+// sparseEncoder reads a sparse file as records, skipping the holes
+type sparseEncoder struct {
+	f *os.File
+	// r reads the data of f, it may wrap f to report progress
+	r    io.Reader
+	size int64
+	off  int64
+	// rec holds the unread part of the current record header, data the unread bytes of its data
+	rec  []byte
+	data int64
+}
+
+// newSparseEncoder returns a reader encoding the file f of size bytes, read through r
+func newSparseEncoder(f *os.File, r io.Reader, size int64) *sparseEncoder {
+	return &sparseEncoder{f: f, r: r, size: size}
+}
+
+// Read returns the records of the data and holes of the file
+func (s *sparseEncoder) Read(p []byte) (int, error) {
+	for len(s.rec) == 0 && s.data == 0 {
+		if s.off >= s.size {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	if len(s.rec) > 0 {
+		n := copy(p, s.rec)
+		s.rec = s.rec[n:]
+		return n, nil
+	}
+	n, err := s.r.Read(p[:min(int64(len(p)), s.data)])
+	s.data -= int64(n)
+	if err == io.EOF {
+		// The file shrank while it was read
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// next starts the record of the region at the current offset
+func (s *sparseEncoder) next() error {
+	start, end, err := dataRegion(s.f, s.off, s.size)
+	if err != nil {
+		return fmt.Errorf("failed to find the data of the sparse file: %w", err)
+	}
+	if start > s.off {
+		s.rec = s.record(sparseHole, start-s.off)
+		s.off = start
+		return nil
+	}
+	if _, err := s.f.Seek(s.off, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in file: %w", err)
+	}
+	s.rec = s.record(sparseData, end-s.off)
+	s.data = end - s.off
+	s.off = end
+	return nil
+}
+
+// record encodes a record header
+func (s *sparseEncoder) record(kind byte, n int64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{kind}, uint64(n))
+}
+
+// sparseDecoder expands the records of a sparse file
+type sparseDecoder struct {
+	r    io.Reader
+	kind byte
+	left int64
+	err  error
+}
+
+// newSparseDecoder returns a reader expanding the records read from r
+func newSparseDecoder(r io.Reader) *sparseDecoder {
+	return &sparseDecoder{r: r}
+}
+
+// next reads the next record header, it returns io.EOF at the end of the records
+func (s *sparseDecoder) next() error {
+	var rec [sparseRecordSize]byte
+	if _, err := io.ReadFull(s.r, rec[:]); err != nil {
+		return err
+	}
+	s.kind, s.left = rec[0], int64(binary.BigEndian.Uint64(rec[1:]))
+	if (s.kind != sparseData && s.kind != sparseHole) || s.left < 0 {
+		return errSparseRecord
+	}
+	return nil
+}
+
+// Read returns the data with the holes filled with zeros
+func (s *sparseDecoder) Read(p []byte) (int, error) {
+	for s.left == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.err = s.next()
+	}
+	p = p[:min(int64(len(p)), s.left)]
+	if s.kind == sparseHole {
+		clear(p)
+		s.left -= int64(len(p))
+		return len(p), nil
+	}
+	n, err := s.r.Read(p)
+	s.left -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// holeWriter writes the output file of DecryptFile, skipping over holes so
+// they stay holes
+type holeWriter struct {
+	f *os.File
+	// holeAtEnd is set while nothing was written after a hole
+	holeAtEnd bool
+}
+
+// Write writes p at the current position
+func (h *holeWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		h.holeAtEnd = false
+	}
+	return h.f.Write(p)
+}
+
+// skip leaves a hole of n bytes
+func (h *holeWriter) skip(n int64) error {
+	if n > 0 {
+		h.holeAtEnd = true
+	}
+	_, err := h.f.Seek(n, io.SeekCurrent)
+	return err
+}
+
+// finish sets the size of the file, a hole at the end is only created this way
+func (h *holeWriter) finish() error {
+	if !h.holeAtEnd {
+		return nil
+	}
+	end, err := h.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return h.f.Truncate(end)
+}
+
+// WriteTo writes the data to w. The holes are skipped over when w is a
+// holeWriter, other writers receive zeros.
+func (s *sparseDecoder) WriteTo(w io.Writer) (int64, error) {
+	hw, ok := w.(*holeWriter)
+	if !ok || s.left != 0 || s.err != nil {
+		return io.Copy(w, struct{ io.Reader }{s})
+	}
+	var written int64
+	for {
+		err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+		if s.kind == sparseHole {
+			if err := hw.skip(s.left); err != nil {
+				return written, fmt.Errorf("failed to write hole: %w", err)
+			}
+			written += s.left
+			continue
+		}
+		n, err := io.CopyN(hw, s.r, s.left)
+		written += n
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	s.left, s.err = 0, io.EOF
+	return written, hw.finish()
+}
+
+// plaintext undoes the sparse encoding recorded in hdr, other data is returned unchanged
+func plaintext(r io.Reader, hdr header) (io.Reader, error) {
+	md, err := hdr.metadata()
+	if err != nil || !md.Sparse {
+		return r, err
+	}
+	return newSparseDecoder(r), nil
+}
+
+// sparseSource returns a reader encoding the holes of file if it has any and
+// the Encryptor writes the fileenc format, else src
+func (e *Encryptor) sparseSource(file *os.File, src io.Reader) (io.Reader, bool, error) {
+	if e.format != FormatFileenc {
+		return src, false, nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !info.Mode().IsRegular() || !hasHoles(file, info.Size()) {
+		return src, false, nil
+	}
+	return newSparseEncoder(file, src, info.Size()), true, nil
+}
@@ -0,0 +1,31 @@
+//go:build !linux && !darwin && !freebsd
+
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import "os"
+
+// hasHoles reports whether the file has holes, which cannot be detected on this platform
+func hasHoles(f *os.File, size int64) bool {
+	return false
+}
+
+// dataRegion returns the whole rest of the file as data
+func dataRegion(f *os.File, off, size int64) (start, end int64, err error) {
+	return off, size, nil
+}
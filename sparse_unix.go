@@ -0,0 +1,50 @@
+//go:build linux || darwin || freebsd
+
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// hasHoles reports whether the file of size bytes has a hole before its end
+func hasHoles(f *os.File, size int64) bool {
+	hole, err := f.Seek(0, unix.SEEK_HOLE)
+	f.Seek(0, 0)
+	return err == nil && hole < size
+}
+
+// dataRegion returns the bounds of the first data region at or after off,
+// start is size if only a hole follows
+func dataRegion(f *os.File, off, size int64) (start, end int64, err error) {
+	start, err = f.Seek(off, unix.SEEK_DATA)
+	if errors.Is(err, unix.ENXIO) {
+		return size, size, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if end, err = f.Seek(start, unix.SEEK_HOLE); err != nil {
+		return 0, 0, err
+	}
+	// Data appended since the size was taken is not read
+	return min(start, size), min(end, size), nil
+}
@@ -0,0 +1,242 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// firstPart is the extension of the first volume of a split file
+const firstPart = ".001"
+
+// WithSplit makes EncryptFile write the encrypted file in volumes of at most
+// size bytes named <dst>.001, <dst>.002 and so on, for media with size limits.
+// The volumes are only renamed into place once all are complete. DecryptFile
+// reassembles them when given <dst> or <dst>.001.
+func WithSplit(size int64) Option {
+	return func(e *Encryptor) {
+		e.split = size
+	}
+}
+
+// validateSplit checks that the settings allow splitting
+func (e *Encryptor) validateSplit() error {
+	switch {
+	case e.split == 0:
+		return nil
+	case e.split < 0:
+		return fmt.Errorf("invalid volume size %d", e.split)
+	case e.resume:
+		return errors.New("split files cannot be resumed")
+	}
+	return nil
+}
+
+// partName returns the name of volume n of path, counting from 1
+func partName(path string, n int) string {
+	return fmt.Sprintf("%s.%03d", path, n)
+}
+
+// SplitParts returns the volumes of the split file path, which may be given
+// with or without .001, in order. It returns nil if path is not split.
+func SplitParts(path string) []string {
+	base := strings.TrimSuffix(path, firstPart)
+	if base == path {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+	}
+	var parts []string
+	for n := 1; ; n++ {
+		part := partName(base, n)
+		if _, err := os.Stat(part); err != nil {
+			return parts
+		}
+		parts = append(parts, part)
+	}
+}
+
+// openEncrypted opens the encrypted file path, or its volumes if it is split,
+// and returns a reader reporting the progress and a function closing it
+func (e *Encryptor) openEncrypted(path string) (io.Reader, func() error, error) {
+	parts := SplitParts(path)
+	if parts == nil {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open encrypted file: %w", err)
+		}
+		src, err := e.progressReader(file, path)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return src, file.Close, nil
+	}
+	r, size, err := openParts(parts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if e.progress == nil {
+		return contextReader(e.ctx, r), r.Close, nil
+	}
+	return contextReader(e.ctx, NewProgressReader(r, path, size, progressInterval, e.progress)), r.Close, nil
+}
+
+// partsReader reads the volumes of a split file one after the other
+type partsReader struct {
+	parts []string
+	file  *os.File
+}
+
+// openParts opens the volumes for reading and returns their total size
+func openParts(parts []string) (*partsReader, int64, error) {
+	var size int64
+	for _, part := range parts {
+		info, err := os.Stat(part)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to open encrypted file: %w", err)
+		}
+		size += info.Size()
+	}
+	return &partsReader{parts: parts}, size, nil
+}
+
+// Read reads from the current volume and moves on to the next at its end
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.file == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			file, err := os.Open(r.parts[0])
+			if err != nil {
+				return 0, fmt.Errorf("failed to open encrypted file: %w", err)
+			}
+			r.file, r.parts = file, r.parts[1:]
+		}
+		n, err := r.file.Read(p)
+		if err == io.EOF {
+			r.file.Close()
+			r.file = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Close closes the current volume
+func (r *partsReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// splitWriter writes volumes of at most size bytes to temporary files
+type splitWriter struct {
+	path    string
+	size    int64
+	written int64
+	temps   []*os.File
+	// e syncs the volumes with WithDurability
+	e *Encryptor
+}
+
+// Write fills the current volume and starts the next when it is full
+func (w *splitWriter) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		if len(w.temps) == 0 || w.written == w.size {
+			name := filepath.Base(partName(w.path, len(w.temps)+1))
+			tmp, err := os.CreateTemp(filepath.Dir(w.path), "."+name+".*.tmp")
+			if err != nil {
+				return total, fmt.Errorf("failed to create temporary file: %w", err)
+			}
+			w.temps = append(w.temps, tmp)
+			w.written = 0
+		}
+		n := int(min(int64(len(p)), w.size-w.written))
+		n, err := w.temps[len(w.temps)-1].Write(p[:n])
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// commit renames the volumes into place and removes volumes left over from a
+// longer file of the same name as well as an unsplit file, which would be read instead
+func (w *splitWriter) commit() error {
+	for _, tmp := range w.temps {
+		if err := w.e.syncFile(tmp); err != nil {
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+		}
+	}
+	for n, tmp := range w.temps {
+		if err := os.Rename(tmp.Name(), partName(w.path, n+1)); err != nil {
+			return fmt.Errorf("failed to rename temporary file: %w", err)
+		}
+	}
+	os.Remove(w.path)
+	for n := len(w.temps) + 1; os.Remove(partName(w.path, n)) == nil; n++ {
+	}
+	return w.e.syncDir(filepath.Dir(w.path))
+}
+
+// abort removes the temporary volumes
+func (w *splitWriter) abort() {
+	for _, tmp := range w.temps {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+}
+
+// writeSplit calls write with a splitWriter for the volumes of path and
+// renames them into place if write succeeds
+func (e *Encryptor) writeSplit(path string, overwrite bool, write func(w io.Writer) error) error {
+	if !overwrite {
+		for _, name := range []string{path, partName(path, 1)} {
+			if _, err := os.Stat(name); err == nil {
+				return fmt.Errorf("%w: %s, overwrite is disabled", ErrFileExists, name)
+			}
+		}
+	}
+	w := &splitWriter{path: path, size: e.split, e: e}
+	if err := write(w); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.commit(); err != nil {
+		w.abort()
+		return err
+	}
+	return nil
+}
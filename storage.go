@@ -0,0 +1,197 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// StorageBackend reads and writes the objects of a URL scheme. The fileenc
+// command uses the registered backends for URLs given as -source, -out and
+// backup destination, so other stores can be added by registering a backend
+// in the init function of a package linked into the command.
+//
+// Operations a backend cannot do return an error wrapping
+// errors.ErrUnsupported, missing objects an error wrapping fs.ErrNotExist.
+type StorageBackend interface {
+	// Open returns the object at u and its size, -1 if unknown
+	Open(u *url.URL) (io.ReadCloser, int64, error)
+	// Create starts writing the object at u. It fails with ErrFileExists if
+	// the object exists and overwrite is false.
+	Create(u *url.URL, overwrite bool) (StorageWriter, error)
+	// Stat returns the size of the object at u, -1 if unknown
+	Stat(u *url.URL) (int64, error)
+	// List returns the names of the objects in the directory u
+	List(u *url.URL) ([]string, error)
+	// Remove deletes the object at u
+	Remove(u *url.URL) error
+}
+
+// StorageWriter is an object being written. It only appears at its
+// destination once Close succeeds, Abort discards what was written.
+type StorageWriter interface {
+	io.WriteCloser
+	Abort()
+}
+
+var (
+	storageMu sync.RWMutex
+	storages  = map[string]StorageBackend{"file": LocalStorage{}}
+)
+
+// RegisterStorage makes b the backend of the URLs with the given scheme, e.g.
+// "webdav" for webdav://host/path. It panics if the scheme is registered
+// already or b is nil.
+func RegisterStorage(scheme string, b StorageBackend) {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	if b == nil {
+		panic("fileenc: RegisterStorage backend is nil")
+	}
+	if _, dup := storages[scheme]; dup {
+		panic("fileenc: RegisterStorage called twice for scheme " + scheme)
+	}
+	storages[scheme] = b
+}
+
+// Storage returns the backend of the URL scheme
+func Storage(scheme string) (StorageBackend, bool) {
+	storageMu.RLock()
+	defer storageMu.RUnlock()
+	b, ok := storages[scheme]
+	return b, ok
+}
+
+// StorageSchemes returns the sorted URL schemes with a registered backend
+func StorageSchemes() []string {
+	storageMu.RLock()
+	defer storageMu.RUnlock()
+	schemes := make([]string, 0, len(storages))
+	for scheme := range storages {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// StorageURL parses name if it is a URL of a registered scheme and returns
+// it with its backend
+func StorageURL(name string) (*url.URL, StorageBackend, bool) {
+	scheme, _, ok := strings.Cut(name, "://")
+	if !ok {
+		return nil, nil, false
+	}
+	b, ok := Storage(scheme)
+	if !ok {
+		return nil, nil, false
+	}
+	u, err := url.Parse(name)
+	if err != nil {
+		return nil, nil, false
+	}
+	return u, b, true
+}
+
+// LocalStorage is the backend of the local file system, registered for
+// file:// URLs. The path of the URL is used as file name.
+type LocalStorage struct{}
+
+// Open opens the file
+func (LocalStorage) Open(u *url.URL) (io.ReadCloser, int64, error) {
+	file, err := os.Open(u.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, stat.Size(), nil
+}
+
+// Create writes to a temporary file next to the destination, which is renamed
+// on Close
+func (LocalStorage) Create(u *url.URL, overwrite bool) (StorageWriter, error) {
+	name := u.Path
+	if !overwrite {
+		if _, err := os.Stat(name); err == nil {
+			return nil, fmt.Errorf("%w: %s, overwrite is disabled", ErrFileExists, name)
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &localWriter{File: tmp, path: name}, nil
+}
+
+// Stat returns the size of the file
+func (LocalStorage) Stat(u *url.URL) (int64, error) {
+	stat, err := os.Stat(u.Path)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// List returns the names of the regular files in the directory
+func (LocalStorage) List(u *url.URL) ([]string, error) {
+	entries, err := os.ReadDir(u.Path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// Remove deletes the file
+func (LocalStorage) Remove(u *url.URL) error {
+	return os.Remove(u.Path)
+}
+
+// localWriter is a temporary file renamed to path on Close
+type localWriter struct {
+	*os.File
+	path string
+}
+
+// Close renames the complete file to its destination
+func (f *localWriter) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), f.path)
+}
+
+// Abort removes the temporary file
+func (f *localWriter) Abort() {
+	f.File.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,141 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxLinkTarget limits the target of an encrypted symbolic link
+const maxLinkTarget = 4096
+
+// WithPreserveSymlinks makes EncryptFile encrypt a symbolic link at the source
+// path as a link: the encrypted file holds the link target and DecryptFile
+// recreates the link. Without it EncryptFile encrypts the file the link points
+// to. Only the fileenc format stores links.
+func WithPreserveSymlinks() Option {
+	return func(e *Encryptor) {
+		e.preserveSymlinks = true
+	}
+}
+
+// WithFollowSymlinks makes EncryptDir archive the files and directories
+// symbolic links point to instead of the links
+func WithFollowSymlinks() Option {
+	return func(e *Encryptor) {
+		e.followSymlinks = true
+	}
+}
+
+// SkipFunc is called for the files EncryptDir leaves out of an archive, named
+// pipes, sockets, devices, broken links and links leading into a loop
+type SkipFunc func(path string, reason string)
+
+// WithSkipFunc makes EncryptDir and the extraction of archives and snapshots
+// report the files they skip to fn
+func WithSkipFunc(fn SkipFunc) Option {
+	return func(e *Encryptor) {
+		e.skipped = fn
+	}
+}
+
+// skip reports a skipped file if a SkipFunc is set
+func (e *Encryptor) skip(path, reason string) {
+	if e.skipped != nil {
+		e.skipped(path, reason)
+	}
+}
+
+// SpecialFileType describes the type of a file that is neither a regular
+// file, a directory nor a symbolic link, empty for those
+func SpecialFileType(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "character device"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	case mode&fs.ModeIrregular != 0:
+		return "irregular file"
+	}
+	return ""
+}
+
+// encryptSymlink encrypts the target of the link at srcPath described by info to dstPath
+func (e *Encryptor) encryptSymlink(srcPath, dstPath string, overwrite bool, info fs.FileInfo) error {
+	target, err := os.Readlink(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to read link: %w", err)
+	}
+	md := Metadata{UID: -1, GID: -1}
+	if e.fileMetadata {
+		md = e.fileMetadataOf(srcPath, info)
+		// Links carry no permissions of their own
+		md.Mode = 0
+	}
+	md.Symlink = true
+	return e.writeAtomic(dstPath, overwrite, func(w io.Writer) error {
+		src := strings.NewReader(target)
+		var sum []byte
+		if e.convergent {
+			var err error
+			if sum, err = e.convergentSum(src); err != nil {
+				return err
+			}
+		}
+		return e.encrypt(w, src, &md, sum)
+	})
+}
+
+// replaceWithSymlink replaces the file at path, which holds a decrypted link
+// target, with the link
+func replaceWithSymlink(path string, md Metadata, owner bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.Size() == 0 || info.Size() > maxLinkTarget {
+		return fmt.Errorf("%w: invalid link target", ErrMalformedHeader)
+	}
+	target, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read link target: %w", err)
+	}
+
+	// Create the link under a temporary name and rename it over the file
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".link.tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(string(target), tmp); err != nil {
+		return fmt.Errorf("failed to create link: %w", err)
+	}
+	if owner && md.UID >= 0 {
+		os.Lchown(tmp, md.UID, md.GID)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rename link: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package fileenc_test
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/itkonzepte-net/fileenc"
+)
+
+// vectorsFile holds the test vectors of the file format, see its description
+const vectorsFile = "testdata/vectors.json"
+
+var update = flag.Bool("update", false, "recompute the expected ciphertexts in "+vectorsFile)
+
+// vectors is the content of vectorsFile
+type vectors struct {
+	Description []string `json:"description"`
+	Vectors     []vector `json:"vectors"`
+}
+
+// vector describes the inputs of one encryption and its expected output
+type vector struct {
+	Name       string `json:"name"`
+	Cipher     string `json:"cipher"`
+	KDF        string `json:"kdf"`
+	KDFTime    uint32 `json:"kdf_time,omitempty"`
+	KDFMemory  uint32 `json:"kdf_memory,omitempty"`
+	KDFThreads uint8  `json:"kdf_threads,omitempty"`
+	// Passphrase is UTF-8, Key a raw key in hex for the kdf none
+	Passphrase string `json:"passphrase,omitempty"`
+	Key        string `json:"key,omitempty"`
+	// Random holds the bytes returned by the random source in hex, the IV followed by the salt
+	Random string `json:"random"`
+	// Plaintext in hex is repeated Repeat times if Repeat is set
+	Plaintext string `json:"plaintext"`
+	Repeat    int    `json:"repeat,omitempty"`
+	// Ciphertext is given in hex for short outputs, else only its SHA-256 hash
+	Ciphertext       string `json:"ciphertext,omitempty"`
+	CiphertextSHA256 string `json:"ciphertext_sha256"`
+}
+
+// inputs decodes the key, the random bytes and the plaintext of the vector
+func (v vector) inputs(t *testing.T) (key, random, plaintext []byte) {
+	key = []byte(v.Passphrase)
+	var err error
+	if v.Key != "" {
+		if key, err = hex.DecodeString(v.Key); err != nil {
+			t.Fatalf("invalid key: %v", err)
+		}
+	}
+	if random, err = hex.DecodeString(v.Random); err != nil {
+		t.Fatalf("invalid random bytes: %v", err)
+	}
+	if plaintext, err = hex.DecodeString(v.Plaintext); err != nil {
+		t.Fatalf("invalid plaintext: %v", err)
+	}
+	if v.Repeat > 0 {
+		plaintext = bytes.Repeat(plaintext, v.Repeat)
+	}
+	return key, random, plaintext
+}
+
+// TestVectors encrypts the inputs of every vector with the injected random
+// bytes, compares the output byte for byte and decrypts it again
+func TestVectors(t *testing.T) {
+	data, err := os.ReadFile(vectorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vs vectors
+	if err := json.Unmarshal(data, &vs); err != nil {
+		t.Fatalf("invalid %s: %v", vectorsFile, err)
+	}
+
+	for i := range vs.Vectors {
+		v := &vs.Vectors[i]
+		t.Run(v.Name, func(t *testing.T) {
+			key, random, plaintext := v.inputs(t)
+			rnd := bytes.NewReader(random)
+			params := fileenc.KDFParams{Name: v.KDF, Time: v.KDFTime, Memory: v.KDFMemory, Threads: v.KDFThreads}
+			enc, err := fileenc.New(key, fileenc.WithCipher(v.Cipher), fileenc.WithKDF(params), fileenc.WithRand(rnd))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := enc.Encrypt(&out, bytes.NewReader(plaintext)); err != nil {
+				t.Fatal(err)
+			}
+			if rnd.Len() != 0 {
+				t.Errorf("%d random bytes left unused", rnd.Len())
+			}
+			sum := sha256.Sum256(out.Bytes())
+
+			if *update {
+				v.CiphertextSHA256 = hex.EncodeToString(sum[:])
+				v.Ciphertext = ""
+				if out.Len() <= 1024 {
+					v.Ciphertext = hex.EncodeToString(out.Bytes())
+				}
+			}
+			if got := hex.EncodeToString(sum[:]); got != v.CiphertextSHA256 {
+				t.Errorf("ciphertext SHA-256 = %s, want %s", got, v.CiphertextSHA256)
+			}
+			if v.Ciphertext != "" && hex.EncodeToString(out.Bytes()) != v.Ciphertext {
+				t.Errorf("ciphertext = %x, want %s", out.Bytes(), v.Ciphertext)
+			}
+
+			// Chunks sealed in parallel give the same file
+			par, err := fileenc.New(key, fileenc.WithCipher(v.Cipher), fileenc.WithKDF(params),
+				fileenc.WithRand(bytes.NewReader(random)), fileenc.WithThreads(4))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var parOut bytes.Buffer
+			if err := par.Encrypt(&parOut, bytes.NewReader(plaintext)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(parOut.Bytes(), out.Bytes()) {
+				t.Error("ciphertext with 4 threads differs")
+			}
+
+			for _, threads := range []int{1, 4} {
+				dec, err := fileenc.New(key, fileenc.WithThreads(threads))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var back bytes.Buffer
+				if err := dec.Decrypt(&back, bytes.NewReader(out.Bytes())); err != nil {
+					t.Fatalf("decrypting with %d threads: %v", threads, err)
+				}
+				if !bytes.Equal(back.Bytes(), plaintext) {
+					t.Errorf("plaintext decrypted with %d threads differs", threads)
+				}
+			}
+		})
+	}
+
+	if *update {
+		data, err := json.MarshalIndent(vs, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(vectorsFile, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotAuthenticated is returned by Verify for aes-cfb files, they carry no
+// authentication tag so modifications cannot be detected
+var ErrNotAuthenticated = errors.New("file is encrypted with aes-cfb and cannot be verified")
+
+// ErrSourceMismatch is returned by VerifySource if the decrypted data differs
+// from the source the file was encrypted from
+var ErrSourceMismatch = errors.New("decrypted data differs from the source")
+
+// Verify reads the header and ciphertext from src and checks every
+// authentication tag without returning any plaintext. It returns nil only if
+// the whole file is intact and the key is correct.
+func (e *Encryptor) Verify(src io.Reader) error {
+	r, hdr, err := e.newReader(src)
+	if err != nil {
+		return err
+	}
+	if _, err := e.copy(io.Discard, r); err != nil {
+		if errors.Is(err, ErrAuthFailed) {
+			return err
+		}
+		return fmt.Errorf("failed to decrypt: %w", err)
+	}
+	if hdr.Cipher == CipherAESCFB {
+		return ErrNotAuthenticated
+	}
+	return nil
+}
+
+// VerifyFile verifies the encrypted file at path or its volumes, see Verify
+func (e *Encryptor) VerifyFile(path string) error {
+	src, closeSrc, err := e.openEncrypted(path)
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+	return e.Verify(src)
+}
+
+// VerifySource decrypts the file at path or its volumes without writing the
+// plaintext and checks that its SHA-256 hash is sum, the hash of the source
+// taken before encrypting it. It needs the key or an identity, not a recipient.
+func (e *Encryptor) VerifySource(path string, sum []byte) error {
+	src, closeSrc, err := e.openEncrypted(path)
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+	h := sha256.New()
+	if err := e.Decrypt(h, src); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return ErrSourceMismatch
+	}
+	return nil
+}
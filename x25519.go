@@ -0,0 +1,236 @@
+package fileenc
+
+/* fileenc - a very basic file en/decryptor
+Copyright (C) 2025 Mathias Pohl, IT Konzepte Pohl, info@itkonzepte.net
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// x25519StanzaType names stanzas of X25519 recipients
+	x25519StanzaType = "X25519"
+	// x25519PublicPrefix starts the text form of X25519 recipients
+	x25519PublicPrefix = "FILEENC-X25519-PUBLIC-"
+	// x25519SecretPrefix starts the text form of X25519 identities
+	x25519SecretPrefix = "FILEENC-X25519-SECRET-"
+)
+
+// X25519Recipient wraps file keys for the holder of an X25519Identity using an
+// ephemeral X25519 key agreement
+type X25519Recipient struct {
+	pub *ecdh.PublicKey
+}
+
+// X25519Identity is an X25519 private key unwrapping file keys of its recipient
+type X25519Identity struct {
+	priv *ecdh.PrivateKey
+}
+
+// GenerateX25519Identity returns a new random X25519 identity
+func GenerateX25519Identity() (*X25519Identity, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return &X25519Identity{priv: priv}, nil
+}
+
+// NewX25519Identity returns the identity of the 32 byte X25519 private key,
+// e.g. derived from a secret held by a hardware token
+func NewX25519Identity(key []byte) (*X25519Identity, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: X25519 secret key: %w", ErrInvalidKey, err)
+	}
+	return &X25519Identity{priv: priv}, nil
+}
+
+// ParseX25519Recipient parses the text form returned by X25519Recipient.String
+func ParseX25519Recipient(s string) (*X25519Recipient, error) {
+	data, err := parseKeyString(s, x25519PublicPrefix)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: X25519 public key: %w", ErrInvalidKey, err)
+	}
+	return &X25519Recipient{pub: pub}, nil
+}
+
+// ParseX25519Identity parses the text form returned by X25519Identity.String
+func ParseX25519Identity(s string) (*X25519Identity, error) {
+	data, err := parseKeyString(s, x25519SecretPrefix)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: X25519 secret key: %w", ErrInvalidKey, err)
+	}
+	return &X25519Identity{priv: priv}, nil
+}
+
+// ParseIdentities reads identities from an identity file. Empty lines and
+// lines starting with # are ignored.
+func ParseIdentities(r io.Reader) ([]Identity, error) {
+	var ids []Identity
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, err := ParseX25519Identity(line)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read identities: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no identities found")
+	}
+	return ids, nil
+}
+
+// ParseRecipients reads recipients, one per line. Empty lines and lines
+// starting with # are ignored.
+func ParseRecipients(r io.Reader) ([]Recipient, error) {
+	var recipients []Recipient
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rcpt, err := ParseX25519Recipient(line)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, rcpt)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recipients: %w", err)
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients found")
+	}
+	return recipients, nil
+}
+
+// String returns the text form of the public key
+func (r *X25519Recipient) String() string {
+	return x25519PublicPrefix + base64.RawURLEncoding.EncodeToString(r.pub.Bytes())
+}
+
+// Wrap encrypts the file key with a key agreed between an ephemeral key and the recipient
+func (r *X25519Recipient) Wrap(fileKey []byte) (Stanza, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return Stanza{}, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	shared, err := eph.ECDH(r.pub)
+	if err != nil {
+		return Stanza{}, fmt.Errorf("failed to agree on key: %w", err)
+	}
+	ephPub := eph.PublicKey().Bytes()
+	aead, err := x25519WrapAEAD(shared, ephPub, r.pub.Bytes())
+	if err != nil {
+		return Stanza{}, err
+	}
+	body := aead.Seal(ephPub, make([]byte, aead.NonceSize()), fileKey, nil)
+	return Stanza{Type: x25519StanzaType, Body: body}, nil
+}
+
+// Recipient returns the public key belonging to the identity
+func (i *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{pub: i.priv.PublicKey()}
+}
+
+// String returns the text form of the private key
+func (i *X25519Identity) String() string {
+	return x25519SecretPrefix + base64.RawURLEncoding.EncodeToString(i.priv.Bytes())
+}
+
+// Unwrap tries to decrypt the file key of every X25519 stanza
+func (i *X25519Identity) Unwrap(stanzas []Stanza) ([]byte, error) {
+	pub := i.priv.PublicKey().Bytes()
+	for _, st := range stanzas {
+		if st.Type != x25519StanzaType || len(st.Body) != 32+fileKeySize+16 {
+			continue
+		}
+		ephPub, err := ecdh.X25519().NewPublicKey(st.Body[:32])
+		if err != nil {
+			continue
+		}
+		shared, err := i.priv.ECDH(ephPub)
+		if err != nil {
+			continue
+		}
+		aead, err := x25519WrapAEAD(shared, st.Body[:32], pub)
+		if err != nil {
+			return nil, err
+		}
+		if key, err := aead.Open(nil, make([]byte, aead.NonceSize()), st.Body[32:], nil); err == nil {
+			return key, nil
+		}
+	}
+	return nil, ErrNoIdentity
+}
+
+// x25519WrapAEAD derives the key wrapping the file key from the shared secret
+// and both public keys. The key is unique per ephemeral key, so a fixed nonce is used.
+func x25519WrapAEAD(shared, ephPub, pub []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephPub...), pub...)
+	wrapKey, err := hkdf.Key(sha256.New, shared, salt, "fileenc x25519", 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive wrapping key: %w", err)
+	}
+	defer clear(wrapKey)
+	block, err := aes.NewCipher(wrapKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// parseKeyString decodes a key in text form with the given prefix
+func parseKeyString(s, prefix string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("%w: key does not start with %s", ErrInvalidKey, prefix)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, prefix))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid encoding: %w", ErrInvalidKey, err)
+	}
+	return data, nil
+}